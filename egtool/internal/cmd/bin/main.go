@@ -6,6 +6,7 @@ package bin
 
 import (
 	"bytes"
+	"debug/elf"
 	"flag"
 	"fmt"
 	"maps"
@@ -13,7 +14,9 @@ import (
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/embeddedgo/tools/egtool/internal/uf2"
 	"github.com/embeddedgo/tools/egtool/internal/util"
 )
 
@@ -44,32 +47,49 @@ func Main(cmd string, args []string) {
 	if cmd == "uf2" {
 		fs.StringVar(
 			&family, "family", "",
-			"UF2 family `ID` (32-bit number) or a known family name:\n"+
-				strings.Join(slices.Sorted(maps.Keys(uf2FamilyMap)), "\n"),
+			"UF2 family `ID` (32-bit number) or a known family name, if\n"+
+				"omitted it is determined from the ELF file:\n"+
+				strings.Join(slices.Sorted(maps.Keys(uf2.FamilyMap)), "\n"),
 		)
 	}
+	jsonOut := fs.Bool("json", false, "report the result as a JSON event on stdout")
 	fs.Parse(args)
+	util.JSON = *jsonOut
 	if fs.NArg() > 2 {
 		fs.Usage()
 		os.Exit(1)
 	}
-	elf, out := util.InOutFiles(fs.Arg(0), ".elf", fs.Arg(1), "."+cmd)
-	sections, err := util.ReadELF(elf)
+	elfName, out := util.InOutFiles(fs.Arg(0), ".elf", fs.Arg(1), "."+cmd)
+	t0 := time.Now()
+	sections, err := util.ReadELF(elfName)
 	util.FatalErr("readelf", err)
 	if *inc != "" {
 		isec, err := util.ReadBins(*inc)
 		util.FatalErr("readbins", err)
 		sections = append(sections, isec...)
 	}
+	buf := bytes.NewBuffer(make([]byte, 0, sections.Size()*5/4))
+	_, err = sections.Flatten(buf, byte(*pad))
+	util.FatalErr("flatten", err)
+	var size int // number of image bytes written to the output file
 	switch cmd {
 	case "bin":
 		of, err := os.Create(out)
 		util.FatalErr("", err)
 		defer of.Close()
-		_, err = sections.Flatten(of, byte(*pad))
-		util.FatalErr("flatten", err)
+		size, err = of.Write(buf.Bytes())
+		util.FatalErr("", err)
 	case "uf2":
-		familyID, ok := uf2FamilyMap[family]
+		if family == "" {
+			f, err := elf.Open(elfName)
+			util.FatalErr("readelf", err)
+			family = uf2.ELFFamily(f)
+			f.Close()
+			if family == "" {
+				util.Fatal("uf2: cannot determine the family of %s (use -family)", elfName)
+			}
+		}
+		familyID, ok := uf2.FamilyMap[family]
 		if !ok {
 			u, err := strconv.ParseUint(family, 0, 32)
 			if err != nil {
@@ -77,19 +97,21 @@ func Main(cmd string, args []string) {
 			}
 			familyID = uint32(u)
 		}
-		buf := bytes.NewBuffer(make([]byte, 0, sections.Size()*5/4))
-		_, err = sections.Flatten(buf, byte(*pad))
-		util.FatalErr("flatten", err)
 		addr := uint32(sections[0].Paddr)
 		if uint64(addr) != sections[0].Paddr {
-			util.Fatal("uf2: the target address %#x doesn't fit in 32 bits")
+			util.Fatal("uf2: the target address %#x doesn't fit in 32 bits", sections[0].Paddr)
 		}
 		of, err := os.Create(out)
 		util.FatalErr("", err)
 		defer of.Close()
-		w := newUF2Writer(of, addr, uf2FamilyIDPresent, familyID, buf.Len())
-		_, err = w.Write(buf.Bytes())
+		w := uf2.NewWriter(of, addr, uf2.FamilyIDPresent, familyID, buf.Len())
+		size, err = w.Write(buf.Bytes())
 		util.FatalErr("", err)
 		util.FatalErr("", w.Flush())
 	}
+	if util.JSON {
+		e := util.DoneEvent("", t0)
+		e.Name, e.Total = out, size
+		util.Emit(e)
+	}
 }
@@ -0,0 +1,115 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import (
+	"debug/elf"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// launchConfig is the VS Code cortex-debug launch configuration.
+type launchConfig struct {
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	Request    string    `json:"request"`
+	Cwd        string    `json:"cwd"`
+	Executable string    `json:"executable"`
+	GDBPath    string    `json:"gdbPath,omitempty"`
+	ServerType string    `json:"servertype"`
+	GDBTarget  string    `json:"gdbTarget,omitempty"`
+	ServerPath string    `json:"serverpath,omitempty"`
+	Machine    string    `json:"machine,omitempty"`
+	CPU        string    `json:"cpu,omitempty"`
+	ServerArgs []string  `json:"serverArgs,omitempty"`
+	RunTo      string    `json:"runToEntryPoint,omitempty"`
+	Launch     *[]string `json:"overrideLaunchCommands,omitempty"`
+}
+
+// writeLaunch writes the launch.json file with the cortex-debug configuration
+// equivalent to the session. It doesn't overwrite an existing file.
+func (s *session) writeLaunch(name string) error {
+	if s.machine != elf.EM_ARM {
+		return errors.New("cortex-debug supports only the Arm targets")
+	}
+	exe := s.elf
+	if wd, err := os.Getwd(); err == nil {
+		if abs, err := filepath.Abs(exe); err == nil {
+			if rel, err := filepath.Rel(wd, abs); err == nil {
+				exe = "${workspaceFolder}/" + filepath.ToSlash(rel)
+			}
+		}
+	}
+	cfg := &launchConfig{
+		Name:       "egtool debug " + filepath.Base(s.elf),
+		Type:       "cortex-debug",
+		Request:    "launch",
+		Cwd:        "${workspaceFolder}",
+		Executable: exe,
+		GDBPath:    s.gdb,
+		RunTo:      "main.main",
+	}
+	if s.qemu != nil {
+		cfg.ServerType = "qemu"
+		cfg.ServerPath = s.qemu[0]
+		// cortex-debug adds the -kernel, -gdb and -S options itself. The
+		// other ones, that make QEMU behave like in the egtool debug
+		// session, are passed in serverArgs.
+		args := s.qemu[1:]
+		for i := 0; i < len(args); i++ {
+			switch a := args[i]; a {
+			case "-machine":
+				i++
+				cfg.Machine = args[i]
+			case "-cpu":
+				i++
+				cfg.CPU = args[i]
+			case "-kernel", "-gdb":
+				i++
+			case "-S":
+			default:
+				a = strings.ReplaceAll(a, "arg="+s.elf, "arg="+exe)
+				cfg.ServerArgs = append(cfg.ServerArgs, a)
+			}
+		}
+	} else {
+		cfg.ServerType = "external"
+		cfg.GDBTarget = s.addr
+		// Skip the set architecture and target remote commands. The empty
+		// list is intentional: it disables the default load command.
+		cmds := s.commands()[2:]
+		cfg.Launch = &cmds
+	}
+	doc := struct {
+		Version        string          `json:"version"`
+		Configurations []*launchConfig `json:"configurations"`
+	}{"0.2.0", []*launchConfig{cfg}}
+	b, err := json.MarshalIndent(&doc, "", "\t")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if name == "-" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	if dir := filepath.Dir(name); dir != "." {
+		if err = os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
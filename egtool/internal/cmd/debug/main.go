@@ -0,0 +1,88 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/embeddedgo/tools/egtool/internal/util"
+)
+
+const Descr = "start a debug session (GDB with QEMU or a GDB server)"
+
+const help = `
+The debug command starts GDB connected to the program. The noostest ELF files
+are run in QEMU with the gdbstub enabled (the machine is selected the same way
+the emgo runelf does it). Any other program is debugged using an external GDB
+server (OpenOCD, pyOCD, etc.) given by the -gdb option: the program is loaded
+and the target is reset before the session starts.
+
+The -launch option writes the equivalent VS Code cortex-debug launch
+configuration instead of starting the session.
+`
+
+func Main(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(
+			os.Stderr,
+			"Usage:\n  %s [OPTIONS] [ELF]\n%s\nOptions:\n",
+			cmd, help,
+		)
+		fs.PrintDefaults()
+	}
+	server := fs.String(
+		"gdb", "",
+		"debug using the GDB server at `HOST:PORT` instead of running the\n"+
+			"noostest ELF in QEMU",
+	)
+	port := fs.Int("port", 1234, "QEMU gdbstub TCP `PORT`")
+	gdbPath := fs.String(
+		"gdbcmd", "",
+		"GDB executable `PATH` (default: the first found of gdb-multiarch,\n"+
+			"arm-none-eabi-gdb or riscv64-unknown-elf-gdb, gdb)",
+	)
+	load := fs.Bool(
+		"load", true, "load the program before the session (GDB server)",
+	)
+	reset := fs.String(
+		"reset", "reset halt",
+		"monitor `COMMAND` that resets and halts the target before and\n"+
+			"after loading, empty means no reset (GDB server)",
+	)
+	launch := fs.String(
+		"launch", "",
+		"write the VS Code cortex-debug launch configuration to `FILE`\n"+
+			"(- means stdout) instead of starting the session",
+	)
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	elfName, _ := util.InOutFiles(fs.Arg(0), ".elf", "", "")
+
+	s, err := newSession(elfName, *server, *port)
+	util.FatalErr("", err)
+	s.load = *load
+	s.reset = *reset
+	if *gdbPath != "" {
+		s.gdb = *gdbPath
+	} else {
+		s.gdb, err = findGDB(s.machine)
+		if err != nil && *launch == "" {
+			util.FatalErr("", err)
+		}
+	}
+	if *launch != "" {
+		util.FatalErr("", s.writeLaunch(*launch))
+		return
+	}
+	code, err := s.run()
+	util.FatalErr("", err)
+	os.Exit(code)
+}
@@ -0,0 +1,18 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build unix
+
+package debug
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detach runs the command in its own process group so it doesn't receive
+// the signals generated by the terminal.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
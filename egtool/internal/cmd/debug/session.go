@@ -0,0 +1,203 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package debug
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"time"
+)
+
+// session describes the debug session.
+type session struct {
+	elf     string
+	machine elf.Machine
+	arch    string   // GDB architecture
+	qemu    []string // QEMU command line, nil for an external GDB server
+	addr    string   // GDB server address
+	gdb     string   // GDB executable
+	load    bool     // load the program (external GDB server)
+	reset   string   // monitor reset command (external GDB server)
+}
+
+// newSession selects the debug backend for the ELF: the external GDB server
+// at addr or QEMU with the gdbstub listening on port if addr is empty.
+func newSession(elfName, addr string, port int) (*session, error) {
+	f, err := elf.Open(elfName)
+	if err != nil {
+		return nil, err
+	}
+	h := f.FileHeader
+	f.Close()
+	s := &session{elf: elfName, machine: h.Machine, addr: addr}
+	switch {
+	case h.Machine == elf.EM_ARM:
+		s.arch = "arm"
+	case h.Machine == elf.EM_RISCV && h.Class == elf.ELFCLASS64:
+		s.arch = "riscv:rv64"
+	case h.Machine == elf.EM_RISCV:
+		s.arch = "riscv:rv32"
+	default:
+		return nil, fmt.Errorf("%s: unsupported architecture %v", elfName, h.Machine)
+	}
+	if addr != "" {
+		return s, nil
+	}
+	s.qemu = qemuArgs(elfName, &h)
+	if s.qemu == nil {
+		return nil, errors.New(
+			elfName + ": not a noostest ELF image, use -gdb to select the GDB server",
+		)
+	}
+	s.addr = "localhost:" + strconv.Itoa(port)
+	s.qemu = append(s.qemu, "-gdb", "tcp:localhost:"+strconv.Itoa(port), "-S")
+	return s, nil
+}
+
+// qemuArgs returns the QEMU command line that runs the noostest ELF or nil if
+// the ELF isn't a noostest one. Keep it in sync with emgo/runelf.go.
+func qemuArgs(elfName string, h *elf.FileHeader) []string {
+	semiconf := "enable=on,target=native,userspace=on,arg=" + elfName
+	switch h.Machine {
+	case elf.EM_ARM:
+		if h.Entry&1 != 0 {
+			return []string{
+				"qemu-system-arm",
+				"-machine", "mps2-an500",
+				"-cpu", "cortex-m7",
+				"-nographic",
+				"-monitor", "none",
+				"-serial", "none",
+				"--semihosting-config", semiconf,
+				"-kernel", elfName,
+			}
+		}
+	case elf.EM_RISCV:
+		if h.Entry == 0x80000000 {
+			return []string{
+				"qemu-system-riscv64",
+				"-machine", "virt",
+				"-cpu", "rv64,pmp=false,mmu=false,c=false",
+				"-smp", "2",
+				"-m", "32",
+				"-nographic",
+				"-monitor", "none",
+				"-serial", "none",
+				"--semihosting-config", semiconf,
+				"-bios", elfName,
+			}
+		}
+	}
+	return nil
+}
+
+// findGDB returns the path to the first GDB found that supports the
+// architecture.
+func findGDB(m elf.Machine) (string, error) {
+	names := []string{"gdb-multiarch"}
+	switch m {
+	case elf.EM_ARM:
+		names = append(names, "arm-none-eabi-gdb")
+	case elf.EM_RISCV:
+		names = append(names, "riscv64-unknown-elf-gdb", "riscv64-elf-gdb")
+	}
+	names = append(names, "gdb")
+	for _, name := range names {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", errors.New("cannot find GDB, use -gdbcmd to provide one")
+}
+
+// commands returns the GDB commands that start the session.
+func (s *session) commands() []string {
+	cmds := []string{
+		"set architecture " + s.arch,
+		"target remote " + s.addr,
+	}
+	if s.qemu != nil {
+		return cmds // QEMU has already loaded the program
+	}
+	if s.reset != "" {
+		cmds = append(cmds, "monitor "+s.reset)
+	}
+	if s.load {
+		cmds = append(cmds, "load")
+		if s.reset != "" {
+			cmds = append(cmds, "monitor "+s.reset)
+		}
+	}
+	return cmds
+}
+
+// run runs the debug session and returns the GDB exit code.
+func (s *session) run() (int, error) {
+	if s.qemu != nil {
+		path, err := exec.LookPath(s.qemu[0])
+		if err != nil {
+			return 0, err
+		}
+		qemu := &exec.Cmd{
+			Path:   path,
+			Args:   s.qemu,
+			Stdout: os.Stdout, // semihosting output
+			Stderr: os.Stderr,
+		}
+		// Ctrl-C in GDB must not kill QEMU.
+		detach(qemu)
+		if err = qemu.Start(); err != nil {
+			return 0, err
+		}
+		defer func() {
+			qemu.Process.Kill()
+			qemu.Wait()
+		}()
+		if err = waitListen(s.addr, 5*time.Second); err != nil {
+			return 0, err
+		}
+	}
+	args := []string{s.gdb, "-q"}
+	for _, c := range s.commands() {
+		args = append(args, "-ex", c)
+	}
+	args = append(args, s.elf)
+	gdb := &exec.Cmd{
+		Path:   s.gdb,
+		Args:   args,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+	// GDB handles Ctrl-C itself (it interrupts the target).
+	signal.Ignore(os.Interrupt)
+	defer signal.Reset(os.Interrupt)
+	err := gdb.Run()
+	if ee, ok := err.(*exec.ExitError); ok {
+		return ee.ProcessState.ExitCode(), nil
+	}
+	return 0, err
+}
+
+// waitListen waits for the server to listen at addr.
+func waitListen(addr string, timeout time.Duration) error {
+	for deadline := time.Now().Add(timeout); ; {
+		c, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			c.Close()
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("QEMU gdbstub isn't listening at %s: %w", addr, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fault
+
+import (
+	"fmt"
+)
+
+type bitDescr struct {
+	bit   uint
+	name  string
+	descr string
+}
+
+var cfsrBits = []bitDescr{
+	{0, "IACCVIOL", "instruction access violation"},
+	{1, "DACCVIOL", "data access violation"},
+	{3, "MUNSTKERR", "MemManage fault on exception return unstacking"},
+	{4, "MSTKERR", "MemManage fault on exception entry stacking"},
+	{5, "MLSPERR", "MemManage fault during FP lazy state preservation"},
+	{7, "MMARVALID", "MMFAR holds the fault address"},
+	{8, "IBUSERR", "instruction bus error"},
+	{9, "PRECISERR", "precise data bus error"},
+	{10, "IMPRECISERR", "imprecise data bus error, the stacked PC may not point to the faulting instruction"},
+	{11, "UNSTKERR", "BusFault on exception return unstacking"},
+	{12, "STKERR", "BusFault on exception entry stacking"},
+	{13, "LSPERR", "BusFault during FP lazy state preservation"},
+	{15, "BFARVALID", "BFAR holds the fault address"},
+	{16, "UNDEFINSTR", "undefined instruction"},
+	{17, "INVSTATE", "invalid state, e.g. branch to an address with the Thumb bit cleared"},
+	{18, "INVPC", "invalid EXC_RETURN value"},
+	{19, "NOCP", "coprocessor access, e.g. FPU disabled"},
+	{20, "STKOF", "stack overflow (ARMv8-M)"},
+	{24, "UNALIGNED", "unaligned access"},
+	{25, "DIVBYZERO", "division by zero"},
+}
+
+var hfsrBits = []bitDescr{
+	{1, "VECTTBL", "BusFault on vector table read"},
+	{30, "FORCED", "escalated configurable fault, see CFSR"},
+	{31, "DEBUGEVT", "debug event"},
+}
+
+func (a *analyzer) printBits(v uint64, bits []bitDescr) {
+	for _, b := range bits {
+		if v>>b.bit&1 != 0 {
+			a.printf("    %-11s %s", b.name, b.descr)
+		}
+	}
+}
+
+var cortexmFrame = []string{"r0", "r1", "r2", "r3", "r12", "lr", "pc", "xpsr"}
+
+// cortexM decodes the Cortex-M fault status registers and the exception
+// stack frame. It returns the state of the interrupted code.
+func (a *analyzer) cortexM() (st state) {
+	d := a.d
+	excRet, haveExcRet := d.reg("exc_return")
+	if lr, ok := d.reg("lr"); ok && lr>>8 == 0xffffff {
+		// The LR of the exception handler.
+		excRet, haveExcRet = lr, true
+		delete(d.regs, "lr")
+	}
+	if hfsr, ok := d.reg("hfsr"); ok {
+		a.printf("HFSR        0x%08x", hfsr)
+		a.printBits(hfsr, hfsrBits)
+	}
+	cfsr, haveCFSR := d.reg("cfsr")
+	if haveCFSR {
+		a.printf("CFSR        0x%08x", cfsr)
+		a.printBits(cfsr, cfsrBits)
+	}
+	if v, ok := d.reg("mmfar"); ok && (!haveCFSR || cfsr&(1<<7) != 0) {
+		a.printf("MMFAR       0x%08x  %s", v, a.sym(v))
+	}
+	if v, ok := d.reg("bfar"); ok && (!haveCFSR || cfsr&(1<<15) != 0) {
+		a.printf("BFAR        0x%08x  %s", v, a.sym(v))
+	}
+
+	// Find the exception stack frame.
+	frameSize := uint64(0x20)
+	sp, haveSP := d.reg("sp")
+	if haveExcRet {
+		mode, stack, frame := "Handler", "MSP", "basic"
+		if excRet&(1<<3) != 0 {
+			mode = "Thread"
+		}
+		if excRet&(1<<2) != 0 {
+			stack = "PSP"
+			if v, ok := d.reg("psp"); ok {
+				sp, haveSP = v, true
+			}
+		} else if v, ok := d.reg("msp"); ok {
+			sp, haveSP = v, true
+		}
+		if excRet&(1<<4) == 0 {
+			frame = "extended (FPU)"
+			frameSize = 0x68
+		}
+		a.printf(
+			"EXC_RETURN  0x%08x  %s mode, %s, %s frame",
+			excRet, mode, stack, frame,
+		)
+	} else if !haveSP {
+		sp, haveSP = d.reg("psp")
+	}
+	var frame [8]uint64
+	haveFrame := haveSP
+	for i := range frame {
+		if haveFrame {
+			frame[i], haveFrame = d.read(sp+uint64(i)*4, 4)
+		}
+	}
+	a.printf("")
+	if haveFrame {
+		a.printf("Exception stack frame at 0x%08x:", sp)
+		for i, name := range cortexmFrame {
+			d.regs[name] = frame[i]
+		}
+		if frame[7]&(1<<9) != 0 {
+			frameSize += 4 // stack realignment
+		}
+		st.sp, st.haveSP = sp+frameSize, true
+	} else {
+		a.printf("Registers (no exception stack frame in the dump):")
+		st.sp, st.haveSP = sp, haveSP
+	}
+	for _, name := range cortexmFrame {
+		v, ok := d.reg(name)
+		if !ok {
+			continue
+		}
+		s := ""
+		switch name {
+		case "pc", "lr":
+			s = a.sym(v)
+		case "xpsr":
+			if ipsr := v & 0x1ff; ipsr != 0 {
+				s = fmt.Sprintf("exception %d was active", ipsr)
+			}
+		}
+		a.printf("  %-4s      0x%08x  %s", name, v, s)
+	}
+	if haveFrame {
+		a.printf("  sp        0x%08x  before the exception", st.sp)
+	} else if st.haveSP {
+		a.printf("  sp        0x%08x", st.sp)
+	}
+	st.pc, st.havePC = d.reg("pc")
+	st.lr, st.haveLR = d.reg("lr")
+	return st
+}
@@ -0,0 +1,122 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fault
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// dump contains the registers and the memory read from the fault dump.
+type dump struct {
+	regs map[string]uint64
+	mem  []memBlock
+}
+
+type memBlock struct {
+	addr uint64
+	data []byte
+}
+
+func newDump() *dump {
+	return &dump{regs: make(map[string]uint64)}
+}
+
+// reg returns the value of the first register found in the dump of the
+// provided names.
+func (d *dump) reg(names ...string) (uint64, bool) {
+	for _, name := range names {
+		if v, ok := d.regs[name]; ok {
+			return v, true
+		}
+	}
+	return 0, false
+}
+
+// read reads the little-endian word of size bytes at addr.
+func (d *dump) read(addr uint64, size int) (uint64, bool) {
+	for i := len(d.mem) - 1; i >= 0; i-- {
+		m := &d.mem[i]
+		if addr < m.addr || addr-m.addr > uint64(len(m.data)-size) ||
+			len(m.data) < size {
+			continue
+		}
+		b := m.data[addr-m.addr:]
+		if size == 8 {
+			return binary.LittleEndian.Uint64(b), true
+		}
+		return uint64(binary.LittleEndian.Uint32(b)), true
+	}
+	return 0, false
+}
+
+// addMem adds the memory content. The later added blocks take precedence.
+func (d *dump) addMem(addr uint64, data []byte) {
+	if len(data) != 0 {
+		d.mem = append(d.mem, memBlock{addr, data})
+	}
+}
+
+var (
+	// memRE matches the memory dump lines (ADDR: WORD WORD ...).
+	memRE = regexp.MustCompile(
+		`^\s*(?:0x)?([0-9a-fA-F]{8}|[0-9a-fA-F]{16})\s*:((?:\s+(?:0x)?(?:[0-9a-fA-F]{8}|[0-9a-fA-F]{16}))+)\s*$`,
+	)
+	// regRE matches the register values (NAME=0xHEX, NAME: HEX, etc.).
+	regRE = regexp.MustCompile(
+		`\b([A-Za-z][A-Za-z0-9_]*)\s*[:=]?\s*(?:0x([0-9a-fA-F]{1,16})|([0-9a-fA-F]{8}|[0-9a-fA-F]{16}))\b`,
+	)
+)
+
+// aliases maps the alternative register names to the ones used by the
+// decoders.
+var aliases = map[string]string{
+	"r13": "sp", "r14": "lr", "r15": "pc", "psr": "xpsr",
+	"excret": "exc_return", "exc_ret": "exc_return",
+	"x1": "ra", "x2": "sp",
+}
+
+// parseText parses the textual dump (e.g. a serial log). It collects the
+// register values in many popular notations and the hexadecimal memory dump
+// lines.
+func (d *dump) parseText(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		line := s.Text()
+		if m := memRE.FindStringSubmatch(line); m != nil {
+			addr, _ := strconv.ParseUint(m[1], 16, 64)
+			var data []byte
+			for _, w := range strings.Fields(m[2]) {
+				w = strings.TrimPrefix(w, "0x")
+				v, _ := strconv.ParseUint(w, 16, 64)
+				if len(w) == 8 {
+					data = binary.LittleEndian.AppendUint32(data, uint32(v))
+				} else {
+					data = binary.LittleEndian.AppendUint64(data, v)
+				}
+			}
+			d.addMem(addr, data)
+			continue
+		}
+		for _, m := range regRE.FindAllStringSubmatch(line, -1) {
+			val := m[2] + m[3]
+			v, err := strconv.ParseUint(val, 16, 64)
+			if err != nil {
+				continue
+			}
+			name := strings.ToLower(m[1])
+			if alias, ok := aliases[name]; ok {
+				name = alias
+			}
+			d.regs[name] = v
+		}
+	}
+	return s.Err()
+}
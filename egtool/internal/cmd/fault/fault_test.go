@@ -0,0 +1,476 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fault
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/embeddedgo/tools/egtool/internal/elfsym"
+)
+
+func parse(t *testing.T, text string) *dump {
+	t.Helper()
+	d := newDump()
+	if err := d.parseText(strings.NewReader(text)); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestParseText(t *testing.T) {
+	tests := []struct {
+		text string
+		regs map[string]uint64
+	}{
+		{"pc=0x08001234", map[string]uint64{"pc": 0x0800_1234}},
+		{"PC: 08001234", map[string]uint64{"pc": 0x0800_1234}},
+		{"r0 0x0  R1=0x1f", map[string]uint64{"r0": 0, "r1": 0x1f}},
+		{
+			"R13=0x20001000 r14: fffffffd R15 = 0x08000100",
+			map[string]uint64{"sp": 0x2000_1000, "lr": 0xffff_fffd, "pc": 0x0800_0100},
+		},
+		{"EXCRET=0xffffffe9", map[string]uint64{"exc_return": 0xffff_ffe9}},
+		{
+			"x1: 0000000080001234 x2: 0000000080002000",
+			map[string]uint64{"ra": 0x8000_1234, "sp": 0x8000_2000},
+		},
+		{"mcause: 8000000000000007", map[string]uint64{"mcause": 1<<63 | 7}},
+		{"panic: index out of range 123", map[string]uint64{}},
+	}
+	for _, tc := range tests {
+		d := parse(t, tc.text)
+		if fmt.Sprint(d.regs) != fmt.Sprint(tc.regs) {
+			t.Errorf("%q: got %x, want %x", tc.text, d.regs, tc.regs)
+		}
+		if len(d.mem) != 0 {
+			t.Errorf("%q: memory: %v", tc.text, d.mem)
+		}
+	}
+}
+
+func TestParseMem(t *testing.T) {
+	d := parse(t, `
+20000000: 00000001 0x00000002 00000003
+0x20000008: 000000ff
+0000000080000000: 1122334455667788
+20000100: 0000000
+`)
+	if len(d.regs) != 0 {
+		t.Errorf("registers: %x", d.regs)
+	}
+	tests := []struct {
+		addr uint64
+		size int
+		val  uint64
+		ok   bool
+	}{
+		{0x2000_0000, 4, 1, true},
+		{0x2000_0004, 4, 2, true},
+		{0x2000_0008, 4, 0xff, true}, // the later line overrides
+		{0x2000_0000, 8, 2<<32 | 1, true},
+		{0x2000_000c, 4, 0, false},
+		{0x2000_000a, 4, 0, false},
+		{0x1fff_fffc, 4, 0, false},
+		{0x2000_0100, 4, 0, false}, // 7 digits: not a memory line
+		{0x8000_0000, 8, 0x1122_3344_5566_7788, true},
+		{0x8000_0004, 4, 0x1122_3344, true},
+	}
+	for _, tc := range tests {
+		v, ok := d.read(tc.addr, tc.size)
+		if v != tc.val || ok != tc.ok {
+			t.Errorf(
+				"read(%#x, %d): %#x, %t, want %#x, %t",
+				tc.addr, tc.size, v, ok, tc.val, tc.ok,
+			)
+		}
+	}
+}
+
+// analyze runs the decoder selected by the machine of tab on the dump and
+// returns the printed lines.
+func analyze(t *testing.T, tab *elfsym.Table, text string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	a := &analyzer{w: &buf, d: parse(t, text), tab: tab}
+	if tab.Machine == elf.EM_ARM {
+		a.cortexM()
+	} else {
+		a.riscv()
+	}
+	return buf.String()
+}
+
+// checkOutput checks that out contains all lines from want (as substrings of
+// the output lines, in order) and doesn't contain any of notWant.
+func checkOutput(t *testing.T, name, out string, want, notWant []string) {
+	t.Helper()
+	rest := out
+	for _, w := range want {
+		i := strings.Index(rest, w)
+		if i < 0 {
+			t.Errorf("%s: no %q in the output:\n%s", name, w, out)
+			return
+		}
+		rest = rest[i+len(w):]
+	}
+	for _, w := range notWant {
+		if strings.Contains(out, w) {
+			t.Errorf("%s: unexpected %q in the output:\n%s", name, w, out)
+		}
+	}
+}
+
+func TestCortexM(t *testing.T) {
+	tab := &elfsym.Table{Machine: elf.EM_ARM, Class: elf.ELFCLASS32}
+	tests := []struct {
+		name    string
+		dump    string
+		want    []string
+		notWant []string
+	}{
+		{
+			"bus-fault",
+			`*** HardFault ***
+HFSR=0x40000000 CFSR=0x00008200 BFAR=0x40021000 MMFAR=0xe000edf4
+EXC_RETURN=0xfffffffd MSP=0x20001fd0 PSP=0x20000f80
+20000f80: 00000001 00000002 00000003 00000004
+20000f90: 0000000c 08000341 08000352 21000000
+`,
+			[]string{
+				"HFSR        0x40000000",
+				"    FORCED      escalated configurable fault",
+				"CFSR        0x00008200",
+				"    PRECISERR   precise data bus error",
+				"    BFARVALID   BFAR holds the fault address",
+				"BFAR        0x40021000",
+				"EXC_RETURN  0xfffffffd  Thread mode, PSP, basic frame",
+				"Exception stack frame at 0x20000f80:",
+				"  r0        0x00000001",
+				"  r12       0x0000000c",
+				"  lr        0x08000341",
+				"  pc        0x08000352",
+				"  xpsr      0x21000000\n",
+				"  sp        0x20000fa0  before the exception",
+			},
+			[]string{"MMFAR", "IMPRECISERR", "DEBUGEVT"},
+		},
+		{
+			"handler-lr",
+			`CFSR: 02000000
+LR: FFFFFFE9
+MSP: 20002000 PSP: 20000800
+20002000: 00000000 00000000 00000000 00000000
+20002010: 00000000 08000411 08000420 21000203
+`,
+			[]string{
+				"CFSR        0x02000000",
+				"    DIVBYZERO   division by zero",
+				"EXC_RETURN  0xffffffe9  Thread mode, MSP, extended (FPU) frame",
+				"Exception stack frame at 0x20002000:",
+				"  lr        0x08000411",
+				"  pc        0x08000420",
+				"  xpsr      0x21000203  exception 3 was active",
+				"  sp        0x2000206c  before the exception", // 0x68 + 4
+			},
+			[]string{"HFSR", "BFAR"},
+		},
+		{
+			"handler-mode",
+			"exc_return=0xfffffff1 msp=0x20003000 hfsr=0x00000002\n" +
+				"20003000: 00000000 00000000 00000000 00000000\n" +
+				"20003010: 00000000 00000000 00000100 01000000\n",
+			[]string{
+				"HFSR        0x00000002",
+				"    VECTTBL     BusFault on vector table read",
+				"EXC_RETURN  0xfffffff1  Handler mode, MSP, basic frame",
+				"  sp        0x20003020  before the exception",
+			},
+			[]string{"CFSR"},
+		},
+		{
+			"no-frame",
+			"cfsr=0x00000082 mmfar=0x00000004 pc=0x08000100 lr=0x08000200 sp=0x20000000",
+			[]string{
+				"CFSR        0x00000082",
+				"    DACCVIOL    data access violation",
+				"    MMARVALID   MMFAR holds the fault address",
+				"MMFAR       0x00000004",
+				"Registers (no exception stack frame in the dump):",
+				"  lr        0x08000200",
+				"  pc        0x08000100",
+				"  sp        0x20000000\n",
+			},
+			[]string{"EXC_RETURN", "before the exception", "r0"},
+		},
+	}
+	for _, tc := range tests {
+		checkOutput(t, tc.name, analyze(t, tab, tc.dump), tc.want, tc.notWant)
+	}
+}
+
+func TestRISCV(t *testing.T) {
+	rv64 := &elfsym.Table{Machine: elf.EM_RISCV, Class: elf.ELFCLASS64}
+	rv32 := &elfsym.Table{Machine: elf.EM_RISCV, Class: elf.ELFCLASS32}
+	tests := []struct {
+		name    string
+		tab     *elfsym.Table
+		dump    string
+		want    []string
+		notWant []string
+	}{
+		{
+			"load-fault", rv64,
+			"mcause=0x5 mtval=0x0000000000000010 mstatus=0x0000000a00001880\n" +
+				"mepc=0x0000000080001234 ra=0x0000000080001000 sp=0x0000000080100000\n",
+			[]string{
+				"mcause   0x0000000000000005  load access fault",
+				"mtval    0x0000000000000010  fault address",
+				"mstatus  0x0000000a00001880  previous privilege: M-mode",
+				"Registers:",
+				"  pc     0x0000000080001234",
+				"  ra     0x0000000080001000",
+				"  sp     0x0000000080100000",
+			},
+			nil,
+		},
+		{
+			"illegal-instruction", rv64,
+			"MCAUSE: 0000000000000002 MTVAL: 0000000000000073 MSTATUS: 0000000000000080",
+			[]string{
+				"mcause   0x0000000000000002  illegal instruction",
+				"mtval    0x0000000000000073  instruction bits",
+				"previous privilege: U-mode",
+			},
+			[]string{"  pc", "  ra", "  sp"},
+		},
+		{
+			"timer-interrupt", rv64,
+			"mcause: 8000000000000007 mtval: 0000000000000123 sepc: 0000000080000100",
+			[]string{
+				"mcause   0x8000000000000007  machine timer interrupt",
+				"  pc     0x0000000080000100",
+			},
+			[]string{"mtval"},
+		},
+		{
+			"unknown", rv64,
+			"cause=0x18 tval=0x4",
+			[]string{
+				"mcause   0x0000000000000018  exception 24",
+				"mtval    0x0000000000000004\n",
+			},
+			nil,
+		},
+		{
+			"rv32-interrupt", rv32,
+			"mcause=0x8000000b mepc=0x20000100 x2=0x80000ff0",
+			[]string{
+				"mcause   0x8000000b  machine external interrupt",
+				"  pc     0x20000100",
+				"  sp     0x80000ff0",
+			},
+			nil,
+		},
+		{
+			"rv32-store-fault", rv32,
+			"mcause=0x00000007 mbadaddr=0x00000000",
+			[]string{
+				"mcause   0x00000007  store/AMO access fault",
+				"mtval    0x00000000  fault address",
+			},
+			nil,
+		},
+	}
+	for _, tc := range tests {
+		checkOutput(t, tc.name, analyze(t, tc.tab, tc.dump), tc.want, tc.notWant)
+	}
+}
+
+const prog = `package main
+
+import "os"
+
+//go:noinline
+func h(x int) int {
+	return x * 3
+}
+
+//go:noinline
+func g(x int) int {
+	return h(x+1) + h(x+2)
+}
+
+func main() {
+	os.Exit(g(len(os.Args)))
+}
+`
+
+// buildARM builds prog for linux/arm and returns the path of the ELF file.
+func buildARM(t *testing.T) string {
+	t.Helper()
+	gocmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":  "module test\n\ngo 1.22\n",
+		"main.go": prog,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(gocmd, "build", "-o", "test.elf")
+	cmd.Dir = dir
+	cmd.Env = append(
+		os.Environ(), "GOOS=linux", "GOARCH=arm", "CGO_ENABLED=0",
+		"GOTOOLCHAIN=local", "GOFLAGS=",
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	return filepath.Join(dir, "test.elf")
+}
+
+// funcSym returns the ELF symbol of the function.
+func funcSym(t *testing.T, f *elf.File, name string) elf.Symbol {
+	t.Helper()
+	syms, err := f.Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range syms {
+		if s.Name == name {
+			return s
+		}
+	}
+	t.Fatalf("no %s symbol", name)
+	return elf.Symbol{}
+}
+
+// retAddr returns the return address of the first BL instruction in the
+// caller function that calls the callee one.
+func retAddr(t *testing.T, f *elf.File, caller, callee string) uint64 {
+	t.Helper()
+	from, to := funcSym(t, f, caller), funcSym(t, f, callee)
+	text := f.Section(".text")
+	code := make([]byte, from.Size)
+	if _, err := text.ReadAt(code, int64(from.Value-text.Addr)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+4 <= len(code); i += 4 {
+		ins := binary.LittleEndian.Uint32(code[i:])
+		if ins>>24 != 0xeb {
+			continue
+		}
+		pc := from.Value + uint64(i)
+		if pc+8+uint64(int64(int32(ins<<8)>>6)) == to.Value {
+			return pc + 4
+		}
+	}
+	t.Fatalf("%s doesn't call %s", caller, callee)
+	return 0
+}
+
+func TestUnwind(t *testing.T) {
+	name := buildARM(t)
+	tab, err := elfsym.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := elf.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	h := funcSym(t, f, "main.h").Value
+	retG := retAddr(t, f, "main.g", "main.h")
+	retMain := retAddr(t, f, "main.main", "main.g")
+	gFrame, ok := tab.SPDelta(retG - 1)
+	if !ok || gFrame == 0 {
+		t.Fatalf("main.g frame size: %d, %t", gFrame, ok)
+	}
+	// The leaf main.h doesn't save LR, the main.g saved its LR at its stack
+	// frame bottom which is the SP in main.h. The zero return address in the
+	// main.main frame ends the unwinding.
+	const sp = 0x2000_1000
+	stack := make([]byte, 0x200)
+	binary.LittleEndian.PutUint32(stack, uint32(retMain))
+
+	tests := []struct {
+		name    string
+		st      state
+		mem     []byte
+		want    []string
+		notWant []string
+	}{
+		{
+			"leaf",
+			state{pc: h, lr: retG, sp: sp, havePC: true, haveLR: true, haveSP: true},
+			stack,
+			[]string{
+				"Go stack:",
+				fmt.Sprintf("  #0  0x%08x  main.h ", h),
+				fmt.Sprintf("  #1  0x%08x  main.g ", retG),
+				fmt.Sprintf("  #2  0x%08x  main.main ", retMain),
+			},
+			[]string{"#3", "...", "unknown", "no stack memory"},
+		},
+		{
+			"bad-call",
+			state{pc: 0, lr: retG, sp: sp, havePC: true, haveLR: true, haveSP: true},
+			stack,
+			[]string{
+				"  #0  0x00000000  ?",
+				fmt.Sprintf("  #1  0x%08x  main.g ", retG),
+				fmt.Sprintf("  #2  0x%08x  main.main ", retMain),
+			},
+			[]string{"#3"},
+		},
+		{
+			"no-memory",
+			state{pc: h, lr: retG, sp: sp, havePC: true, haveLR: true, haveSP: true},
+			nil,
+			[]string{
+				"  #0",
+				"  #1",
+				"  (no stack memory at 0x20001000 in the dump)",
+			},
+			[]string{"#2"},
+		},
+		{
+			"no-sp",
+			state{pc: h, lr: retG, havePC: true, haveLR: true},
+			stack,
+			[]string{"  #0", "  (unknown stack frame)"},
+			[]string{"#1"},
+		},
+		{
+			"no-pc",
+			state{lr: retG, sp: sp, haveLR: true, haveSP: true},
+			stack,
+			[]string{"No PC in the dump, cannot unwind the stack."},
+			[]string{"Go stack"},
+		},
+	}
+	for _, tc := range tests {
+		d := newDump()
+		d.addMem(sp, tc.mem)
+		var buf bytes.Buffer
+		a := &analyzer{w: &buf, d: d, tab: tab}
+		a.unwind(tc.st)
+		checkOutput(t, tc.name, buf.String(), tc.want, tc.notWant)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fault
+
+import (
+	"debug/elf"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/embeddedgo/tools/egtool/internal/elfsym"
+	"github.com/embeddedgo/tools/egtool/internal/util"
+)
+
+const Descr = "decode the hard fault dump and unwind the Go stack"
+
+const help = `
+The fault command explains the crash of the program using the register
+values and the memory content captured on the device (e.g. printed by the
+fault handler to the serial console) without attaching a debugger.
+
+The DUMP is a text file (the standard input by default). The register values
+are accepted in most popular notations (pc=0x08001234, PC: 08001234, r0 0x0).
+Lines in the ADDR: WORD WORD ... form are read as the memory content (e.g. a
+stack dump). A raw RAM image can be provided using the -ram option.
+
+For Cortex-M the CFSR, HFSR, MMFAR, BFAR and EXC_RETURN (or the handler's LR)
+registers are decoded and the exception stack frame is read from the memory
+at MSP/PSP. For RISC-V the mcause, mtval, mstatus, mepc, ra and sp registers
+are used. The Go stack is unwound using the frame sizes from the pclntab of
+the ELF file.
+`
+
+// regFlag collects the -reg NAME=VALUE options.
+type regFlag map[string]uint64
+
+func (r regFlag) String() string { return "" }
+
+func (r regFlag) Set(s string) error {
+	name, val, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf("bad register assignment: %s", s)
+	}
+	v, err := strconv.ParseUint(val, 0, 64)
+	if err != nil {
+		return err
+	}
+	r[strings.ToLower(name)] = v
+	return nil
+}
+
+func Main(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(
+			os.Stderr,
+			"Usage:\n  %s [OPTIONS] ELF [DUMP]\n%s\nOptions:\n",
+			cmd, help,
+		)
+		fs.PrintDefaults()
+	}
+	ram := fs.String("ram", "", "read the raw RAM image from `FILE`")
+	ramAddr := fs.String(
+		"ramaddr", "",
+		"RAM image start `ADDRESS`, the default is the address of the\n"+
+			"lowest writable section of the ELF",
+	)
+	regs := make(regFlag)
+	fs.Var(
+		regs, "reg",
+		"set the register value (`NAME=VALUE`), can be repeated, overrides\n"+
+			"the values read from the DUMP",
+	)
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	elfFile := fs.Arg(0)
+	tab, err := elfsym.Open(elfFile)
+	util.FatalErr("", err)
+
+	d := newDump()
+	dumpFile := fs.Arg(1)
+	if dumpFile != "" || *ram == "" && len(regs) == 0 {
+		var r io.Reader = os.Stdin
+		if dumpFile != "" && dumpFile != "-" {
+			f, err := os.Open(dumpFile)
+			util.FatalErr("", err)
+			defer f.Close()
+			r = f
+		}
+		util.FatalErr(dumpFile, d.parseText(r))
+	}
+	if *ram != "" {
+		data, err := os.ReadFile(*ram)
+		util.FatalErr("", err)
+		var addr uint64
+		if *ramAddr != "" {
+			addr, err = strconv.ParseUint(*ramAddr, 0, 64)
+			util.FatalErr("-ramaddr", err)
+		} else {
+			addr, err = lowestRAM(elfFile)
+			util.FatalErr("", err)
+		}
+		d.addMem(addr, data)
+	}
+	for name, v := range regs {
+		if alias, ok := aliases[name]; ok {
+			name = alias
+		}
+		d.regs[name] = v
+	}
+
+	a := &analyzer{w: os.Stdout, d: d, tab: tab}
+	var st state
+	switch tab.Machine {
+	case elf.EM_ARM:
+		st = a.cortexM()
+	case elf.EM_RISCV:
+		st = a.riscv()
+	default:
+		util.Fatal("%s: unsupported architecture: %v", elfFile, tab.Machine)
+	}
+	a.unwind(st)
+}
+
+// lowestRAM returns the address of the lowest writable section of the ELF.
+func lowestRAM(name string) (uint64, error) {
+	f, err := elf.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	addr, found := uint64(0), false
+	for _, s := range f.Sections {
+		flags := elf.SHF_ALLOC | elf.SHF_WRITE
+		if s.Flags&flags == flags && (!found || s.Addr < addr) {
+			addr, found = s.Addr, true
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("%s: no writable sections, use -ramaddr", name)
+	}
+	return addr, nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fault
+
+import (
+	"fmt"
+)
+
+var riscvExceptions = map[uint64]string{
+	0:  "instruction address misaligned",
+	1:  "instruction access fault",
+	2:  "illegal instruction",
+	3:  "breakpoint",
+	4:  "load address misaligned",
+	5:  "load access fault",
+	6:  "store/AMO address misaligned",
+	7:  "store/AMO access fault",
+	8:  "environment call from U-mode",
+	9:  "environment call from S-mode",
+	11: "environment call from M-mode",
+	12: "instruction page fault",
+	13: "load page fault",
+	15: "store/AMO page fault",
+	18: "software check",
+	19: "hardware error",
+}
+
+var riscvInterrupts = map[uint64]string{
+	1:  "supervisor software interrupt",
+	3:  "machine software interrupt",
+	5:  "supervisor timer interrupt",
+	7:  "machine timer interrupt",
+	9:  "supervisor external interrupt",
+	11: "machine external interrupt",
+	13: "counter overflow interrupt",
+}
+
+var riscvPriv = [4]string{"U", "S", "reserved", "M"}
+
+// riscv decodes the RISC-V trap CSRs. It returns the state of the
+// interrupted code.
+func (a *analyzer) riscv() (st state) {
+	d := a.d
+	xlen := uint(a.tab.PtrSize() * 8)
+	mcause, haveCause := d.reg("mcause", "scause", "cause")
+	if haveCause {
+		intr := mcause>>(xlen-1) != 0
+		code := mcause &^ (1 << (xlen - 1))
+		var s string
+		if intr {
+			s = riscvInterrupts[code]
+			if s == "" {
+				s = fmt.Sprintf("interrupt %d", code)
+			}
+		} else if s = riscvExceptions[code]; s == "" {
+			s = fmt.Sprintf("exception %d", code)
+		}
+		a.printf("mcause   %s  %s", a.hex(mcause), s)
+		if mtval, ok := d.reg("mtval", "stval", "tval", "mbadaddr"); ok && !intr {
+			switch code {
+			case 0, 1, 4, 5, 6, 7, 12, 13, 15:
+				a.printf(
+					"mtval    %s  fault address %s", a.hex(mtval),
+					a.sym(mtval),
+				)
+			case 2:
+				a.printf("mtval    %s  instruction bits", a.hex(mtval))
+			default:
+				a.printf("mtval    %s", a.hex(mtval))
+			}
+		}
+	}
+	if mstatus, ok := d.reg("mstatus"); ok {
+		a.printf(
+			"mstatus  %s  previous privilege: %s-mode", a.hex(mstatus),
+			riscvPriv[mstatus>>11&3],
+		)
+	}
+	a.printf("")
+	a.printf("Registers:")
+	st.pc, st.havePC = d.reg("mepc", "sepc", "epc", "pc")
+	st.lr, st.haveLR = d.reg("ra")
+	st.sp, st.haveSP = d.reg("sp")
+	if st.havePC {
+		a.printf("  pc     %s  %s", a.hex(st.pc), a.sym(st.pc))
+	}
+	if st.haveLR {
+		a.printf("  ra     %s  %s", a.hex(st.lr), a.sym(st.lr))
+	}
+	if st.haveSP {
+		a.printf("  sp     %s", a.hex(st.sp))
+	}
+	return st
+}
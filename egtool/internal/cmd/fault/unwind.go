@@ -0,0 +1,117 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fault
+
+import (
+	"debug/elf"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/embeddedgo/tools/egtool/internal/elfsym"
+)
+
+// state is the state of the interrupted code.
+type state struct {
+	pc, lr, sp             uint64
+	havePC, haveLR, haveSP bool
+}
+
+type analyzer struct {
+	w   io.Writer
+	d   *dump
+	tab *elfsym.Table
+	n   int // number of printed lines
+}
+
+// sym returns the source position of addr or an empty string if addr isn't
+// a code address.
+func (a *analyzer) sym(addr uint64) string {
+	if pos, ok := a.tab.Lookup(addr); ok {
+		return pos.String()
+	}
+	return ""
+}
+
+// printf prints the line without the trailing spaces of the empty columns.
+// The leading empty lines are omitted.
+func (a *analyzer) printf(format string, args ...any) {
+	s := strings.TrimRight(fmt.Sprintf(format, args...), " ")
+	if s == "" && a.n == 0 {
+		return
+	}
+	fmt.Fprintln(a.w, s)
+	a.n++
+}
+
+func (a *analyzer) hex(v uint64) string {
+	return fmt.Sprintf("0x%0*x", a.tab.PtrSize()*2, v)
+}
+
+const maxFrames = 100
+
+// unwind prints the Go call stack. It uses the frame sizes from the pclntab
+// and, as all Go ports for the link register architectures do, assumes the
+// return address is saved at the bottom of the allocated stack frame.
+func (a *analyzer) unwind(st state) {
+	tab := a.tab
+	a.printf("")
+	if !st.havePC {
+		a.printf("No PC in the dump, cannot unwind the stack.")
+		return
+	}
+	a.printf("Go stack:")
+	ptrSize := tab.PtrSize()
+	pc, sp, lr := st.pc, st.sp, st.lr
+	haveLR := st.haveLR
+	for i := 0; i < maxFrames; i++ {
+		if tab.Machine == elf.EM_ARM {
+			pc &^= 1
+		}
+		lookupPC := pc
+		if i > 0 {
+			lookupPC-- // call instruction
+		}
+		pos, ok := tab.Lookup(lookupPC)
+		if !ok {
+			a.printf("  #%-2d %s  ?", i, a.hex(pc))
+			if i != 0 || !haveLR {
+				return
+			}
+			// Bad jump or call. Continue from its return address.
+			pc, haveLR = lr, false
+			continue
+		}
+		a.printf("  #%-2d %s  %s", i, a.hex(pc), pos)
+		if pos.Func == "runtime.goexit" || pos.Func == "runtime.mstart" ||
+			pos.Func == "runtime.rt0_go" || strings.HasPrefix(pos.Func, "_rt0") {
+			return
+		}
+		delta, ok := tab.SPDelta(lookupPC)
+		if !ok || !st.haveSP {
+			a.printf("  (unknown stack frame)")
+			return
+		}
+		var ret uint64
+		switch {
+		case delta == 0 && i == 0 && haveLR:
+			ret = lr // leaf function or prologue
+		case delta == 0:
+			return // function that has not called anything
+		default:
+			if ret, ok = a.d.read(sp, ptrSize); !ok {
+				a.printf(
+					"  (no stack memory at %s in the dump)", a.hex(sp),
+				)
+				return
+			}
+		}
+		if ret == 0 {
+			return
+		}
+		pc, sp = ret, sp+uint64(delta)
+	}
+	a.printf("  ...")
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/embeddedgo/tools/egtool/internal/util"
 	"github.com/marcinbor85/gohex"
@@ -30,12 +31,15 @@ func Main(cmd string, args []string) {
 		"inc", "",
 		"binary files to be included BIN1:ADDR1[,BIN2:ADDR2[,...]]",
 	)
+	jsonOut := fs.Bool("json", false, "report the result as a JSON event on stdout")
 	fs.Parse(args)
+	util.JSON = *jsonOut
 	if fs.NArg() > 2 {
 		fs.Usage()
 		os.Exit(1)
 	}
 	elf, out := util.InOutFiles(fs.Arg(0), ".elf", fs.Arg(1), ".hex")
+	t0 := time.Now()
 	sections, err := util.ReadELF(elf)
 	util.FatalErr("readelf", err)
 	if *inc != "" {
@@ -53,4 +57,9 @@ func Main(cmd string, args []string) {
 	defer of.Close()
 	err = mem.DumpIntelHex(of, 16)
 	util.FatalErr("dumpintelhex", err)
+	if util.JSON {
+		e := util.DoneEvent("", t0)
+		e.Name, e.Total = out, int(sections.Size())
+		util.Emit(e)
+	}
 }
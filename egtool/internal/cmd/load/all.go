@@ -0,0 +1,129 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package load
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/cmsisdap"
+	"github.com/embeddedgo/tools/egtool/internal/dfu"
+	"github.com/embeddedgo/tools/egtool/internal/halfkay"
+	"github.com/embeddedgo/tools/egtool/internal/picoboot"
+	"github.com/embeddedgo/tools/egtool/internal/stlink"
+	"github.com/embeddedgo/tools/egtool/internal/usbdev"
+	"github.com/embeddedgo/tools/egtool/internal/util"
+)
+
+// listDevs returns all devices in the bootloader mode that can be programmed
+// using the o.target method.
+func listDevs(o *options) ([]usbdev.Info, error) {
+	switch o.target {
+	case "pico":
+		return picoboot.List()
+	case "teensy":
+		return halfkay.List()
+	case "stm32":
+		return dfu.List(0x0483, 0xdf11)
+	case "dfu":
+		return dfu.List(o.vendor, o.product)
+	case "swd":
+		return cmsisdap.List()
+	case "stlink":
+		return stlink.List()
+	}
+	return nil, fmt.Errorf("the %s target doesn't support the -all option", o.target)
+}
+
+type result struct {
+	dev usbdev.Info
+	err error
+	dt  time.Duration
+}
+
+// errNoDevs is returned by loadAll if there are no devices to load onto.
+var errNoDevs = errors.New("no devices in the bootloader mode were found")
+
+// loadAll loads the program concurrently onto all devices in the bootloader
+// mode and prints the summary. It returns false if any device failed.
+func loadAll(o *options, quiet bool) (bool, error) {
+	devs, err := listDevs(o)
+	if err != nil {
+		return false, err
+	}
+	if len(devs) == 0 {
+		return false, errNoDevs
+	}
+	// The rebooted devices enumerate again at unknown locations.
+	o.reboot = false
+
+	mu := new(sync.Mutex)
+	results := make([]result, len(devs))
+	var wg sync.WaitGroup
+	for i, dev := range devs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			j := &job{
+				busAddr: dev.BusAddr, serial: dev.Serial, quiet: quiet,
+				label: dev.BusAddr, mu: mu,
+			}
+			t0 := time.Now()
+			err := o.load(j)
+			j.result(err, t0)
+			results[i] = result{dev, err, time.Since(t0)}
+			if err != nil {
+				j.printf("%v\n", err)
+			}
+		}()
+	}
+	wg.Wait()
+	return printSummary(results), nil
+}
+
+func serial(dev usbdev.Info) string {
+	if dev.Serial == "" {
+		return "-"
+	}
+	return dev.Serial
+}
+
+func resultStr(err error) string {
+	if err != nil {
+		return "FAIL: " + err.Error()
+	}
+	return "ok"
+}
+
+// printSummary prints the per-device results (nothing in the JSON mode). It
+// returns false if any device failed.
+func printSummary(results []result) bool {
+	ok := true
+	if util.JSON {
+		for _, r := range results {
+			if r.err != nil {
+				ok = false
+			}
+		}
+		return ok
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "DEVICE\tSERIAL\tTIME\tRESULT\n")
+	for _, r := range results {
+		if r.err != nil {
+			ok = false
+		}
+		fmt.Fprintf(
+			tw, "%s\t%s\t%.1fs\t%s\n",
+			r.dev.BusAddr, serial(r.dev), r.dt.Seconds(), resultStr(r.err),
+		)
+	}
+	tw.Flush()
+	return ok
+}
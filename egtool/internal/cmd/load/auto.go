@@ -25,6 +25,9 @@ func auto(name string) string {
 		if s.Name == "picometa" {
 			return "pico"
 		}
+		if strings.HasPrefix(s.Name, "github.com/embeddedgo/rp2040/hal/") {
+			return "pico"
+		}
 		if strings.HasPrefix(s.Name, "github.com/embeddedgo/imxrt/hal/") {
 			return "teensy"
 		}
@@ -32,5 +35,27 @@ func auto(name string) string {
 			return "stm32"
 		}
 	}
+	// RP2040 image that starts with a valid boot2 stage.
+	if f.Machine == elf.EM_ARM {
+		for _, p := range f.Progs {
+			if p.Type != elf.PT_LOAD || p.Paddr != boot2Addr || p.Filesz < boot2Size {
+				continue
+			}
+			boot2 := make([]byte, boot2Size)
+			if _, err := p.ReadAt(boot2, 0); err == nil && validBoot2(boot2) {
+				return "pico"
+			}
+		}
+	}
 	return ""
 }
+
+// elfHeader returns the machine and the entry point of the ELF file.
+func elfHeader(name string) (elf.Machine, uint64, error) {
+	f, err := elf.Open(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	return f.Machine, f.Entry, nil
+}
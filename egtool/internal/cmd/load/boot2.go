@@ -0,0 +1,98 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package load
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/embeddedgo/tools/egtool/internal/util"
+)
+
+// RP2040 second stage bootloader
+const (
+	boot2Addr = 0x1000_0000
+	boot2Size = 256
+)
+
+// boot2CRC calculates CRC-32/MPEG-2 used by the RP2040 boot ROM to check the
+// second stage bootloader.
+func boot2CRC(p []byte) uint32 {
+	crc := ^uint32(0)
+	for _, b := range p {
+		crc ^= uint32(b) << 24
+		for range 8 {
+			if crc&(1<<31) != 0 {
+				crc = crc<<1 ^ 0x04c1_1db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func validBoot2(p []byte) bool {
+	return len(p) >= boot2Size &&
+		boot2CRC(p[:boot2Size-4]) == binary.LittleEndian.Uint32(p[boot2Size-4:])
+}
+
+// readBoot2 reads the second stage bootloader from the binary file. The file
+// may contain up to 252 bytes of code or 256 bytes of code and CRC.
+func readBoot2(name string) ([]byte, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > boot2Size || len(data) == boot2Size && !validBoot2(data) {
+		return nil, fmt.Errorf("%s: not a valid boot2 binary", name)
+	}
+	boot2 := make([]byte, boot2Size)
+	copy(boot2, data)
+	binary.LittleEndian.PutUint32(
+		boot2[boot2Size-4:], boot2CRC(boot2[:boot2Size-4]),
+	)
+	return boot2, nil
+}
+
+// addBoot2 ensures the RP2040 image that starts at the flash base (with the
+// boot2 stage or just after it) starts with a valid second stage bootloader.
+// If the image doesn't provide it addBoot2 adds the one read from the
+// boot2File. The images linked elsewhere (e.g. loaded at an offset) are left
+// untouched.
+func addBoot2(ss util.Sections, boot2File string) (util.Sections, error) {
+	ss.SortByPaddr()
+	first := ss[0]
+	if first.Paddr < boot2Addr || first.Paddr > boot2Addr+boot2Size {
+		if boot2File != "" {
+			util.Warn("pico: the image doesn't start at the flash base, ignoring %s", boot2File)
+		}
+		return ss, nil
+	}
+	if boot2File == "" {
+		if first.Paddr == boot2Addr && validBoot2(first.Data) {
+			return ss, nil
+		}
+		return nil, errors.New("the image doesn't start with a valid boot2 stage (see -boot2)")
+	}
+	boot2, err := readBoot2(boot2File)
+	if err != nil {
+		return nil, err
+	}
+	if first.Paddr < boot2Addr+boot2Size && first.Paddr+uint64(len(first.Data)) > boot2Addr {
+		if first.Paddr != boot2Addr || len(first.Data) < boot2Size {
+			return nil, errors.New("the image overlaps the boot2 area")
+		}
+		util.Warn("pico: replacing the boot2 stage of the image with %s", boot2File)
+		data := make([]byte, len(first.Data))
+		copy(data, boot2)
+		copy(data[boot2Size:], first.Data[boot2Size:])
+		ss[0] = &util.Section{Paddr: first.Paddr, Vaddr: first.Vaddr, Data: data}
+		return ss, nil
+	}
+	return append(ss, &util.Section{Paddr: boot2Addr, Data: boot2}), nil
+}
@@ -6,68 +6,113 @@ package load
 
 import (
 	"bytes"
-	"os"
+	"errors"
 
 	"github.com/embeddedgo/tools/egtool/internal/dfu"
 	"github.com/embeddedgo/tools/egtool/internal/util"
-	usb "github.com/google/gousb"
 )
 
-func dfuDev(target string, elf, busAddr string, quiet bool) {
+func dfuDev(o *options, j *job) error {
+	target, vendor, product, alt := o.target, o.vendor, o.product, o.alt
 	var (
-		vendor, product usb.ID
-		blkId           uint16
-		blkSize         int
-		poolSpeed       uint = 1
+		blkId   uint16
+		blkSize int
 	)
 	switch target {
 	case "stm32":
 		vendor, product = 0x0483, 0xdf11
 		blkId, blkSize = 2, 1024
-		poolSpeed = 64
-		// FIXME: blkSize = 2048 doesn't work and gousb doesn't provide access
-		// to the extra descriptors where the DFU functional one provides the
-		// wTransferSize
+		// FIXME: blkSize = 2048 (wTransferSize) doesn't work
+	}
+	var (
+		detach func() error
+		serial string // serial number of the detached device
+	)
+	again := func() (*dfu.Conn, error) {
+		// The device in the DFU mode may use a different product ID so the
+		// detached one is recognized by its vendor ID and serial number.
+		p := product
+		if target == "dfu" {
+			p = 0
+		}
+		return dfu.ConnectSerial(vendor, p, serial, alt)
+	}
+	// Without the vendor ID any DFU run-time device could be detached.
+	if o.reboot && vendor != 0 {
+		detach = func() error {
+			p := product
+			if target == "stm32" {
+				p = 0
+			}
+			var err error
+			serial, err = dfu.Detach(vendor, p, j.busAddr)
+			return err
+		}
+	}
+	conn, err := connect(
+		func() (*dfu.Conn, error) {
+			return dfu.Connect(vendor, product, j.busAddr, alt)
+		},
+		dfu.ErrNotFound, detach, again, j,
+	)
+	if err != nil {
+		return err
 	}
-	conn, err := dfu.Connect(vendor, product, busAddr, poolSpeed)
-	util.FatalErr("", err)
 	defer conn.Close()
 
-	sections, err := util.ReadELF(elf)
-	util.FatalErr("", err)
+	j.emit(&util.Event{Event: util.EvFound, Name: target})
+
+	if target == "dfu" {
+		if !conn.CanDownload() {
+			return errors.New("dfu: the device doesn't support the download operation")
+		}
+		blkSize = conn.TransferSize()
+		if blkSize == 0 {
+			blkSize = 1024
+		}
+	}
+
+	sections, err := util.ReadELF(o.elf)
+	if err != nil {
+		return err
+	}
 	img := bytes.NewBuffer(make([]byte, 0, sections.Size()*5/4))
 	const pad = 0xff
-	_, err = sections.Flatten(img, pad)
-	util.FatalErr("", err)
+	if _, err = sections.Flatten(img, pad); err != nil {
+		return err
+	}
 
 	if target == "stm32" {
-		os.Stderr.WriteString("Erasing flash... ")
+		j.printf("Erasing flash... ")
+		j.emit(&util.Event{Event: util.EvErase, Msg: "mass erase"})
 		massErase := [1]byte{0x41}
-		err = conn.Download(0, massErase[:])
-		util.FatalErr("", err)
-		os.Stderr.WriteString("done\n")
+		if err = conn.Download(0, massErase[:]); err != nil {
+			return err
+		}
+		j.printf("done\n")
 	}
 
 	imgBytes, imgSize := img.Bytes(), img.Len()
 
 	for i := 0; i < imgSize; i += blkSize {
-		if !quiet {
-			util.Progress("Loading:", i, imgSize, 1024, "KiB")
-		}
+		j.progress(util.EvWrite, "Loading:", i, imgSize)
 		blk := imgBytes[i:]
 		if len(blk) > blkSize {
 			blk = blk[:blkSize]
 		}
-		err = conn.Download(blkId, blk)
-		util.FatalErr("", err)
+		if err = conn.Download(blkId, blk); err != nil {
+			return err
+		}
 		blkId++
 	}
-	err = conn.Download(blkId, nil)
-	if target != "stm32" {
-		util.FatalErr("", err)
-	}
-	if !quiet {
-		util.Progress("Loaded: ", imgSize, imgSize, 1024, "KiB")
+	j.emit(&util.Event{Event: util.EvReboot, Msg: "program"})
+	if target == "stm32" {
+		// DfuSe leaves the DFU mode on the zero-length download so the
+		// status request usually fails.
+		conn.Download(blkId, nil)
+	} else if err = conn.Manifest(blkId); err != nil {
+		return err
 	}
-
+	j.progress(util.EvWrite, "Loaded: ", imgSize, imgSize)
+	return nil
 }
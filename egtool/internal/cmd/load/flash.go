@@ -0,0 +1,152 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package load
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/cortexm"
+	"github.com/embeddedgo/tools/egtool/internal/flashalgo"
+	"github.com/embeddedgo/tools/egtool/internal/util"
+)
+
+// openAlgo returns the flash algorithm for the microcontroller selected by
+// the -chip option or detected. The core must be halted. The STM32 read-out
+// protection is removed if allowed by the -unlock option.
+func openAlgo(o *options, j *job, core *cortexm.Core) (flashalgo.Algo, error) {
+	open := func() (flashalgo.Algo, error) {
+		if o.chip == "" {
+			return flashalgo.Detect(core)
+		}
+		return flashalgo.Open(o.chip, core)
+	}
+	algo, err := open()
+	if err == nil {
+		return algo, nil
+	}
+	err = unprotect(o, j, err, func() error {
+		if err := flashalgo.Unprotect(core); err != nil {
+			return err
+		}
+		return core.ResetHalt()
+	})
+	if err != nil {
+		return nil, err
+	}
+	return open()
+}
+
+// unprotect removes the read-out protection reported by err using the remove
+// function if the -unlock option allows it. It returns err if it isn't
+// a *flashalgo.ProtectedError or the protection cannot be removed.
+func unprotect(o *options, j *job, err error, remove func() error) error {
+	var pe *flashalgo.ProtectedError
+	if !errors.As(err, &pe) || pe.Permanent {
+		return err
+	}
+	if !o.unlock {
+		return fmt.Errorf(
+			"%w (use -unlock to mass erase the flash and remove the protection)",
+			err,
+		)
+	}
+	j.printf("Removing the read-out protection (mass erase)... ")
+	if err = remove(); err != nil {
+		j.printf("\n")
+		return err
+	}
+	j.printf("done\n")
+	return nil
+}
+
+// flash loads the program into the flash using the flash algorithm a, verifies
+// it and resets the target. The core must be halted.
+func flash(o *options, j *job, core *cortexm.Core, a flashalgo.Algo) error {
+	j.printf("Found %s\n", a.Name())
+	j.emit(&util.Event{Event: util.EvFound, Name: a.Name()})
+
+	sections, err := util.ReadELF(o.elf)
+	if err != nil {
+		return err
+	}
+	img := bytes.NewBuffer(make([]byte, 0, sections.Size()*5/4))
+	const pad = 0xff
+	if _, err = sections.Flatten(img, pad); err != nil {
+		return err
+	}
+	// Align the image to the largest flash programming unit.
+	const align = 32
+	paddr := sections[0].Paddr
+	head := int(paddr % align)
+	imgBytes := append(util.PadBytes(nil, head, pad), img.Bytes()...)
+	addr := uint32(paddr) - uint32(head)
+	imgSize := len(imgBytes)
+
+	base, size := a.Flash()
+	if paddr < uint64(base) || paddr+uint64(img.Len()) > uint64(base)+uint64(size) {
+		return fmt.Errorf(
+			"the image (%#x-%#x) doesn't fit in the %s flash (%#x-%#x)",
+			paddr, paddr+uint64(img.Len()), a.Name(), base, uint64(base)+uint64(size),
+		)
+	}
+
+	start, end := a.SectorAlign(addr, imgSize)
+	j.printf("Erasing flash... ")
+	j.emit(&util.Event{Event: util.EvErase, Addr: start, Total: int(end - start)})
+	if err = a.Erase(addr, imgSize); err != nil {
+		j.printf("\n")
+		return err
+	}
+	j.printf("done\n")
+
+	const chunkSize = 16 * 1024
+	t0 := time.Now()
+	for i := 0; i < imgSize; i += chunkSize {
+		j.progress(util.EvWrite, "Loading:", i, imgSize)
+		chunk := imgBytes[i:min(i+chunkSize, imgSize)]
+		if err = a.Program(addr+uint32(i), chunk); err != nil {
+			return err
+		}
+	}
+	j.progress(util.EvWrite, "Loaded: ", imgSize, imgSize)
+	dt := time.Since(t0)
+	j.printf(
+		"Written %d KiB in %.2f s (%.1f KiB/s)\n",
+		imgSize/1024, dt.Seconds(), float64(imgSize)/1024/dt.Seconds(),
+	)
+	if err = a.Done(); err != nil {
+		return err
+	}
+
+	buf := make([]byte, chunkSize)
+	for i := 0; i < imgSize; i += chunkSize {
+		j.progress(util.EvVerify, "Verifying:", i, imgSize)
+		chunk := imgBytes[i:min(i+chunkSize, imgSize)]
+		if err = cortexm.ReadBytes(core, addr+uint32(i), buf[:len(chunk)]); err != nil {
+			return err
+		}
+		if k := mismatch(buf, chunk); k >= 0 {
+			return fmt.Errorf("verification failed at %#x", addr+uint32(i+k))
+		}
+	}
+	j.progress(util.EvVerify, "Verified: ", imgSize, imgSize)
+
+	j.emit(&util.Event{Event: util.EvReboot, Msg: "program"})
+	return core.Reset()
+}
+
+// mismatch returns the index of the first byte of b that differs from the
+// corresponding byte of a or -1 if there is no such byte.
+func mismatch(a, b []byte) int {
+	for i := range b {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return -1
+}
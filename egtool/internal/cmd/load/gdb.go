@@ -0,0 +1,184 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package load
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/gdbrsp"
+	"github.com/embeddedgo/tools/egtool/internal/util"
+)
+
+// gdbRange is the memory range to be written. The flash ranges are aligned to
+// the erase blocks.
+type gdbRange struct {
+	start, end uint64
+}
+
+// flashRange returns the erase block aligned range of the flash that contains
+// the section. It reports false if the section doesn't lie in the flash.
+func flashRange(regions []gdbrsp.Region, s *util.Section) (r gdbRange, ok bool, err error) {
+	start, end := s.Paddr, s.Paddr+uint64(len(s.Data))
+	var rs, re *gdbrsp.Region
+	for i := range regions {
+		if regions[i].Type != "flash" {
+			continue
+		}
+		if regions[i].Contains(start) {
+			rs = &regions[i]
+		}
+		if regions[i].Contains(end - 1) {
+			re = &regions[i]
+		}
+	}
+	if rs == nil && re == nil {
+		return
+	}
+	if rs == nil || re == nil {
+		err = fmt.Errorf("the section at %#x crosses the flash boundary", start)
+		return
+	}
+	r.start = start - (start-rs.Start)%rs.BlockSize
+	r.end = end + (re.BlockSize-(end-re.Start)%re.BlockSize)%re.BlockSize
+	return r, true, nil
+}
+
+// gdbServer loads the program using the GDB server (OpenOCD, pyOCD, QEMU
+// gdbstub, etc.). The sections that lie in the flash, according to the memory
+// map provided by the server, are written using the vFlash requests, the
+// others are written to the memory directly. The written data are verified
+// using qCRC.
+func gdbServer(o *options, j *job) error {
+	sections, err := util.ReadELF(o.elf)
+	if err != nil {
+		return err
+	}
+	sections.SortByPaddr()
+
+	c, err := gdbrsp.Dial(o.gdb)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	j.printf("Connected to the GDB server at %s\n", o.gdb)
+	j.emit(&util.Event{Event: util.EvFound, Name: o.gdb})
+
+	regions, err := c.MemoryMap()
+	if err != nil && !errors.Is(err, gdbrsp.ErrUnsupported) {
+		return err
+	}
+	inFlash := make([]bool, len(sections))
+	var erase []gdbRange
+	for i, s := range sections {
+		r, ok, err := flashRange(regions, s)
+		if err != nil {
+			return err
+		}
+		inFlash[i] = ok
+		if !ok {
+			continue
+		}
+		if n := len(erase); n != 0 && r.start <= erase[n-1].end {
+			erase[n-1].end = max(erase[n-1].end, r.end)
+		} else {
+			erase = append(erase, r)
+		}
+	}
+
+	if len(erase) != 0 {
+		j.printf("Erasing flash... ")
+		for _, r := range erase {
+			j.emit(&util.Event{
+				Event: util.EvErase, Addr: uint32(r.start),
+				Total: int(r.end - r.start),
+			})
+			if err = c.FlashErase(r.start, int(r.end-r.start)); err != nil {
+				j.printf("\n")
+				return err
+			}
+		}
+		j.printf("done\n")
+	}
+
+	const chunkSize = 16 * 1024
+	total := int(sections.Size())
+	done := 0
+	t0 := time.Now()
+	for i, s := range sections {
+		for k := 0; k < len(s.Data); k += chunkSize {
+			j.progress(util.EvWrite, "Loading:", done, total)
+			chunk := s.Data[k:min(k+chunkSize, len(s.Data))]
+			addr := s.Paddr + uint64(k)
+			if inFlash[i] {
+				err = c.FlashWrite(addr, chunk)
+			} else {
+				err = c.WriteMem(addr, chunk)
+			}
+			if err != nil {
+				return err
+			}
+			done += len(chunk)
+		}
+	}
+	if len(erase) != 0 {
+		// The server may write the buffered data only now.
+		if err = c.FlashDone(); err != nil {
+			return err
+		}
+	}
+	j.progress(util.EvWrite, "Loaded: ", total, total)
+	dt := time.Since(t0)
+	j.printf(
+		"Written %d KiB in %.2f s (%.1f KiB/s)\n",
+		total/1024, dt.Seconds(), float64(total)/1024/dt.Seconds(),
+	)
+
+	done = 0
+	for _, s := range sections {
+		j.progress(util.EvVerify, "Verifying:", done, total)
+		if err = gdbVerify(c, s); err != nil {
+			return err
+		}
+		done += len(s.Data)
+	}
+	j.progress(util.EvVerify, "Verified: ", total, total)
+
+	if o.gdbReset != "" {
+		j.emit(&util.Event{Event: util.EvReboot, Msg: "program"})
+		if _, err = c.Monitor(o.gdbReset); err != nil {
+			return err
+		}
+	}
+	return c.Detach()
+}
+
+// gdbVerify compares the section data with the target memory. It uses the
+// qCRC request and falls back to reading the memory back if the server
+// doesn't support it.
+func gdbVerify(c *gdbrsp.Conn, s *util.Section) error {
+	crc, err := c.CRC(s.Paddr, len(s.Data))
+	if err == nil {
+		if crc != gdbrsp.CRC32(s.Data) {
+			return fmt.Errorf(
+				"verification failed in %#x-%#x",
+				s.Paddr, s.Paddr+uint64(len(s.Data)),
+			)
+		}
+		return nil
+	}
+	if !errors.Is(err, gdbrsp.ErrUnsupported) {
+		return err
+	}
+	buf := make([]byte, len(s.Data))
+	if err = c.ReadMem(s.Paddr, buf); err != nil {
+		return err
+	}
+	if k := mismatch(buf, s.Data); k >= 0 {
+		return fmt.Errorf("verification failed at %#x", s.Paddr+uint64(k))
+	}
+	return nil
+}
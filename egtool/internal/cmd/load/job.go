@@ -0,0 +1,149 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package load
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/util"
+	usb "github.com/google/gousb"
+)
+
+// options contains the load parameters common for all devices.
+type options struct {
+	target    string
+	elf       string
+	vendor    usb.ID
+	product   usb.ID
+	alt       string
+	drive     string
+	boot2     string
+	part      string
+	chip      string
+	swdClock  int // kHz
+	gdb       string
+	gdbReset  string
+	flashSize int
+	ram       bool
+	diff      bool
+	reboot    bool
+	unlock    bool
+}
+
+// load loads the program onto the device described by j.
+func (o *options) load(j *job) error {
+	switch o.target {
+	case "pico":
+		return pico(o, j)
+	case "teensy":
+		return teensy(o, j)
+	case "stm32", "dfu":
+		return dfuDev(o, j)
+	case "uf2drive":
+		return uf2Drive(o, j)
+	case "swd":
+		return swdDev(o, j)
+	case "stlink":
+		return stlinkDev(o, j)
+	case "gdb":
+		return gdbServer(o, j)
+	}
+	return fmt.Errorf("unknown target: %s", o.target)
+}
+
+// job represents loading the program onto a single device.
+type job struct {
+	busAddr string // BUS:ADDR of the device, empty means the only one
+	serial  string // serial number of the device if known
+	quiet   bool
+
+	// The following fields are used if many jobs run concurrently.
+	label   string      // device label prefixed to all messages
+	mu      *sync.Mutex // serializes the output
+	lastPct int
+}
+
+// printf prints the diagnostic message unless j.quiet or util.JSON is set.
+func (j *job) printf(format string, a ...any) {
+	if j.quiet || util.JSON {
+		return
+	}
+	if j.mu == nil {
+		fmt.Fprintf(os.Stderr, format, a...)
+		return
+	}
+	s := strings.TrimSpace(fmt.Sprintf(format, a...))
+	j.mu.Lock()
+	fmt.Fprintf(os.Stderr, "[%s] %s\n", j.label, s)
+	j.mu.Unlock()
+}
+
+// progress reports the progress of the operation described by pre. In the
+// JSON mode it emits the ev event every percent.
+func (j *job) progress(ev, pre string, cur, max int) {
+	if util.JSON {
+		pct := 100
+		if max != 0 {
+			pct = cur * 100 / max
+		}
+		if cur != 0 && cur != max && pct == j.lastPct {
+			return
+		}
+		j.lastPct = pct
+		j.emit(&util.Event{Event: ev, Done: cur, Total: max})
+		return
+	}
+	if j.quiet {
+		return
+	}
+	if j.mu == nil {
+		util.Progress(pre, cur, max, 1024, "KiB")
+		return
+	}
+	// Print a line every 10 percent, not to flood the terminal.
+	pct := 100
+	if max != 0 {
+		pct = cur * 100 / max
+	}
+	if pct/10 == j.lastPct/10 && pct != 100 {
+		return
+	}
+	j.lastPct = pct
+	j.mu.Lock()
+	fmt.Fprintf(os.Stderr, "[%s] %s %3d%% (%d KiB)\n", j.label, pre, pct, cur/1024)
+	j.mu.Unlock()
+}
+
+// emit emits the event about the device in the JSON mode.
+func (j *job) emit(e *util.Event) {
+	if !util.JSON {
+		return
+	}
+	if e.Device == "" {
+		e.Device = j.busAddr
+	}
+	if e.Serial == "" {
+		e.Serial = j.serial
+	}
+	util.Emit(e)
+}
+
+// result emits the "done" or "error" event that describes the result of the
+// job started at t0.
+func (j *job) result(err error, t0 time.Time) {
+	if err == nil {
+		j.emit(util.DoneEvent("", t0))
+		return
+	}
+	e := util.ErrorEvent("", err)
+	if e.Code == "" && notFound(err) {
+		e.Code = "NO_DEVICE"
+	}
+	j.emit(e)
+}
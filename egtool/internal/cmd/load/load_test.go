@@ -0,0 +1,676 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package load
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/flashalgo"
+	"github.com/embeddedgo/tools/egtool/internal/halfkay"
+	"github.com/embeddedgo/tools/egtool/internal/picoboot"
+	"github.com/embeddedgo/tools/egtool/internal/usbdev"
+	"github.com/embeddedgo/tools/egtool/internal/usbsim"
+	"github.com/embeddedgo/tools/egtool/internal/util"
+)
+
+// segment is the loadable content of the test ELF file.
+type segment struct {
+	addr uint32
+	data []byte
+}
+
+// writeELF writes the 32-bit little-endian executable ELF file that contains
+// one PT_LOAD segment and one PROGBITS section for every provided segment.
+func writeELF(t *testing.T, machine elf.Machine, entry uint32, segs ...segment) string {
+	t.Helper()
+	const (
+		ehSize = 52
+		phSize = 32
+		shSize = 40
+	)
+	le := binary.LittleEndian
+	shstrtab := []byte("\x00.shstrtab\x00.text\x00")
+	off := uint32(ehSize + phSize*len(segs))
+	var (
+		progs []elf.Prog32
+		sects = []elf.Section32{{}} // SHN_UNDEF
+		data  []byte
+	)
+	for _, s := range segs {
+		progs = append(progs, elf.Prog32{
+			Type: uint32(elf.PT_LOAD), Off: off, Vaddr: s.addr, Paddr: s.addr,
+			Filesz: uint32(len(s.data)), Memsz: uint32(len(s.data)),
+			Flags: uint32(elf.PF_R | elf.PF_X), Align: 4,
+		})
+		sects = append(sects, elf.Section32{
+			Name: 11, Type: uint32(elf.SHT_PROGBITS),
+			Flags: uint32(elf.SHF_ALLOC | elf.SHF_EXECINSTR),
+			Addr:  s.addr, Off: off, Size: uint32(len(s.data)), Addralign: 4,
+		})
+		data = append(data, s.data...)
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+		off = uint32(ehSize+phSize*len(segs)) + uint32(len(data))
+	}
+	sects = append(sects, elf.Section32{
+		Name: 1, Type: uint32(elf.SHT_STRTAB), Off: off,
+		Size: uint32(len(shstrtab)), Addralign: 1,
+	})
+	data = append(data, shstrtab...)
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+	hdr := elf.Header32{
+		Type: uint16(elf.ET_EXEC), Machine: uint16(machine),
+		Version: uint32(elf.EV_CURRENT), Entry: entry,
+		Phoff: ehSize, Shoff: uint32(ehSize+phSize*len(segs)) + uint32(len(data)),
+		Ehsize: ehSize, Phentsize: phSize, Phnum: uint16(len(segs)),
+		Shentsize: shSize, Shnum: uint16(len(sects)),
+		Shstrndx: uint16(len(sects) - 1),
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	var buf bytes.Buffer
+	binary.Write(&buf, le, &hdr)
+	binary.Write(&buf, le, progs)
+	buf.Write(data)
+	binary.Write(&buf, le, sects)
+	name := filepath.Join(t.TempDir(), "test.elf")
+	if err := os.WriteFile(name, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// pattern returns n bytes of the test data.
+func pattern(n int, seed byte) []byte {
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = byte(i*7) + seed
+	}
+	return p
+}
+
+func attach(t *testing.T, sim usbdev.Device) {
+	usbdev.Attach(sim)
+	t.Cleanup(func() { usbdev.Detach(sim) })
+}
+
+func TestLoadSTM32(t *testing.T) {
+	sim := usbsim.NewSTM32(64)
+	attach(t, sim)
+	code := pattern(5000, 1)
+	o := &options{
+		target: "stm32",
+		elf:    writeELF(t, elf.EM_ARM, 0x0800_0000, segment{0x0800_0000, code}),
+	}
+	if err := o.load(&job{quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sim.Flash.Bytes(0x0800_0000, len(code)), code) {
+		t.Error("bad flash content")
+	}
+	// Mass erase.
+	if n := sim.Flash.Size() / sim.Flash.SectorSize; sim.Flash.Erased != n {
+		t.Errorf("erased %d pages, want %d", sim.Flash.Erased, n)
+	}
+	if sim.Flash.Written != len(code) {
+		t.Errorf("written %d bytes, want %d", sim.Flash.Written, len(code))
+	}
+}
+
+func TestLoadPico(t *testing.T) {
+	const flashBase = 0x1000_0000
+	sim := usbsim.NewRP2350(2048)
+	attach(t, sim)
+	code := pattern(10000, 2)
+	o := &options{
+		target: "pico",
+		elf:    writeELF(t, elf.EM_ARM, flashBase, segment{flashBase, code}),
+	}
+	if err := o.load(&job{quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sim.Flash.Bytes(flashBase, len(code)), code) {
+		t.Error("bad flash content")
+	}
+	// The image is written in whole sectors.
+	if sim.Flash.Erased != 3 || sim.Flash.Written != 3*4096 {
+		t.Errorf(
+			"erased %d sectors, written %d bytes, want 3, %d",
+			sim.Flash.Erased, sim.Flash.Written, 3*4096,
+		)
+	}
+	want := usbsim.Reboot{
+		Type: picoboot.RebootNormal | picoboot.RebootToARM, Delay: time.Second / 2,
+	}
+	if r := sim.Rebooted; r == nil || *r != want {
+		t.Errorf("rebooted: %+v, want %+v", r, want)
+	}
+
+	// Load the modified image. Only the changed sector should be written.
+	code[5000] ^= 0xff
+	o.elf = writeELF(t, elf.EM_ARM, flashBase, segment{flashBase, code})
+	o.diff = true
+	sim.Rebooted = nil
+	attach(t, sim) // the device is back in the BOOTSEL mode
+	if err := o.load(&job{quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sim.Flash.Bytes(flashBase, len(code)), code) {
+		t.Error("bad flash content after the second load")
+	}
+	if sim.Flash.Erased != 4 || sim.Flash.Written != 4*4096 {
+		t.Errorf(
+			"erased %d sectors, written %d bytes, want 4, %d",
+			sim.Flash.Erased, sim.Flash.Written, 4*4096,
+		)
+	}
+}
+
+func TestLoadPicoRISCV(t *testing.T) {
+	const flashBase = 0x1000_0000
+	sim := usbsim.NewRP2350(2048)
+	attach(t, sim)
+	code := pattern(4096, 3)
+	o := &options{
+		target: "pico",
+		elf:    writeELF(t, elf.EM_RISCV, flashBase, segment{flashBase, code}),
+	}
+	if err := o.load(&job{quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sim.Flash.Bytes(flashBase, len(code)), code) {
+		t.Error("bad flash content")
+	}
+	want := picoboot.RebootNormal | picoboot.RebootToRISCV
+	if r := sim.Rebooted; r == nil || r.Type != want {
+		t.Errorf("rebooted: %+v, want type %#x", r, want)
+	}
+}
+
+func TestLoadRP2040(t *testing.T) {
+	sim := usbsim.NewRP2040(2048)
+	attach(t, sim)
+	boot2 := make([]byte, boot2Size)
+	binary.LittleEndian.PutUint32(
+		boot2[boot2Size-4:], boot2CRC(boot2[:boot2Size-4]),
+	)
+	code := pattern(3000, 4)
+	o := &options{
+		target: "pico",
+		elf: writeELF(
+			t, elf.EM_ARM, boot2Addr+boot2Size,
+			segment{boot2Addr, boot2}, segment{boot2Addr + boot2Size, code},
+		),
+	}
+	if err := o.load(&job{quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sim.Flash.Bytes(boot2Addr, boot2Size), boot2) ||
+		!bytes.Equal(sim.Flash.Bytes(boot2Addr+boot2Size, len(code)), code) {
+		t.Error("bad flash content")
+	}
+	if sim.Flash.Erased != 1 || sim.Flash.Written != 4096 {
+		t.Errorf(
+			"erased %d sectors, written %d bytes, want 1, 4096",
+			sim.Flash.Erased, sim.Flash.Written,
+		)
+	}
+	want := usbsim.Reboot{
+		Type: picoboot.RebootNormal, Delay: time.Second / 2, P1: 0x2004_2000,
+	}
+	if r := sim.Rebooted; r == nil || *r != want {
+		t.Errorf("rebooted: %+v, want %+v", r, want)
+	}
+}
+
+func TestLoadRP2040Offset(t *testing.T) {
+	const codeAddr = boot2Addr + 0x1_0000
+	sim := usbsim.NewRP2040(2048)
+	attach(t, sim)
+	code := pattern(3000, 7)
+	o := &options{
+		target: "pico",
+		elf:    writeELF(t, elf.EM_ARM, codeAddr, segment{codeAddr, code}),
+	}
+	if err := o.load(&job{quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sim.Flash.Bytes(codeAddr, len(code)), code) {
+		t.Error("bad flash content")
+	}
+	if sim.Flash.Erased != 1 || sim.Flash.Written != 4096 {
+		t.Errorf(
+			"erased %d sectors, written %d bytes, want 1, 4096",
+			sim.Flash.Erased, sim.Flash.Written,
+		)
+	}
+}
+
+func TestAddBoot2(t *testing.T) {
+	boot2 := make([]byte, boot2Size)
+	boot2[0] = 0xb5
+	binary.LittleEndian.PutUint32(
+		boot2[boot2Size-4:], boot2CRC(boot2[:boot2Size-4]),
+	)
+	boot2File := filepath.Join(t.TempDir(), "boot2.bin")
+	if err := os.WriteFile(boot2File, boot2[:boot2Size-4], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	code := pattern(100, 8)
+	withBoot2 := append(append([]byte{}, boot2...), code...)
+	badBoot2 := append(make([]byte, boot2Size), code...)
+	tests := []struct {
+		name  string
+		addr  uint64 // address of the image
+		data  []byte
+		boot2 string
+		start uint64 // start address of the resulting image
+		want  []byte // flattened resulting image, nil means error
+	}{
+		{"valid", boot2Addr, withBoot2, "", boot2Addr, withBoot2},
+		{"missing", boot2Addr + boot2Size, code, "", 0, nil},
+		{"invalid", boot2Addr, badBoot2, "", 0, nil},
+		{"offset", boot2Addr + 0x1_0000, code, "", boot2Addr + 0x1_0000, code},
+		{"ram", 0x2000_0000, code, "", 0x2000_0000, code},
+		{"add", boot2Addr + boot2Size, code, boot2File, boot2Addr, withBoot2},
+		{"replace", boot2Addr, badBoot2, boot2File, boot2Addr, withBoot2},
+		{"overlap", boot2Addr + 0x80, code, boot2File, 0, nil},
+		{"offset-file", boot2Addr + 0x1_0000, code, boot2File, boot2Addr + 0x1_0000, code},
+	}
+	for _, tc := range tests {
+		ss := util.Sections{{Paddr: tc.addr, Data: tc.data}}
+		ss, err := addBoot2(ss, tc.boot2)
+		if tc.want == nil {
+			if err == nil {
+				t.Errorf("%s: no error", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		var buf bytes.Buffer
+		if _, err = ss.Flatten(&buf, 0xff); err != nil {
+			t.Fatal(err)
+		}
+		if ss[0].Paddr != tc.start {
+			t.Errorf("%s: image starts at %#x, want %#x", tc.name, ss[0].Paddr, tc.start)
+		}
+		if !bytes.Equal(buf.Bytes(), tc.want) {
+			t.Errorf("%s: bad image content", tc.name)
+		}
+	}
+}
+
+func TestLoadRP2040RAM(t *testing.T) {
+	const ramBase, entry = 0x2000_0000, 0x2000_0100
+	sim := usbsim.NewRP2040(2048)
+	attach(t, sim)
+	code := pattern(6000, 5)
+	o := &options{
+		target: "pico",
+		elf:    writeELF(t, elf.EM_ARM, entry, segment{ramBase, code}),
+		ram:    true,
+	}
+	if err := o.load(&job{quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sim.RAM[:len(code)], code) {
+		t.Error("bad RAM content")
+	}
+	if sim.Flash.Erased != 0 || sim.Flash.Written != 0 {
+		t.Errorf(
+			"erased %d sectors, written %d bytes, want 0, 0",
+			sim.Flash.Erased, sim.Flash.Written,
+		)
+	}
+	want := usbsim.Reboot{
+		Type: picoboot.RebootPCSP, Delay: time.Second / 2,
+		P0: entry | 1, P1: 0x2004_2000,
+	}
+	if r := sim.Rebooted; r == nil || *r != want {
+		t.Errorf("rebooted: %+v, want %+v", r, want)
+	}
+}
+
+func TestLoadTeensy(t *testing.T) {
+	const codeAddr = 0x6000_2000 // just after the MBR
+	sim := usbsim.NewTeensy(halfkay.Teensy40)
+	attach(t, sim)
+	code := pattern(3000, 6)
+	o := &options{
+		target: "teensy",
+		elf:    writeELF(t, elf.EM_ARM, codeAddr, segment{codeAddr, code}),
+	}
+	if err := o.load(&job{quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sim.Flash.Bytes(codeAddr, len(code)), code) {
+		t.Error("bad flash content")
+	}
+	if !sim.Booted {
+		t.Error("the program wasn't started")
+	}
+	// The first block erases the whole flash, the erased blocks are skipped.
+	if n := sim.Flash.Size() / sim.Flash.SectorSize; sim.Flash.Erased != n {
+		t.Errorf("erased %d sectors, want %d", sim.Flash.Erased, n)
+	}
+	bs := halfkay.Teensy40.BlockSize
+	img := sim.Flash.Bytes(0x6000_0000, codeAddr-0x6000_0000+len(code))
+	blocks := 1
+	for a := bs; a < len(img); a += bs {
+		blk := img[a:min(a+bs, len(img))]
+		if bytes.Count(blk, []byte{0xff}) != len(blk) {
+			blocks++
+		}
+	}
+	if sim.Flash.Written != blocks*bs {
+		t.Errorf("written %d bytes, want %d", sim.Flash.Written, blocks*bs)
+	}
+}
+
+func TestLoadSWD(t *testing.T) {
+	target := usbsim.NewNRF52(0x52840, 256)
+	attach(t, usbsim.NewDAP(target))
+	code := pattern(9000, 7)
+	o := &options{
+		target:   "swd",
+		elf:      writeELF(t, elf.EM_ARM, 0, segment{0, code}),
+		swdClock: 4000,
+	}
+	if err := o.load(&job{quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(target.Flash.Bytes(0, len(code)), code) {
+		t.Error("bad flash content")
+	}
+	if target.Flash.Erased != 3 {
+		t.Errorf("erased %d pages, want 3", target.Flash.Erased)
+	}
+	if target.Flash.Written != len(code) {
+		t.Errorf("written %d bytes, want %d", target.Flash.Written, len(code))
+	}
+}
+
+func TestLoadSWDUnlock(t *testing.T) {
+	nrf := usbsim.NewNRF52(0x52840, 256)
+	nrf.APProtect = true
+	stm := usbsim.NewSTM32F4(0x413, 1024)
+	stm.RDP = 0xbb
+	for _, tc := range []struct {
+		name   string
+		target usbsim.Target
+		base   uint32
+		flash  *usbsim.Flash
+	}{
+		{"nRF52", nrf, 0, nrf.Flash},
+		{"STM32F4", stm, 0x0800_0000, stm.Flash},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			attach(t, usbsim.NewDAP(tc.target))
+			code := pattern(1000, 8)
+			o := &options{
+				target:   "swd",
+				elf:      writeELF(t, elf.EM_ARM, tc.base, segment{tc.base, code}),
+				swdClock: 4000,
+			}
+			err := o.load(&job{quiet: true})
+			var pe *flashalgo.ProtectedError
+			if !errors.As(err, &pe) || !strings.Contains(err.Error(), "-unlock") {
+				t.Fatalf("load error: %v, want ProtectedError with the hint", err)
+			}
+			o.unlock = true
+			if err = o.load(&job{quiet: true}); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(tc.flash.Bytes(tc.base, len(code)), code) {
+				t.Error("bad flash content")
+			}
+		})
+	}
+}
+
+func TestLoadSTLink(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		version uint16
+		div     int
+	}{
+		{"V2J37", 2<<12 | 37<<6 | 26, 1},
+		{"V2J21", 2<<12 | 21<<6 | 4, -1}, // no SWD frequency command
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			target := usbsim.NewSTM32F4(0x413, 1024)
+			sim := usbsim.NewSTLink(target)
+			sim.Version = tc.version
+			sim.Div = -1
+			attach(t, sim)
+			const base = 0x0800_0000
+			code := pattern(40000, 9)
+			o := &options{
+				target:   "stlink",
+				elf:      writeELF(t, elf.EM_ARM, base, segment{base, code}),
+				swdClock: 1800,
+			}
+			if err := o.load(&job{quiet: true}); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(target.Flash.Bytes(base, len(code)), code) {
+				t.Error("bad flash content")
+			}
+			if target.Flash.Erased != 3 {
+				t.Errorf("erased %d sectors, want 3", target.Flash.Erased)
+			}
+			if target.Flash.Written != len(code) {
+				t.Errorf("written %d bytes, want %d", target.Flash.Written, len(code))
+			}
+			if sim.Div != tc.div {
+				t.Errorf("SWD clock divisor %d, want %d", sim.Div, tc.div)
+			}
+		})
+	}
+}
+
+// captureStdout returns the standard output written by f.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	func() {
+		defer func() {
+			os.Stdout = stdout
+			w.Close()
+		}()
+		f()
+	}()
+	return <-out
+}
+
+// countLines returns the number of output lines that contain all substrings.
+func countLines(out string, substrs ...string) int {
+	n := 0
+	for _, line := range strings.Split(out, "\n") {
+		match := true
+		for _, s := range substrs {
+			match = match && strings.Contains(line, s)
+		}
+		if match {
+			n++
+		}
+	}
+	return n
+}
+
+func TestLoadAll(t *testing.T) {
+	const flashBase = 0x1000_0000
+	good := usbsim.NewRP2350(2048)
+	attach(t, good)
+	bad := usbsim.NewRP2040(2048) // doesn't support RISC-V
+	attach(t, bad)
+	code := pattern(4096, 9)
+	o := &options{
+		target: "pico",
+		elf:    writeELF(t, elf.EM_RISCV, flashBase, segment{flashBase, code}),
+		reboot: true,
+	}
+	var (
+		ok  bool
+		err error
+	)
+	out := captureStdout(t, func() { ok, err = loadAll(o, true) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("loadAll succeeded with a failed device")
+	}
+	if !bytes.Equal(good.Flash.Bytes(flashBase, len(code)), code) || good.Rebooted == nil {
+		t.Error("the good device wasn't loaded")
+	}
+	if bad.Flash.Written != 0 || bad.Rebooted != nil {
+		t.Error("the bad device was modified")
+	}
+	goodAddr := usbdev.BusAddr(good.Desc())
+	badAddr := usbdev.BusAddr(bad.Desc())
+	if countLines(out, goodAddr, "ok") != 1 ||
+		countLines(out, badAddr, "FAIL: pico: RP2040 supports only ARM images") != 1 {
+		t.Errorf("bad summary:\n%s", out)
+	}
+	if o.reboot {
+		t.Error("loadAll doesn't disable the reboot")
+	}
+}
+
+func TestStation(t *testing.T) {
+	const flashBase = 0x1000_0000
+	first := usbsim.NewRP2350(2048)
+	attach(t, first)
+	bad := usbsim.NewRP2040(2048) // doesn't support RISC-V
+	attach(t, bad)
+	late := usbsim.NewRP2350(2048)
+	code := pattern(4096, 10)
+	o := &options{
+		target: "pico",
+		elf:    writeELF(t, elf.EM_RISCV, flashBase, segment{flashBase, code}),
+	}
+	// The new device appears after a few polls. The bad one stays in the
+	// bootloader mode all the time.
+	timer := time.AfterFunc(3*pollPeriod, func() { usbdev.Attach(late) })
+	t.Cleanup(func() {
+		timer.Stop()
+		usbdev.Detach(late)
+	})
+	var (
+		ok  bool
+		err error
+	)
+	out := captureStdout(t, func() { ok, err = station(o, true, 4*pollPeriod) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("station succeeded with a failed device")
+	}
+	for _, sim := range []*usbsim.RP2350{first, late} {
+		if !bytes.Equal(sim.Flash.Bytes(flashBase, len(code)), code) || sim.Rebooted == nil {
+			t.Errorf("device %s wasn't loaded", usbdev.BusAddr(sim.Desc()))
+		}
+		// Loaded once: the sectors are erased and written only once.
+		if sim.Flash.Erased != 1 || sim.Flash.Written != len(code) {
+			t.Errorf(
+				"device %s: erased %d sectors, written %d bytes",
+				usbdev.BusAddr(sim.Desc()), sim.Flash.Erased, sim.Flash.Written,
+			)
+		}
+	}
+	// Every device is reported once, the bad one isn't loaded again although
+	// it stays on the bus.
+	for _, tc := range []struct {
+		sim    usbdev.Device
+		result string
+	}{
+		{first, " ok"},
+		{late, " ok"},
+		{bad, " FAIL: pico: RP2040 supports only ARM images"},
+	} {
+		if n := countLines(out, usbdev.BusAddr(tc.sim.Desc())+" ", tc.result); n != 2 {
+			t.Errorf(
+				"device %s reported %d times, want 2 (result and summary):\n%s",
+				usbdev.BusAddr(tc.sim.Desc()), n, out,
+			)
+		}
+	}
+}
+
+func TestLoadNotFound(t *testing.T) {
+	// Make the virtual bus used without any PICOBOOT device on it.
+	attach(t, usbsim.NewTeensy(halfkay.Teensy40))
+	o := &options{
+		target: "pico",
+		elf:    writeELF(t, elf.EM_ARM, 0x1000_0000, segment{0x1000_0000, pattern(16, 0)}),
+	}
+	if err := o.load(&job{quiet: true}); !notFound(err) {
+		t.Errorf("load error: %v, want not found", err)
+	}
+	if _, err := loadAll(o, true); err != errNoDevs {
+		t.Errorf("loadAll error: %v, want %v", err, errNoDevs)
+	}
+	o.elf = filepath.Join(t.TempDir(), "nonexistent.elf")
+	attach(t, usbsim.NewRP2350(2048))
+	if err := o.load(&job{quiet: true}); err == nil || notFound(err) {
+		t.Errorf("load error: %v, want the ELF read error", err)
+	}
+}
+
+func TestTermConfig(t *testing.T) {
+	sim := usbsim.NewSTLink(usbsim.NewSTM32F4(0x413, 1024))
+	sim.SetSerialNumber("066DFF505")
+	attach(t, sim)
+	for _, tc := range []struct {
+		target string
+		vendor uint16
+		serial string
+	}{
+		{"pico", 0x2e8a, ""},
+		{"teensy", 0x16c0, ""},
+		{"stm32", 0x0483, ""},
+		{"dfu", 0x1234, ""},
+		{"stlink", 0x0483, "066DFF505"},
+		{"swd", 0, ""}, // no CMSIS-DAP probe
+	} {
+		o := &options{target: tc.target, vendor: 0x1234, elf: "test.elf"}
+		cfg := termConfig(o, &job{})
+		if cfg.Vendor != tc.vendor || cfg.Serial != tc.serial || cfg.ELF != o.elf {
+			t.Errorf(
+				"%s: vendor=%04x serial=%q, want vendor=%04x serial=%q",
+				tc.target, cfg.Vendor, cfg.Serial, tc.vendor, tc.serial,
+			)
+		}
+	}
+}
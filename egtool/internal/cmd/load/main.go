@@ -8,8 +8,16 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/embeddedgo/tools/egtool/internal/cmd/term"
+	"github.com/embeddedgo/tools/egtool/internal/flashalgo"
+	"github.com/embeddedgo/tools/egtool/internal/halfkay"
+	"github.com/embeddedgo/tools/egtool/internal/picoboot"
+	"github.com/embeddedgo/tools/egtool/internal/stlink"
 	"github.com/embeddedgo/tools/egtool/internal/util"
+	usb "github.com/google/gousb"
 )
 
 const Descr = "load the program / memory range stored in a file onto the device"
@@ -26,14 +34,114 @@ func Main(cmd string, args []string) {
 	}
 	target := fs.String(
 		"target", "auto", "select the target device and transport:\n"+
-			"auto:   try to determine the target device automatically\n"+
-			"pico:   RP2350 (aka Raspberry Pi Pico 2) via USB PICOBOOT\n"+
-			"teensy: Teensy 4.x via USB\n"+
-			"stm32:  STM32 via USB DFU\n",
+			"auto:     try to determine the target device automatically\n"+
+			"pico:     RP2040/RP2350 (Raspberry Pi Pico, Pico 2) via USB PICOBOOT\n"+
+			"teensy:   Teensy 4.x via USB\n"+
+			"stm32:    STM32 via USB DFU\n"+
+			"dfu:      generic USB DFU 1.1 device (see -vid, -pid, -alt)\n"+
+			"uf2drive: device with a UF2 bootloader via its USB drive\n"+
+			"swd:      microcontroller flash via a CMSIS-DAP debug probe\n"+
+			"          (see -chip)\n"+
+			"stlink:   microcontroller flash via an ST-LINK debug probe\n"+
+			"          (see -chip)\n"+
+			"gdb:      flash or RAM via a GDB server: OpenOCD, pyOCD, QEMU\n"+
+			"          gdbstub, etc. (see -gdb)\n",
+	)
+	busAddr := fs.String(
+		"usb", "", "select the USB device (or debug probe) by `BUS:ADDR`",
+	)
+	vid := fs.Uint("vid", 0, "select the USB device by vendor `ID` (dfu target)")
+	pid := fs.Uint("pid", 0, "select the USB device by product `ID` (dfu target)")
+	alt := fs.String(
+		"alt", "",
+		"select the DFU alternate setting by `NUMBER or NAME` (dfu target)",
+	)
+	drive := fs.String(
+		"drive", "",
+		"select the UF2 drive by its mount point `DIR` (uf2drive target)",
+	)
+	flashSize := fs.Uint(
+		"flash", 0,
+		"override the detected flash `SIZE` in KiB (teensy target)",
+	)
+	ram := fs.Bool(
+		"ram", false,
+		"load the image linked for SRAM and run it without touching the\n"+
+			"flash (pico target)",
+	)
+	diff := fs.Bool(
+		"diff", false,
+		"write only the flash sectors that differ from the image (pico target)",
+	)
+	boot2 := fs.String(
+		"boot2", "",
+		"RP2040 second stage bootloader `BIN` file to use if the image\n"+
+			"doesn't provide one (pico target)",
+	)
+	part := fs.String(
+		"partition", "",
+		"load into the RP2350 partition selected by `NUMBER, NAME or ab`\n"+
+			"(the non-booted partition of an A/B pair, marked for\n"+
+			"try before you buy) instead of the UF2 target one (pico target)",
+	)
+	chip := fs.String(
+		"chip", "",
+		"select the target microcontroller `NAME` instead of detecting it\n"+
+			"(swd, stlink targets): "+strings.Join(flashalgo.Names(), ", "),
+	)
+	swdClock := fs.Uint(
+		"swdclk", 4000, "SWD clock `FREQ` in kHz (swd, stlink targets)",
+	)
+	unlock := fs.Bool(
+		"unlock", false,
+		"mass erase the read-out protected flash to remove the protection\n"+
+			"(swd target: nRF52 APPROTECT, STM32F4 RDP level 1; stlink\n"+
+			"target: STM32F4 RDP level 1)",
+	)
+	gdbAddr := fs.String(
+		"gdb", "localhost:3333",
+		"connect to the GDB server at `HOST:PORT` (gdb target)",
+	)
+	gdbReset := fs.String(
+		"gdbreset", "reset",
+		"monitor `COMMAND` that resets the target after loading, empty\n"+
+			"means leave the target halted (gdb target)",
+	)
+	reboot := fs.Bool(
+		"reboot", true,
+		"reboot the running program into the bootloader if there is no\n"+
+			"device in the bootloader mode (pico, teensy, stm32 targets,\n"+
+			"dfu target if -vid is given)",
+	)
+	all := fs.Bool(
+		"all", false,
+		"program concurrently all devices in the bootloader mode (all\n"+
+			"debug probes for the swd and stlink targets) and print the\n"+
+			"summary (pico, teensy, stm32, dfu, swd, stlink targets)",
+	)
+	wait := fs.Duration(
+		"wait", 0,
+		"wait up to `DURATION` for the device to appear in the bootloader\n"+
+			"mode, negative means forever. With -all it keeps programming\n"+
+			"every device as it appears until there is no new one for\n"+
+			"DURATION (station mode)",
 	)
-	busAddr := fs.String("usb", "", "select the USB device by `BUS:ADDR`")
 	quiet := fs.Bool("quiet", false, "do not print diagnostic information")
+	jsonOut := fs.Bool(
+		"json", false,
+		"print newline-delimited JSON events to stdout instead of the\n"+
+			"diagnostic information",
+	)
+	termOut := fs.Bool(
+		"term", false,
+		"connect to the serial port of the board after loading (see\n"+
+			"egtool term)",
+	)
 	fs.Parse(args)
+	util.JSON = *jsonOut
+	if *termOut && (*all || *jsonOut) {
+		util.Fatal("-term cannot be used with -all or -json")
+	}
 	if fs.NArg() > 1 {
 		fs.Usage()
 		os.Exit(1)
@@ -45,14 +153,87 @@ func Main(cmd string, args []string) {
 			util.Fatal("cannot determine the target by reading %s", elf)
 		}
 	}
-	switch *target {
+	o := &options{
+		target:    *target,
+		elf:       elf,
+		vendor:    usb.ID(*vid),
+		product:   usb.ID(*pid),
+		alt:       *alt,
+		drive:     *drive,
+		boot2:     *boot2,
+		part:      *part,
+		chip:      *chip,
+		swdClock:  int(*swdClock),
+		gdb:       *gdbAddr,
+		gdbReset:  *gdbReset,
+		flashSize: int(*flashSize) * 1024,
+		ram:       *ram,
+		diff:      *diff,
+		reboot:    *reboot,
+		unlock:    *unlock,
+	}
+	if *all {
+		var (
+			ok  bool
+			err error
+		)
+		if *wait != 0 {
+			ok, err = station(o, *quiet, *wait)
+		} else {
+			ok, err = loadAll(o, *quiet)
+		}
+		util.FatalErr("", err)
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+	j := &job{busAddr: *busAddr, quiet: *quiet}
+	t0 := time.Now()
+	err := loadWait(o, j, *wait)
+	if util.JSON {
+		j.result(err, t0)
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	util.FatalErr("", err)
+	if *termOut {
+		// The USB serial port of the program appears after reboot.
+		util.FatalErr("", term.Run(termConfig(o, j)))
+	}
+}
+
+// termConfig returns the configuration of the terminal connected to the
+// serial port of the just loaded board. The port is selected by the vendor ID
+// of the target. The serial port of a debug probe (its USB interface) is
+// selected by the probe serial number.
+func termConfig(o *options, j *job) *term.Config {
+	cfg := &term.Config{
+		Baud: 115200,
+		EOL:  "lf",
+		ELF:  o.elf,
+		Wait: 10 * time.Second,
+	}
+	switch o.target {
 	case "pico":
-		pico(elf, *busAddr, *quiet)
+		cfg.Vendor = uint16(picoboot.Vendor)
 	case "teensy":
-		teensy(elf, *busAddr, *quiet)
-	case "stm32":
-		dfuDev("stm32", elf, *busAddr, *quiet)
-	default:
-		util.Fatal("unknown target: %s", *target)
+		cfg.Vendor = uint16(halfkay.Vendor)
+	case "stm32", "stlink":
+		cfg.Vendor = uint16(stlink.Vendor) // STMicroelectronics
+	case "dfu":
+		cfg.Vendor = uint16(o.vendor)
+	}
+	if o.target == "swd" || o.target == "stlink" {
+		if devs, err := listDevs(o); err == nil {
+			for _, dev := range devs {
+				if j.busAddr == "" && len(devs) == 1 || dev.BusAddr == j.busAddr {
+					cfg.Serial = dev.Serial
+				}
+			}
+		}
 	}
+	return cfg
 }
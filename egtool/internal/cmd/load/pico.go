@@ -6,94 +6,335 @@ package load
 
 import (
 	"bytes"
-	"encoding/binary"
+	elfpkg "debug/elf"
+	"fmt"
 	"time"
 
 	"github.com/embeddedgo/tools/egtool/internal/picoboot"
+	"github.com/embeddedgo/tools/egtool/internal/uf2"
 	"github.com/embeddedgo/tools/egtool/internal/util"
 )
 
-func pico(elf, busAddr string, quiet bool) {
-	pb, err := picoboot.Connect(busAddr)
-	util.FatalErr("", err)
+func pico(o *options, j *job) error {
+	elf, part := o.elf, o.part
+	var rebootToBootsel func() error
+	if o.reboot {
+		rebootToBootsel = func() error {
+			return picoboot.RebootToBootsel(j.busAddr)
+		}
+	}
+	pb, err := connect(
+		func() (*picoboot.Conn, error) { return picoboot.Connect(j.busAddr) },
+		picoboot.ErrNotFound, rebootToBootsel,
+		func() (*picoboot.Conn, error) { return picoboot.Connect("") },
+		j,
+	)
+	if err != nil {
+		return err
+	}
 	defer pb.Close()
-	err = pb.ExclusiveAccess(true)
-	util.FatalErr("", err)
-
-	var buf [4]byte
-	pb.SetReadAddr(0x0000_0010)
-	_, err = pb.Read(buf[:])
-	util.FatalErr("", err)
+	err = pb.ExclusiveAccess(picoboot.Exclusive)
+	if err != nil {
+		return err
+	}
 
-	devTypeStr := "unknown"
-	devType := binary.LittleEndian.Uint32(buf[:]) & 0xffffff
+	devType, err := pb.Chip()
+	if err != nil {
+		return err
+	}
+	machine, entry, err := elfHeader(elf)
+	if err != nil {
+		return err
+	}
 	var uf2Type uint32
+	arch := picoboot.RebootToARM
 	switch devType {
-	case 0x01754d:
-		devTypeStr = "RP2040"
-		uf2Type = 0xe48bff56
-	case 0x02754d:
-		devTypeStr = "RP2350"
-		uf2Type = 0xe48bff59 // we support only rp2350_arm_s
-	}
-	if devTypeStr != "RP2350" {
-		util.Fatal("unsupported device type: %s (%#x)\n", devTypeStr, devType)
+	case picoboot.ChipRP2040:
+		uf2Type = uf2.FamilyMap["rp2040"]
+		if machine != elfpkg.EM_ARM {
+			return fmt.Errorf("pico: RP2040 supports only ARM images")
+		}
+	case picoboot.ChipRP2350:
+		switch machine {
+		case elfpkg.EM_ARM:
+			uf2Type = uf2.FamilyMap["rp2350_arm_s"]
+		case elfpkg.EM_RISCV:
+			uf2Type = uf2.FamilyMap["rp2350_riscv"]
+			arch = picoboot.RebootToRISCV
+		default:
+			return fmt.Errorf("pico: unsupported ELF machine: %v", machine)
+		}
+	default:
+		return fmt.Errorf(
+			"pico: unsupported device type: %s (%#x)",
+			picoboot.ChipName(devType), devType,
+		)
 	}
+	rp2040 := devType == picoboot.ChipRP2040
+	j.emit(&util.Event{Event: util.EvFound, Name: picoboot.ChipName(devType)})
 
-	// Check partition table
-	var info [4]uint32
-	err = pb.GetInfo(info[:], picoboot.UF2TargetPartition, uf2Type)
-	util.FatalErr("", err)
-	if info[0] != 3 {
-		util.Fatal("picoboot: GetInfo: UF2TargetPartition: bad response")
+	if o.ram {
+		return picoRAM(pb, j, rp2040, arch, elf, uint32(entry))
 	}
-	firstSector := info[2] & 0x1fff
-	lastSector := info[2] >> 13 & 0x1fff
-	if firstSector == 0 && lastSector == 8191 {
-		//TODO: whole flash, determine its size
+
+	var firstSector, lastSector uint32
+	tbyb := false
+	switch {
+	case rp2040:
+		if part != "" {
+			return fmt.Errorf("pico: RP2040 doesn't support partitions")
+		}
+		// No partitions, the whole 16 MiB flash address space.
+		firstSector, lastSector = 0, 4095
+	case part != "":
+		n, p, ab, err := picoPartition(pb, part, uf2Type)
+		if err != nil {
+			return fmt.Errorf("pico: %w", err)
+		}
+		if p.Flags&picoboot.PartAcceptsDefaultFamilies != 0 &&
+			p.Flags&uf2FamilyFlag(uf2Type) == 0 {
+			return fmt.Errorf("pico: partition %d doesn't accept the image family", n)
+		}
+		firstSector, lastSector = uint32(p.First), uint32(p.Last)
+		tbyb = ab
+		j.printf("Partition %d (sectors %d-%d)\n", n, firstSector, lastSector)
+	default:
+		// Check partition table
+		part, err := pb.UF2Target(uf2Type)
+		if err != nil {
+			return err
+		}
+		firstSector, lastSector = uint32(part.First), uint32(part.Last)
+		si, err := pb.SysInfo(picoboot.FlashDevInfo)
+		if err != nil {
+			return err
+		}
+		if flashSize := si.FlashSize(0); flashSize != 0 {
+			lastFlashSector := uint32(flashSize/4096 - 1)
+			if firstSector > lastFlashSector {
+				return fmt.Errorf("pico: the target partition is outside the flash")
+			}
+			lastSector = min(lastSector, lastFlashSector)
+		}
 	}
 
 	sections, err := util.ReadELF(elf)
-	util.FatalErr("", err)
+	if err != nil {
+		return err
+	}
+	if rp2040 {
+		sections, err = addBoot2(sections, o.boot2)
+		if err != nil {
+			return fmt.Errorf("pico: %w", err)
+		}
+	}
 	img := bytes.NewBuffer(make([]byte, 0, sections.Size()*5/4))
 	const pad = 0xff
 	_, err = sections.Flatten(img, pad)
-	util.FatalErr("", err)
-
-	addr := uint32(sections[0].Paddr)
-	if addr != 0x1000_0000 {
-		// For now we don't support partial loadings.
-		util.Fatal("the load address must be 0x1000_0000")
+	if err != nil {
+		return err
 	}
+	if tbyb {
+		// The new image in the A/B partition must be explicitly accepted
+		// by itself after the first boot, otherwise the boot ROM will
+		// go back to the previous one.
+		if err = setTBYB(img.Bytes()); err != nil {
+			return fmt.Errorf("pico: %w", err)
+		}
+	}
+
 	const (
+		flashBase = 0x1000_0000
 		sectSize  = 4096 // flash sector size
 		sectAlign = sectSize - 1
+		blockSize = 64 * 1024 // flash block size, max. read/write transfer
 	)
-	imgSize := (img.Len() + sectAlign) &^ sectAlign
-	img.Write(util.PadBytes(nil, imgSize-img.Len(), pad))
-	imgBytes := img.Bytes()
-	addr += firstSector * sectSize
-	pb.SetWriteAddr(addr)
+	// The image is linked at the beginning of the flash address space and
+	// the target partition is mapped there by the address translation.
+	paddr := sections[0].Paddr
+	partSize := int(lastSector-firstSector+1) * sectSize
+	if paddr < flashBase || paddr-flashBase+uint64(img.Len()) > uint64(partSize) {
+		return fmt.Errorf(
+			"pico: the image (%#x-%#x) doesn't fit in the target partition/flash (%d KiB)",
+			paddr, paddr+uint64(img.Len()), partSize/1024,
+		)
+	}
+	addr := uint32(paddr) + firstSector*sectSize
 
-	util.FatalErr("", pb.ExitXIP()) // nop
-	for i := 0; i < imgSize; i += sectSize {
-		if !quiet {
-			util.Progress("Loading:", i, imgSize, 1024, "KiB")
+	// Align the image to the sector boundaries preserving the remaining
+	// content of the first and last sector.
+	head := int(addr & sectAlign)
+	tail := -(int(addr) + img.Len()) & sectAlign
+	addr -= uint32(head)
+	imgBytes := make([]byte, head+img.Len()+tail)
+	if rp2040 && (head != 0 || tail != 0 || o.diff) {
+		// RP2040 can read the flash only in the XIP mode.
+		if err = pb.EnterXIP(); err != nil {
+			return err
+		}
+	}
+	if head != 0 {
+		pb.SetReadAddr(addr)
+		_, err = pb.Read(imgBytes[:head])
+		if err != nil {
+			return err
 		}
+	}
+	copy(imgBytes[head:], img.Bytes())
+	if tail != 0 {
+		pb.SetReadAddr(addr + uint32(len(imgBytes)-tail))
+		_, err = pb.Read(imgBytes[len(imgBytes)-tail:])
+		if err != nil {
+			return err
+		}
+	}
+	imgSize := len(imgBytes)
+	nsect := imgSize / sectSize
 
-		err = pb.FlashErase(pb.WriteAddr(), sectSize)
-		util.FatalErr("", err)
-		util.FatalErr("", pb.ExitXIP()) // nop
+	// Determine the sectors to be written before touching the flash so the
+	// erase/write sequence below isn't interleaved with reads and XIP mode
+	// switching.
+	dirty := make([]bool, nsect)
+	ndirty := nsect
+	for i := range dirty {
+		dirty[i] = true
+	}
+	if o.diff {
+		// The read-back content is compared with the image so it is
+		// reported as the verification.
+		ndirty = 0
+		buf := make([]byte, blockSize)
+		for i := 0; i < imgSize; i += blockSize {
+			j.progress(util.EvVerify, "Comparing:", i, imgSize)
+			n := min(blockSize, imgSize-i)
+			pb.SetReadAddr(addr + uint32(i))
+			_, err = pb.Read(buf[:n])
+			if err != nil {
+				return err
+			}
+			for k := 0; k < n; k += sectSize {
+				d := !bytes.Equal(buf[k:k+sectSize], imgBytes[i+k:i+k+sectSize])
+				dirty[(i+k)/sectSize] = d
+				if d {
+					ndirty++
+				}
+			}
+		}
+		j.progress(util.EvVerify, "Compared: ", imgSize, imgSize)
+	}
 
-		_, err = pb.Write(imgBytes[i : i+sectSize])
-		util.FatalErr("", err)
-		util.FatalErr("", pb.ExitXIP()) // nop
+	if err = pb.ExitXIP(); err != nil { // nop on RP2350
+		return err
+	}
+	total, done := ndirty*sectSize, 0
+	t0 := time.Now()
+	for i := 0; i < nsect; {
+		if !dirty[i] {
+			i++
+			continue
+		}
+		// Erase and write the run of dirty sectors up to the end of the
+		// 64 KiB flash block. The boot ROM uses the block erase command
+		// for the aligned 64 KiB ranges.
+		a := addr + uint32(i)*sectSize
+		e := i + 1
+		for e < nsect && dirty[e] && (addr+uint32(e)*sectSize)%blockSize != 0 {
+			e++
+		}
+		j.progress(util.EvWrite, "Loading:", done, total)
+		data := imgBytes[i*sectSize : e*sectSize]
+		j.emit(&util.Event{Event: util.EvErase, Addr: a, Total: len(data)})
+		err = pb.FlashErase(a, len(data))
+		if err != nil {
+			return err
+		}
+		pb.SetWriteAddr(a)
+		_, err = pb.Write(data)
+		if err != nil {
+			return err
+		}
+		done += len(data)
+		i = e
+	}
+	if total != 0 {
+		dt := time.Since(t0)
+		j.progress(util.EvWrite, "Loaded: ", total, total)
+		j.printf(
+			"Written %d KiB in %.2f s (%.1f KiB/s)\n",
+			total/1024, dt.Seconds(), float64(total)/1024/dt.Seconds(),
+		)
+	}
+	if o.diff {
+		j.printf("Skipped %d of %d unchanged sectors\n", nsect-ndirty, nsect)
+	}
+	j.emit(&util.Event{Event: util.EvReboot, Msg: "program"})
+
+	switch {
+	case rp2040:
+		err = pb.Reboot(0, 0x2004_2000, time.Second/2)
+	case part != "":
+		// Tell the boot ROM which region was updated so it prefers it
+		// (and handles TBYB) in the next boot.
+		err = pb.Reboot2(
+			picoboot.RebootFlashUpdate|arch, time.Second/2,
+			flashBase+firstSector*sectSize, 0,
+		)
+	default:
+		// The arch flag makes the chip switch the architecture if needed.
+		err = pb.Reboot2(picoboot.RebootNormal|arch, time.Second/2, 0, 0)
+	}
+	return err
+}
 
+// picoRAM loads the image linked for SRAM and runs it.
+func picoRAM(pb *picoboot.Conn, j *job, rp2040 bool, arch uint32, elfName string, entry uint32) error {
+	const ramBase = 0x2000_0000
+	ramEnd := uint64(0x2008_2000) // RP2350: 520 KiB
+	if rp2040 {
+		ramEnd = 0x2004_2000 // RP2040: 264 KiB
+	}
+	sections, err := util.ReadELF(elfName)
+	if err != nil {
+		return err
+	}
+	sections.SortByPaddr()
+	start := sections[0].Paddr
+	last := sections[len(sections)-1]
+	end := last.Paddr + uint64(len(last.Data))
+	if start < ramBase || end > ramEnd {
+		return fmt.Errorf(
+			"pico: the image (%#x-%#x) doesn't fit in SRAM (%#x-%#x)",
+			start, end, ramBase, ramEnd,
+		)
 	}
-	if !quiet {
-		util.Progress("Loaded: ", imgSize, imgSize, 1024, "KiB")
+
+	const chunkSize = 4096
+	size, done := int(sections.Size()), 0
+	for _, s := range sections {
+		pb.SetWriteAddr(uint32(s.Paddr))
+		for i := 0; i < len(s.Data); i += chunkSize {
+			j.progress(util.EvWrite, "Loading:", done, size)
+			chunk := s.Data[i:min(i+chunkSize, len(s.Data))]
+			_, err = pb.Write(chunk)
+			if err != nil {
+				return err
+			}
+			done += len(chunk)
+		}
 	}
+	j.progress(util.EvWrite, "Loaded: ", size, size)
 
-	err = pb.Reboot2(picoboot.RebootNormal, time.Second/2, 0, 0)
-	util.FatalErr("", err)
+	j.emit(&util.Event{Event: util.EvReboot, Msg: "program"})
+	if rp2040 {
+		// The EXEC command acknowledges after the called function returns,
+		// which the program never does, so use the watchdog reboot that
+		// starts the code at pc with the sp at the top of SRAM.
+		err = pb.Reboot(entry|1, uint32(ramEnd), time.Second/2)
+	} else {
+		err = pb.Reboot2(
+			picoboot.RebootRAMImage|arch, time.Second/2,
+			uint32(start), uint32(end-start),
+		)
+	}
+	return err
 }
@@ -0,0 +1,153 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package load
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/embeddedgo/tools/egtool/internal/picoboot"
+	"github.com/embeddedgo/tools/egtool/internal/uf2"
+)
+
+// picoPartition selects the partition according to the spec which can be the
+// partition number, its name or "ab" which means the non-booted partition of
+// the A/B pair. It returns the partition number, its description and reports
+// whether the partition is a part of an A/B pair.
+func picoPartition(pb *picoboot.Conn, spec string, family uint32) (n int, p *picoboot.PartInfo, ab bool, err error) {
+	pt, err := pb.PartitionTable()
+	if err != nil {
+		return
+	}
+	if !pt.Present || len(pt.Partitions) == 0 {
+		err = errors.New("there is no partition table")
+		return
+	}
+	n = -1
+	switch {
+	case spec == "ab":
+		si, err1 := pb.SysInfo(picoboot.BootInfo)
+		if err = err1; err != nil {
+			return
+		}
+		booted := int(si.Boot.Partition)
+		if booted < 0 || booted >= len(pt.Partitions) {
+			// The last boot wasn't from a partition (e.g. the device
+			// was reset into BOOTSEL). The boot ROM chooses the UF2
+			// target partition of the A/B pair the same way.
+			tp, err1 := pb.UF2Target(family)
+			if err = err1; err != nil {
+				return
+			}
+			for i, p := range pt.Partitions {
+				if p.First == tp.First && p.Last == tp.Last {
+					n = i
+					break
+				}
+			}
+		} else {
+			n = pt.ABPartner(booted)
+		}
+		if n < 0 || pt.ABPartner(n) < 0 {
+			err = errors.New("cannot find the non-booted partition of an A/B pair")
+			return
+		}
+	default:
+		if i, err1 := strconv.ParseUint(spec, 0, 8); err1 == nil {
+			n = int(i)
+			if n >= len(pt.Partitions) {
+				err = fmt.Errorf("there is no partition %d", n)
+				return
+			}
+			break
+		}
+		for i, p := range pt.Partitions {
+			if p.Flags&picoboot.PartHasName != 0 && p.Name == spec {
+				n = i
+				break
+			}
+		}
+		if n < 0 {
+			err = fmt.Errorf("there is no partition named %q", spec)
+			return
+		}
+	}
+	return n, &pt.Partitions[n], pt.ABPartner(n) >= 0, nil
+}
+
+// uf2FamilyFlag returns the partition flag that tells the partition accepts
+// the given UF2 family.
+func uf2FamilyFlag(family uint32) uint32 {
+	switch family {
+	case uf2.FamilyMap["absolute"]:
+		return picoboot.PartAcceptsAbsolute
+	case uf2.FamilyMap["rp2040"]:
+		return picoboot.PartAcceptsRP2040
+	case uf2.FamilyMap["rp2350_arm_s"]:
+		return picoboot.PartAcceptsRP2350ARMS
+	case uf2.FamilyMap["rp2350_arm_ns"]:
+		return picoboot.PartAcceptsRP2350ARMNS
+	case uf2.FamilyMap["rp2350_riscv"]:
+		return picoboot.PartAcceptsRP2350RISCV
+	case uf2.FamilyMap["data"]:
+		return picoboot.PartAcceptsData
+	}
+	return 0
+}
+
+// Picobin block constants.
+const (
+	blockMarkerStart = 0xffffded3
+
+	itemImageType = 0x42
+	itemSignature = 0x09
+	itemHashValue = 0x1b
+	itemLast      = 0xff
+
+	imageTypeTBYB = 0x8000 // try before you buy
+)
+
+// setTBYB finds the IMAGE_DEF block in the first 4 KiB of the image and sets
+// the try-before-you-buy flag in its IMAGE_TYPE item.
+func setTBYB(img []byte) error {
+	le := binary.LittleEndian
+	end := min(len(img), 4096) &^ 3
+	for i := 0; i+8 <= end; i += 4 {
+		if le.Uint32(img[i:]) != blockMarkerStart {
+			continue
+		}
+		imageType := -1
+		for k := i + 4; k+4 <= len(img); {
+			item := le.Uint32(img[k:])
+			typ := item & 0xff
+			if typ == itemLast {
+				break
+			}
+			size := int(item >> 8 & 0xff)
+			if item&0x80 != 0 {
+				size = int(item >> 8 & 0xffff) // 2-byte size item
+			}
+			if size == 0 {
+				return errors.New("malformed block in the image")
+			}
+			switch typ {
+			case itemImageType:
+				imageType = k
+			case itemSignature, itemHashValue:
+				return errors.New("cannot set the TBYB flag of a signed/hashed image")
+			}
+			k += size * 4
+		}
+		if imageType < 0 {
+			continue // not an IMAGE_DEF block
+		}
+		item := le.Uint32(img[imageType:])
+		le.PutUint32(img[imageType:], item|imageTypeTBYB<<16)
+		return nil
+	}
+	return errors.New("no IMAGE_DEF block in the first 4 KiB of the image")
+}
@@ -0,0 +1,48 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package load
+
+import (
+	"errors"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/util"
+)
+
+// rebootTimeout is the maximum time of waiting for the device to enumerate in
+// the bootloader mode after reboot.
+const rebootTimeout = 10 * time.Second
+
+// connect calls conn to connect to the device in the bootloader mode. If there
+// is no such device (conn returned notFound) and reboot isn't nil it calls
+// reboot to restart the running program into the bootloader and next calls
+// again until the device appears or the rebootTimeout expires.
+func connect[C any](conn func() (C, error), notFound error, reboot func() error, again func() (C, error), j *job) (c C, err error) {
+	c, err = conn()
+	if reboot == nil || !errors.Is(err, notFound) {
+		return
+	}
+	if err1 := reboot(); err1 != nil {
+		if !errors.Is(err1, notFound) {
+			err = err1
+		}
+		return
+	}
+	j.printf("Rebooting into the bootloader... ")
+	j.emit(&util.Event{Event: util.EvReboot, Msg: "bootloader"})
+	for deadline := time.Now().Add(rebootTimeout); time.Now().Before(deadline); {
+		time.Sleep(100 * time.Millisecond)
+		c, err = again()
+		if !errors.Is(err, notFound) {
+			break
+		}
+	}
+	if err == nil {
+		j.printf("done\n")
+	} else {
+		j.printf("\n")
+	}
+	return
+}
@@ -0,0 +1,33 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package load
+
+import (
+	"github.com/embeddedgo/tools/egtool/internal/cortexm"
+	"github.com/embeddedgo/tools/egtool/internal/stlink"
+)
+
+// stlinkDev loads the program into the flash of the microcontroller connected
+// to the ST-LINK debug probe.
+func stlinkDev(o *options, j *job) error {
+	probe, err := stlink.Connect(j.busAddr)
+	if err != nil {
+		return err
+	}
+	defer probe.Close()
+	if err = probe.EnterSWD(o.swdClock); err != nil {
+		return err
+	}
+	defer probe.Exit()
+	core := &cortexm.Core{Mem: probe}
+	if err = core.ResetHalt(); err != nil {
+		return err
+	}
+	algo, err := openAlgo(o, j, core)
+	if err != nil {
+		return err
+	}
+	return flash(o, j, core, algo)
+}
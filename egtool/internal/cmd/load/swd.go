@@ -0,0 +1,58 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package load
+
+import (
+	"github.com/embeddedgo/tools/egtool/internal/cmsisdap"
+	"github.com/embeddedgo/tools/egtool/internal/cortexm"
+	"github.com/embeddedgo/tools/egtool/internal/flashalgo"
+	"github.com/embeddedgo/tools/egtool/internal/swd"
+)
+
+// rp2350CoreAP is the ADIv6 address of the RP2350 Arm core 0 MEM-AP.
+const rp2350CoreAP = 0x2000
+
+// swdDev loads the program into the flash of the microcontroller connected to
+// the CMSIS-DAP debug probe.
+func swdDev(o *options, j *job) error {
+	probe, err := cmsisdap.Connect(j.busAddr)
+	if err != nil {
+		return err
+	}
+	defer probe.Close()
+	if err = probe.ConnectSWD(o.swdClock * 1000); err != nil {
+		return err
+	}
+	defer probe.Disconnect()
+	dp, err := swd.Connect(probe)
+	if err != nil {
+		return err
+	}
+	var ap uint32
+	if dp.ADIv6() {
+		ap = rp2350CoreAP // the only supported ADIv6 target
+	} else if err = flashalgo.CheckNRF52(dp); err != nil {
+		// The APPROTECT disables the AHB-AP, check it before using it.
+		err = unprotect(o, j, err, func() error {
+			return flashalgo.NRF52EraseAll(dp)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	mem, err := dp.MemAP(ap)
+	if err != nil {
+		return err
+	}
+	core := &cortexm.Core{Mem: mem}
+	if err = core.ResetHalt(); err != nil {
+		return err
+	}
+	algo, err := openAlgo(o, j, core)
+	if err != nil {
+		return err
+	}
+	return flash(o, j, core, algo)
+}
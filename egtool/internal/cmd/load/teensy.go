@@ -6,71 +6,93 @@ package load
 
 import (
 	"bytes"
-	"time"
+	"errors"
+	"fmt"
 
+	"github.com/embeddedgo/tools/egtool/internal/halfkay"
 	"github.com/embeddedgo/tools/egtool/internal/imxmbr"
 	"github.com/embeddedgo/tools/egtool/internal/util"
-	usb "github.com/google/gousb"
 )
 
-func teensy(elf, busAddr string, quiet bool) {
-	ctx, devs, err := util.OpenUSB(0x16C0, 0x0478, busAddr)
-	util.FatalErr("", err)
-	defer ctx.Close()
-	if len(devs) == 0 {
-		util.Fatal("no USB devices in the bootloader mode were found")
+func teensy(o *options, j *job) error {
+	flashSize := o.flashSize
+	var rebootToHalfKay func() error
+	if o.reboot {
+		rebootToHalfKay = func() error { return halfkay.Reboot(j.busAddr) }
 	}
-	if len(devs) != 1 {
-		util.Fatal("found more than one USB device in the bootloader mode")
+	hk, err := connect(
+		func() (*halfkay.Conn, error) { return halfkay.Connect(j.busAddr) },
+		halfkay.ErrNotFound, rebootToHalfKay,
+		func() (*halfkay.Conn, error) { return halfkay.Connect("") },
+		j,
+	)
+	if err != nil {
+		return err
+	}
+	defer hk.Close()
+
+	model := hk.Model()
+	if flashSize != 0 {
+		m := &halfkay.Model{
+			Name:      "Teensy 4.x",
+			FlashSize: flashSize, CodeSize: halfkay.CodeSize(flashSize),
+			BlockSize: 1024, FlexRAMCfg: halfkay.Teensy41.FlexRAMCfg,
+		}
+		if model != nil {
+			m.Name = model.Name
+			m.BlockSize = model.BlockSize
+			m.FlexRAMCfg = model.FlexRAMCfg
+		}
+		if m.CodeSize <= 0 {
+			return fmt.Errorf("teensy: bad flash size: %d KiB", flashSize/1024)
+		}
+		model = m
+	}
+	if model == nil {
+		return errors.New("teensy: unknown board model, use the -flash option")
 	}
-	dev := devs[0]
-	dev.SetAutoDetach(true)
-	cfg, err := dev.Config(1)
-	util.FatalErr("", err)
-	defer cfg.Close()
-	ifa, err := cfg.Interface(0, 0)
-	util.FatalErr("", err)
-	defer ifa.Close()
+	j.printf("Found %s\n", model.Name)
+	j.emit(&util.Event{Event: util.EvFound, Name: model.Name})
 
-	sections, err := util.ReadELF(elf)
-	util.FatalErr("", err)
+	sections, err := util.ReadELF(o.elf)
+	if err != nil {
+		return err
+	}
 
-	const (
-		flashSize  = 16 * 1024 * 1024 // TODO: determine the actual size
-		flexRAMCfg = 0x5555_5556      // 480 KiB OCRAM, 32 KiB DTCM
-	)
-	mbr := imxmbr.Make(flashSize, 0, flexRAMCfg)
+	mbr := imxmbr.Make(model.FlashSize, 0, model.FlexRAMCfg)
 	sections = append(sections, &util.Section{Paddr: 0x6000_0000, Data: mbr})
 
 	img := bytes.NewBuffer(make([]byte, 0, sections.Size()*5/4))
 	const pad = 0xff
-	_, err = sections.Flatten(img, pad)
-	util.FatalErr("", err)
+	if _, err = sections.Flatten(img, pad); err != nil {
+		return err
+	}
 
 	addr := uint32(sections[0].Paddr)
 	if addr != 0x6000_0000 {
 		// For now we don't support partial loadings.
-		util.Fatal("the load address must be 0x6000_0000")
+		return errors.New("teensy: the load address must be 0x6000_0000")
+	}
+	if img.Len() > model.CodeSize {
+		return fmt.Errorf(
+			"teensy: the image (%d KiB) doesn't fit in the %s flash (%d KiB)",
+			(img.Len()+1023)/1024, model.Name, model.CodeSize/1024,
+		)
 	}
 
-	// Teensy 4.x constants
-	const (
-		blockSize  = 1024
-		blockAlign = blockSize - 1
-	)
+	blockSize := model.BlockSize
+	blockAlign := blockSize - 1
 	imgSize := (img.Len() + blockAlign) &^ blockAlign
 	img.Write(util.PadBytes(nil, imgSize-img.Len(), pad))
-	var buf [64 + blockSize]byte
+	buf := make([]byte, blockSize)
 
 	// Load
 	cnt := 0
 	for addr := 0; addr < imgSize; addr += blockSize {
-		if !quiet {
-			util.Progress("Loading:", addr, imgSize, 1024, "KiB")
-		}
-		img.Read(buf[64:])
+		j.progress(util.EvWrite, "Loading:", addr, imgSize)
+		img.Read(buf)
 		if addr != 0 {
-			for _, b := range buf[64:] {
+			for _, b := range buf {
 				if b != pad {
 					goto write
 				}
@@ -78,31 +100,14 @@ func teensy(elf, busAddr string, quiet bool) {
 			continue
 		}
 	write:
-		buf[0] = byte(addr)
-		buf[1] = byte(addr >> 8)
-		buf[2] = byte(addr >> 16)
-		util.FatalErr("", teensyWrite(dev, buf[:]))
+		if err = hk.Write(addr, buf); err != nil {
+			return err
+		}
 		cnt++
 	}
-	if !quiet {
-		util.Progress("Loaded: ", imgSize, imgSize, 1024, "KiB")
-	}
+	j.progress(util.EvWrite, "Loaded: ", imgSize, imgSize)
 
 	// Boot
-	buf[0] = 0xff
-	buf[1] = 0xff
-	buf[2] = 0xff
-	clear(buf[64:])
-	util.FatalErr("", teensyWrite(dev, buf[:]))
-}
-
-func teensyWrite(dev *usb.Device, buf []byte) (err error) {
-	for range 500 {
-		_, err = dev.Control(0x21, 9, 0x0200, 0, buf)
-		if err == nil {
-			return
-		}
-		time.Sleep(10 * time.Millisecond)
-	}
-	return
+	j.emit(&util.Event{Event: util.EvReboot, Msg: "program"})
+	return hk.Boot(blockSize)
 }
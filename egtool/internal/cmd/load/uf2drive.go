@@ -0,0 +1,102 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package load
+
+import (
+	"bytes"
+	"debug/elf"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/uf2"
+	"github.com/embeddedgo/tools/egtool/internal/util"
+)
+
+var errNoDrive = errors.New("uf2drive: no UF2 drives were found")
+
+func uf2Drive(o *options, j *job) error {
+	var d *uf2.Drive
+	if o.drive != "" {
+		var err error
+		d, err = uf2.OpenDrive(o.drive)
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w (%s)", errNoDrive, o.drive) // not mounted yet
+		}
+		if err != nil {
+			return fmt.Errorf("uf2drive: %w", err)
+		}
+	} else {
+		drives, err := uf2.Drives()
+		if err != nil {
+			return err
+		}
+		switch len(drives) {
+		case 0:
+			return errNoDrive
+		case 1:
+			d = drives[0]
+		default:
+			paths := make([]string, len(drives))
+			for i, d := range drives {
+				paths[i] = d.Path
+			}
+			return fmt.Errorf(
+				"uf2drive: found more than one UF2 drive (use -drive): %s",
+				strings.Join(paths, ", "),
+			)
+		}
+	}
+	j.emit(&util.Event{Event: util.EvFound, Device: d.Path, Name: d.BoardID()})
+	f, err := elf.Open(o.elf)
+	if err != nil {
+		return err
+	}
+	familyName, family, ok := d.FamilyELF(f)
+	f.Close()
+	if !ok {
+		return fmt.Errorf(
+			"uf2drive: unknown UF2 family of the %s board (%s)",
+			d.BoardID(), d.Path,
+		)
+	}
+
+	sections, err := util.ReadELF(o.elf)
+	if err != nil {
+		return err
+	}
+	img := bytes.NewBuffer(make([]byte, 0, sections.Size()*5/4))
+	const pad = 0xff
+	if _, err = sections.Flatten(img, pad); err != nil {
+		return err
+	}
+	addr := uint32(sections[0].Paddr)
+	if uint64(addr) != sections[0].Paddr {
+		return fmt.Errorf("uf2drive: the load address %#x doesn't fit in 32 bits", sections[0].Paddr)
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, img.Len()*2+512))
+	w := uf2.NewWriter(buf, addr, uf2.FamilyIDPresent, family, img.Len())
+	if _, err = w.Write(img.Bytes()); err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	j.printf(
+		"Copying %d KiB to %s (%s, %s)... ",
+		(img.Len()+1023)/1024, d.Path, d.BoardID(), familyName,
+	)
+	n := buf.Len()
+	j.emit(&util.Event{Event: util.EvWrite, Device: d.Path, Total: n})
+	if err = d.Copy("NEW.UF2", buf.Bytes(), 10*time.Second); err != nil {
+		return fmt.Errorf("uf2drive: %w", err)
+	}
+	j.emit(&util.Event{Event: util.EvWrite, Device: d.Path, Done: n, Total: n})
+	j.printf("done\n")
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package load
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/cmsisdap"
+	"github.com/embeddedgo/tools/egtool/internal/dfu"
+	"github.com/embeddedgo/tools/egtool/internal/halfkay"
+	"github.com/embeddedgo/tools/egtool/internal/picoboot"
+	"github.com/embeddedgo/tools/egtool/internal/stlink"
+	"github.com/embeddedgo/tools/egtool/internal/util"
+)
+
+// pollPeriod is the period of checking for the new devices. The gousb doesn't
+// support the libusb hotplug API so the bus is polled.
+const pollPeriod = 250 * time.Millisecond
+
+// notFound reports whether the err means there is no device to load onto.
+func notFound(err error) bool {
+	return errors.Is(err, picoboot.ErrNotFound) ||
+		errors.Is(err, halfkay.ErrNotFound) ||
+		errors.Is(err, dfu.ErrNotFound) ||
+		errors.Is(err, cmsisdap.ErrNotFound) ||
+		errors.Is(err, stlink.ErrNotFound) ||
+		errors.Is(err, errNoDrive)
+}
+
+// expired reports whether the wait time, measured from t0, has expired. The
+// negative wait means forever.
+func expired(t0 time.Time, wait time.Duration) bool {
+	return wait >= 0 && time.Since(t0) >= wait
+}
+
+// loadWait loads the program onto the device described by j. If there is no
+// such device it waits for it up to the wait time.
+func loadWait(o *options, j *job, wait time.Duration) error {
+	t0 := time.Now()
+	for waiting := false; ; waiting = true {
+		err := o.load(j)
+		if !notFound(err) || expired(t0, wait) {
+			return err
+		}
+		if !waiting {
+			j.printf("Waiting for the device...\n")
+		}
+		time.Sleep(pollPeriod)
+	}
+}
+
+// station loads the program onto every device that appears on the bus in the
+// bootloader mode until there is no new device for the wait time (the
+// negative wait means forever). The device isn't programmed again until it
+// leaves the bootloader mode (reboots or is unplugged). Station prints the
+// result of every device as soon as it is known and the summary at the end.
+// It returns false if any device failed.
+func station(o *options, quiet bool, wait time.Duration) (bool, error) {
+	// The rebooted devices enumerate again at unknown locations.
+	o.reboot = false
+	if _, err := listDevs(o); err != nil {
+		return false, err
+	}
+	if !quiet && !util.JSON {
+		fmt.Fprintf(os.Stderr, "Waiting for devices in the bootloader mode...\n")
+	}
+	mu := new(sync.Mutex)
+	done := make(chan result)
+	handled := make(map[string]bool) // devices seen in the bootloader mode
+	busy := make(map[string]bool)    // devices being programmed
+	var results []result
+	idle := time.Now()
+	tick := time.NewTicker(pollPeriod)
+	defer tick.Stop()
+	for len(busy) != 0 || !expired(idle, wait) {
+		select {
+		case r := <-done:
+			delete(busy, r.dev.BusAddr)
+			idle = time.Now()
+			results = append(results, r)
+			if util.JSON {
+				continue
+			}
+			mu.Lock()
+			fmt.Printf(
+				"%s %s %.1fs %s\n",
+				r.dev.BusAddr, serial(r.dev), r.dt.Seconds(), resultStr(r.err),
+			)
+			mu.Unlock()
+		case <-tick.C:
+			devs, err := listDevs(o)
+			if err != nil {
+				util.Warn("load: %v", err)
+				continue
+			}
+			present := make(map[string]bool, len(devs))
+			for _, dev := range devs {
+				present[dev.BusAddr] = true
+				if handled[dev.BusAddr] {
+					continue
+				}
+				handled[dev.BusAddr] = true
+				busy[dev.BusAddr] = true
+				idle = time.Now()
+				go func() {
+					j := &job{
+						busAddr: dev.BusAddr, serial: dev.Serial, quiet: quiet,
+						label: dev.BusAddr, mu: mu,
+					}
+					t0 := time.Now()
+					err := o.load(j)
+					j.result(err, t0)
+					if err != nil {
+						j.printf("%v\n", err)
+					}
+					done <- result{dev, err, time.Since(t0)}
+				}()
+			}
+			for addr := range handled {
+				if !present[addr] && !busy[addr] {
+					delete(handled, addr)
+				}
+			}
+		}
+	}
+	return printSummary(results), nil
+}
@@ -0,0 +1,216 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package otp
+
+import (
+	"flag"
+	"fmt"
+	"math/bits"
+	"os"
+	"strings"
+
+	"github.com/embeddedgo/tools/egtool/internal/picoboot"
+	"github.com/embeddedgo/tools/egtool/internal/util"
+)
+
+// Known OTP rows (see the RP2350 datasheet, OTP data list).
+const (
+	rowChipID0      = 0x000 // 4 ECC rows
+	rowRandID0      = 0x004 // 8 ECC rows
+	rowCrit0        = 0x038 // 8 raw copies
+	rowCrit1        = 0x040 // 8 raw copies
+	rowBootFlags0   = 0x048 // 3 raw copies
+	rowBootFlags1   = 0x04b // 3 raw copies
+	rowFlashDevInfo = 0x054 // ECC
+	rowUSBBootFlags = 0x059 // 3 raw copies
+	rowBootKey0     = 0x080 // 4 keys, 16 ECC rows each
+	rowPage0Lock0   = 0xf80 // 2 raw rows per page
+)
+
+var bootFlags0Names = []string{
+	"DISABLE_BOOTSEL_EXEC2",
+	"ENABLE_BOOTSEL_LED",
+	"ENABLE_BOOTSEL_NON_DEFAULT_PLL_XOSC_CFG",
+	"FAST_SIGCHECK_ROSC_DIV",
+	"FLASH_IO_VOLTAGE_1V8",
+	"FLASH_DEVINFO_ENABLE",
+	"OVERRIDE_FLASH_PARTITION_SLOT_SIZE",
+	"SINGLE_FLASH_BINARY",
+	"DISABLE_AUTO_SWITCH_ARCH",
+	"SECURE_PARTITION_TABLE",
+	"DISABLE_FLASH_BOOT",
+	"DISABLE_OTP_BOOT",
+	"ENABLE_OTP_BOOT",
+	"DISABLE_POWER_SCRATCH",
+	"DISABLE_WATCHDOG_SCRATCH",
+	"DISABLE_SRAM_WINDOW_BOOT",
+	"DISABLE_XIP_ACCESS_ON_SRAM_ENTRY",
+	"ROLLBACK_REQUIRED",
+	"HASHED_PARTITION_TABLE",
+}
+
+var lockNames = [4]string{"rw", "ro", "reserved", "inaccessible"}
+
+// vote returns the value of the redundantly stored row. A bit is considered
+// set if it is set in at least n copies.
+func vote(copies []uint32, n int) uint32 {
+	var v uint32
+	for bit := 0; bit < 24; bit++ {
+		k := 0
+		for _, c := range copies {
+			k += int(c >> bit & 1)
+		}
+		if k >= n {
+			v |= 1 << bit
+		}
+	}
+	return v
+}
+
+func flagNames(v uint32, names []string) string {
+	var s []string
+	for v != 0 {
+		bit := bits.TrailingZeros32(v)
+		v &^= 1 << bit
+		if bit < len(names) {
+			s = append(s, names[bit])
+		} else {
+			s = append(s, fmt.Sprintf("bit%d", bit))
+		}
+	}
+	if len(s) == 0 {
+		return "none"
+	}
+	return strings.Join(s, " ")
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func decode(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage:\n  %s [OPTIONS]\nOptions:\n", cmd)
+		fs.PrintDefaults()
+	}
+	busAddr := fs.String("usb", "", "select the USB device by `BUS:ADDR`")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	pb := connect(*busAddr)
+	defer pb.Close()
+
+	read := func(row, n int, ecc bool) []uint32 {
+		rows, err := readRows(pb, row, n, ecc)
+		util.FatalErr("", err)
+		return rows
+	}
+
+	id := read(rowChipID0, 4, true)
+	fmt.Printf("CHIPID:        %04x%04x%04x%04x\n", id[3], id[2], id[1], id[0])
+	rid := read(rowRandID0, 8, true)
+	fmt.Printf("RANDID:        ")
+	for i := 7; i >= 0; i-- {
+		fmt.Printf("%04x", rid[i])
+	}
+	fmt.Println()
+
+	crit0 := vote(read(rowCrit0, 8, false), 3)
+	crit1 := vote(read(rowCrit1, 8, false), 3)
+	crit := crit1 | crit0<<16 // the same layout as in SysInfo.Critical
+	fmt.Printf("CRIT0:         %#06x\n", crit0)
+	fmt.Printf("  ARM disable:      %s\n", onOff(crit&picoboot.CritARMDisable != 0))
+	fmt.Printf("  RISC-V disable:   %s\n", onOff(crit&picoboot.CritRISCVDisable != 0))
+	fmt.Printf("CRIT1:         %#06x\n", crit1)
+	fmt.Printf("  secure boot:      %s\n", onOff(crit&picoboot.CritSecureBootEnable != 0))
+	fmt.Printf("  secure debug dis: %s\n", onOff(crit&picoboot.CritSecureDebugDisable != 0))
+	fmt.Printf("  debug disable:    %s\n", onOff(crit&picoboot.CritDebugDisable != 0))
+	arch := "ARM"
+	if crit&picoboot.CritDefaultArchSel != 0 {
+		arch = "RISC-V"
+	}
+	fmt.Printf("  boot arch:        %s\n", arch)
+	fmt.Printf("  glitch detector:  %s (sensitivity %d)\n",
+		onOff(crit&picoboot.CritGlitchDetectorEna != 0),
+		crit&picoboot.CritGlitchDetectorSens>>5,
+	)
+
+	flags0 := vote(read(rowBootFlags0, 3, false), 2)
+	flags1 := vote(read(rowBootFlags1, 3, false), 2)
+	fmt.Printf("BOOT_FLAGS0:   %#08x\n  %s\n", flags0, flagNames(flags0, bootFlags0Names))
+	keyValid := flags1 & 0xf
+	keyInvalid := flags1 >> 8 & 0xf
+	fmt.Printf("BOOT_FLAGS1:   %#08x\n", flags1)
+	fmt.Printf("  key valid:        %04b\n", keyValid)
+	fmt.Printf("  key invalid:      %04b\n", keyInvalid)
+	fmt.Printf("  double tap:       %s (delay %d)\n",
+		onOff(flags1>>19&1 != 0), flags1>>16&7,
+	)
+
+	di := read(rowFlashDevInfo, 1, true)[0]
+	si := picoboot.SysInfo{FlashDevInfo: di}
+	fmt.Printf("FLASH_DEVINFO: %#06x\n", di)
+	fmt.Printf("  CS0 size:         %d KiB\n", si.FlashSize(0)/1024)
+	fmt.Printf("  CS1 size:         %d KiB (GPIO %d)\n", si.FlashSize(1)/1024, di&0x1f)
+	fmt.Printf("  D8h erase:        %s\n", onOff(di>>7&1 != 0))
+
+	usbFlags := vote(read(rowUSBBootFlags, 3, false), 2)
+	fmt.Printf("USB_BOOT_FLAGS: %#08x\n", usbFlags)
+
+	keys := read(rowBootKey0, 4*16, true)
+	for k := range 4 {
+		key := keys[k*16 : k*16+16]
+		fmt.Printf("BOOTKEY%d:      ", k)
+		empty := true
+		for _, v := range key {
+			fmt.Printf("%02x%02x", v&0xff, v>>8)
+			empty = empty && v == 0
+		}
+		switch {
+		case keyInvalid>>k&1 != 0:
+			fmt.Print(" (invalid)")
+		case keyValid>>k&1 != 0:
+			fmt.Print(" (valid)")
+		case empty:
+			fmt.Print(" (empty)")
+		}
+		fmt.Println()
+	}
+
+	locks := read(rowPage0Lock0, numRows/rowsPerPage*2, false)
+	fmt.Printf("Page locks:\n")
+	unlocked := true
+	for page := range numRows / rowsPerPage {
+		// The 8-bit lock value is stored in three copies in the raw row.
+		l0 := vote(bytesOf(locks[page*2]), 2)
+		l1 := vote(bytesOf(locks[page*2+1]), 2)
+		if l0 == 0 && l1 == 0 {
+			continue
+		}
+		unlocked = false
+		noKey := "ro"
+		if l0>>6&1 != 0 {
+			noKey = "inaccessible"
+		}
+		fmt.Printf(
+			"  page %2d: S=%s NS=%s BL=%s KEY_R=%d KEY_W=%d NO_KEY_STATE=%s\n",
+			page, lockNames[l1&3], lockNames[l1>>2&3], lockNames[l1>>4&3],
+			l0>>3&7, l0&7, noKey,
+		)
+	}
+	if unlocked {
+		fmt.Printf("  all pages unlocked\n")
+	}
+}
+
+func bytesOf(row uint32) []uint32 {
+	return []uint32{row & 0xff, row >> 8 & 0xff, row >> 16 & 0xff}
+}
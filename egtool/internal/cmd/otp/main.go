@@ -0,0 +1,170 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package otp
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/picoboot"
+	"github.com/embeddedgo/tools/egtool/internal/util"
+)
+
+const Descr = "read, decode and program the RP2350 OTP memory"
+
+const (
+	numRows     = 4096
+	rowsPerPage = 64
+	maxECC      = 0xffff   // 16-bit ECC protected row
+	maxRaw      = 0xffffff // 24-bit raw row
+)
+
+type subcmd struct {
+	descr string
+	main  func(cmd string, args []string)
+}
+
+var subcmds = map[string]subcmd{
+	"dump":   {"print the content of the OTP rows/pages", dump},
+	"decode": {"decode the known OTP fields", decode},
+	"write":  {"program the OTP rows described in the JSON file", write},
+}
+
+func usage(cmd string) {
+	names := slices.Sorted(maps.Keys(subcmds))
+	fmt.Fprintf(os.Stderr, "Usage:\n  %s SUBCOMMAND [OPTIONS]\n\n", cmd)
+	os.Stderr.WriteString("Available subcommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s  %s\n", name, subcmds[name].descr)
+	}
+}
+
+func Main(cmd string, args []string) {
+	if len(args) == 0 || args[0] == "-h" {
+		usage(cmd)
+		os.Exit(1)
+	}
+	sc, ok := subcmds[args[0]]
+	if !ok {
+		usage(cmd)
+		os.Exit(1)
+	}
+	sc.main(cmd+" "+args[0], args[1:])
+}
+
+// connect connects to the RP2350 device in BOOTSEL mode.
+func connect(busAddr string) *picoboot.Conn {
+	pb, err := picoboot.Connect(busAddr)
+	util.FatalErr("", err)
+	chip, err := pb.Chip()
+	util.FatalErr("", err)
+	if chip != picoboot.ChipRP2350 {
+		pb.Close()
+		util.Fatal("otp: unsupported device: %s", picoboot.ChipName(chip))
+	}
+	return pb
+}
+
+// readRows reads n OTP rows starting from row.
+func readRows(pb *picoboot.Conn, row, n int, ecc bool) ([]uint32, error) {
+	rs := 4
+	if ecc {
+		rs = 2
+	}
+	buf := make([]byte, n*rs)
+	if err := pb.OTPRead(uint16(row), buf, ecc); err != nil {
+		return nil, err
+	}
+	rows := make([]uint32, n)
+	for i := range rows {
+		if ecc {
+			rows[i] = uint32(binary.LittleEndian.Uint16(buf[i*2:]))
+		} else {
+			rows[i] = binary.LittleEndian.Uint32(buf[i*4:]) & maxRaw
+		}
+	}
+	return rows, nil
+}
+
+// writeRows writes the OTP rows starting from row.
+func writeRows(pb *picoboot.Conn, row int, rows []uint32, ecc bool) error {
+	var buf []byte
+	for _, v := range rows {
+		if ecc {
+			buf = binary.LittleEndian.AppendUint16(buf, uint16(v))
+		} else {
+			buf = binary.LittleEndian.AppendUint32(buf, v)
+		}
+	}
+	return pb.OTPWrite(uint16(row), buf, ecc)
+}
+
+func dump(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage:\n  %s [OPTIONS]\nOptions:\n", cmd)
+		fs.PrintDefaults()
+	}
+	busAddr := fs.String("usb", "", "select the USB device by `BUS:ADDR`")
+	raw := fs.Bool("raw", false, "read the raw 24-bit rows instead of the ECC data")
+	page := fs.Int("page", -1, "dump the OTP `PAGE` (64 rows)")
+	row := fs.Int("row", 0, "the first `ROW` to dump")
+	count := fs.Int("n", rowsPerPage, "the number of rows to dump")
+	jsonOut := fs.Bool(
+		"json", false,
+		"print the rows as newline-delimited JSON events (one per page)",
+	)
+	fs.Parse(args)
+	util.JSON = *jsonOut
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *page >= 0 {
+		if *page >= numRows/rowsPerPage {
+			util.Fatal("otp: page number out of range: %d", *page)
+		}
+		*row, *count = *page*rowsPerPage, rowsPerPage
+	}
+	if *row < 0 || *count <= 0 || *row+*count > numRows {
+		util.Fatal("otp: row range out of bounds: %#x+%d", *row, *count)
+	}
+	t0 := time.Now()
+	pb := connect(*busAddr)
+	defer pb.Close()
+
+	const perLine = 8
+	format := " %04x"
+	if *raw {
+		format = " %06x"
+	}
+	for i := *row; i < *row+*count; i += rowsPerPage {
+		n := min(rowsPerPage, *row+*count-i)
+		rows, err := readRows(pb, i, n, !*raw)
+		util.FatalErr("", err)
+		if util.JSON {
+			util.Emit(&util.Event{Event: util.EvData, Addr: uint32(i), Data: rows})
+			continue
+		}
+		for k, v := range rows {
+			if k%perLine == 0 {
+				if k != 0 {
+					fmt.Println()
+				}
+				fmt.Printf("%03x:", i+k)
+			}
+			fmt.Printf(format, v)
+		}
+		fmt.Println()
+	}
+	if util.JSON {
+		util.Emit(util.DoneEvent("", t0))
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package otp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/embeddedgo/tools/egtool/internal/util"
+)
+
+// number is a JSON number or a string accepted by strconv.ParseUint with the
+// base 0 (e.g. "0x1f").
+type number uint32
+
+func (n *number) UnmarshalJSON(b []byte) error {
+	if len(b) != 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		b = []byte(s)
+	}
+	u, err := strconv.ParseUint(string(b), 0, 32)
+	if err != nil {
+		return err
+	}
+	*n = number(u)
+	return nil
+}
+
+// entry describes the consecutive OTP rows to be written.
+type entry struct {
+	Row  number   `json:"row"`
+	Raw  bool     `json:"raw"`  // write the raw 24-bit rows instead of ECC data
+	Data []number `json:"data"` // row values
+}
+
+func writeUsage(cmd string, fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintf(os.Stderr, "Usage:\n  %s [OPTIONS] FILE.json\nOptions:\n", cmd)
+		fs.PrintDefaults()
+		os.Stderr.WriteString(`
+The JSON file contains an array of entries. Every entry describes a range of
+consecutive rows starting from row. By default the rows are written as 16-bit
+ECC protected data. Set raw to true to write raw 24-bit rows. Example:
+
+  [
+    {"row": "0x48", "raw": true, "data": ["0x000020", "0x000020", "0x000020"]},
+    {"row": "0xc00", "data": [1, 2, 3, "0xbeef"]}
+  ]
+
+The OTP bits can be set but never cleared, so the writes are irreversible.
+Check the changes with -dryrun first and then use -confirm to write them.
+`)
+	}
+}
+
+func write(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	fs.Usage = writeUsage(cmd, fs)
+	busAddr := fs.String("usb", "", "select the USB device by `BUS:ADDR`")
+	dryRun := fs.Bool("dryrun", false, "print the changes but don't write anything")
+	confirm := fs.Bool("confirm", false, "confirm the irreversible OTP write")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if !*dryRun && !*confirm {
+		util.Fatal("otp: OTP writes are irreversible, check them with -dryrun and write with -confirm")
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	util.FatalErr("", err)
+	var entries []entry
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&entries)
+	util.FatalErr(fs.Arg(0), err)
+	for i, e := range entries {
+		util.FatalErr(fs.Arg(0), e.check(i))
+	}
+
+	pb := connect(*busAddr)
+	defer pb.Close()
+
+	// Check all entries before writing anything.
+	read := func(row, n int, ecc bool) ([]uint32, error) {
+		return readRows(pb, row, n, ecc)
+	}
+	writes := make([][]uint32, len(entries))
+	for i, e := range entries {
+		writes[i], err = e.plan(read)
+		util.FatalErr("", err)
+	}
+	for i, e := range entries {
+		ecc := !e.Raw
+		for k, v := range writes[i] {
+			if v == 0 {
+				continue
+			}
+			row := int(e.Row) + k
+			if *dryRun {
+				fmt.Printf("%03x: write %#x\n", row, v)
+				continue
+			}
+			err = writeRows(pb, row, []uint32{v}, ecc)
+			util.FatalErr("", err)
+			got, err := readRows(pb, row, 1, ecc)
+			util.FatalErr("", err)
+			if got[0] != uint32(e.Data[k]) {
+				util.Fatal("otp: verify error at row %#03x: %#x != %#x", row, got[0], e.Data[k])
+			}
+			fmt.Printf("%03x: written %#x\n", row, v)
+		}
+	}
+}
+
+func (e *entry) check(i int) error {
+	if len(e.Data) == 0 {
+		return fmt.Errorf("entry %d: no data", i)
+	}
+	if int(e.Row)+len(e.Data) > numRows {
+		return fmt.Errorf("entry %d: rows out of bounds: %#x+%d", i, e.Row, len(e.Data))
+	}
+	max := number(maxECC)
+	if e.Raw {
+		max = maxRaw
+	}
+	for k, v := range e.Data {
+		if v > max {
+			return fmt.Errorf("entry %d: value %#x at row %#03x too large", i, v, int(e.Row)+k)
+		}
+	}
+	return nil
+}
+
+var errNotWritable = errors.New("the requested value requires clearing the programmed bits")
+
+// rowReader reads n OTP rows starting from row (see readRows).
+type rowReader func(row, n int, ecc bool) ([]uint32, error)
+
+// plan compares the entry with the current OTP content and returns the values
+// that have to be written (zero means no write required).
+func (e *entry) plan(read rowReader) ([]uint32, error) {
+	row, n := int(e.Row), len(e.Data)
+	raw, err := read(row, n, false)
+	if err != nil {
+		return nil, err
+	}
+	w := make([]uint32, n)
+	for k, v := range e.Data {
+		cur, want := raw[k], uint32(v)
+		if e.Raw {
+			if cur&^want != 0 {
+				return nil, fmt.Errorf("otp: row %#03x: %#x -> %#x: %w", row+k, cur, want, errNotWritable)
+			}
+			if cur != want {
+				w[k] = want
+			}
+			continue
+		}
+		if cur == 0 {
+			w[k] = want // unprogrammed row
+			continue
+		}
+		// The ECC protected row can be written only once.
+		ecc, err := read(row+k, 1, true)
+		if err != nil {
+			return nil, err
+		}
+		if ecc[0] != want {
+			return nil, fmt.Errorf("otp: row %#03x: %#x -> %#x: %w", row+k, ecc[0], want, errNotWritable)
+		}
+	}
+	return w, nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package otp
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeOTP is the OTP row source. The raw rows contain the 24-bit values, the
+// ECC rows are the 16-bit data decoded from the programmed raw rows.
+type fakeOTP struct {
+	raw, ecc map[int]uint32
+}
+
+func (f *fakeOTP) read(row, n int, ecc bool) ([]uint32, error) {
+	rows := make([]uint32, n)
+	for i := range rows {
+		if ecc {
+			rows[i] = f.ecc[row+i]
+		} else {
+			rows[i] = f.raw[row+i]
+		}
+	}
+	return rows, nil
+}
+
+func TestNumber(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []number
+		err  bool
+	}{
+		{`[1, 22, 4294967295]`, []number{1, 22, 0xffff_ffff}, false},
+		{`["0x1f", "0X20", "077", "0b101", "10"]`, []number{0x1f, 0x20, 077, 5, 10}, false},
+		{`["0xbeef"]`, []number{0xbeef}, false},
+		{`[4294967296]`, nil, true},
+		{`["0x100000000"]`, nil, true},
+		{`[-1]`, nil, true},
+		{`[1.5]`, nil, true},
+		{`["beef"]`, nil, true},
+		{`[""]`, nil, true},
+		{`[true]`, nil, true},
+	}
+	for _, tc := range tests {
+		var got []number
+		err := json.Unmarshal([]byte(tc.in), &got)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: got %v, want error", tc.in, got)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got %v, %v, want %v", tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		e   entry
+		err string // expected error substring, empty means no error
+	}{
+		{entry{Row: 0xc00, Data: []number{1, 2, 0xffff}}, ""},
+		{entry{Row: 0x48, Raw: true, Data: []number{0xffffff}}, ""},
+		{entry{Row: numRows - 2, Data: []number{1, 2}}, ""},
+		{entry{Row: 0xc00}, "no data"},
+		{entry{Row: numRows - 1, Data: []number{1, 2}}, "out of bounds"},
+		{entry{Row: 0xc00, Data: []number{1, 0x10000}}, "value 0x10000 at row 0xc01 too large"},
+		{entry{Row: 0x48, Raw: true, Data: []number{0x1000000}}, "too large"},
+	}
+	for i, tc := range tests {
+		err := tc.e.check(i)
+		if tc.err == "" && err != nil || tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%+v: got %v, want %q", tc.e, err, tc.err)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	otp := &fakeOTP{
+		raw: map[int]uint32{
+			0x48: 0x000020, 0x49: 0x0000ff,
+			0xc00: 0x12beef, 0xc01: 0x340001, // ECC rows: 0xbeef, 0x0001
+		},
+		ecc: map[int]uint32{0xc00: 0xbeef, 0xc01: 0x0001},
+	}
+	tests := []struct {
+		name string
+		e    entry
+		want []uint32 // nil means errNotWritable
+	}{
+		{
+			"raw-set-bits",
+			entry{Row: 0x48, Raw: true, Data: []number{0x000021, 0x0000ff, 0x800000}},
+			[]uint32{0x000021, 0, 0x800000},
+		},
+		{
+			"raw-unchanged",
+			entry{Row: 0x48, Raw: true, Data: []number{0x000020, 0x0000ff}},
+			[]uint32{0, 0},
+		},
+		{
+			"raw-clear-bits",
+			entry{Row: 0x48, Raw: true, Data: []number{0x000020, 0x0000fe}},
+			nil,
+		},
+		{
+			"ecc-unprogrammed",
+			entry{Row: 0xc02, Data: []number{1, 0xffff}},
+			[]uint32{1, 0xffff},
+		},
+		{
+			"ecc-unchanged",
+			entry{Row: 0xc00, Data: []number{0xbeef, 1, 7}},
+			[]uint32{0, 0, 7},
+		},
+		{
+			"ecc-rewrite",
+			entry{Row: 0xc00, Data: []number{0xbeef, 3}}, // sets bits only
+			nil,
+		},
+	}
+	for _, tc := range tests {
+		got, err := tc.e.plan(otp.read)
+		if tc.want == nil {
+			if !errors.Is(err, errNotWritable) {
+				t.Errorf("%s: got %x, %v, want %v", tc.name, got, err, errNotWritable)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got %x, %v, want %x", tc.name, got, err, tc.want)
+		}
+	}
+}
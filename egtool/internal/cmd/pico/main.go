@@ -0,0 +1,161 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pico
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/embeddedgo/tools/egtool/internal/picoboot"
+	"github.com/embeddedgo/tools/egtool/internal/util"
+)
+
+const Descr = "inspect the RP2040/RP2350 device in BOOTSEL mode"
+
+type subcmd struct {
+	descr string
+	main  func(cmd string, args []string)
+}
+
+var subcmds = map[string]subcmd{
+	"info":       {"print the chip, flash and boot information", info},
+	"partitions": {"print the partition table (RP2350)", partitions},
+	"slot":       {"print the booted partition and A/B slots (RP2350)", slot},
+}
+
+func usage(cmd string) {
+	names := slices.Sorted(maps.Keys(subcmds))
+	fmt.Fprintf(os.Stderr, "Usage:\n  %s SUBCOMMAND [OPTIONS]\n\n", cmd)
+	os.Stderr.WriteString("Available subcommands:\n")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s  %s\n", name, subcmds[name].descr)
+	}
+}
+
+func Main(cmd string, args []string) {
+	if len(args) == 0 || args[0] == "-h" {
+		usage(cmd)
+		os.Exit(1)
+	}
+	sc, ok := subcmds[args[0]]
+	if !ok {
+		usage(cmd)
+		os.Exit(1)
+	}
+	sc.main(cmd+" "+args[0], args[1:])
+}
+
+// parseFlags parses the common flags and connects to the device.
+func parseFlags(fs *flag.FlagSet, args []string) *picoboot.Conn {
+	cmd := fs.Name()
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage:\n  %s [OPTIONS]\nOptions:\n", cmd)
+		fs.PrintDefaults()
+	}
+	busAddr := fs.String("usb", "", "select the USB device by `BUS:ADDR`")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	pb, err := picoboot.Connect(*busAddr)
+	util.FatalErr("", err)
+	return pb
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func sizeStr(size int) string {
+	switch {
+	case size == 0:
+		return "none/unknown"
+	case size%(1<<20) == 0:
+		return fmt.Sprintf("%d MiB", size>>20)
+	}
+	return fmt.Sprintf("%d KiB", size>>10)
+}
+
+var bootTypeStr = map[uint8]string{
+	uint8(picoboot.RebootNormal):      "normal",
+	uint8(picoboot.RebootBootsel):     "BOOTSEL",
+	uint8(picoboot.RebootRAMImage):    "RAM image",
+	uint8(picoboot.RebootFlashUpdate): "flash update",
+	uint8(picoboot.RebootPCSP):        "PC/SP",
+}
+
+func info(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	pb := parseFlags(fs, args)
+	defer pb.Close()
+
+	chip, err := pb.Chip()
+	util.FatalErr("", err)
+	fmt.Printf("Chip:          %s\n", picoboot.ChipName(chip))
+	if chip != picoboot.ChipRP2350 {
+		return // GetInfo isn't supported
+	}
+	si, err := pb.SysInfo(
+		picoboot.ChipInfo | picoboot.Critical | picoboot.CPUInfo |
+			picoboot.FlashDevInfo | picoboot.BootInfo,
+	)
+	util.FatalErr("", err)
+	if si.Flags&picoboot.ChipInfo != 0 {
+		pkg := "QFN80"
+		if si.PackageSel&1 != 0 {
+			pkg = "QFN60"
+		}
+		fmt.Printf("Package:       %s\n", pkg)
+		fmt.Printf("Chip ID:       %#016x\n", si.ChipID())
+	}
+	if si.Flags&picoboot.CPUInfo != 0 {
+		arch := "ARM"
+		if si.CPU != 0 {
+			arch = "RISC-V"
+		}
+		fmt.Printf("Architecture:  %s\n", arch)
+	}
+	if si.Flags&picoboot.Critical != 0 {
+		c := si.Critical
+		fmt.Printf("Critical:      %#08x\n", c)
+		fmt.Printf("  secure boot:      %s\n", onOff(c&picoboot.CritSecureBootEnable != 0))
+		fmt.Printf("  secure debug dis: %s\n", onOff(c&picoboot.CritSecureDebugDisable != 0))
+		fmt.Printf("  debug disable:    %s\n", onOff(c&picoboot.CritDebugDisable != 0))
+		fmt.Printf("  glitch detector:  %s (sensitivity %d)\n",
+			onOff(c&picoboot.CritGlitchDetectorEna != 0),
+			c&picoboot.CritGlitchDetectorSens>>5,
+		)
+		fmt.Printf("  ARM disable:      %s\n", onOff(c&picoboot.CritARMDisable != 0))
+		fmt.Printf("  RISC-V disable:   %s\n", onOff(c&picoboot.CritRISCVDisable != 0))
+	}
+	if si.Flags&picoboot.FlashDevInfo != 0 {
+		fmt.Printf("Flash:         %#04x\n", si.FlashDevInfo)
+		fmt.Printf("  CS0 size:         %s\n", sizeStr(si.FlashSize(0)))
+		fmt.Printf("  CS1 size:         %s\n", sizeStr(si.FlashSize(1)))
+	}
+	if si.Flags&picoboot.BootInfo != 0 {
+		b := &si.Boot
+		bt, ok := bootTypeStr[b.Type&^picoboot.BootChained]
+		if !ok {
+			bt = fmt.Sprintf("%#x", b.Type&^picoboot.BootChained)
+		}
+		if b.Type&picoboot.BootChained != 0 {
+			bt += " (chained)"
+		}
+		fmt.Printf("Last boot:\n")
+		fmt.Printf("  type:             %s\n", bt)
+		fmt.Printf("  partition:        %d\n", b.Partition)
+		fmt.Printf("  TBYB/update info: %#02x\n", b.TBYBAndUpdateInfo)
+		fmt.Printf("  diagnostic:       %#08x (partition %d)\n", b.Diagnostic, b.DiagnosticPartition)
+		fmt.Printf("  reboot params:    %#08x %#08x\n", b.RebootParams[0], b.RebootParams[1])
+	}
+}
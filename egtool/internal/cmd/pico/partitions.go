@@ -0,0 +1,241 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pico
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/embeddedgo/tools/egtool/internal/picoboot"
+	"github.com/embeddedgo/tools/egtool/internal/uf2"
+	"github.com/embeddedgo/tools/egtool/internal/util"
+)
+
+// Default families accepted by a partition (the names used by the partition
+// table JSON description).
+var partFamilies = []struct {
+	flag uint32
+	name string
+}{
+	{picoboot.PartAcceptsAbsolute, "absolute"},
+	{picoboot.PartAcceptsRP2040, "rp2040"},
+	{picoboot.PartAcceptsRP2350ARMS, "rp2350-arm-s"},
+	{picoboot.PartAcceptsRP2350ARMNS, "rp2350-arm-ns"},
+	{picoboot.PartAcceptsRP2350RISCV, "rp2350-riscv"},
+	{picoboot.PartAcceptsData, "data"},
+}
+
+func familyNames(p *picoboot.PartInfo) []string {
+	var names []string
+	for _, f := range partFamilies {
+		if p.Flags&f.flag != 0 {
+			names = append(names, f.name)
+		}
+	}
+	for _, id := range p.Families {
+		name := fmt.Sprintf("%#08x", id)
+		for k, v := range uf2.FamilyMap {
+			if v == id {
+				name = strings.ReplaceAll(k, "_", "-")
+				break
+			}
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func permStr(perm, r, w uint8) string {
+	s := ""
+	if perm&r != 0 {
+		s += "r"
+	}
+	if perm&w != 0 {
+		s += "w"
+	}
+	return s
+}
+
+type jsonPerm struct {
+	Secure     string `json:"secure"`
+	Nonsecure  string `json:"nonsecure"`
+	Bootloader string `json:"bootloader"`
+}
+
+func permissions(perm uint8) jsonPerm {
+	return jsonPerm{
+		permStr(perm, picoboot.PermSecureRead, picoboot.PermSecureWrite),
+		permStr(perm, picoboot.PermNonsecureRead, picoboot.PermNonsecureWrite),
+		permStr(perm, picoboot.PermBootRead, picoboot.PermBootWrite),
+	}
+}
+
+type jsonUnpartitioned struct {
+	Families    []string `json:"families"`
+	Permissions jsonPerm `json:"permissions"`
+}
+
+type jsonPartition struct {
+	Name                    string   `json:"name,omitempty"`
+	ID                      string   `json:"id,omitempty"`
+	Start                   string   `json:"start"`
+	Size                    string   `json:"size"`
+	Families                []string `json:"families"`
+	Permissions             jsonPerm `json:"permissions"`
+	Link                    []any    `json:"link,omitempty"`
+	IgnoredDuringARMBoot    bool     `json:"ignored_during_arm_boot,omitempty"`
+	IgnoredDuringRISCVBoot  bool     `json:"ignored_during_riscv_boot,omitempty"`
+	NoRebootOnUF2Download   bool     `json:"no_reboot_on_uf2_download,omitempty"`
+	ABNonBootableOwnerAffin bool     `json:"ab_non_bootable_owner_affinity,omitempty"`
+}
+
+type jsonPT struct {
+	Version       [2]int            `json:"version"`
+	Unpartitioned jsonUnpartitioned `json:"unpartitioned"`
+	Partitions    []jsonPartition   `json:"partitions"`
+}
+
+func linkStr(p *picoboot.PartInfo) (typ string, n int) {
+	t, n := p.Link()
+	switch t {
+	case picoboot.PartLinkA:
+		return "a", n
+	case picoboot.PartLinkOwner:
+		return "owner", n
+	}
+	return "", 0
+}
+
+// writeJSON writes the partition table in the JSON format accepted by the
+// partition table builders (e.g. picotool partition create).
+func writeJSON(pt *picoboot.PartitionTable) {
+	j := jsonPT{
+		Version: [2]int{1, 0},
+		Unpartitioned: jsonUnpartitioned{
+			Families:    familyNames(&pt.Unpartitioned),
+			Permissions: permissions(pt.Unpartitioned.Perm),
+		},
+		Partitions: []jsonPartition{},
+	}
+	for i := range pt.Partitions {
+		p := &pt.Partitions[i]
+		jp := jsonPartition{
+			Start:                   fmt.Sprintf("%dK", p.First*4),
+			Size:                    fmt.Sprintf("%dK", p.Size()/1024),
+			Families:                familyNames(p),
+			Permissions:             permissions(p.Perm),
+			IgnoredDuringARMBoot:    p.Flags&picoboot.PartNotBootableARM != 0,
+			IgnoredDuringRISCVBoot:  p.Flags&picoboot.PartNotBootableRISCV != 0,
+			NoRebootOnUF2Download:   p.Flags&picoboot.PartUF2DownloadNoReboot != 0,
+			ABNonBootableOwnerAffin: p.Flags&picoboot.PartABNonBootableOwnerAff != 0,
+		}
+		if p.Flags&picoboot.PartHasName != 0 {
+			jp.Name = p.Name
+		}
+		if p.Flags&picoboot.PartHasID != 0 {
+			jp.ID = fmt.Sprintf("%#x", p.ID)
+		}
+		if typ, n := linkStr(p); typ != "" {
+			jp.Link = []any{typ, n}
+		}
+		j.Partitions = append(j.Partitions, jp)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	util.FatalErr("", enc.Encode(&j))
+}
+
+func printPart(p *picoboot.PartInfo) {
+	perm := permissions(p.Perm)
+	fmt.Printf(
+		"  sectors:     %d-%d (%#08x-%#08x, %s)\n",
+		p.First, p.Last, p.First*4096, (p.Last+1)*4096, sizeStr(p.Size()),
+	)
+	fmt.Printf(
+		"  permissions: S:%s NS:%s BL:%s\n",
+		perm.Secure, perm.Nonsecure, perm.Bootloader,
+	)
+	fmt.Printf("  families:    %s\n", strings.Join(familyNames(p), " "))
+}
+
+func partitions(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	jsonOut := fs.Bool("json", false, "print the partition table in the JSON format")
+	pb := parseFlags(fs, args)
+	defer pb.Close()
+
+	chip, err := pb.Chip()
+	util.FatalErr("", err)
+	if chip != picoboot.ChipRP2350 {
+		util.Fatal("pico: %s doesn't support partitions", picoboot.ChipName(chip))
+	}
+	pt, err := pb.PartitionTable()
+	util.FatalErr("", err)
+	if *jsonOut {
+		writeJSON(pt)
+		return
+	}
+
+	if !pt.Present {
+		fmt.Printf("No partition table.\n")
+	}
+	fmt.Printf("Unpartitioned space:\n")
+	printPart(&pt.Unpartitioned)
+	for i := range pt.Partitions {
+		p := &pt.Partitions[i]
+		fmt.Printf("Partition %d:\n", i)
+		printPart(p)
+		if p.Flags&picoboot.PartHasName != 0 {
+			fmt.Printf("  name:        %s\n", p.Name)
+		}
+		if p.Flags&picoboot.PartHasID != 0 {
+			fmt.Printf("  id:          %#016x\n", p.ID)
+		}
+		if typ, n := linkStr(p); typ != "" {
+			fmt.Printf("  link:        %s %d\n", typ, n)
+		}
+		var flags []string
+		if p.Flags&picoboot.PartNotBootableARM != 0 {
+			flags = append(flags, "not-bootable-arm")
+		}
+		if p.Flags&picoboot.PartNotBootableRISCV != 0 {
+			flags = append(flags, "not-bootable-riscv")
+		}
+		if p.Flags&picoboot.PartUF2DownloadNoReboot != 0 {
+			flags = append(flags, "uf2-no-reboot")
+		}
+		if p.Flags&picoboot.PartABNonBootableOwnerAff != 0 {
+			flags = append(flags, "ab-non-bootable-owner-affinity")
+		}
+		if len(flags) != 0 {
+			fmt.Printf("  flags:       %s\n", strings.Join(flags, " "))
+		}
+	}
+
+	// Show where the UF2 images will go.
+	fmt.Printf("UF2 targets:\n")
+	for _, name := range []string{"rp2350_arm_s", "rp2350_riscv"} {
+		label := strings.ReplaceAll(name, "_", "-") + ":"
+		tp, err := pb.UF2Target(uf2.FamilyMap[name])
+		if err != nil {
+			fmt.Printf("  %-13s none (%v)\n", label, err)
+			continue
+		}
+		where := "unpartitioned space"
+		for i, p := range pt.Partitions {
+			if p.First == tp.First && p.Last == tp.Last {
+				where = fmt.Sprintf("partition %d", i)
+				break
+			}
+		}
+		fmt.Printf(
+			"  %-13s %s (sectors %d-%d)\n",
+			label, where, tp.First, tp.Last,
+		)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pico
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/embeddedgo/tools/egtool/internal/picoboot"
+	"github.com/embeddedgo/tools/egtool/internal/util"
+)
+
+func partName(pt *picoboot.PartitionTable, i int) string {
+	if i < 0 || i >= len(pt.Partitions) {
+		return "none"
+	}
+	s := fmt.Sprintf("%d", i)
+	if p := &pt.Partitions[i]; p.Flags&picoboot.PartHasName != 0 {
+		s += " (" + p.Name + ")"
+	}
+	return s
+}
+
+func slot(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	pb := parseFlags(fs, args)
+	defer pb.Close()
+
+	chip, err := pb.Chip()
+	util.FatalErr("", err)
+	if chip != picoboot.ChipRP2350 {
+		util.Fatal("pico: %s doesn't support partitions", picoboot.ChipName(chip))
+	}
+	pt, err := pb.PartitionTable()
+	util.FatalErr("", err)
+	si, err := pb.SysInfo(picoboot.BootInfo)
+	util.FatalErr("", err)
+	b := &si.Boot
+	booted := int(b.Partition)
+
+	fmt.Printf("Booted partition: %s\n", partName(pt, booted))
+	fmt.Printf("Buy pending:      %s\n", onOff(b.TBYBAndUpdateInfo&picoboot.TBYBBuyPending != 0))
+	fmt.Printf("Flash update:     %s\n", onOff(b.TBYBAndUpdateInfo&picoboot.TBYBFlashUpdate != 0))
+	if booted >= 0 && booted < len(pt.Partitions) {
+		if other := pt.ABPartner(booted); other >= 0 {
+			fmt.Printf("Other slot:       %s\n", partName(pt, other))
+		}
+	}
+	fmt.Printf("A/B pairs:\n")
+	pairs := 0
+	for i := range pt.Partitions {
+		if typ, a := pt.Partitions[i].Link(); typ == picoboot.PartLinkA {
+			mark := func(n int) string {
+				if n == booted {
+					return "*"
+				}
+				return " "
+			}
+			fmt.Printf(
+				"  A: %s%s  B: %s%s\n",
+				partName(pt, a), mark(a), partName(pt, i), mark(i),
+			)
+			pairs++
+		}
+	}
+	if pairs == 0 {
+		fmt.Printf("  none\n")
+	}
+}
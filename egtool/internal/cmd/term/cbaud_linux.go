@@ -0,0 +1,9 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !ppc64 && !ppc64le
+
+package term
+
+const cbaud = 0o10017 // CBAUD | CBAUDEX
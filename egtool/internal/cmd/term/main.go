@@ -0,0 +1,87 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package term
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/embeddedgo/tools/egtool/internal/util"
+)
+
+const Descr = "serial terminal with the panic and fault symbolization"
+
+const help = `
+The term command connects the terminal to the serial port (USB CDC-ACM or
+USB-UART) of the board. If there is only one such port it is used, otherwise
+select it by the USB IDs, the serial number or its path. Received lines that
+contain code addresses (Go panic tracebacks, hard fault dumps) are annotated
+with the function names and source positions read from the ELF file. Lines
+read from the standard input are sent to the port.
+`
+
+func Main(cmd string, args []string) {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(
+			os.Stderr,
+			"Usage:\n  %s [OPTIONS] [ELF]\n%s\nOptions:\n",
+			cmd, help,
+		)
+		fs.PrintDefaults()
+	}
+	port := fs.String("port", "", "use the serial port at `PATH`")
+	vid := fs.Uint("vid", 0, "select the serial port by USB vendor `ID`")
+	pid := fs.Uint("pid", 0, "select the serial port by USB product `ID`")
+	serial := fs.String(
+		"serial", "", "select the serial port by USB serial `NUMBER`",
+	)
+	baud := fs.Int("baud", 115200, "baud `RATE` (ignored by most CDC-ACM ports)")
+	eol := fs.String(
+		"eol", "lf",
+		"line ending sent after every input line: `cr, lf or crlf`",
+	)
+	logFile := fs.String("log", "", "append the received data to `FILE`")
+	wait := fs.Duration(
+		"wait", 0,
+		"wait up to `DURATION` for the port to appear, negative means\n"+
+			"forever",
+	)
+	list := fs.Bool("list", false, "list the serial ports and exit")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *list {
+		ports, err := listPorts()
+		util.FatalErr("", err)
+		for _, p := range ports {
+			fmt.Println(p)
+		}
+		return
+	}
+	elf := fs.Arg(0)
+	if elf == "" {
+		// Use the default ELF if it exists.
+		elf, _ = util.InOutFiles("", ".elf", "", "")
+		if _, err := os.Stat(elf); err != nil {
+			elf = ""
+		}
+	}
+	err := Run(&Config{
+		Port:    *port,
+		Vendor:  uint16(*vid),
+		Product: uint16(*pid),
+		Serial:  *serial,
+		Baud:    *baud,
+		EOL:     *eol,
+		Log:     *logFile,
+		ELF:     elf,
+		Wait:    *wait,
+	})
+	util.FatalErr("", err)
+}
@@ -0,0 +1,74 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package term
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+// listPorts returns the USB serial ports (cu.usbmodem*, cu.usbserial*). The
+// USB IDs aren't available without IOKit so only the -serial option, matched
+// against the port name, can be used to select the port.
+func listPorts() ([]*Port, error) {
+	var ports []*Port
+	for _, pat := range []string{"cu.usbmodem*", "cu.usbserial*"} {
+		names, err := filepath.Glob("/dev/" + pat)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			ports = append(ports, &Port{Path: name})
+		}
+	}
+	return ports, nil
+}
+
+// openPort opens the serial port and sets the raw 8N1 mode.
+func openPort(path string, baud int) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := f.SyscallConn()
+	if err == nil {
+		cerr := rc.Control(func(fd uintptr) {
+			var t syscall.Termios
+			if err = ioctl(fd, syscall.TIOCGETA, &t); err != nil {
+				return
+			}
+			t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK |
+				syscall.ISTRIP | syscall.INLCR | syscall.IGNCR |
+				syscall.ICRNL | syscall.IXON
+			t.Oflag &^= syscall.OPOST
+			t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON |
+				syscall.ISIG | syscall.IEXTEN
+			t.Cflag &^= syscall.CSIZE | syscall.PARENB | syscall.CSTOPB
+			t.Cflag |= syscall.CS8 | syscall.CREAD | syscall.CLOCAL
+			t.Ispeed, t.Ospeed = uint64(baud), uint64(baud)
+			t.Cc[syscall.VMIN], t.Cc[syscall.VTIME] = 1, 0
+			err = ioctl(fd, syscall.TIOCSETA, &t)
+		})
+		if err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+func ioctl(fd, req uintptr, t *syscall.Termios) error {
+	_, _, e := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(t)))
+	if e != 0 {
+		return e
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package term
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// listPorts returns the USB serial ports (ttyACM, ttyUSB) found in sysfs.
+func listPorts() ([]*Port, error) {
+	var ports []*Port
+	for _, pat := range []string{"ttyACM*", "ttyUSB*"} {
+		ttys, err := filepath.Glob("/sys/class/tty/" + pat)
+		if err != nil {
+			return nil, err
+		}
+		for _, tty := range ttys {
+			p := &Port{Path: "/dev/" + filepath.Base(tty)}
+			dir, err := filepath.EvalSymlinks(filepath.Join(tty, "device"))
+			if err != nil {
+				continue
+			}
+			// Walk up to the USB device directory.
+			for ; dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+				if _, err := os.Stat(filepath.Join(dir, "idVendor")); err == nil {
+					break
+				}
+			}
+			read := func(name string) string {
+				b, _ := os.ReadFile(filepath.Join(dir, name))
+				return strings.TrimSpace(string(b))
+			}
+			vid, _ := strconv.ParseUint(read("idVendor"), 16, 16)
+			pid, _ := strconv.ParseUint(read("idProduct"), 16, 16)
+			p.Vendor, p.Product = uint16(vid), uint16(pid)
+			p.Serial = read("serial")
+			p.Descr = read("product")
+			ports = append(ports, p)
+		}
+	}
+	return ports, nil
+}
+
+var bauds = map[int]uint32{
+	1200: syscall.B1200, 2400: syscall.B2400, 4800: syscall.B4800,
+	9600: syscall.B9600, 19200: syscall.B19200, 38400: syscall.B38400,
+	57600: syscall.B57600, 115200: syscall.B115200, 230400: syscall.B230400,
+	460800: syscall.B460800, 500000: syscall.B500000, 576000: syscall.B576000,
+	921600: syscall.B921600, 1000000: syscall.B1000000,
+	1500000: syscall.B1500000, 2000000: syscall.B2000000,
+	3000000: syscall.B3000000, 4000000: syscall.B4000000,
+}
+
+// openPort opens the serial port and sets the raw 8N1 mode.
+func openPort(path string, baud int) (*os.File, error) {
+	speed, ok := bauds[baud]
+	if !ok {
+		return nil, fmt.Errorf("unsupported baud rate: %d", baud)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := f.SyscallConn()
+	if err == nil {
+		cerr := rc.Control(func(fd uintptr) {
+			var t syscall.Termios
+			if err = ioctl(fd, syscall.TCGETS, &t); err != nil {
+				return
+			}
+			t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK |
+				syscall.ISTRIP | syscall.INLCR | syscall.IGNCR |
+				syscall.ICRNL | syscall.IXON
+			t.Oflag &^= syscall.OPOST
+			t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON |
+				syscall.ISIG | syscall.IEXTEN
+			t.Cflag &^= syscall.CSIZE | syscall.PARENB | syscall.CSTOPB | cbaud
+			// TCSETS takes the speed only from Cflag. The Ispeed, Ospeed
+			// fields don't exist on all architectures (e.g. mips64).
+			t.Cflag |= syscall.CS8 | syscall.CREAD | syscall.CLOCAL | speed
+			t.Cc[syscall.VMIN], t.Cc[syscall.VTIME] = 1, 0
+			err = ioctl(fd, syscall.TCSETS, &t)
+		})
+		if err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+func ioctl(fd, req uintptr, t *syscall.Termios) error {
+	_, _, e := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(unsafe.Pointer(t)))
+	if e != 0 {
+		return e
+	}
+	return nil
+}
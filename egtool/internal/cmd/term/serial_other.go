@@ -0,0 +1,23 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux && !darwin
+
+package term
+
+import (
+	"errors"
+	"os"
+	"runtime"
+)
+
+var errOS = errors.New("serial ports aren't supported on " + runtime.GOOS)
+
+func listPorts() ([]*Port, error) {
+	return nil, errOS
+}
+
+func openPort(path string, baud int) (*os.File, error) {
+	return nil, errOS
+}
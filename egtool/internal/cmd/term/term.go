@@ -0,0 +1,242 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package term
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/elfsym"
+	"github.com/embeddedgo/tools/egtool/internal/util"
+)
+
+// Config contains the terminal parameters.
+type Config struct {
+	Port    string // path to the serial port, empty means find it
+	Vendor  uint16 // USB vendor ID of the port, 0 means any
+	Product uint16 // USB product ID of the port, 0 means any
+	Serial  string // USB serial number of the port, empty means any
+	Baud    int
+	EOL     string        // line ending sent after every input line
+	Log     string        // log file
+	ELF     string        // ELF file used to symbolize the addresses
+	Wait    time.Duration // wait for the port to appear, negative means forever
+}
+
+// Port describes a serial port.
+type Port struct {
+	Path    string
+	Vendor  uint16 // USB vendor ID (0 if unknown)
+	Product uint16 // USB product ID (0 if unknown)
+	Serial  string // USB serial number
+	Descr   string // USB product description
+}
+
+func (p *Port) String() string {
+	s := p.Path
+	if p.Vendor != 0 {
+		s += fmt.Sprintf(" %04x:%04x", p.Vendor, p.Product)
+	}
+	if p.Serial != "" {
+		s += " " + p.Serial
+	}
+	if p.Descr != "" {
+		s += " (" + p.Descr + ")"
+	}
+	return s
+}
+
+// ErrNotFound is returned by Run if there is no matching serial port.
+var ErrNotFound = errors.New("no serial port found")
+
+var eols = map[string]string{"cr": "\r", "lf": "\n", "crlf": "\r\n"}
+
+// find returns the path to the only serial port that matches the cfg.
+func find(cfg *Config) (string, error) {
+	if cfg.Port != "" {
+		return cfg.Port, nil
+	}
+	all, err := listPorts()
+	if err != nil {
+		return "", err
+	}
+	var ports []*Port
+	for _, p := range all {
+		if cfg.Vendor != 0 && p.Vendor != cfg.Vendor ||
+			cfg.Product != 0 && p.Product != cfg.Product ||
+			cfg.Serial != "" && p.Serial != cfg.Serial &&
+				!(p.Serial == "" && strings.Contains(p.Path, cfg.Serial)) {
+			continue
+		}
+		ports = append(ports, p)
+	}
+	switch len(ports) {
+	case 0:
+		return "", ErrNotFound
+	case 1:
+		return ports[0].Path, nil
+	}
+	s := "found more than one serial port, use -port, -vid, -pid or -serial:"
+	for _, p := range ports {
+		s += "\n  " + p.String()
+	}
+	return "", errors.New(s)
+}
+
+// Run runs the terminal until the Ctrl-C is pressed or the port is closed.
+func Run(cfg *Config) error {
+	eol, ok := eols[cfg.EOL]
+	if !ok {
+		return fmt.Errorf("unknown line ending: %s", cfg.EOL)
+	}
+	var sym *elfsym.Table
+	if cfg.ELF != "" {
+		var err error
+		if sym, err = elfsym.Open(cfg.ELF); err != nil {
+			util.Warn("term: no symbolization: %v", err)
+		}
+	}
+	var path string
+	for t0 := time.Now(); ; {
+		var err error
+		path, err = find(cfg)
+		if err == nil {
+			break
+		}
+		if err != ErrNotFound || cfg.Wait >= 0 && time.Since(t0) >= cfg.Wait {
+			return err
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	port, err := openPort(path, cfg.Baud)
+	if err != nil {
+		return err
+	}
+	defer port.Close()
+	var out io.Writer = os.Stdout
+	if cfg.Log != "" {
+		f, err := os.OpenFile(cfg.Log, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = io.MultiWriter(os.Stdout, f)
+	}
+	fmt.Fprintf(os.Stderr, "Connected to %s (%d baud), Ctrl-C to exit\n", path, cfg.Baud)
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(&annotator{w: out, sym: sym}, port)
+		done <- err
+	}()
+	go func() {
+		r := bufio.NewReader(os.Stdin)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return // keep receiving if the stdin is closed
+			}
+			line = strings.TrimRight(line, "\r\n") + eol
+			if _, err = io.WriteString(port, line); err != nil {
+				return
+			}
+		}
+	}()
+	select {
+	case <-sig:
+		return nil
+	case err = <-done:
+		if err == nil {
+			err = errors.New(path + ": port closed")
+		}
+		return err
+	}
+}
+
+// addrRE matches the hexadecimal numbers that may be code addresses: the
+// 0x-prefixed ones, excluding the offsets (+0x1c), and the unprefixed 8 or 16
+// digit ones that follow a PC/LR register name or a colon (PC: 08001234).
+var addrRE = regexp.MustCompile(
+	`(?:^|[^+\w])0x([0-9a-fA-F]{1,16})\b|` +
+		`(?i:\b(?:pc|lr|ra|[ms]?epc)\s*[:=]?|:)\s*([0-9a-fA-F]{8}(?:[0-9a-fA-F]{8})?)\b`,
+)
+
+// annotator copies the received data to w appending the function name and
+// the source position to every line that contains code addresses (e.g. a
+// Go panic traceback or a hard fault dump).
+type annotator struct {
+	w    io.Writer
+	sym  *elfsym.Table
+	line []byte // current line
+	cr   bool   // pending CR
+	out  []byte
+}
+
+const maxLine = 512
+
+func (a *annotator) Write(p []byte) (int, error) {
+	out := a.out[:0]
+	for _, b := range p {
+		if a.cr {
+			a.cr = false
+			if b == '\n' {
+				out = append(out, a.annotation()...)
+				out = append(out, '\r', '\n')
+				a.line = a.line[:0]
+				continue
+			}
+			out = append(out, '\r')
+		}
+		switch b {
+		case '\r':
+			a.cr = true // may be followed by LF
+		case '\n':
+			out = append(out, a.annotation()...)
+			out = append(out, '\n')
+			a.line = a.line[:0]
+		default:
+			out = append(out, b)
+			if len(a.line) < maxLine {
+				a.line = append(a.line, b)
+			}
+		}
+	}
+	a.out = out
+	if _, err := a.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// annotation returns the annotation of the current line.
+func (a *annotator) annotation() string {
+	if a.sym == nil {
+		return ""
+	}
+	var s []string
+	for _, m := range addrRE.FindAllSubmatch(a.line, -1) {
+		var addr uint64
+		if _, err := fmt.Sscanf(string(m[1])+string(m[2]), "%x", &addr); err != nil {
+			continue
+		}
+		if pos, ok := a.sym.Lookup(addr); ok {
+			s = append(s, pos.String())
+		}
+	}
+	if len(s) == 0 {
+		return ""
+	}
+	return "  <- " + strings.Join(s, ", ")
+}
@@ -0,0 +1,115 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package term
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/embeddedgo/tools/egtool/internal/elfsym"
+)
+
+// testFunc provides the code address for the tests.
+func testFunc() {}
+
+// testTable returns the symbol table of the test binary and the address of
+// testFunc in it.
+func testTable(t *testing.T) (*elfsym.Table, uint64) {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	tab, err := elfsym.Open(exe)
+	if err != nil {
+		t.Skip(err) // not an ELF
+	}
+	addr := uint64(reflect.ValueOf(testFunc).Pointer())
+	if pos, ok := tab.Lookup(addr); !ok || !strings.HasSuffix(pos.Func, ".testFunc") {
+		t.Skipf("%#x isn't the address of testFunc in %s (PIE?)", addr, exe)
+	}
+	return tab, addr
+}
+
+func TestAnnotator(t *testing.T) {
+	tab, addr := testTable(t)
+	pos, _ := tab.Lookup(addr)
+	note := "  <- " + pos.String()
+	tests := []struct {
+		line string
+		want bool // annotated
+	}{
+		{"panic at pc=0x%x", true},
+		{"main.f(...) main.go:12 +0x%x", false}, // offset
+		{"PC: %08x", true},
+		{"PC: %08X", true},
+		{"pc 00000000%08x", true},
+		{"LR=%08x SP=20001000", true},
+		{"mepc: %016x", true},
+		{"20000f80: %08x 00000000", true},
+		{"value %08x", false},              // no context
+		{"pc: %07x", false},                // not 8 digits
+		{"x%x", false},                     // no prefix
+		{"at 0x%x and lr 0x%[1]x", true},   // both annotated
+		{"pc 0a0b0c0d0a0b0c0d%08x", false}, // part of a longer number
+		{"time 12:00:00 pc 0x%08x", true},  // colons without addresses
+		{"tick: 00000000 00000000", false}, // not a code address
+		{"bad PC: 0xffffffff", false},      // not a code address
+		{"no address in this line", false},
+	}
+	for _, tc := range tests {
+		line := tc.line
+		if strings.Contains(line, "%") {
+			line = fmt.Sprintf(line, addr)
+		}
+		var buf bytes.Buffer
+		a := &annotator{w: &buf, sym: tab}
+		if _, err := a.Write([]byte(line + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		want := line + "\n"
+		if tc.want {
+			want = line + note + "\n"
+			if strings.Count(tc.line, "%") == 2 {
+				want = line + note + ", " + pos.String() + "\n"
+			}
+		}
+		if buf.String() != want {
+			t.Errorf("%q:\ngot  %q\nwant %q", line, buf.String(), want)
+		}
+	}
+}
+
+func TestAnnotatorLines(t *testing.T) {
+	tab, addr := testTable(t)
+	pos, _ := tab.Lookup(addr)
+	note := "  <- " + pos.String()
+	in := fmt.Sprintf("a\r\nPC: %08x\r\nprogress\rdone\npc=0x%x", addr, addr)
+	want := fmt.Sprintf(
+		"a\r\nPC: %08x%s\r\nprogress\rdone\npc=0x%x", addr, note, addr,
+	)
+	// Feed the data byte by byte to split the lines between writes.
+	var buf bytes.Buffer
+	a := &annotator{w: &buf, sym: tab}
+	for i := range len(in) {
+		if _, err := a.Write([]byte{in[i]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if buf.String() != want {
+		t.Errorf("got  %q\nwant %q", buf.String(), want)
+	}
+	// The line isn't annotated without the symbol table.
+	buf.Reset()
+	a = &annotator{w: &buf}
+	a.Write([]byte(in + "\n"))
+	if buf.String() != in+"\n" {
+		t.Errorf("got %q, want %q", buf.String(), in+"\n")
+	}
+}
@@ -0,0 +1,608 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cmsisdap implements the host side of the CMSIS-DAP protocol used by
+// many debug probes (DAPLink, Picoprobe, debugprobe, etc.). It supports the
+// CMSIS-DAP v2 probes (vendor specific interface with bulk endpoints) and the
+// CMSIS-DAP v1 ones (HID interface with interrupt endpoints). The probe is
+// recognized by the "CMSIS-DAP" string in its interface description.
+//
+// The Conn implements the SWD transfers (see the swd package). The JTAG mode
+// isn't supported.
+package cmsisdap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/embeddedgo/tools/egtool/internal/usbdev"
+	usb "github.com/google/gousb"
+)
+
+// Commands
+const (
+	cmdInfo              uint8 = 0x00
+	cmdHostStatus        uint8 = 0x01
+	cmdConnect           uint8 = 0x02
+	cmdDisconnect        uint8 = 0x03
+	cmdTransferConfigure uint8 = 0x04
+	cmdTransfer          uint8 = 0x05
+	cmdTransferBlock     uint8 = 0x06
+	cmdResetTarget       uint8 = 0x0a
+	cmdSWJPins           uint8 = 0x10
+	cmdSWJClock          uint8 = 0x11
+	cmdSWJSequence       uint8 = 0x12
+	cmdSWDConfigure      uint8 = 0x13
+)
+
+// DAP_Info IDs
+const (
+	InfoVendor      uint8 = 0x01
+	InfoProduct     uint8 = 0x02
+	InfoSerial      uint8 = 0x03
+	InfoVersion     uint8 = 0x04
+	InfoCaps        uint8 = 0xf0
+	InfoPacketCount uint8 = 0xfe
+	InfoPacketSize  uint8 = 0xff
+)
+
+// Capabilities (InfoCaps)
+const (
+	CapSWD  = 1 << 0
+	CapJTAG = 1 << 1
+)
+
+// Transfer request bits
+const (
+	APnDP uint8 = 1 << 0
+	RnW   uint8 = 1 << 1
+)
+
+const (
+	dapOK    = 0x00
+	dapError = 0xff
+)
+
+type Error struct {
+	Op  string
+	Err error
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Error() string {
+	return "cmsisdap: " + e.Op + ": " + e.Err.Error()
+}
+
+func wrapErr(op string, err *error) {
+	if *err != nil {
+		*err = &Error{op, *err}
+	}
+}
+
+// ErrNotFound is returned by Connect if there is no CMSIS-DAP probe on the USB
+// bus.
+var ErrNotFound = errors.New("no CMSIS-DAP probes were found")
+
+// TransferError describes the failed SWD transfer.
+type TransferError struct {
+	Ack uint8 // the last transfer response (ACK) reported by the probe
+}
+
+func (e *TransferError) Error() string {
+	switch {
+	case e.Ack&0x08 != 0:
+		return "SWD protocol error (parity)"
+	case e.Ack&0x07 == 2:
+		return "SWD WAIT response"
+	case e.Ack&0x07 == 4:
+		return "SWD FAULT response"
+	case e.Ack&0x07 == 7:
+		return "no SWD response (target not connected or powered)"
+	}
+	return fmt.Sprintf("SWD transfer failed (response %#x)", e.Ack)
+}
+
+// ErrorCode returns the symbolic name of the transfer response.
+func (e *TransferError) ErrorCode() string {
+	switch {
+	case e.Ack&0x08 != 0:
+		return "SWD_PROTOCOL_ERROR"
+	case e.Ack&0x07 == 2:
+		return "SWD_WAIT"
+	case e.Ack&0x07 == 4:
+		return "SWD_FAULT"
+	case e.Ack&0x07 == 7:
+		return "SWD_NO_ACK"
+	}
+	return "SWD_ERROR"
+}
+
+type Conn struct {
+	dev     usbdev.Device
+	intf    usbdev.Interface
+	oe      io.Writer
+	ie      io.Reader
+	v1      bool // HID probe, packets padded to pktSize
+	pktSize int
+	buf     []byte
+	rsp     []byte
+}
+
+// probeIntf returns the CMSIS-DAP interface of the device. The version is 2
+// for the bulk interface and 1 for the HID one. It prefers the v2 interface if
+// the device provides both.
+func probeIntf(dev usbdev.Device) (cfg, intf, alt int, eps []usb.EndpointDesc, version int) {
+	for _, c := range dev.Desc().Configs {
+		for _, id := range c.Interfaces {
+			for _, is := range id.AltSettings {
+				v := 0
+				switch is.Class {
+				case usb.ClassVendorSpec:
+					v = 2
+				case usb.ClassHID:
+					v = 1
+				default:
+					continue
+				}
+				if v <= version {
+					continue
+				}
+				// The v2 interface string must contain "CMSIS-DAP". The v1
+				// probes are identified by the product string.
+				var s string
+				if v == 2 {
+					s, _ = dev.InterfaceDescription(c.Number, is.Number, is.Alternate)
+				} else {
+					s, _ = dev.Product()
+				}
+				if !strings.Contains(s, "CMSIS-DAP") {
+					continue
+				}
+				eps = eps[:0]
+				for _, ed := range is.Endpoints {
+					eps = append(eps, ed)
+				}
+				cfg, intf, alt, version = c.Number, is.Number, is.Alternate, v
+			}
+		}
+	}
+	return
+}
+
+// maybeProbe reports whether the device descriptor describes a possible
+// CMSIS-DAP probe. The interface description is checked after open.
+func maybeProbe(desc *usb.DeviceDesc) bool {
+	for _, c := range desc.Configs {
+		for _, id := range c.Interfaces {
+			for _, is := range id.AltSettings {
+				if is.Class == usb.ClassVendorSpec || is.Class == usb.ClassHID {
+					if len(is.Endpoints) >= 2 {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// openProbes opens the devices that may be CMSIS-DAP probes (see maybeProbe)
+// and returns the ones that have the CMSIS-DAP interface.
+func openProbes(busAddr string) (devs []usbdev.Device, err error) {
+	all, err := usbdev.OpenMatch(0, 0, busAddr, maybeProbe)
+	if err != nil {
+		return
+	}
+	for _, d := range all {
+		if _, _, _, _, v := probeIntf(d); v != 0 {
+			devs = append(devs, d)
+			continue
+		}
+		d.Close()
+	}
+	return
+}
+
+// Connect connects to the CMSIS-DAP probe. You can connect to the concrete
+// probe on the USB bus by providing BUS:DEV string where both BUS and DEV are
+// decimal unsigned integers. If busAddr is empty connect will try to find a
+// CMSIS-DAP probe on the bus (it will return an error if there are more than
+// one such probes).
+func Connect(busAddr string) (conn *Conn, err error) {
+	defer wrapErr("Connect", &err)
+
+	devs, err := openProbes(busAddr)
+	if err != nil {
+		return
+	}
+	if len(devs) != 1 {
+		usbdev.Close(devs)
+		if len(devs) == 0 {
+			return nil, ErrNotFound
+		}
+		return nil, errors.New("found more than one CMSIS-DAP probe")
+	}
+	dev := devs[0]
+	var intf usbdev.Interface
+	defer func() {
+		if err != nil {
+			if intf != nil {
+				intf.Close()
+			}
+			dev.Close()
+		}
+	}()
+	cn, in, an, eps, version := probeIntf(dev)
+
+	// The first IN and OUT endpoints are the DAP ones (v2 may provide the
+	// second IN endpoint for SWO).
+	var rxn, txn int
+	for _, ed := range eps {
+		if ed.Direction == usb.EndpointDirectionIn {
+			if rxn == 0 || ed.Number < rxn {
+				rxn = ed.Number
+			}
+		} else if txn == 0 || ed.Number < txn {
+			txn = ed.Number
+		}
+	}
+	if rxn == 0 {
+		return nil, errors.New("no USB IN endpoint in the USB interface")
+	}
+	if txn == 0 {
+		return nil, errors.New("no USB OUT endpoint in the USB interface")
+	}
+	intf, err = dev.Claim(cn, in, an)
+	if err != nil {
+		return nil, err
+	}
+	ie, err := intf.In(rxn)
+	if err != nil {
+		return nil, err
+	}
+	oe, err := intf.Out(txn)
+	if err != nil {
+		return nil, err
+	}
+	conn = &Conn{dev: dev, intf: intf, oe: oe, ie: ie, v1: version == 1, pktSize: 64}
+	if !conn.v1 {
+		conn.pktSize = 512
+	}
+	conn.rsp = make([]byte, conn.pktSize)
+	info, err := conn.Info(InfoPacketSize)
+	if err != nil {
+		return nil, err
+	}
+	if len(info) == 2 {
+		if n := int(binary.LittleEndian.Uint16(info)); n >= 64 {
+			conn.pktSize = n
+			conn.rsp = make([]byte, n)
+		}
+	}
+	return
+}
+
+// List returns all CMSIS-DAP probes.
+func List() (list []usbdev.Info, err error) {
+	defer wrapErr("List", &err)
+	devs, err := openProbes("")
+	if err != nil {
+		return
+	}
+	for _, d := range devs {
+		sn, _ := d.SerialNumber()
+		list = append(list, usbdev.Info{BusAddr: usbdev.BusAddr(d.Desc()), Serial: sn})
+		d.Close()
+	}
+	return
+}
+
+func (c *Conn) Close() (err error) {
+	c.intf.Close()
+	err = c.dev.Close()
+	wrapErr("Close", &err)
+	return
+}
+
+// Version returns the CMSIS-DAP protocol version (1 or 2) used to communicate
+// with the probe.
+func (c *Conn) Version() int {
+	if c.v1 {
+		return 1
+	}
+	return 2
+}
+
+// PacketSize returns the maximum size of the command/response packet.
+func (c *Conn) PacketSize() int {
+	return c.pktSize
+}
+
+// command sends the command packet built in c.buf and returns the response
+// without the command ID.
+func (c *Conn) command() ([]byte, error) {
+	cmd := c.buf[0]
+	p := c.buf
+	if c.v1 {
+		// HID reports have the fixed size.
+		for len(p) < c.pktSize {
+			p = append(p, 0)
+		}
+	}
+	if len(p) > c.pktSize {
+		return nil, errors.New("command packet too long")
+	}
+	if _, err := c.oe.Write(p); err != nil {
+		return nil, err
+	}
+	n, err := c.ie.Read(c.rsp)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 || c.rsp[0] != cmd {
+		return nil, fmt.Errorf("bad response to the command %#02x", cmd)
+	}
+	return c.rsp[1:n], nil
+}
+
+func (c *Conn) start(cmd uint8) {
+	c.buf = append(c.buf[:0], cmd)
+}
+
+// status checks the one-byte DAP_OK/DAP_ERROR status response.
+func status(rsp []byte, err error) error {
+	if err != nil {
+		return err
+	}
+	if len(rsp) < 1 {
+		return errors.New("response too short")
+	}
+	if rsp[0] != dapOK {
+		return errors.New("the probe returned DAP_ERROR")
+	}
+	return nil
+}
+
+// Info returns the information of the given ID (see the Info* constants).
+func (c *Conn) Info(id uint8) (info []byte, err error) {
+	defer wrapErr("Info", &err)
+	c.start(cmdInfo)
+	c.buf = append(c.buf, id)
+	rsp, err := c.command()
+	if err != nil {
+		return
+	}
+	if len(rsp) < 1 || int(rsp[0]) > len(rsp)-1 {
+		return nil, errors.New("response too short")
+	}
+	return append([]byte(nil), rsp[1:1+rsp[0]]...), nil
+}
+
+// InfoString returns the string information of the given ID.
+func (c *Conn) InfoString(id uint8) (string, error) {
+	info, err := c.Info(id)
+	return strings.TrimRight(string(info), "\x00"), err
+}
+
+// HostStatus sets the state of the connect (typ=0) or running (typ=1) LED.
+func (c *Conn) HostStatus(typ uint8, on bool) (err error) {
+	defer wrapErr("HostStatus", &err)
+	c.start(cmdHostStatus)
+	c.buf = append(c.buf, typ, 0)
+	if on {
+		c.buf[2] = 1
+	}
+	return status(c.command())
+}
+
+// ConnectSWD switches the probe to the SWD mode with the given clock
+// frequency and performs the JTAG to SWD switching sequence followed by the
+// line reset.
+func (c *Conn) ConnectSWD(clockHz int) (err error) {
+	defer wrapErr("ConnectSWD", &err)
+	caps, err := c.Info(InfoCaps)
+	if err != nil {
+		return
+	}
+	if len(caps) < 1 || caps[0]&CapSWD == 0 {
+		return errors.New("the probe doesn't support SWD")
+	}
+	c.start(cmdConnect)
+	c.buf = append(c.buf, 1) // SWD
+	rsp, err := c.command()
+	if err != nil {
+		return
+	}
+	if len(rsp) < 1 || rsp[0] != 1 {
+		return errors.New("cannot switch the probe to the SWD mode")
+	}
+	c.start(cmdSWJClock)
+	c.buf = binary.LittleEndian.AppendUint32(c.buf, uint32(clockHz))
+	if err = status(c.command()); err != nil {
+		return
+	}
+	// Idle cycles: 0, WAIT retry: 0xffff, match retry: 0
+	c.start(cmdTransferConfigure)
+	c.buf = append(c.buf, 0, 0xff, 0xff, 0, 0)
+	if err = status(c.command()); err != nil {
+		return
+	}
+	// Turnaround: 1 cycle, no data phase on WAIT/FAULT.
+	c.start(cmdSWDConfigure)
+	c.buf = append(c.buf, 0)
+	if err = status(c.command()); err != nil {
+		return
+	}
+	return c.SWDLineReset()
+}
+
+// SWDLineReset performs the JTAG to SWD switching sequence and the SWD line
+// reset. It leaves the line in the idle state.
+func (c *Conn) SWDLineReset() (err error) {
+	ones := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	if err = c.SWJSequence(56, ones); err != nil {
+		return
+	}
+	if err = c.SWJSequence(16, []byte{0x9e, 0xe7}); err != nil {
+		return
+	}
+	if err = c.SWJSequence(56, ones); err != nil {
+		return
+	}
+	return c.SWJSequence(8, []byte{0x00})
+}
+
+// SWJSequence generates the sequence of n bits on SWDIO/TMS (LSB first).
+func (c *Conn) SWJSequence(n int, bits []byte) (err error) {
+	defer wrapErr("SWJSequence", &err)
+	if n <= 0 || n > 256 || len(bits) < (n+7)/8 {
+		return errors.New("bad sequence length")
+	}
+	c.start(cmdSWJSequence)
+	c.buf = append(c.buf, uint8(n))
+	c.buf = append(c.buf, bits[:(n+7)/8]...)
+	return status(c.command())
+}
+
+// ResetTarget performs the device specific target reset sequence (if
+// implemented by the probe).
+func (c *Conn) ResetTarget() (err error) {
+	defer wrapErr("ResetTarget", &err)
+	c.start(cmdResetTarget)
+	return status(c.command())
+}
+
+// SetReset sets the state of the nRESET pin (active low).
+func (c *Conn) SetReset(assert bool) (err error) {
+	defer wrapErr("SetReset", &err)
+	const nRESET = 1 << 7
+	var val uint8
+	if !assert {
+		val = nRESET
+	}
+	c.start(cmdSWJPins)
+	c.buf = append(c.buf, val, nRESET, 0, 0, 0, 0)
+	_, err = c.command()
+	return
+}
+
+// Disconnect disconnects the probe from the target.
+func (c *Conn) Disconnect() (err error) {
+	defer wrapErr("Disconnect", &err)
+	c.start(cmdDisconnect)
+	return status(c.command())
+}
+
+// Transfer performs the sequence of the SWD transfers. The reqs[i] contains the
+// APnDP, RnW bits and the A[3:2] register address bits. For the write request
+// data[i] contains the value to be written, for the read request data[i]
+// receives the read value. The transfers are split into as many commands as
+// required by the packet size of the probe.
+func (c *Conn) Transfer(reqs []uint8, data []uint32) (err error) {
+	defer wrapErr("Transfer", &err)
+	if len(data) < len(reqs) {
+		return errors.New("data slice too short")
+	}
+	le := binary.LittleEndian
+	for len(reqs) != 0 {
+		// Command: ID, DAP index, count, requests. Response: ID, count,
+		// ACK, read data.
+		c.start(cmdTransfer)
+		c.buf = append(c.buf, 0, 0)
+		n, rspLen := 0, 3
+		for _, r := range reqs {
+			in, out := 1, 0
+			if r&RnW != 0 {
+				out = 4
+			} else {
+				in = 5
+			}
+			if n == 255 || len(c.buf)+in > c.pktSize || rspLen+out > c.pktSize {
+				break
+			}
+			c.buf = append(c.buf, r&0x0f)
+			if r&RnW == 0 {
+				c.buf = le.AppendUint32(c.buf, data[n])
+			}
+			n++
+			rspLen += out
+		}
+		c.buf[2] = uint8(n)
+		var rsp []byte
+		rsp, err = c.command()
+		if err != nil {
+			return
+		}
+		if len(rsp) < 2 {
+			return errors.New("response too short")
+		}
+		done, ack := int(rsp[0]), rsp[1]
+		if done != n || ack != 1 {
+			return &TransferError{ack}
+		}
+		rsp = rsp[2:]
+		for i, r := range reqs[:n] {
+			if r&RnW != 0 {
+				if len(rsp) < 4 {
+					return errors.New("response too short")
+				}
+				data[i] = le.Uint32(rsp)
+				rsp = rsp[4:]
+			}
+		}
+		reqs, data = reqs[n:], data[n:]
+	}
+	return
+}
+
+// TransferBlock reads (RnW set in req) or writes len(data) words from/to the
+// same DP/AP register.
+func (c *Conn) TransferBlock(req uint8, data []uint32) (err error) {
+	defer wrapErr("TransferBlock", &err)
+	le := binary.LittleEndian
+	maxWords := (c.pktSize - 5) / 4
+	for len(data) != 0 {
+		n := min(len(data), maxWords, 0xffff)
+		// Command: ID, DAP index, count (16-bit), request, write data.
+		// Response: ID, count (16-bit), ACK, read data.
+		c.start(cmdTransferBlock)
+		c.buf = append(c.buf, 0)
+		c.buf = le.AppendUint16(c.buf, uint16(n))
+		c.buf = append(c.buf, req&0x0f)
+		if req&RnW == 0 {
+			for _, v := range data[:n] {
+				c.buf = le.AppendUint32(c.buf, v)
+			}
+		}
+		var rsp []byte
+		rsp, err = c.command()
+		if err != nil {
+			return
+		}
+		if len(rsp) < 3 {
+			return errors.New("response too short")
+		}
+		done, ack := int(le.Uint16(rsp)), rsp[2]
+		if done != n || ack != 1 {
+			return &TransferError{ack}
+		}
+		if req&RnW != 0 {
+			rsp = rsp[3:]
+			if len(rsp) < 4*n {
+				return errors.New("response too short")
+			}
+			for i := range data[:n] {
+				data[i] = le.Uint32(rsp[4*i:])
+			}
+		}
+		data = data[n:]
+	}
+	return
+}
@@ -0,0 +1,113 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cmsisdap
+
+import (
+	"testing"
+
+	"github.com/embeddedgo/tools/egtool/internal/halfkay"
+	"github.com/embeddedgo/tools/egtool/internal/usbdev"
+	"github.com/embeddedgo/tools/egtool/internal/usbsim"
+	usb "github.com/google/gousb"
+)
+
+// descDev is the device that provides only the descriptors and strings.
+type descDev struct {
+	usbdev.Device
+	desc    usb.DeviceDesc
+	product string
+	intf    map[int]string
+}
+
+func (d *descDev) Desc() *usb.DeviceDesc {
+	return &d.desc
+}
+
+func (d *descDev) Product() (string, error) {
+	return d.product, nil
+}
+
+func (d *descDev) InterfaceDescription(cfg, intf, alt int) (string, error) {
+	return d.intf[intf], nil
+}
+
+func newDescDev(product string, intf map[int]string, classes ...usb.Class) *descDev {
+	cfg := usb.ConfigDesc{Number: 1}
+	for i, c := range classes {
+		cfg.Interfaces = append(cfg.Interfaces, usb.InterfaceDesc{
+			Number: i,
+			AltSettings: []usb.InterfaceSetting{{
+				Number: i, Class: c,
+				Endpoints: map[usb.EndpointAddress]usb.EndpointDesc{
+					0x81: {Address: 0x81, Number: 1},
+				},
+			}},
+		})
+	}
+	return &descDev{
+		desc:    usb.DeviceDesc{Configs: map[int]usb.ConfigDesc{1: cfg}},
+		product: product,
+		intf:    intf,
+	}
+}
+
+func TestProbeIntf(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		dev     *descDev
+		intf    int
+		version int
+	}{
+		{
+			"v1 product",
+			newDescDev("LPC-Link CMSIS-DAP", nil, usb.ClassHID),
+			0, 1,
+		},
+		{
+			"v1 not a probe",
+			newDescDev("USB Keyboard", map[int]string{0: "CMSIS-DAP"}, usb.ClassHID),
+			0, 0,
+		},
+		{
+			"v2",
+			newDescDev("Probe", map[int]string{1: "CMSIS-DAP v2"}, usb.ClassHID, usb.ClassVendorSpec),
+			1, 2,
+		},
+		{
+			"v2 preferred",
+			newDescDev("Probe CMSIS-DAP", map[int]string{1: "CMSIS-DAP v2"}, usb.ClassHID, usb.ClassVendorSpec),
+			1, 2,
+		},
+		{
+			"v2 no interface string",
+			newDescDev("Probe CMSIS-DAP", nil, usb.ClassVendorSpec),
+			0, 0,
+		},
+	} {
+		_, intf, _, eps, version := probeIntf(tc.dev)
+		if version != tc.version || version != 0 && (intf != tc.intf || len(eps) != 1) {
+			t.Errorf(
+				"%s: intf=%d version=%d, want intf=%d version=%d",
+				tc.name, intf, version, tc.intf, tc.version,
+			)
+		}
+	}
+}
+
+func TestList(t *testing.T) {
+	// Only the probe is opened, the other devices are skipped.
+	dap := usbsim.NewDAP(usbsim.NewNRF52(0x52840, 256))
+	for _, sim := range []usbdev.Device{usbsim.NewTeensy(halfkay.Teensy40), dap} {
+		usbdev.Attach(sim)
+		t.Cleanup(func() { usbdev.Detach(sim) })
+	}
+	list, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].BusAddr != usbdev.BusAddr(dap.Desc()) {
+		t.Errorf("List: %v, want the %s probe", list, usbdev.BusAddr(dap.Desc()))
+	}
+}
@@ -0,0 +1,305 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package cortexm provides the debug control of the Cortex-M cores (halt,
+// reset, core register access, calling the target functions) implemented on
+// top of the access to the target memory provided by a debug probe.
+package cortexm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Mem provides the access to the target memory.
+type Mem interface {
+	// ReadMem32 reads len(p) words starting from the word-aligned addr.
+	ReadMem32(addr uint32, p []uint32) error
+
+	// WriteMem32 writes len(p) words starting from the word-aligned addr.
+	WriteMem32(addr uint32, p []uint32) error
+}
+
+// Read32 reads the word at addr.
+func Read32(m Mem, addr uint32) (uint32, error) {
+	var w [1]uint32
+	err := m.ReadMem32(addr, w[:])
+	return w[0], err
+}
+
+// Write32 writes the word at addr.
+func Write32(m Mem, addr, val uint32) error {
+	return m.WriteMem32(addr, []uint32{val})
+}
+
+// ReadBytes reads len(p) bytes starting from the word-aligned addr.
+func ReadBytes(m Mem, addr uint32, p []byte) error {
+	w := make([]uint32, (len(p)+3)/4)
+	if err := m.ReadMem32(addr, w); err != nil {
+		return err
+	}
+	for i := range p {
+		p[i] = byte(w[i/4] >> (i % 4 * 8))
+	}
+	return nil
+}
+
+// WriteBytes writes p starting from the word-aligned addr. The last word is
+// padded with pad bytes.
+func WriteBytes(m Mem, addr uint32, p []byte, pad byte) error {
+	return m.WriteMem32(addr, Words(p, pad))
+}
+
+// Words converts p to the little-endian words padding the last one with pad.
+func Words(p []byte, pad byte) []uint32 {
+	w := make([]uint32, (len(p)+3)/4)
+	for i := range w {
+		var b [4]byte
+		for k := range b {
+			b[k] = pad
+			if i*4+k < len(p) {
+				b[k] = p[i*4+k]
+			}
+		}
+		w[i] = uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+	}
+	return w
+}
+
+// Debug registers
+const (
+	DHCSR = 0xe000_edf0
+	DCRSR = 0xe000_edf4
+	DCRDR = 0xe000_edf8
+	DEMCR = 0xe000_edfc
+	AIRCR = 0xe000_ed0c
+	CPUID = 0xe000_ed00
+	DFSR  = 0xe000_ed30
+)
+
+// DHCSR bits
+const (
+	dbgKey      = 0xa05f << 16
+	CDebugEn    = 1 << 0
+	CHalt       = 1 << 1
+	CStep       = 1 << 2
+	CMaskInts   = 1 << 3
+	SRegRdy     = 1 << 16
+	SHalt       = 1 << 17
+	SSleep      = 1 << 18
+	SLockup     = 1 << 19
+	SResetSt    = 1 << 25
+	vcCoreReset = 1 << 0 // DEMCR
+	sysResetReq = 0x05fa_0004
+)
+
+// Core registers (DCRSR REGSEL)
+const (
+	R0   = 0
+	R1   = 1
+	R2   = 2
+	R3   = 3
+	R9   = 9
+	SP   = 13
+	LR   = 14
+	PC   = 15 // DebugReturnAddress
+	XPSR = 16
+	MSP  = 17
+	PSP  = 18
+)
+
+type Error struct {
+	Op  string
+	Err error
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Error() string {
+	return "cortexm: " + e.Op + ": " + e.Err.Error()
+}
+
+func wrapErr(op string, err *error) {
+	if *err != nil {
+		*err = &Error{op, *err}
+	}
+}
+
+// Core is a Cortex-M core accessed through the debug interface.
+type Core struct {
+	Mem
+}
+
+// Timeout limits the time of waiting for the core to halt.
+var Timeout = 2 * time.Second
+
+// Halted reports whether the core is halted.
+func (c *Core) Halted() (bool, error) {
+	s, err := Read32(c, DHCSR)
+	return s&SHalt != 0, err
+}
+
+// waitHalt waits for the core to halt.
+func (c *Core) waitHalt(timeout time.Duration) error {
+	for deadline := time.Now().Add(timeout); ; {
+		s, err := Read32(c, DHCSR)
+		if err != nil {
+			return err
+		}
+		if s&SHalt != 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timeout waiting for the core to halt")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Halt halts the core.
+func (c *Core) Halt() (err error) {
+	defer wrapErr("Halt", &err)
+	if err = Write32(c, DHCSR, dbgKey|CDebugEn|CHalt); err != nil {
+		return
+	}
+	return c.waitHalt(Timeout)
+}
+
+// ResetHalt resets the system and halts the core before the first
+// instruction is executed.
+func (c *Core) ResetHalt() (err error) {
+	defer wrapErr("ResetHalt", &err)
+	if err = Write32(c, DHCSR, dbgKey|CDebugEn|CHalt); err != nil {
+		return
+	}
+	demcr, err := Read32(c, DEMCR)
+	if err != nil {
+		return
+	}
+	if err = Write32(c, DEMCR, demcr|vcCoreReset); err != nil {
+		return
+	}
+	// The debug port may not respond to the write of the AIRCR if the reset
+	// happens before the transfer is finished.
+	Write32(c, AIRCR, sysResetReq)
+	time.Sleep(20 * time.Millisecond)
+	err = c.waitHalt(Timeout)
+	if err != nil {
+		return
+	}
+	return Write32(c, DEMCR, demcr&^vcCoreReset)
+}
+
+// Reset resets the system and lets the core run.
+func (c *Core) Reset() (err error) {
+	defer wrapErr("Reset", &err)
+	demcr, err := Read32(c, DEMCR)
+	if err != nil {
+		return
+	}
+	if err = Write32(c, DEMCR, demcr&^vcCoreReset); err != nil {
+		return
+	}
+	if err = Write32(c, DHCSR, dbgKey); err != nil {
+		return
+	}
+	Write32(c, AIRCR, sysResetReq) // see ResetHalt
+	return nil
+}
+
+// Resume lets the halted core run.
+func (c *Core) Resume() (err error) {
+	defer wrapErr("Resume", &err)
+	// Clear the halt reasons.
+	if err = Write32(c, DFSR, 0x1f); err != nil {
+		return
+	}
+	return Write32(c, DHCSR, dbgKey|CDebugEn)
+}
+
+func (c *Core) waitRegRdy() error {
+	for deadline := time.Now().Add(Timeout); ; {
+		s, err := Read32(c, DHCSR)
+		if err != nil {
+			return err
+		}
+		if s&SRegRdy != 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timeout waiting for the register transfer")
+		}
+	}
+}
+
+// ReadReg reads the core register of the halted core.
+func (c *Core) ReadReg(reg int) (val uint32, err error) {
+	defer wrapErr("ReadReg", &err)
+	if err = Write32(c, DCRSR, uint32(reg)); err != nil {
+		return
+	}
+	if err = c.waitRegRdy(); err != nil {
+		return
+	}
+	return Read32(c, DCRDR)
+}
+
+// WriteReg writes the core register of the halted core.
+func (c *Core) WriteReg(reg int, val uint32) (err error) {
+	defer wrapErr("WriteReg", &err)
+	if err = Write32(c, DCRDR, val); err != nil {
+		return
+	}
+	if err = Write32(c, DCRSR, 1<<16|uint32(reg)); err != nil {
+		return
+	}
+	return c.waitRegRdy()
+}
+
+// Call calls the function at the address fn on the halted core with up to
+// four arguments and returns the value of R0 after the function returns. The
+// bkpt is the address of the BKPT instruction the function returns to and sp
+// is the initial stack pointer. The timeout limits the execution time.
+func (c *Core) Call(fn, bkpt, sp uint32, timeout time.Duration, args ...uint32) (ret uint32, err error) {
+	if len(args) > 4 {
+		return 0, &Error{"Call", errors.New("too many arguments")}
+	}
+	for i, a := range args {
+		if err = c.WriteReg(R0+i, a); err != nil {
+			return
+		}
+	}
+	regs := [...]struct {
+		n int
+		v uint32
+	}{
+		{PC, fn &^ 1},
+		{LR, bkpt | 1},
+		{SP, sp},
+		{XPSR, 1 << 24}, // Thumb state
+	}
+	for _, r := range regs {
+		if err = c.WriteReg(r.n, r.v); err != nil {
+			return
+		}
+	}
+	if err = c.Resume(); err != nil {
+		return
+	}
+	if err = c.waitHalt(timeout); err != nil {
+		c.Halt()
+		return 0, &Error{"Call", fmt.Errorf("function %#x: %w", fn, err)}
+	}
+	pc, err := c.ReadReg(PC)
+	if err != nil {
+		return
+	}
+	if pc != bkpt {
+		return 0, &Error{"Call", fmt.Errorf("function %#x stopped at %#x", fn, pc)}
+	}
+	return c.ReadReg(R0)
+}
@@ -7,21 +7,36 @@ package dfu
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/embeddedgo/tools/egtool/internal/util"
+	"github.com/embeddedgo/tools/egtool/internal/usbdev"
 	usb "github.com/google/gousb"
 )
 
 type Conn struct {
-	ctx       *usb.Context
-	dev       *usb.Device
+	dev       usbdev.Device
+	intf      usbdev.Interface
 	iid       uint16
 	statusBuf [6]byte
-	poolSpeed uint
+	fd        funcDesc
+
+	// Timeout limits the time of a single operation (Download, Manifest),
+	// including all its retries and bwPollTimeout periods. Connect sets it to
+	// DefaultTimeout.
+	Timeout time.Duration
+
+	// Retries is the number of additional attempts to transfer a block after
+	// a transient USB error. Connect sets it to DefaultRetries.
+	Retries int
 }
 
+const (
+	DefaultTimeout = 30 * time.Second
+	DefaultRetries = 3
+)
+
 type Error struct {
 	Op  string
 	Err error
@@ -41,82 +56,349 @@ func wrapErr(op string, err *error) {
 	}
 }
 
-// Connect connects to the USB device in the DFU mode. You can connect to the
-// concrete device on the USB bus by providing BUS:DEV string where both BUS and
-// DEV are decimal unsigned integers. If busAddr is empty connect will try to
-// find a DFU device on the bus (it will return an error if there are more than
-// one such devices).
-func Connect(vendor, product usb.ID, busAddr string, poolSpeed uint) (conn *Conn, err error) {
-	defer wrapErr("Connect", &err)
+// ErrNotFound is returned by Connect and Detach if there is no matching device
+// on the USB bus.
+var ErrNotFound = errors.New("no matching USB devices were found")
 
-	ctx, devs, err := util.OpenUSB(vendor, product, busAddr)
-	if err != nil {
-		return
+// DFU functional descriptor attributes
+const (
+	bitCanDnload             uint8 = 1 << 0
+	bitCanUpload             uint8 = 1 << 1
+	bitManifestationTolerant uint8 = 1 << 2
+	bitWillDetach            uint8 = 1 << 3
+)
+
+// DFU interface protocols
+const (
+	protoRuntime uint8 = 1
+	protoDFU     uint8 = 2
+)
+
+type funcDesc struct {
+	attrs         uint8
+	detachTimeout uint16
+	transferSize  uint16
+	version       uint16
+}
+
+const reqGetDescriptor uint8 = 0x06 // USB standard request
+
+// readFuncDesc reads the DFU functional descriptor of the iid interface. The
+// gousb doesn't provide access to the class-specific descriptors so it reads
+// the whole configuration descriptor and looks for it.
+func readFuncDesc(dev usbdev.Device, cfgNum, iid int) (fd funcDesc, err error) {
+	var hdr [9]byte
+	for idx := range len(dev.Desc().Configs) {
+		_, err = dev.Control(
+			usb.ControlIn|usb.ControlDevice, reqGetDescriptor, 0x0200|uint16(idx), 0, hdr[:],
+		)
+		if err != nil {
+			return
+		}
+		if int(hdr[5]) != cfgNum {
+			continue
+		}
+		buf := make([]byte, int(hdr[2])|int(hdr[3])<<8)
+		var n int
+		n, err = dev.Control(
+			usb.ControlIn|usb.ControlDevice, reqGetDescriptor, 0x0200|uint16(idx), 0, buf,
+		)
+		if err != nil {
+			return
+		}
+		intf := -1
+		for buf = buf[:n]; len(buf) >= 2; buf = buf[buf[0]:] {
+			if buf[0] < 2 || int(buf[0]) > len(buf) {
+				break
+			}
+			switch buf[1] {
+			case 0x04: // interface
+				intf = int(buf[2])
+			case 0x21: // DFU functional
+				if intf != iid || buf[0] < 7 {
+					continue
+				}
+				fd.attrs = buf[2]
+				fd.detachTimeout = uint16(buf[3]) | uint16(buf[4])<<8
+				fd.transferSize = uint16(buf[5]) | uint16(buf[6])<<8
+				if buf[0] >= 9 {
+					fd.version = uint16(buf[7]) | uint16(buf[8])<<8
+				}
+				return
+			}
+		}
+		break
 	}
-	defer ctx.Close()
+	err = errors.New("no DFU functional descriptor")
+	return
+}
+
+type dfuAlt struct {
+	cfg, intf, alt int
+}
 
-	var dev *usb.Device
-	var alts [][3]int
+// findIntf looks for the DFU interfaces with the given protocol. It returns
+// an error if they are provided by more than one device.
+func findIntf(devs []usbdev.Device, proto uint8) (dev usbdev.Device, alts []dfuAlt, err error) {
 	for _, d := range devs {
-		for _, cfg := range d.Desc.Configs {
+		for _, cfg := range d.Desc().Configs {
 			for _, id := range cfg.Interfaces {
 				for _, is := range id.AltSettings {
-					if is.Class == 0xfe && is.SubClass == 1 && is.Protocol == 2 && len(is.Endpoints) == 0 {
-						if dev == nil {
-							dev = d
-						} else if dev != d {
-							err = errors.New("found more than one USB device in DFU mode")
-							return
-						}
-						alts = append(
-							alts,
-							[3]int{cfg.Number, is.Number, is.Alternate},
-						)
+					if is.Class != 0xfe || is.SubClass != 1 || uint8(is.Protocol) != proto || len(is.Endpoints) != 0 {
+						continue
 					}
+					if dev == nil {
+						dev = d
+					} else if dev != d {
+						err = errors.New("found more than one matching USB device")
+						return
+					}
+					alts = append(alts, dfuAlt{cfg.Number, is.Number, is.Alternate})
 				}
 			}
 		}
 	}
 	if dev == nil {
-		err = errors.New("no USB devices in DFU mode were found")
+		err = ErrNotFound
+	}
+	return
+}
+
+// hasIntf reports whether the device provides the DFU interface that uses the
+// given protocol.
+func hasIntf(desc *usb.DeviceDesc, proto uint8) bool {
+	for _, cfg := range desc.Configs {
+		for _, id := range cfg.Interfaces {
+			for _, is := range id.AltSettings {
+				if is.Class == 0xfe && is.SubClass == 1 && uint8(is.Protocol) == proto && len(is.Endpoints) == 0 {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// List returns all devices in the DFU mode with the given vendor and product
+// ID. The zero vendor or product matches any ID.
+func List(vendor, product usb.ID) (list []usbdev.Info, err error) {
+	list, err = usbdev.List(vendor, product, func(desc *usb.DeviceDesc) bool {
+		return hasIntf(desc, protoDFU)
+	})
+	wrapErr("List", &err)
+	return
+}
+
+// open opens the only device that provides the DFU interface with the given
+// protocol. The devices without such interface aren't opened at all. The
+// non-empty serial selects the device by its serial number.
+func open(vendor, product usb.ID, busAddr, serial string, proto uint8) (dev usbdev.Device, alts []dfuAlt, err error) {
+	devs, err := usbdev.OpenMatch(vendor, product, busAddr, func(desc *usb.DeviceDesc) bool {
+		return hasIntf(desc, proto)
+	})
+	if err != nil {
 		return
 	}
+	if serial != "" {
+		var match []usbdev.Device
+		for _, d := range devs {
+			if sn, _ := d.SerialNumber(); sn == serial {
+				match = append(match, d)
+			} else {
+				d.Close()
+			}
+		}
+		devs = match
+	}
+	dev, alts, err = findIntf(devs, proto)
+	closeDevs(devs, dev)
+	return
+}
+
+// closeDevs closes all devices except the keep one.
+func closeDevs(devs []usbdev.Device, keep usbdev.Device) {
+	for _, d := range devs {
+		if d != keep {
+			d.Close()
+		}
+	}
+}
 
-	var alt *[3]int
-	for _, a := range alts {
+// Connect connects to the USB device in the DFU mode. You can connect to the
+// concrete device on the USB bus by providing BUS:DEV string where both BUS and
+// DEV are decimal unsigned integers. If busAddr is empty connect will try to
+// find a DFU device on the bus (it will return an error if there are more than
+// one such devices). The zero vendor or product matches any ID.
+//
+// The alt selects the DFU alternate setting by its number or by a substring of
+// its name. If alt is empty Connect uses the only alternate setting provided by
+// the device or the one which name contains "flash".
+//
+// Connect checks the state of the device and, if it was left in the middle of
+// an operation or in the error state by a previous session, aborts the
+// operation or clears the error to bring it back to the dfuIDLE state.
+func Connect(vendor, product usb.ID, busAddr, alt string) (conn *Conn, err error) {
+	defer wrapErr("Connect", &err)
+	return connectTo(vendor, product, busAddr, "", alt)
+}
+
+// ConnectSerial works like Connect but selects the device by its serial number
+// instead of its location on the bus. Use it to connect to the device that
+// re-enumerated in the DFU mode after Detach. The empty serial matches any
+// device.
+func ConnectSerial(vendor, product usb.ID, serial, alt string) (conn *Conn, err error) {
+	defer wrapErr("Connect", &err)
+	return connectTo(vendor, product, "", serial, alt)
+}
+
+func connectTo(vendor, product usb.ID, busAddr, serial, alt string) (conn *Conn, err error) {
+	dev, alts, err := open(vendor, product, busAddr, serial, protoDFU)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			dev.Close()
+		}
+	}()
+
+	a, err := selectAlt(dev, alts, alt)
+	if err != nil {
+		return
+	}
+	fd, err := readFuncDesc(dev, a.cfg, a.intf)
+	if err != nil {
+		return
+	}
+	intf, err := dev.Claim(a.cfg, a.intf, a.alt)
+	if err != nil {
+		return
+	}
+	conn = &Conn{
+		dev: dev, intf: intf, iid: uint16(a.intf),
+		fd: fd, Timeout: DefaultTimeout, Retries: DefaultRetries,
+	}
+	if err = conn.recover(); err != nil {
+		intf.Close()
+		conn = nil
+	}
+	return
+}
+
+func selectAlt(dev usbdev.Device, alts []dfuAlt, alt string) (a dfuAlt, err error) {
+	if n, err1 := strconv.ParseUint(alt, 0, 8); err1 == nil {
+		for _, a = range alts {
+			if a.alt == int(n) {
+				return
+			}
+		}
+		err = fmt.Errorf(
+			"device %d:%d has no DFU alternate setting %d",
+			dev.Desc().Bus, dev.Desc().Address, n,
+		)
+		return
+	}
+	if alt == "" && len(alts) == 1 {
+		return alts[0], nil
+	}
+	name := alt
+	if name == "" {
+		name = "flash"
+	}
+	found := false
+	for _, aa := range alts {
 		var aname string
-		aname, err = dev.InterfaceDescription(a[0], a[1], a[2])
+		aname, err = dev.InterfaceDescription(aa.cfg, aa.intf, aa.alt)
 		if err != nil {
 			return
 		}
-		if strings.Contains(strings.ToLower(aname), "flash") {
-			if alt != nil {
+		if strings.Contains(strings.ToLower(aname), strings.ToLower(name)) {
+			if found {
 				err = fmt.Errorf(
-					"device %d:%d has more than one valid DFU configuration",
-					dev.Desc.Bus, dev.Desc.Address,
+					"device %d:%d has more than one DFU alternate setting matching %q",
+					dev.Desc().Bus, dev.Desc().Address, name,
 				)
 				return
 			}
-			alt = &a
+			a, found = aa, true
 		}
 	}
+	if !found {
+		err = fmt.Errorf(
+			"device %d:%d has no DFU alternate setting matching %q",
+			dev.Desc().Bus, dev.Desc().Address, name,
+		)
+	}
+	return
+}
+
+// Detach looks for the device in the DFU run-time mode and sends it the
+// DFU_DETACH request. If the device doesn't detach itself from the bus Detach
+// resets it. The caller should wait for the device to re-enumerate in the DFU
+// mode. The meaning of the parameters is the same as for Connect. Detach
+// returns the serial number of the detached device (empty if not available)
+// which can be used to find it again with ConnectSerial.
+func Detach(vendor, product usb.ID, busAddr string) (serial string, err error) {
+	defer wrapErr("Detach", &err)
 
-	dev.SetAutoDetach(true)
-	cfg, err := dev.Config(alt[0])
+	dev, alts, err := open(vendor, product, busAddr, "", protoRuntime)
 	if err != nil {
 		return
 	}
-	_, err = cfg.Interface(alt[1], alt[2])
+	defer dev.Close()
+	serial, _ = dev.SerialNumber()
+	a := alts[0]
+	fd, err := readFuncDesc(dev, a.cfg, a.intf)
 	if err != nil {
 		return
 	}
-
-	conn = &Conn{ctx: ctx, dev: dev, iid: uint16(alt[1]), poolSpeed: poolSpeed}
+	intf, err := dev.Claim(a.cfg, a.intf, a.alt)
+	if err != nil {
+		return
+	}
+	timeout := fd.detachTimeout
+	if timeout == 0 {
+		timeout = 1000
+	}
+	_, err = dev.Control(
+		usb.ControlOut|usb.ControlClass|usb.ControlInterface,
+		reqDetach, timeout, uint16(a.intf), nil,
+	)
+	intf.Close()
+	if err != nil {
+		if fd.attrs&bitWillDetach == 0 {
+			return
+		}
+		err = nil // the device could detach before the status stage
+	}
+	if fd.attrs&bitWillDetach == 0 {
+		dev.Reset() // the device disappears so an error is expected here
+	}
 	return
 }
 
+// TransferSize returns the maximum number of bytes that the device can accept
+// per control-write transaction.
+func (c *Conn) TransferSize() int {
+	return int(c.fd.transferSize)
+}
+
+// CanDownload reports whether the device supports the download operation.
+func (c *Conn) CanDownload() bool {
+	return c.fd.attrs&bitCanDnload != 0
+}
+
+// Version returns the DFU specification release number supported by the
+// device in the BCD format (0x011a means DfuSe).
+func (c *Conn) Version() uint16 {
+	return c.fd.version
+}
+
 func (c *Conn) Close() (err error) {
-	err = c.ctx.Close()
+	if c.intf != nil {
+		c.intf.Close()
+	}
+	err = c.dev.Close()
 	wrapErr("Close", &err)
 	return
 }
@@ -179,55 +461,260 @@ const (
 	reqAbort     uint8 = 0x06
 )
 
-func wrapErrStatus(c *Conn, op string, err *error) {
-	defer wrapErr(op, err)
-	if *err != nil {
-		return
+// StatusError describes the error status reported by the device.
+type StatusError struct {
+	Status uint8 // bStatus
+	State  uint8 // bState
+}
+
+func (e *StatusError) Error() string {
+	es := "unknown error"
+	if int(e.Status) < len(statusStr) && statusStr[e.Status] != "" {
+		es = statusStr[e.Status]
 	}
-again:
-	_, *err = c.dev.Control(
+	return es + " (" + stateName(e.State) + ")"
+}
+
+var statusCode = [...]string{
+	"OK", "errTARGET", "errFILE", "errWRITE", "errERASE", "errCHECK_ERASED",
+	"errPROG", "errVERIFY", "errADDRESS", "errNOTDONE", "errFIRMWARE",
+	"errVENDOR", "errUSBR", "errPOR", "errUNKNOWN", "errSTALLEDPKT",
+}
+
+// ErrorCode returns the name of the bStatus code as defined in the DFU 1.1
+// specification (e.g. errVERIFY).
+func (e *StatusError) ErrorCode() string {
+	if int(e.Status) < len(statusCode) {
+		return statusCode[e.Status]
+	}
+	return "errUNKNOWN"
+}
+
+// Status represents the response to the DFU_GETSTATUS request.
+type Status struct {
+	Status      uint8
+	PollTimeout time.Duration
+	State       uint8
+}
+
+func (c *Conn) ctrlOut(req uint8, val uint16, p []byte) error {
+	_, err := c.dev.Control(
+		usb.ControlOut|usb.ControlClass|usb.ControlInterface,
+		req, val, c.iid, p,
+	)
+	return err
+}
+
+func (c *Conn) ctrlIn(req uint8, p []byte) error {
+	n, err := c.dev.Control(
 		usb.ControlIn|usb.ControlClass|usb.ControlInterface,
-		reqGetStatus, 0, c.iid, c.statusBuf[:],
+		req, 0, c.iid, p,
 	)
-	if *err != nil {
-		op += ": GetStatus"
+	if err == nil && n != len(p) {
+		err = errors.New("short response")
+	}
+	return err
+}
+
+// GetStatus sends the DFU_GETSTATUS request.
+func (c *Conn) GetStatus() (st Status, err error) {
+	err = c.ctrlIn(reqGetStatus, c.statusBuf[:])
+	if err != nil {
+		wrapErr("GetStatus", &err)
 		return
 	}
-	status := c.statusBuf[0]
-	state := c.statusBuf[4]
-	if state == dfuError {
-		_, *err = c.dev.Control(
-			usb.ControlOut|usb.ControlClass|usb.ControlInterface,
-			reqClrStatus, 0, c.iid, nil,
-		)
-		if *err != nil {
-			op += ": ClrStatus"
+	b := c.statusBuf[:]
+	st.Status = b[0]
+	st.PollTimeout = time.Duration(
+		uint(b[1])|uint(b[2])<<8|uint(b[3])<<16,
+	) * time.Millisecond
+	st.State = b[4]
+	return
+}
+
+// GetState sends the DFU_GETSTATE request.
+func (c *Conn) GetState() (state uint8, err error) {
+	err = c.ctrlIn(reqGetState, c.statusBuf[:1])
+	wrapErr("GetState", &err)
+	return c.statusBuf[0], err
+}
+
+// ClrStatus sends the DFU_CLRSTATUS request which moves the device from the
+// dfuERROR state to dfuIDLE.
+func (c *Conn) ClrStatus() (err error) {
+	err = c.ctrlOut(reqClrStatus, 0, nil)
+	wrapErr("ClrStatus", &err)
+	return
+}
+
+// Abort sends the DFU_ABORT request which moves the device from one of the
+// idle states to dfuIDLE.
+func (c *Conn) Abort() (err error) {
+	err = c.ctrlOut(reqAbort, 0, nil)
+	wrapErr("Abort", &err)
+	return
+}
+
+// poll sends DFU_GETSTATUS requests, honoring the bwPollTimeout periods, as
+// long as the device stays in one of the busy states. It returns the first
+// state that isn't busy or an error if the device is still busy at the
+// deadline of the whole operation.
+func (c *Conn) poll(deadline time.Time, busy ...uint8) (st Status, err error) {
+	for {
+		st, err = c.GetStatus()
+		if err != nil {
+			return
+		}
+		if st.Status != 0 || st.State == dfuError {
+			err = &StatusError{st.Status, st.State}
+			return
+		}
+		isBusy := false
+		for _, b := range busy {
+			if st.State == b {
+				isBusy = true
+				break
+			}
+		}
+		if !isBusy {
+			return
+		}
+		if time.Now().Add(st.PollTimeout).After(deadline) {
+			err = fmt.Errorf("timeout in the %s state", stateName(st.State))
 			return
 		}
+		time.Sleep(st.PollTimeout)
 	}
-	if status != 0 {
-		es := "unknown error"
-		if int(status) < len(statusStr) {
-			es = statusStr[status]
+}
+
+// recover brings the device to the dfuIDLE state.
+func (c *Conn) recover() (err error) {
+	defer wrapErr("recover", &err)
+	deadline := time.Now().Add(c.Timeout)
+	state, err := c.GetState()
+	if err != nil {
+		return
+	}
+	switch state {
+	case dfuIdle:
+		return
+	case appIdle, appDetach:
+		return errors.New("the device is in the run-time mode")
+	case dfuDnbusy, dfuManifest, dfuDnloadSync, dfuManifestSync:
+		// Wait for the end of the interrupted operation.
+		var st Status
+		st, err = c.poll(deadline, dfuDnbusy, dfuManifest)
+		if _, ok := err.(*StatusError); ok {
+			state, err = dfuError, nil
+		} else if err != nil {
+			return
+		} else {
+			state = st.State
 		}
-		*err = errors.New(es)
+	}
+	switch state {
+	case dfuError:
+		err = c.ClrStatus()
+	case dfuDnloadSync, dfuDnloadIdle, dfuManifestSync, dfuUploadIdle:
+		err = c.Abort()
+	}
+	if err != nil {
 		return
 	}
-	if state == dfuDnbusy {
-		pollTimeout := uint(c.statusBuf[1]) +
-			uint(c.statusBuf[2])<<8 +
-			uint(c.statusBuf[3])<<16
+	if state, err = c.GetState(); err == nil && state != dfuIdle {
+		err = fmt.Errorf("cannot recover from the %s state", stateName(state))
+	}
+	return
+}
 
-		time.Sleep(time.Duration(pollTimeout/c.poolSpeed) * time.Millisecond)
-		goto again
+// transient reports whether the err may be caused by a temporary USB problem.
+func transient(err error) bool {
+	var ue usb.Error
+	if errors.As(err, &ue) {
+		switch ue {
+		case usb.ErrorIO, usb.ErrorTimeout, usb.ErrorPipe, usb.ErrorInterrupted, usb.ErrorOverflow:
+			return true
+		}
+	}
+	var ts usb.TransferStatus
+	if errors.As(err, &ts) {
+		return ts != usb.TransferNoDevice && ts != usb.TransferCancelled
 	}
+	return false
 }
 
+// Download sends the block of data to the device and waits until the device
+// processes it. In case of a transient USB error it tries to bring the device
+// back to the state that allows to repeat the transfer and repeats it up to
+// c.Retries times. The device must tolerate the retransmission of the same
+// block (DfuSe and most of the DFU 1.1 bootloaders do).
 func (c *Conn) Download(blockNum uint16, p []byte) (err error) {
-	_, err = c.dev.Control(
-		usb.ControlOut|usb.ControlClass|usb.ControlInterface,
-		reqDnload, blockNum, c.iid, p,
-	)
-	wrapErrStatus(c, "Download", &err)
+	defer wrapErr("Download", &err)
+	deadline := time.Now().Add(c.Timeout)
+	for try := 0; ; try++ {
+		err = c.ctrlOut(reqDnload, blockNum, p)
+		if err == nil {
+			_, err = c.poll(deadline, dfuDnloadSync, dfuDnbusy)
+		}
+		if err == nil || try >= c.Retries || !transient(err) || time.Now().After(deadline) {
+			return
+		}
+		var st Status
+		st, err = c.GetStatus()
+		if err != nil {
+			return
+		}
+		switch st.State {
+		case dfuError:
+			if err = c.ClrStatus(); err != nil {
+				return
+			}
+		case dfuDnloadSync, dfuDnbusy:
+			if _, err = c.poll(deadline, dfuDnloadSync, dfuDnbusy); err != nil {
+				return
+			}
+		case dfuIdle, dfuDnloadIdle:
+		default:
+			return fmt.Errorf(
+				"cannot retry in the %s state", stateName(st.State),
+			)
+		}
+	}
+}
+
+// Manifest finishes the download by sending the zero-length DFU_DNLOAD request
+// and waits for the end of the manifestation phase. If the device isn't
+// manifestation tolerant and doesn't detach itself from the bus Manifest
+// resets it. The connection cannot be used after the device was reset.
+func (c *Conn) Manifest(blockNum uint16) (err error) {
+	defer wrapErr("Manifest", &err)
+	if err = c.ctrlOut(reqDnload, blockNum, nil); err != nil {
+		return
+	}
+	st, err := c.poll(time.Now().Add(c.Timeout), dfuManifestSync, dfuManifest)
+	if err != nil {
+		if _, ok := err.(*StatusError); !ok && c.fd.attrs&bitManifestationTolerant == 0 {
+			err = nil // the device may have already left the DFU mode
+		}
+		return
+	}
+	switch st.State {
+	case dfuIdle:
+	case dfuManifestWaitReset:
+		if c.fd.attrs&bitWillDetach == 0 {
+			c.intf.Close()
+			c.intf = nil
+			c.dev.Reset() // the device leaves the DFU mode
+		}
+	default:
+		err = fmt.Errorf("unexpected state: %s", stateName(st.State))
+	}
 	return
 }
+
+func stateName(state uint8) string {
+	if int(state) < len(stateStr) {
+		return stateStr[state]
+	}
+	return "unknown"
+}
@@ -0,0 +1,198 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dfu
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/halfkay"
+	"github.com/embeddedgo/tools/egtool/internal/usbdev"
+	"github.com/embeddedgo/tools/egtool/internal/usbsim"
+	usb "github.com/google/gousb"
+)
+
+func attachSTM32(t *testing.T) *usbsim.STM32 {
+	sim := usbsim.NewSTM32(64)
+	usbdev.Attach(sim)
+	t.Cleanup(func() { usbdev.Detach(sim) })
+	return sim
+}
+
+func connect(t *testing.T) *Conn {
+	c, err := Connect(0, 0, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestRecover(t *testing.T) {
+	tests := []struct {
+		name   string
+		state  uint8
+		status uint8
+		req    uint8 // request expected to be used for recovery
+	}{
+		{"error", dfuError, 7, reqClrStatus},
+		{"dnload-idle", dfuDnloadIdle, 0, reqAbort},
+		{"upload-idle", dfuUploadIdle, 0, reqAbort},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sim := attachSTM32(t)
+			sim.SetState(tc.state, tc.status)
+			connect(t)
+			if st := sim.State(); st != dfuIdle {
+				t.Fatalf("state after Connect: %s", stateName(st))
+			}
+			if n := sim.Requests(tc.req); n != 1 {
+				t.Errorf("request %d sent %d times, want 1", tc.req, n)
+			}
+		})
+	}
+}
+
+// block is the first data block of the DfuSe download, written at the
+// beginning of the flash.
+var block = bytes.Repeat([]byte{1, 2, 3, 4, 5, 6, 7, 8}, 128)
+
+func TestDownloadRetry(t *testing.T) {
+	sim := attachSTM32(t)
+	c := connect(t)
+	sim.DnloadErrors = 2
+	if err := c.Download(2, block); err != nil {
+		t.Fatal(err)
+	}
+	if n := sim.Requests(reqDnload); n != 3 {
+		t.Errorf("DFU_DNLOAD sent %d times, want 3", n)
+	}
+	if !bytes.Equal(sim.Flash.Bytes(0x0800_0000, len(block)), block) {
+		t.Error("bad flash content")
+	}
+}
+
+func TestDownloadLostStatus(t *testing.T) {
+	sim := attachSTM32(t)
+	c := connect(t)
+	// The device executes the block but the host doesn't know it so the
+	// block is sent again.
+	sim.StatusErrors = 1
+	if err := c.Download(2, block); err != nil {
+		t.Fatal(err)
+	}
+	if n := sim.Requests(reqDnload); n != 2 {
+		t.Errorf("DFU_DNLOAD sent %d times, want 2", n)
+	}
+	if !bytes.Equal(sim.Flash.Bytes(0x0800_0000, len(block)), block) {
+		t.Error("bad flash content")
+	}
+	if st := sim.State(); st != dfuDnloadIdle {
+		t.Errorf("state after Download: %s", stateName(st))
+	}
+}
+
+func TestDownloadRetryLimit(t *testing.T) {
+	sim := attachSTM32(t)
+	c := connect(t)
+	sim.DnloadErrors = c.Retries + 1
+	err := c.Download(2, block)
+	if !errors.Is(err, usb.ErrorIO) {
+		t.Fatalf("Download error: %v, want %v", err, usb.ErrorIO)
+	}
+	if n := sim.Requests(reqDnload); n != c.Retries+1 {
+		t.Errorf("DFU_DNLOAD sent %d times, want %d", n, c.Retries+1)
+	}
+	if sim.Flash.Written != 0 {
+		t.Errorf("written %d bytes, want 0", sim.Flash.Written)
+	}
+}
+
+func TestDownloadStatusError(t *testing.T) {
+	sim := attachSTM32(t)
+	c := connect(t)
+	if err := c.Download(2, block); err != nil {
+		t.Fatal(err)
+	}
+	// Program the same location with another content without erasing.
+	err := c.Download(2, bytes.Repeat([]byte{0xf0}, len(block)))
+	var se *StatusError
+	if !errors.As(err, &se) || se.ErrorCode() != "errVERIFY" {
+		t.Fatalf("Download error: %v, want errVERIFY", err)
+	}
+	if n := sim.Requests(reqDnload); n != 2 {
+		t.Errorf("DFU_DNLOAD sent %d times, want 2 (no retries)", n)
+	}
+}
+
+func TestPollTimeout(t *testing.T) {
+	sim := attachSTM32(t)
+	c := connect(t)
+	sim.PollTimeout = time.Second
+	c.Timeout = 50 * time.Millisecond
+	t0 := time.Now()
+	err := c.Download(2, block)
+	if err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("Download error: %v, want timeout", err)
+	}
+	if dt := time.Since(t0); dt > time.Second/2 {
+		t.Errorf("Download returned after %v", dt)
+	}
+}
+
+func TestConnectSerial(t *testing.T) {
+	// The non-DFU device must not disturb the search.
+	teensy := usbsim.NewTeensy(halfkay.Teensy40)
+	usbdev.Attach(teensy)
+	t.Cleanup(func() { usbdev.Detach(teensy) })
+	a := attachSTM32(t)
+	a.SetSerialNumber("A")
+	c := connect(t)
+	c.Close()
+
+	b := attachSTM32(t)
+	b.SetSerialNumber("B")
+	if _, err := Connect(0, 0, "", ""); err == nil {
+		t.Fatal("Connect succeeded with two DFU devices")
+	}
+	for _, sn := range []string{"A", "B"} {
+		c, err := ConnectSerial(0x0483, 0xdf11, sn, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _ := c.dev.SerialNumber()
+		c.Close()
+		if got != sn {
+			t.Errorf("connected to %q, want %q", got, sn)
+		}
+	}
+	if _, err := ConnectSerial(0, 0, "C", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("ConnectSerial error: %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestPollDeadline(t *testing.T) {
+	sim := attachSTM32(t)
+	c := connect(t)
+	// The short bwPollTimeout periods must not extend the operation.
+	sim.PollTimeout = time.Millisecond
+	sim.BusyPolls = 1 << 30
+	c.Timeout = 50 * time.Millisecond
+	t0 := time.Now()
+	err := c.Download(2, block)
+	if err == nil || !strings.Contains(err.Error(), "timeout in the DFU download busy state") {
+		t.Fatalf("Download error: %v, want timeout", err)
+	}
+	if dt := time.Since(t0); dt > time.Second/2 {
+		t.Errorf("Download returned after %v", dt)
+	}
+	if n := sim.Requests(reqGetStatus); n < 3 {
+		t.Errorf("DFU_GETSTATUS sent %d times, want many", n)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package elfsym translates the program counter values to the function names
+// and source positions using the Go line table (pclntab) of the ELF file.
+package elfsym
+
+import (
+	"debug/elf"
+	"debug/gosym"
+	"errors"
+	"fmt"
+)
+
+// Table is the symbol table of the program.
+type Table struct {
+	Machine elf.Machine
+	Class   elf.Class
+
+	tab  *gosym.Table
+	ft   *funcTab      // nil if the pclntab format isn't supported
+	text []elf.Section // executable sections
+}
+
+// Open reads the symbol table from the ELF file.
+func Open(name string) (t *Table, err error) {
+	f, err := elf.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	t = &Table{Machine: f.Machine, Class: f.Class}
+	pcln := f.Section(".gopclntab")
+	if pcln == nil {
+		return nil, fmt.Errorf("%s: no .gopclntab section", name)
+	}
+	data, err := pcln.Data()
+	if err != nil {
+		return
+	}
+	var textStart uint64
+	for _, s := range f.Sections {
+		if s.Type == elf.SHT_PROGBITS && s.Flags&elf.SHF_EXECINSTR != 0 {
+			t.text = append(t.text, *s)
+			if s.Name == ".text" {
+				textStart = s.Addr
+			}
+		}
+	}
+	if syms, err := f.Symbols(); err == nil {
+		for _, s := range syms {
+			if s.Name == "runtime.text" {
+				textStart = s.Value
+				break
+			}
+		}
+	}
+	if len(t.text) == 0 {
+		return nil, errors.New(name + ": no executable sections")
+	}
+	t.tab, err = gosym.NewTable(nil, gosym.NewLineTable(data, textStart))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	t.ft, _ = newFuncTab(data, f.ByteOrder, textStart)
+	return t, nil
+}
+
+// PtrSize returns the size of the pointer in bytes.
+func (t *Table) PtrSize() int {
+	if t.Class == elf.ELFCLASS64 {
+		return 8
+	}
+	return 4
+}
+
+// InText reports whether addr lies in the executable sections.
+func (t *Table) InText(addr uint64) bool {
+	for i := range t.text {
+		s := &t.text[i]
+		if addr >= s.Addr && addr-s.Addr < s.Size {
+			return true
+		}
+	}
+	return false
+}
+
+// Pos describes the source position of the program counter.
+type Pos struct {
+	Func  string
+	File  string
+	Line  int
+	Entry uint64 // function entry address
+}
+
+func (p Pos) String() string {
+	if p.File == "" {
+		return p.Func
+	}
+	return fmt.Sprintf("%s %s:%d", p.Func, p.File, p.Line)
+}
+
+// Lookup returns the source position of pc. The Thumb bit of the Arm code
+// addresses is ignored.
+func (t *Table) Lookup(pc uint64) (pos Pos, ok bool) {
+	if t.Machine == elf.EM_ARM {
+		pc &^= 1
+	}
+	if !t.InText(pc) {
+		return
+	}
+	fn := t.tab.PCToFunc(pc)
+	if fn == nil {
+		return
+	}
+	pos.Func, pos.Entry = fn.Name, fn.Entry
+	pos.File, pos.Line, _ = t.tab.PCToLine(pc)
+	return pos, true
+}
+
+// SPDelta returns the size of the stack frame allocated by the function at pc
+// (the difference between the stack pointer at the function entry and at pc).
+// It reports false if pc doesn't belong to any Go function or the pclntab
+// format isn't supported.
+func (t *Table) SPDelta(pc uint64) (int, bool) {
+	if t.Machine == elf.EM_ARM {
+		pc &^= 1
+	}
+	if t.ft == nil || !t.InText(pc) {
+		return 0, false
+	}
+	return t.ft.spdelta(pc)
+}
@@ -0,0 +1,186 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package elfsym
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const prog = `package main
+
+import "os"
+
+//go:noinline
+func h(x int) int {
+	return x * 3
+}
+
+//go:noinline
+func g(x int) int {
+	return h(x+1) + h(x+2)
+}
+
+func main() {
+	os.Exit(g(len(os.Args)))
+}
+`
+
+// build builds prog for linux/goarch and returns the path of the ELF file.
+func build(t *testing.T, goarch string) string {
+	t.Helper()
+	gocmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go command not found")
+	}
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":  "module test\n\ngo 1.22\n",
+		"main.go": prog,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command(gocmd, "build", "-o", "test.elf")
+	cmd.Dir = dir
+	cmd.Env = append(
+		os.Environ(), "GOOS=linux", "GOARCH="+goarch, "CGO_ENABLED=0",
+		"GOTOOLCHAIN=local", "GOFLAGS=",
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	return filepath.Join(dir, "test.elf")
+}
+
+// funcSym returns the ELF symbol of the function.
+func funcSym(t *testing.T, f *elf.File, name string) elf.Symbol {
+	t.Helper()
+	syms, err := f.Symbols()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range syms {
+		if s.Name == name {
+			return s
+		}
+	}
+	t.Fatalf("no %s symbol", name)
+	return elf.Symbol{}
+}
+
+func TestLookup(t *testing.T) {
+	for _, goarch := range []string{"arm", "riscv64"} {
+		t.Run(goarch, func(t *testing.T) {
+			name := build(t, goarch)
+			tab, err := Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := elf.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			if tab.Machine != f.Machine || tab.PtrSize()*8 != map[string]int{"arm": 32, "riscv64": 64}[goarch] {
+				t.Fatalf("Machine=%v PtrSize=%d", tab.Machine, tab.PtrSize())
+			}
+			for _, fn := range []struct {
+				name string
+				line int // line of the first instruction
+			}{
+				{"main.h", 7},
+				{"main.g", 11},
+			} {
+				s := funcSym(t, f, fn.name)
+				for _, pc := range []uint64{s.Value, s.Value + s.Size - 1} {
+					pos, ok := tab.Lookup(pc)
+					if !ok || pos.Func != fn.name || pos.Entry != s.Value ||
+						!strings.HasSuffix(pos.File, "main.go") {
+						t.Errorf("Lookup(%#x): %+v, %t", pc, pos, ok)
+					}
+				}
+				if pos, _ := tab.Lookup(s.Value); pos.Line != fn.line {
+					t.Errorf("%s: line %d, want %d", fn.name, pos.Line, fn.line)
+				}
+			}
+			if pos, ok := tab.Lookup(0); ok {
+				t.Errorf("Lookup(0): %+v", pos)
+			}
+		})
+	}
+}
+
+func TestSPDelta(t *testing.T) {
+	for _, goarch := range []string{"arm", "riscv64"} {
+		t.Run(goarch, func(t *testing.T) {
+			name := build(t, goarch)
+			tab, err := Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := elf.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			// The leaf function doesn't allocate any stack frame.
+			h := funcSym(t, f, "main.h")
+			for pc := h.Value; pc < h.Value+h.Size; pc += 4 {
+				if delta, ok := tab.SPDelta(pc); !ok || delta != 0 {
+					t.Errorf("main.h: SPDelta(%#x) = %d, %t", pc, delta, ok)
+				}
+			}
+
+			g := funcSym(t, f, "main.g")
+			if delta, ok := tab.SPDelta(g.Value); !ok || delta != 0 {
+				t.Errorf("main.g: SPDelta(entry) = %d, %t", delta, ok)
+			}
+			maxDelta := 0
+			for pc := g.Value; pc < g.Value+g.Size; pc += 4 {
+				delta, ok := tab.SPDelta(pc)
+				if !ok || delta < 0 || delta%tab.PtrSize() != 0 {
+					t.Fatalf("main.g: SPDelta(%#x) = %d, %t", pc, delta, ok)
+				}
+				maxDelta = max(maxDelta, delta)
+			}
+			if goarch == "arm" {
+				// Check the frame size against the prologue instruction
+				// MOVW.W R14, -size(R13).
+				text := f.Section(".text")
+				code := make([]byte, g.Size)
+				if _, err := text.ReadAt(code, int64(g.Value-text.Addr)); err != nil {
+					t.Fatal(err)
+				}
+				i := 0
+				for i+4 <= len(code) && binary.LittleEndian.Uint32(code[i:])&0xfffff000 != 0xe52de000 {
+					i += 4
+				}
+				if i+4 > len(code) {
+					t.Fatal("main.g: no MOVW.W R14, -size(R13) in the prologue")
+				}
+				size := int(binary.LittleEndian.Uint32(code[i:]) & 0xfff)
+				pc := g.Value + uint64(i) + 4
+				if delta, _ := tab.SPDelta(pc); delta != size || maxDelta != size {
+					t.Errorf("main.g: SPDelta(%#x) = %d (max %d), want %d", pc, delta, maxDelta, size)
+				}
+			}
+			if maxDelta == 0 {
+				t.Error("main.g: no stack frame")
+			}
+			if _, ok := tab.SPDelta(0); ok {
+				t.Error("SPDelta(0) succeeded")
+			}
+		})
+	}
+}
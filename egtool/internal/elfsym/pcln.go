@@ -0,0 +1,126 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package elfsym
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// funcTab gives access to the per function data of the Go 1.18+ pclntab that
+// isn't available through the debug/gosym package (the pcsp table).
+type funcTab struct {
+	bo        binary.ByteOrder
+	quantum   uint64
+	nfunc     int
+	textStart uint64
+	funcdata  []byte // function table followed by the _func structures
+	pctab     []byte
+}
+
+var errPclntab = errors.New("unsupported .gopclntab format")
+
+func newFuncTab(data []byte, bo binary.ByteOrder, textStart uint64) (*funcTab, error) {
+	if len(data) < 8 {
+		return nil, errPclntab
+	}
+	switch bo.Uint32(data) {
+	case 0xfffffff0, 0xfffffff1: // Go 1.18, Go 1.20
+	default:
+		return nil, errPclntab
+	}
+	ptrSize := int(data[7])
+	if ptrSize != 4 && ptrSize != 8 || len(data) < 8+8*ptrSize {
+		return nil, errPclntab
+	}
+	word := func(i int) uint64 {
+		b := data[8+i*ptrSize:]
+		if ptrSize == 4 {
+			return uint64(bo.Uint32(b))
+		}
+		return bo.Uint64(b)
+	}
+	ft := &funcTab{
+		bo:        bo,
+		quantum:   uint64(data[6]),
+		nfunc:     int(word(0)),
+		textStart: textStart,
+	}
+	pctabOff, pclnOff := word(6), word(7)
+	if pctabOff > uint64(len(data)) || pclnOff > uint64(len(data)) ||
+		uint64(ft.nfunc+1)*8 > uint64(len(data))-pclnOff {
+		return nil, errPclntab
+	}
+	ft.pctab = data[pctabOff:]
+	ft.funcdata = data[pclnOff:]
+	return ft, nil
+}
+
+// find returns the offset of the _func structure of the function that
+// contains pc and its entry address.
+func (ft *funcTab) find(pc uint64) (off int, entry uint64, ok bool) {
+	if pc < ft.textStart {
+		return
+	}
+	entryOff := func(i int) uint64 {
+		return uint64(ft.bo.Uint32(ft.funcdata[i*8:]))
+	}
+	rel := pc - ft.textStart
+	i := sort.Search(ft.nfunc, func(i int) bool { return entryOff(i) > rel }) - 1
+	if i < 0 || rel >= entryOff(i+1) {
+		return
+	}
+	off = int(ft.bo.Uint32(ft.funcdata[i*8+4:]))
+	if off+20 > len(ft.funcdata) {
+		return
+	}
+	return off, ft.textStart + entryOff(i), true
+}
+
+// pcvalue decodes the pc-value table at off and returns the value at pc.
+func (ft *funcTab) pcvalue(off uint32, entry, pc uint64) (int, bool) {
+	if off == 0 || int(off) >= len(ft.pctab) {
+		return 0, false
+	}
+	p := ft.pctab[off:]
+	val := int64(-1)
+	cur := entry
+	for first := true; ; first = false {
+		uvdelta, n := binary.Uvarint(p)
+		if n <= 0 || uvdelta == 0 && !first {
+			return 0, false
+		}
+		p = p[n:]
+		if uvdelta&1 != 0 {
+			val += -int64(uvdelta>>1) - 1
+		} else {
+			val += int64(uvdelta >> 1)
+		}
+		pcdelta, n := binary.Uvarint(p)
+		if n <= 0 {
+			return 0, false
+		}
+		p = p[n:]
+		cur += pcdelta * ft.quantum
+		if pc < cur {
+			return int(val), true
+		}
+	}
+}
+
+// spdelta returns the size of the stack frame allocated by the function at
+// pc.
+func (ft *funcTab) spdelta(pc uint64) (int, bool) {
+	off, entry, ok := ft.find(pc)
+	if !ok {
+		return 0, false
+	}
+	pcsp := ft.bo.Uint32(ft.funcdata[off+16:])
+	if pcsp == 0 {
+		return 0, true // no frame
+	}
+	return ft.pcvalue(pcsp, entry, pc)
+}
@@ -0,0 +1,198 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package flashalgo implements programming of the internal/external flash
+// memory of the supported microcontrollers using a debug probe. The STM32 and
+// nRF52 flash controllers are driven directly by the memory accesses of the
+// probe. The RP2350 external flash is programmed by calling the boot ROM
+// functions on the halted core.
+package flashalgo
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/cortexm"
+)
+
+// Algo programs the flash memory of a concrete microcontroller. The core must
+// be halted.
+type Algo interface {
+	// Name returns the name of the target microcontroller.
+	Name() string
+
+	// Flash returns the address and the size of the flash memory.
+	Flash() (base uint32, size int)
+
+	// SectorAlign returns the start address and the end address of the
+	// range of the flash sectors that overlap [addr, addr+size).
+	SectorAlign(addr uint32, size int) (start, end uint32)
+
+	// Erase erases all flash sectors that overlap [addr, addr+size).
+	Erase(addr uint32, size int) error
+
+	// Program programs data at addr. The range must be erased. The addr
+	// must be aligned to the programming unit of the flash (at most 32
+	// bytes), the data is padded with 0xff if required.
+	Program(addr uint32, data []byte) error
+
+	// Done finishes the programming (locks the flash controller, restores
+	// the memory mapped access to the flash).
+	Done() error
+}
+
+type Error struct {
+	Op  string
+	Err error
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Error() string {
+	return "flashalgo: " + e.Op + ": " + e.Err.Error()
+}
+
+func wrapErr(op string, err *error) {
+	if *err != nil {
+		*err = &Error{op, *err}
+	}
+}
+
+var algos = map[string]func(core *cortexm.Core) (Algo, error){
+	"stm32f4": newSTM32F4,
+	"stm32h7": newSTM32H7,
+	"stm32l4": newSTM32L4,
+	"nrf52":   newNRF52,
+	"rp2350":  newRP2350,
+}
+
+// Names returns the names of the supported targets.
+func Names() []string {
+	names := make([]string, 0, len(algos))
+	for name := range algos {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Open returns the flash algorithm for the named target (see Names).
+func Open(name string, core *cortexm.Core) (a Algo, err error) {
+	defer wrapErr("Open", &err)
+	newAlgo := algos[strings.ToLower(name)]
+	if newAlgo == nil {
+		return nil, fmt.Errorf("unknown target: %s", name)
+	}
+	return newAlgo(core)
+}
+
+// Cortex-M part numbers (CPUID[15:4])
+const (
+	partM4  = 0xc24
+	partM7  = 0xc27
+	partM33 = 0xd21
+)
+
+// Detect determines the target by reading its identification registers and
+// returns the corresponding flash algorithm.
+func Detect(core *cortexm.Core) (a Algo, err error) {
+	defer wrapErr("Detect", &err)
+	cpuid, err := cortexm.Read32(core, cortexm.CPUID)
+	if err != nil {
+		return
+	}
+	part := cpuid >> 4 & 0xfff
+	switch part {
+	case partM33:
+		if id, err := cortexm.Read32(core, rp2350ChipID); err == nil &&
+			id>>12&0xffff == rp2350Part {
+			return newRP2350(core)
+		}
+	case partM7:
+		if id, err := cortexm.Read32(core, h7DBGMCU); err == nil &&
+			h7Dev(id&0xfff) != nil {
+			return newSTM32H7(core)
+		}
+	case partM4:
+		if p, err := cortexm.Read32(core, nrfInfoPart); err == nil &&
+			p>>12 == 0x52 {
+			return newNRF52(core)
+		}
+		if id, err := cortexm.Read32(core, stm32DBGMCU); err == nil {
+			switch {
+			case slices.Contains(f4DevIDs, id&0xfff):
+				return newSTM32F4(core)
+			case l4Dev(id&0xfff) != nil:
+				return newSTM32L4(core)
+			}
+		}
+	}
+	return nil, fmt.Errorf("unsupported target (CPUID=%#08x)", cpuid)
+}
+
+// waitClear waits for the bits of the register at addr selected by mask to be
+// cleared. It returns the last read value.
+func waitClear(m cortexm.Mem, addr, mask uint32, timeout time.Duration) (uint32, error) {
+	for deadline := time.Now().Add(timeout); ; {
+		v, err := cortexm.Read32(m, addr)
+		if err != nil || v&mask == 0 {
+			return v, err
+		}
+		if time.Now().After(deadline) {
+			return v, errors.New("timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// unlock writes the two unlock keys to the key register if the lock bit in
+// the control register is set.
+func unlock(m cortexm.Mem, cr, lock, keyr uint32) error {
+	return unlockKeys(m, cr, lock, keyr, 0x4567_0123, 0xcdef_89ab)
+}
+
+// unlockKeys works like unlock but for the register that uses the different
+// keys (e.g. the option bytes control register).
+func unlockKeys(m cortexm.Mem, cr, lock, keyr, key1, key2 uint32) error {
+	v, err := cortexm.Read32(m, cr)
+	if err != nil || v&lock == 0 {
+		return err
+	}
+	// Separate writes, the block write would auto-increment the address.
+	for _, key := range []uint32{key1, key2} {
+		if err = cortexm.Write32(m, keyr, key); err != nil {
+			return err
+		}
+	}
+	if v, err = cortexm.Read32(m, cr); err == nil && v&lock != 0 {
+		err = errors.New("cannot unlock the flash controller")
+	}
+	return err
+}
+
+// pad pads p with 0xff to the multiple of n bytes.
+func pad(p []byte, n int) []byte {
+	if len(p)%n == 0 {
+		return p
+	}
+	q := make([]byte, (len(p)+n-1)/n*n)
+	copy(q, p)
+	for i := len(p); i < len(q); i++ {
+		q[i] = 0xff
+	}
+	return q
+}
+
+// uniform implements SectorAlign for the flash with uniform sectors.
+func uniform(base uint32, sectSize int, addr uint32, size int) (start, end uint32) {
+	ss := uint32(sectSize)
+	start = base + (addr-base)/ss*ss
+	end = base + (addr-base+uint32(size)+ss-1)/ss*ss
+	return
+}
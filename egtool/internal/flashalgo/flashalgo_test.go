@@ -0,0 +1,173 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flashalgo_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/embeddedgo/tools/egtool/internal/cmsisdap"
+	"github.com/embeddedgo/tools/egtool/internal/cortexm"
+	"github.com/embeddedgo/tools/egtool/internal/flashalgo"
+	"github.com/embeddedgo/tools/egtool/internal/swd"
+	"github.com/embeddedgo/tools/egtool/internal/usbdev"
+	"github.com/embeddedgo/tools/egtool/internal/usbsim"
+)
+
+// connect connects to the target using the simulated CMSIS-DAP probe. It
+// doesn't halt the core.
+func connect(t *testing.T, target usbsim.Target) (*swd.DP, *cortexm.Core) {
+	t.Helper()
+	sim := usbsim.NewDAP(target)
+	usbdev.Attach(sim)
+	t.Cleanup(func() { usbdev.Detach(sim) })
+	probe, err := cmsisdap.Connect("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { probe.Close() })
+	if err = probe.ConnectSWD(4e6); err != nil {
+		t.Fatal(err)
+	}
+	dp, err := swd.Connect(probe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem, err := dp.MemAP(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dp, &cortexm.Core{Mem: mem}
+}
+
+// program erases the flash at addr and programs data using the detected
+// algorithm.
+func program(t *testing.T, core *cortexm.Core, name string, addr uint32, data []byte) {
+	t.Helper()
+	if err := core.ResetHalt(); err != nil {
+		t.Fatal(err)
+	}
+	a, err := flashalgo.Detect(core)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Name() != name {
+		t.Fatalf("detected %s, want %s", a.Name(), name)
+	}
+	if err = a.Erase(addr, len(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Program(addr, data); err != nil {
+		t.Fatal(err)
+	}
+	if err = a.Done(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func pattern(n int) []byte {
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = byte(i*13 + 5)
+	}
+	return p
+}
+
+func TestNRF52(t *testing.T) {
+	target := usbsim.NewNRF52(0x52840, 256)
+	dp, core := connect(t, target)
+	if err := flashalgo.CheckNRF52(dp); err != nil {
+		t.Fatal(err)
+	}
+	data := pattern(10000)
+	program(t, core, "nRF52840", 0x1000, data)
+	if !bytes.Equal(target.Flash.Bytes(0x1000, len(data)), data) {
+		t.Error("bad flash content")
+	}
+	if target.Flash.Erased != 3 {
+		t.Errorf("erased %d pages, want 3", target.Flash.Erased)
+	}
+	if target.Flash.Written != len(data) {
+		t.Errorf("written %d bytes, want %d", target.Flash.Written, len(data))
+	}
+}
+
+func TestNRF52Protected(t *testing.T) {
+	target := usbsim.NewNRF52(0x52840, 256)
+	target.Flash.Program(0, []byte{1, 2, 3, 4})
+	target.APProtect = true
+	dp, core := connect(t, target)
+	err := flashalgo.CheckNRF52(dp)
+	var pe *flashalgo.ProtectedError
+	if !errors.As(err, &pe) || pe.Permanent {
+		t.Fatalf("got %v, want the non-permanent ProtectedError", err)
+	}
+	if err = flashalgo.NRF52EraseAll(dp); err != nil {
+		t.Fatal(err)
+	}
+	if err = flashalgo.CheckNRF52(dp); err != nil {
+		t.Fatal(err)
+	}
+	if b := target.Flash.Bytes(0, 4); !bytes.Equal(b, []byte{0xff, 0xff, 0xff, 0xff}) {
+		t.Errorf("flash not erased: % x", b)
+	}
+	program(t, core, "nRF52840", 0, pattern(100))
+}
+
+func TestSTM32F4(t *testing.T) {
+	target := usbsim.NewSTM32F4(0x413, 1024)
+	dp, core := connect(t, target)
+	if err := flashalgo.CheckNRF52(dp); err != nil {
+		t.Fatal(err) // no CTRL-AP
+	}
+	data := pattern(20000)
+	program(t, core, "STM32F4", 0x0800_4000, data)
+	if !bytes.Equal(target.Flash.Bytes(0x0800_4000, len(data)), data) {
+		t.Error("bad flash content")
+	}
+	if target.Flash.Erased != 2 {
+		t.Errorf("erased %d sectors, want 2", target.Flash.Erased)
+	}
+}
+
+func TestSTM32F4Protected(t *testing.T) {
+	for _, tc := range []struct {
+		rdp       uint8
+		permanent bool
+	}{
+		{0xbb, false},
+		{0xcc, true},
+	} {
+		t.Run(fmt.Sprintf("RDP=%#x", tc.rdp), func(t *testing.T) {
+			target := usbsim.NewSTM32F4(0x413, 1024)
+			target.Flash.Program(0x0800_0000, []byte{1, 2, 3, 4})
+			target.RDP = tc.rdp
+			_, core := connect(t, target)
+			if err := core.ResetHalt(); err != nil {
+				t.Fatal(err)
+			}
+			_, err := flashalgo.Detect(core)
+			var pe *flashalgo.ProtectedError
+			if !errors.As(err, &pe) || pe.Permanent != tc.permanent {
+				t.Fatalf("got %v, want ProtectedError{Permanent: %t}", err, tc.permanent)
+			}
+			if tc.permanent {
+				return
+			}
+			if err = flashalgo.Unprotect(core); err != nil {
+				t.Fatal(err)
+			}
+			if target.RDP != 0xaa {
+				t.Fatalf("RDP=%#x after Unprotect", target.RDP)
+			}
+			if b := target.Flash.Bytes(0x0800_0000, 4); !bytes.Equal(b, []byte{0xff, 0xff, 0xff, 0xff}) {
+				t.Errorf("flash not erased: % x", b)
+			}
+			program(t, core, "STM32F4", 0x0800_0000, pattern(100))
+		})
+	}
+}
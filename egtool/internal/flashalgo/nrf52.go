@@ -0,0 +1,116 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flashalgo
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/cortexm"
+)
+
+// nRF52 FICR and NVMC registers
+const (
+	nrfCodePageSize = 0x1000_0010
+	nrfCodeSize     = 0x1000_0014
+	nrfInfoPart     = 0x1000_0100
+
+	nrfREADY     = 0x4001_e400
+	nrfCONFIG    = 0x4001_e504
+	nrfERASEPAGE = 0x4001_e508
+
+	nrfConfigRen = 0
+	nrfConfigWen = 1
+	nrfConfigEen = 2
+)
+
+type nrf52 struct {
+	core     *cortexm.Core
+	part     uint32
+	pageSize int
+	size     int
+}
+
+func newNRF52(core *cortexm.Core) (Algo, error) {
+	var ficr [2]uint32
+	if err := core.ReadMem32(nrfCodePageSize, ficr[:]); err != nil {
+		return nil, err
+	}
+	part, err := cortexm.Read32(core, nrfInfoPart)
+	if err != nil {
+		return nil, err
+	}
+	a := &nrf52{core, part, int(ficr[0]), int(ficr[0] * ficr[1])}
+	if a.pageSize == 0 || a.pageSize&(a.pageSize-1) != 0 || a.size == 0 {
+		return nil, errors.New("nrf52: cannot read the flash size")
+	}
+	return a, nil
+}
+
+func (a *nrf52) Name() string {
+	if a.part>>12 == 0x52 {
+		return fmt.Sprintf("nRF%x", a.part)
+	}
+	return "nRF52"
+}
+
+func (a *nrf52) Flash() (uint32, int) {
+	return 0, a.size
+}
+
+func (a *nrf52) SectorAlign(addr uint32, size int) (start, end uint32) {
+	return uniform(0, a.pageSize, addr, size)
+}
+
+// ready waits for the NVMC to be ready.
+func (a *nrf52) ready(timeout time.Duration) error {
+	for deadline := time.Now().Add(timeout); ; {
+		r, err := cortexm.Read32(a.core, nrfREADY)
+		if err != nil || r&1 != 0 {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return errors.New("NVMC timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (a *nrf52) Erase(addr uint32, size int) (err error) {
+	defer wrapErr("nrf52: Erase", &err)
+	start, end := a.SectorAlign(addr, size)
+	if err = cortexm.Write32(a.core, nrfCONFIG, nrfConfigEen); err != nil {
+		return
+	}
+	for p := start; p < end; p += uint32(a.pageSize) {
+		if err = cortexm.Write32(a.core, nrfERASEPAGE, p); err != nil {
+			return
+		}
+		if err = a.ready(time.Second); err != nil {
+			return
+		}
+	}
+	return cortexm.Write32(a.core, nrfCONFIG, nrfConfigRen)
+}
+
+func (a *nrf52) Program(addr uint32, data []byte) (err error) {
+	defer wrapErr("nrf52: Program", &err)
+	if err = cortexm.Write32(a.core, nrfCONFIG, nrfConfigWen); err != nil {
+		return
+	}
+	// The bus is stalled while the word is being written.
+	if err = cortexm.WriteBytes(a.core, addr, data, 0xff); err != nil {
+		return
+	}
+	if err = a.ready(time.Second); err != nil {
+		return
+	}
+	return cortexm.Write32(a.core, nrfCONFIG, nrfConfigRen)
+}
+
+func (a *nrf52) Done() error {
+	return nil
+}
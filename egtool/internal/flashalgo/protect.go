@@ -0,0 +1,176 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flashalgo
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/cortexm"
+)
+
+// ProtectedError is returned if the flash of the target is read-out protected
+// (STM32 RDP level 1 or 2, nRF52 APPROTECT).
+type ProtectedError struct {
+	Target string
+	State  string // protection state, e.g. "RDP level 1"
+
+	// Permanent means the protection cannot be removed (STM32 RDP level 2).
+	// Otherwise it can be removed at the cost of erasing the whole flash
+	// (see Unprotect, NRF52EraseAll).
+	Permanent bool
+}
+
+func (e *ProtectedError) Error() string {
+	s := e.Target + ": the flash is read-out protected (" + e.State + ")"
+	if e.Permanent {
+		s += ", the debug access is permanently disabled"
+	}
+	return s
+}
+
+// ErrorCode returns the error code used by the JSON error events.
+func (e *ProtectedError) ErrorCode() string {
+	return "READ_PROTECTED"
+}
+
+// STM32 option bytes
+const (
+	f4OPTKEYR = 0x4002_3c08
+	f4OPTCR   = 0x4002_3c14
+	l4OPTR    = 0x4002_2020
+	h7OPTSR   = 0x5200_201c // FLASH_OPTSR_CUR
+
+	f4OPTCRLOCK = 1 << 0
+	f4OPTCRSTRT = 1 << 1
+
+	rdpLevel0 = 0xaa
+	rdpLevel2 = 0xcc
+)
+
+// checkRDP returns *ProtectedError if the RDP option byte read from the
+// register at addr (the byte at the bit position shift) isn't level 0.
+func checkRDP(m cortexm.Mem, target string, addr uint32, shift uint) error {
+	v, err := cortexm.Read32(m, addr)
+	if err != nil {
+		return err
+	}
+	switch rdp := v >> shift & 0xff; rdp {
+	case rdpLevel0:
+		return nil
+	case rdpLevel2:
+		return &ProtectedError{target, "RDP level 2", true}
+	}
+	return &ProtectedError{target, "RDP level 1", false}
+}
+
+// Unprotect removes the read-out protection of the STM32F4 flash by
+// programming the RDP level 0 option byte. The hardware erases the whole
+// flash before the protection is removed. Some devices require the power
+// cycle before the flash can be accessed again.
+func Unprotect(core *cortexm.Core) (err error) {
+	defer wrapErr("Unprotect", &err)
+	id, err := cortexm.Read32(core, stm32DBGMCU)
+	if err != nil {
+		return
+	}
+	if !slices.Contains(f4DevIDs, id&0xfff) {
+		return errors.New("removing the read-out protection is supported only for STM32F4")
+	}
+	err = unlockKeys(
+		core, f4OPTCR, f4OPTCRLOCK, f4OPTKEYR, 0x0819_2a3b, 0x4c5d_6e7f,
+	)
+	if err != nil {
+		return
+	}
+	opt, err := cortexm.Read32(core, f4OPTCR)
+	if err != nil {
+		return
+	}
+	opt = opt&^0xff00 | rdpLevel0<<8
+	if err = cortexm.Write32(core, f4OPTCR, opt); err != nil {
+		return
+	}
+	if err = cortexm.Write32(core, f4OPTCR, opt|f4OPTCRSTRT); err != nil {
+		return
+	}
+	// The mass erase of 2 MiB takes up to 32 s.
+	sr, err := waitClear(core, f4SR, f4SRBSY, time.Minute)
+	if err != nil {
+		return
+	}
+	if sr&f4SRErrors != 0 {
+		cortexm.Write32(core, f4SR, f4SRErrors)
+		return fmt.Errorf("flash error (SR=%#08x)", sr)
+	}
+	return cortexm.Write32(core, f4OPTCR, opt|f4OPTCRLOCK)
+}
+
+// APPort provides the access to the AP registers (see swd.DP).
+type APPort interface {
+	ReadAP(ap, reg uint32) (uint32, error)
+	WriteAP(ap, reg, val uint32) error
+}
+
+// nRF52 CTRL-AP (APSEL 1) registers
+const (
+	nrfCtrlAP            = 1 << 24
+	nrfCtrlAPIDR         = 0x0288_0000
+	nrfCtrlReset         = 0x000
+	nrfCtrlEraseAll      = 0x004
+	nrfCtrlEraseAllSt    = 0x008
+	nrfCtrlAPProtectSt   = 0x00c
+	nrfCtrlIDR           = 0x0fc
+	nrfAPProtectDisabled = 1
+)
+
+// CheckNRF52 uses the nRF52 CTRL-AP, accessible even if the access port
+// protection (APPROTECT) disables the AHB-AP, to check the protection state. It
+// returns *ProtectedError if the protection is enabled and nil if it isn't or
+// the target isn't an nRF52.
+func CheckNRF52(ap APPort) (err error) {
+	defer wrapErr("CheckNRF52", &err)
+	idr, err := ap.ReadAP(nrfCtrlAP, nrfCtrlIDR)
+	if err != nil || idr != nrfCtrlAPIDR {
+		return
+	}
+	st, err := ap.ReadAP(nrfCtrlAP, nrfCtrlAPProtectSt)
+	if err != nil || st&nrfAPProtectDisabled != 0 {
+		return
+	}
+	return &ProtectedError{"nrf52", "APPROTECT", false}
+}
+
+// NRF52EraseAll erases the flash, UICR and RAM of the nRF52 using the CTRL-AP
+// and resets the chip, which disables the access port protection.
+func NRF52EraseAll(ap APPort) (err error) {
+	defer wrapErr("NRF52EraseAll", &err)
+	if err = ap.WriteAP(nrfCtrlAP, nrfCtrlEraseAll, 1); err != nil {
+		return
+	}
+	for deadline := time.Now().Add(15 * time.Second); ; {
+		st, err := ap.ReadAP(nrfCtrlAP, nrfCtrlEraseAllSt)
+		if err != nil {
+			return err
+		}
+		if st == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			return errors.New("ERASEALL timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, r := range [...][2]uint32{
+		{nrfCtrlReset, 1}, {nrfCtrlReset, 0}, {nrfCtrlEraseAll, 0},
+	} {
+		if err = ap.WriteAP(nrfCtrlAP, r[0], r[1]); err != nil {
+			return
+		}
+	}
+	return
+}
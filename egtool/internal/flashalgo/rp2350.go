@@ -0,0 +1,131 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flashalgo
+
+import (
+	"errors"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/cortexm"
+)
+
+const (
+	rp2350ChipID = 0x4000_0000 // SYSINFO CHIP_ID
+	rp2350Part   = 0x0004
+
+	rp2350Flash     = 0x1000_0000
+	rp2350FlashSize = 16 << 20 // the XIP window of the CS0 flash
+	rp2350RAM       = 0x2000_0000
+
+	// RAM usage during programming
+	rp2350Bkpt   = rp2350RAM          // BKPT instructions
+	rp2350Buf    = rp2350RAM + 0x1000 // data buffer
+	rp2350BufLen = 64 * 1024
+	rp2350Stack  = 0x2008_0000
+
+	rp2350TableLookup = 0x16   // 16-bit pointer to rom_table_lookup
+	rp2350FuncArmSec  = 0x0004 // RT_FLAG_FUNC_ARM_SEC
+
+	rp2350SectSize = 4096
+)
+
+// rp2350 programs the external QSPI flash of RP2350 using the boot ROM flash
+// functions called on the halted Arm core.
+type rp2350 struct {
+	core  *cortexm.Core
+	funcs map[string]uint32
+}
+
+func newRP2350(core *cortexm.Core) (Algo, error) {
+	a := &rp2350{core: core, funcs: make(map[string]uint32)}
+	// Two BKPT instructions the called functions return to.
+	err := cortexm.Write32(core, rp2350Bkpt, 0xbe00_be00)
+	if err != nil {
+		return nil, err
+	}
+	w, err := cortexm.Read32(core, rp2350TableLookup&^3)
+	if err != nil {
+		return nil, err
+	}
+	lookup := w >> 16 & 0xffff
+	if lookup == 0 {
+		return nil, errors.New("rp2350: cannot find rom_table_lookup")
+	}
+	for _, name := range []string{"IF", "EX", "RE", "RP", "FC", "CX"} {
+		code := uint32(name[0]) | uint32(name[1])<<8
+		fn, err := a.call(lookup, time.Second, code, rp2350FuncArmSec)
+		if err != nil {
+			return nil, err
+		}
+		if fn == 0 {
+			return nil, errors.New("rp2350: cannot find the ROM function " + name)
+		}
+		a.funcs[name] = fn
+	}
+	// Connect the flash and leave the XIP mode.
+	if _, err = a.call(a.funcs["IF"], time.Second); err != nil {
+		return nil, err
+	}
+	if _, err = a.call(a.funcs["EX"], time.Second); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *rp2350) call(fn uint32, timeout time.Duration, args ...uint32) (uint32, error) {
+	return a.core.Call(fn, rp2350Bkpt, rp2350Stack, timeout, args...)
+}
+
+func (a *rp2350) Name() string {
+	return "RP2350"
+}
+
+func (a *rp2350) Flash() (uint32, int) {
+	return rp2350Flash, rp2350FlashSize
+}
+
+func (a *rp2350) SectorAlign(addr uint32, size int) (start, end uint32) {
+	return uniform(rp2350Flash, rp2350SectSize, addr, size)
+}
+
+func (a *rp2350) Erase(addr uint32, size int) (err error) {
+	defer wrapErr("rp2350: Erase", &err)
+	start, end := a.SectorAlign(addr, size)
+	// flash_range_erase(offs, count, block_size, block_cmd)
+	timeout := time.Second + time.Duration(end-start)/rp2350SectSize*time.Second/8
+	_, err = a.call(a.funcs["RE"], timeout, start-rp2350Flash, end-start, 1<<16, 0xd8)
+	return
+}
+
+func (a *rp2350) Program(addr uint32, data []byte) (err error) {
+	defer wrapErr("rp2350: Program", &err)
+	// The ROM function programs the 256-byte flash pages.
+	data = pad(data, 256)
+	for len(data) != 0 {
+		n := min(len(data), rp2350BufLen)
+		if err = cortexm.WriteBytes(a.core, rp2350Buf, data[:n], 0xff); err != nil {
+			return
+		}
+		// flash_range_program(offs, data, count)
+		_, err = a.call(
+			a.funcs["RP"], 5*time.Second, addr-rp2350Flash, rp2350Buf, uint32(n),
+		)
+		if err != nil {
+			return
+		}
+		addr += uint32(n)
+		data = data[n:]
+	}
+	return
+}
+
+func (a *rp2350) Done() (err error) {
+	defer wrapErr("rp2350: Done", &err)
+	if _, err = a.call(a.funcs["FC"], time.Second); err != nil {
+		return
+	}
+	_, err = a.call(a.funcs["CX"], time.Second)
+	return
+}
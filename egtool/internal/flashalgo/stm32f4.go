@@ -0,0 +1,163 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flashalgo
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/cortexm"
+)
+
+const (
+	stm32DBGMCU = 0xe004_2000 // DBGMCU_IDCODE (STM32F4, STM32L4)
+	stm32Flash  = 0x0800_0000
+)
+
+var f4DevIDs = []uint32{
+	0x413, 0x419, 0x421, 0x423, 0x431, 0x433, 0x434, 0x441, 0x458, 0x463,
+}
+
+// STM32F4 flash interface registers and bits
+const (
+	f4FlashSizeReg = 0x1fff_7a22
+
+	f4KEYR = 0x4002_3c04
+	f4SR   = 0x4002_3c0c
+	f4CR   = 0x4002_3c10
+
+	f4SRBSY    = 1 << 16
+	f4SRErrors = 0xf2 // OPERR, WRPERR, PGAERR, PGPERR, PGSERR
+	f4CRPG     = 1 << 0
+	f4CRSER    = 1 << 1
+	f4CRPSIZE  = 2 << 8 // x32
+	f4CRSTRT   = 1 << 16
+	f4CRLOCK   = 1 << 31
+)
+
+type stm32f4 struct {
+	core *cortexm.Core
+	size int
+}
+
+func newSTM32F4(core *cortexm.Core) (Algo, error) {
+	v, err := cortexm.Read32(core, f4FlashSizeReg&^3)
+	if err != nil {
+		return nil, err
+	}
+	a := &stm32f4{core, int(v>>16&0xffff) * 1024}
+	if a.size == 0 {
+		return nil, fmt.Errorf("stm32f4: cannot read the flash size")
+	}
+	if err = checkRDP(core, "stm32f4", f4OPTCR, 8); err != nil {
+		return nil, err
+	}
+	return a, unlock(core, f4CR, f4CRLOCK, f4KEYR)
+}
+
+func (a *stm32f4) Name() string {
+	return "STM32F4"
+}
+
+func (a *stm32f4) Flash() (uint32, int) {
+	return stm32Flash, a.size
+}
+
+// sectors returns the start addresses of all sectors and the end of flash.
+// Every bank (1 MiB) consists of 4 x 16 KiB, 1 x 64 KiB and 7 x 128 KiB
+// sectors.
+func (a *stm32f4) sectors() []uint32 {
+	var ss []uint32
+	addr := uint32(stm32Flash)
+	end := addr + uint32(a.size)
+	for addr < end {
+		bank := addr
+		for addr < end && addr-bank < 1<<20 {
+			ss = append(ss, addr)
+			switch off := addr - bank; {
+			case off < 64*1024:
+				addr += 16 * 1024
+			case off < 128*1024:
+				addr += 64 * 1024
+			default:
+				addr += 128 * 1024
+			}
+		}
+	}
+	return append(ss, end)
+}
+
+func (a *stm32f4) SectorAlign(addr uint32, size int) (start, end uint32) {
+	ss := a.sectors()
+	start, end = ss[0], ss[len(ss)-1]
+	for _, s := range ss {
+		if s <= addr {
+			start = s
+		}
+		if s >= addr+uint32(size) {
+			end = s
+			break
+		}
+	}
+	return
+}
+
+func (a *stm32f4) wait(timeout time.Duration) error {
+	sr, err := waitClear(a.core, f4SR, f4SRBSY, timeout)
+	if err != nil {
+		return err
+	}
+	if sr&f4SRErrors != 0 {
+		cortexm.Write32(a.core, f4SR, f4SRErrors)
+		return fmt.Errorf("flash error (SR=%#08x)", sr)
+	}
+	return nil
+}
+
+func (a *stm32f4) Erase(addr uint32, size int) (err error) {
+	defer wrapErr("stm32f4: Erase", &err)
+	ss := a.sectors()
+	for i, s := range ss[:len(ss)-1] {
+		if ss[i+1] <= addr || s >= addr+uint32(size) {
+			continue
+		}
+		// The sectors of the second bank are numbered from 12 with the
+		// bit 4 of SNB set.
+		snb := uint32(i)
+		if i >= 12 {
+			snb = 0x10 | uint32(i-12)
+		}
+		cr := uint32(f4CRPSIZE | f4CRSER | snb<<3)
+		if err = cortexm.Write32(a.core, f4CR, cr); err != nil {
+			return
+		}
+		if err = cortexm.Write32(a.core, f4CR, cr|f4CRSTRT); err != nil {
+			return
+		}
+		if err = a.wait(5 * time.Second); err != nil {
+			return
+		}
+	}
+	return cortexm.Write32(a.core, f4CR, 0)
+}
+
+func (a *stm32f4) Program(addr uint32, data []byte) (err error) {
+	defer wrapErr("stm32f4: Program", &err)
+	if err = cortexm.Write32(a.core, f4CR, f4CRPSIZE|f4CRPG); err != nil {
+		return
+	}
+	// The bus is stalled during the word programming.
+	if err = cortexm.WriteBytes(a.core, addr, data, 0xff); err != nil {
+		return
+	}
+	if err = a.wait(time.Second); err != nil {
+		return
+	}
+	return cortexm.Write32(a.core, f4CR, 0)
+}
+
+func (a *stm32f4) Done() error {
+	return cortexm.Write32(a.core, f4CR, f4CRLOCK)
+}
@@ -0,0 +1,187 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flashalgo
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/cortexm"
+)
+
+const h7DBGMCU = 0x5c00_1000 // DBGMCU_IDC
+
+// STM32H7 flash interface registers (bank 1, bank 2 at +0x100) and bits
+const (
+	h7KEYR = 0x5200_2004
+	h7CR   = 0x5200_200c
+	h7SR   = 0x5200_2010
+	h7CCR  = 0x5200_2014
+
+	h7SRBSY    = 1 << 0
+	h7SRQW     = 1 << 2
+	h7SRErrors = 0x07ee_0000
+	h7CRLOCK   = 1 << 0
+	h7CRPG     = 1 << 1
+	h7CRSER    = 1 << 2
+)
+
+// h7Device describes the flash organization of an STM32H7 line.
+type h7Device struct {
+	sizeReg   uint32 // address of the flash size register
+	sectSize  int
+	wordSize  int    // flash word (programming unit) size
+	bankSize  int    // maximum bank size
+	start     uint32 // CR_START bit
+	snbShift  uint   // position of the SNB field in CR
+	crDefault uint32 // PSIZE field (x64) if exists
+}
+
+// h7Dev returns the device description for the DBGMCU_IDC device ID.
+func h7Dev(devID uint32) *h7Device {
+	switch devID {
+	case 0x450: // H742, H743/753, H750
+		return &h7Device{0x1ff1_e880, 128 * 1024, 32, 1 << 20, 1 << 7, 8, 3 << 4}
+	case 0x483: // H723/733, H725/735, H730
+		return &h7Device{0x1ff1_e880, 128 * 1024, 32, 1 << 20, 1 << 7, 8, 3 << 4}
+	case 0x480: // H7A3/7B3, H7B0
+		return &h7Device{0x08ff_f80c, 8 * 1024, 16, 1 << 20, 1 << 5, 6, 0}
+	}
+	return nil
+}
+
+type stm32h7 struct {
+	core *cortexm.Core
+	dev  *h7Device
+	size int
+}
+
+func newSTM32H7(core *cortexm.Core) (Algo, error) {
+	id, err := cortexm.Read32(core, h7DBGMCU)
+	if err != nil {
+		return nil, err
+	}
+	dev := h7Dev(id & 0xfff)
+	if dev == nil {
+		return nil, fmt.Errorf("stm32h7: unknown device ID: %#x", id&0xfff)
+	}
+	v, err := cortexm.Read32(core, dev.sizeReg)
+	if err != nil {
+		return nil, err
+	}
+	a := &stm32h7{core, dev, int(v&0xffff) * 1024}
+	if a.size == 0 {
+		return nil, fmt.Errorf("stm32h7: cannot read the flash size")
+	}
+	if err = checkRDP(core, "stm32h7", h7OPTSR, 8); err != nil {
+		return nil, err
+	}
+	for bank := 0; bank*dev.bankSize < a.size; bank++ {
+		b := uint32(bank) * 0x100
+		if err = unlock(core, h7CR+b, h7CRLOCK, h7KEYR+b); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func (a *stm32h7) Name() string {
+	return "STM32H7"
+}
+
+func (a *stm32h7) Flash() (uint32, int) {
+	return stm32Flash, a.size
+}
+
+func (a *stm32h7) SectorAlign(addr uint32, size int) (start, end uint32) {
+	return uniform(stm32Flash, a.dev.sectSize, addr, size)
+}
+
+// bank returns the offset of the bank registers and the address offset in the
+// bank.
+func (a *stm32h7) bank(addr uint32) (regs, off uint32) {
+	off = addr - stm32Flash
+	bs := uint32(min(a.size, a.dev.bankSize))
+	if off >= bs {
+		return 0x100, off - bs
+	}
+	return 0, off
+}
+
+func (a *stm32h7) wait(regs uint32, timeout time.Duration) error {
+	sr, err := waitClear(a.core, h7SR+regs, h7SRBSY|h7SRQW, timeout)
+	if err != nil {
+		return err
+	}
+	if sr&h7SRErrors != 0 {
+		cortexm.Write32(a.core, h7CCR+regs, h7SRErrors)
+		return fmt.Errorf("flash error (SR=%#08x)", sr)
+	}
+	return nil
+}
+
+func (a *stm32h7) Erase(addr uint32, size int) (err error) {
+	defer wrapErr("stm32h7: Erase", &err)
+	start, end := a.SectorAlign(addr, size)
+	ss := uint32(a.dev.sectSize)
+	for s := start; s < end; s += ss {
+		regs, off := a.bank(s)
+		cr := a.dev.crDefault | h7CRSER | off/ss<<a.dev.snbShift
+		if err = cortexm.Write32(a.core, h7CR+regs, cr); err != nil {
+			return
+		}
+		if err = cortexm.Write32(a.core, h7CR+regs, cr|a.dev.start); err != nil {
+			return
+		}
+		if err = a.wait(regs, 5*time.Second); err != nil {
+			return
+		}
+		if err = cortexm.Write32(a.core, h7CR+regs, a.dev.crDefault); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (a *stm32h7) Program(addr uint32, data []byte) (err error) {
+	defer wrapErr("stm32h7: Program", &err)
+	data = pad(data, a.dev.wordSize)
+	// Program the data separately in every bank.
+	for len(data) != 0 {
+		regs, off := a.bank(addr)
+		n := len(data)
+		if bs := uint32(min(a.size, a.dev.bankSize)); regs == 0 && off+uint32(n) > bs {
+			n = int(bs - off)
+		}
+		cr := a.dev.crDefault | h7CRPG
+		if err = cortexm.Write32(a.core, h7CR+regs, cr); err != nil {
+			return
+		}
+		// The flash word is programmed when the write buffer is full. The
+		// bus is stalled while the previous one is being programmed.
+		if err = cortexm.WriteBytes(a.core, addr, data[:n], 0xff); err != nil {
+			return
+		}
+		if err = a.wait(regs, time.Second); err != nil {
+			return
+		}
+		if err = cortexm.Write32(a.core, h7CR+regs, a.dev.crDefault); err != nil {
+			return
+		}
+		addr += uint32(n)
+		data = data[n:]
+	}
+	return
+}
+
+func (a *stm32h7) Done() error {
+	for bank := 0; bank*a.dev.bankSize < a.size; bank++ {
+		b := uint32(bank) * 0x100
+		if err := cortexm.Write32(a.core, h7CR+b, h7CRLOCK); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package flashalgo
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/cortexm"
+)
+
+// STM32L4 flash interface registers and bits
+const (
+	l4FlashSizeReg = 0x1fff_75e0
+
+	l4KEYR = 0x4002_2008
+	l4SR   = 0x4002_2010
+	l4CR   = 0x4002_2014
+
+	l4SRBSY    = 1 << 16
+	l4SRErrors = 0xc3fa // OPERR, PROGERR, WRPERR, PGAERR, SIZERR, PGSERR, MISSERR, FASTERR, RDERR, OPTVERR
+	l4CRPG     = 1 << 0
+	l4CRPER    = 1 << 1
+	l4CRBKER   = 1 << 11
+	l4CRSTRT   = 1 << 16
+	l4CRLOCK   = 1 << 31
+)
+
+// l4Device describes the flash organization of an STM32L4 line.
+type l4Device struct {
+	pageSize int
+	dualBank bool // dual bank if the flash size >= 512 KiB
+}
+
+// l4Dev returns the device description for the DBGMCU_IDCODE device ID.
+func l4Dev(devID uint32) *l4Device {
+	switch devID {
+	case 0x415, 0x461: // L47x/L48x, L49x/L4Ax
+		return &l4Device{2048, true}
+	case 0x435, 0x462, 0x464: // L43x/L44x, L45x/L46x, L41x/L42x
+		return &l4Device{2048, false}
+	case 0x470, 0x471: // L4Rx/L4Sx, L4P5/L4Q5 (dual bank mode)
+		return &l4Device{4096, true}
+	}
+	return nil
+}
+
+type stm32l4 struct {
+	core *cortexm.Core
+	dev  *l4Device
+	size int
+}
+
+func newSTM32L4(core *cortexm.Core) (Algo, error) {
+	id, err := cortexm.Read32(core, stm32DBGMCU)
+	if err != nil {
+		return nil, err
+	}
+	dev := l4Dev(id & 0xfff)
+	if dev == nil {
+		return nil, fmt.Errorf("stm32l4: unknown device ID: %#x", id&0xfff)
+	}
+	v, err := cortexm.Read32(core, l4FlashSizeReg)
+	if err != nil {
+		return nil, err
+	}
+	a := &stm32l4{core, dev, int(v&0xffff) * 1024}
+	if a.size == 0 {
+		return nil, fmt.Errorf("stm32l4: cannot read the flash size")
+	}
+	if err = checkRDP(core, "stm32l4", l4OPTR, 0); err != nil {
+		return nil, err
+	}
+	return a, unlock(core, l4CR, l4CRLOCK, l4KEYR)
+}
+
+func (a *stm32l4) Name() string {
+	return "STM32L4"
+}
+
+func (a *stm32l4) Flash() (uint32, int) {
+	return stm32Flash, a.size
+}
+
+func (a *stm32l4) SectorAlign(addr uint32, size int) (start, end uint32) {
+	return uniform(stm32Flash, a.dev.pageSize, addr, size)
+}
+
+func (a *stm32l4) wait(timeout time.Duration) error {
+	sr, err := waitClear(a.core, l4SR, l4SRBSY, timeout)
+	if err != nil {
+		return err
+	}
+	if sr&l4SRErrors != 0 {
+		cortexm.Write32(a.core, l4SR, l4SRErrors)
+		return fmt.Errorf("flash error (SR=%#08x)", sr)
+	}
+	return nil
+}
+
+func (a *stm32l4) Erase(addr uint32, size int) (err error) {
+	defer wrapErr("stm32l4: Erase", &err)
+	start, end := a.SectorAlign(addr, size)
+	ps := uint32(a.dev.pageSize)
+	bankSize := uint32(a.size)
+	if a.dev.dualBank && a.size >= 512*1024 {
+		bankSize /= 2
+	}
+	for p := start; p < end; p += ps {
+		off := p - stm32Flash
+		cr := uint32(l4CRPER)
+		if off >= bankSize {
+			cr |= l4CRBKER
+			off -= bankSize
+		}
+		cr |= off / ps << 3
+		if err = cortexm.Write32(a.core, l4CR, cr); err != nil {
+			return
+		}
+		if err = cortexm.Write32(a.core, l4CR, cr|l4CRSTRT); err != nil {
+			return
+		}
+		if err = a.wait(time.Second); err != nil {
+			return
+		}
+	}
+	return cortexm.Write32(a.core, l4CR, 0)
+}
+
+func (a *stm32l4) Program(addr uint32, data []byte) (err error) {
+	defer wrapErr("stm32l4: Program", &err)
+	if err = cortexm.Write32(a.core, l4CR, l4CRPG); err != nil {
+		return
+	}
+	// The flash is programmed by double words, the bus is stalled while the
+	// previous one is being programmed.
+	if err = cortexm.WriteBytes(a.core, addr, pad(data, 8), 0xff); err != nil {
+		return
+	}
+	if err = a.wait(time.Second); err != nil {
+		return
+	}
+	return cortexm.Write32(a.core, l4CR, 0)
+}
+
+func (a *stm32l4) Done() error {
+	return cortexm.Write32(a.core, l4CR, l4CRLOCK)
+}
@@ -0,0 +1,32 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gdbrsp
+
+var crcTable [256]uint32
+
+func init() {
+	for i := range crcTable {
+		c := uint32(i) << 24
+		for k := 0; k < 8; k++ {
+			if c&(1<<31) != 0 {
+				c = c<<1 ^ 0x04c1_1db7
+			} else {
+				c <<= 1
+			}
+		}
+		crcTable[i] = c
+	}
+}
+
+// CRC32 returns the CRC-32 of p the way the qCRC request calculates it: the
+// non-reflected 0x04c11db7 polynomial, 0xffffffff initial value and no final
+// XOR.
+func CRC32(p []byte) uint32 {
+	crc := uint32(0xffff_ffff)
+	for _, b := range p {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^b]
+	}
+	return crc
+}
@@ -0,0 +1,443 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package gdbrsp implements the client side of the GDB Remote Serial Protocol
+// good enough to load a program using a GDB server (OpenOCD, pyOCD, QEMU
+// gdbstub, etc.): memory read/write, flash programming, the CRC check and the
+// monitor commands.
+package gdbrsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Error struct {
+	Op  string
+	Err error
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Error() string {
+	return "gdbrsp: " + e.Op + ": " + e.Err.Error()
+}
+
+func wrapErr(op string, err *error) {
+	if *err != nil {
+		*err = &Error{op, *err}
+	}
+}
+
+// ErrUnsupported is returned if the server doesn't support the request (it
+// replied with the empty packet).
+var ErrUnsupported = errors.New("request not supported by the server")
+
+// ErrorReply is the error reply (Enn packet) of the server.
+type ErrorReply struct {
+	Code int
+}
+
+func (e *ErrorReply) Error() string {
+	return fmt.Sprintf("error reply E%02X", e.Code)
+}
+
+// ErrorCode returns the error number with the GDB_E prefix (e.g. GDB_E01).
+func (e *ErrorReply) ErrorCode() string {
+	return fmt.Sprintf("GDB_E%02X", e.Code)
+}
+
+// Timeouts
+var (
+	DialTimeout  = 5 * time.Second
+	ReplyTimeout = 10 * time.Second
+	EraseTimeout = 2 * time.Minute
+)
+
+type Conn struct {
+	c        net.Conn
+	r        *bufio.Reader
+	noAck    bool
+	pktSize  int
+	features map[string]string
+	buf      []byte
+}
+
+// Dial connects to the GDB server at addr (HOST:PORT), negotiates the
+// supported features and queries the halt reason like GDB does.
+func Dial(addr string) (conn *Conn, err error) {
+	defer wrapErr("Dial", &err)
+	c, err := net.DialTimeout("tcp", addr, DialTimeout)
+	if err != nil {
+		return
+	}
+	conn = &Conn{
+		c:        c,
+		r:        bufio.NewReader(c),
+		pktSize:  400,
+		features: make(map[string]string),
+	}
+	defer func() {
+		if err != nil {
+			c.Close()
+			conn = nil
+		}
+	}()
+	rsp, err := conn.request(ReplyTimeout, "qSupported:swbreak+;hwbreak+")
+	if err != nil && err != ErrUnsupported {
+		return
+	}
+	for _, f := range strings.Split(string(rsp), ";") {
+		if name, val, ok := strings.Cut(f, "="); ok {
+			conn.features[name] = val
+		} else if n := len(f); n > 1 {
+			conn.features[f[:n-1]] = f[n-1:]
+		}
+	}
+	if v, ok := conn.features["PacketSize"]; ok {
+		if n, err := strconv.ParseUint(v, 16, 32); err == nil && n >= 64 {
+			conn.pktSize = int(min(n, 64*1024))
+		}
+	}
+	if conn.features["QStartNoAckMode"] == "+" {
+		if rsp, err = conn.request(ReplyTimeout, "QStartNoAckMode"); err != nil {
+			return
+		}
+		conn.noAck = string(rsp) == "OK"
+	}
+	_, err = conn.request(ReplyTimeout, "?")
+	return
+}
+
+func (c *Conn) Close() (err error) {
+	err = c.c.Close()
+	wrapErr("Close", &err)
+	return
+}
+
+// Feature returns the value of the feature reported by the server in the
+// qSupported reply. The boolean features have the "+" or "-" value.
+func (c *Conn) Feature(name string) string {
+	return c.features[name]
+}
+
+// PacketSize returns the maximum size of the packet accepted by the server.
+func (c *Conn) PacketSize() int {
+	return c.pktSize
+}
+
+func checksum(p []byte) uint8 {
+	var cs uint8
+	for _, b := range p {
+		cs += b
+	}
+	return cs
+}
+
+// send sends the packet with the given data (already escaped if needed).
+func (c *Conn) send(data []byte) error {
+	pkt := append(append(c.buf[:0], '$'), data...)
+	pkt = append(pkt, '#')
+	pkt = append(pkt, fmt.Sprintf("%02x", checksum(data))...)
+	c.buf = pkt
+	for retry := 0; ; retry++ {
+		if _, err := c.c.Write(pkt); err != nil {
+			return err
+		}
+		if c.noAck {
+			return nil
+		}
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		switch {
+		case b == '+':
+			return nil
+		case b != '-':
+			c.r.UnreadByte() // the server doesn't send acks
+			c.noAck = true
+			return nil
+		case retry == 3:
+			return errors.New("packet rejected by the server")
+		}
+	}
+}
+
+// receive receives the packet and returns its decoded data. It skips the
+// stray acks before the packet.
+func (c *Conn) receive() ([]byte, error) {
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == '$' {
+			break
+		}
+	}
+	raw, err := c.r.ReadBytes('#')
+	if err != nil {
+		return nil, err
+	}
+	raw = raw[:len(raw)-1]
+	var cs [2]byte
+	if _, err = io.ReadFull(c.r, cs[:]); err != nil {
+		return nil, err
+	}
+	if n, err := strconv.ParseUint(string(cs[:]), 16, 8); err != nil ||
+		uint8(n) != checksum(raw) {
+		if !c.noAck {
+			c.c.Write([]byte{'-'})
+		}
+		return nil, errors.New("bad checksum of the received packet")
+	}
+	if !c.noAck {
+		if _, err = c.c.Write([]byte{'+'}); err != nil {
+			return nil, err
+		}
+	}
+	return decode(raw), nil
+}
+
+// decode removes the escapes and expands the run-length encoding.
+func decode(raw []byte) []byte {
+	data := make([]byte, 0, len(raw))
+	for i := 0; i < len(raw); i++ {
+		switch b := raw[i]; {
+		case b == '}' && i+1 < len(raw):
+			i++
+			data = append(data, raw[i]^0x20)
+		case b == '*' && i+1 < len(raw) && len(data) != 0:
+			i++
+			for n := int(raw[i]) - 29; n > 0; n-- {
+				data = append(data, data[len(data)-1])
+			}
+		default:
+			data = append(data, b)
+		}
+	}
+	return data
+}
+
+// escape appends the escaped p to the buf.
+func escape(buf, p []byte) []byte {
+	for _, b := range p {
+		switch b {
+		case '#', '$', '}', '*':
+			buf = append(buf, '}', b^0x20)
+		default:
+			buf = append(buf, b)
+		}
+	}
+	return buf
+}
+
+// exchange sends the packet and returns the reply. It returns ErrUnsupported
+// for the empty reply and *ErrorReply for the Enn reply.
+func (c *Conn) exchange(timeout time.Duration, pkt []byte) ([]byte, error) {
+	c.c.SetDeadline(time.Now().Add(timeout))
+	if err := c.send(pkt); err != nil {
+		return nil, err
+	}
+	rsp, err := c.receive()
+	if err != nil {
+		return nil, err
+	}
+	return rsp, replyErr(rsp)
+}
+
+func replyErr(rsp []byte) error {
+	if len(rsp) == 0 {
+		return ErrUnsupported
+	}
+	if len(rsp) == 3 && rsp[0] == 'E' {
+		if n, err := strconv.ParseUint(string(rsp[1:]), 16, 8); err == nil {
+			return &ErrorReply{int(n)}
+		}
+	}
+	return nil
+}
+
+func (c *Conn) request(timeout time.Duration, pkt string) ([]byte, error) {
+	return c.exchange(timeout, []byte(pkt))
+}
+
+// ok checks that the reply is OK.
+func ok(rsp []byte, err error) error {
+	if err == nil && string(rsp) != "OK" {
+		err = fmt.Errorf("unexpected reply: %.32q", rsp)
+	}
+	return err
+}
+
+// maxHex returns the maximum number of data bytes that fits in the packet
+// with the header of the given length when the data are sent in hex.
+func (c *Conn) maxHex(hdr int) int {
+	return (c.pktSize - hdr - 4) / 2
+}
+
+// ReadMem reads len(p) bytes of the target memory starting from addr.
+func (c *Conn) ReadMem(addr uint64, p []byte) (err error) {
+	defer wrapErr("ReadMem", &err)
+	for len(p) != 0 {
+		n := min(len(p), c.maxHex(0))
+		var rsp []byte
+		rsp, err = c.request(ReplyTimeout, fmt.Sprintf("m%x,%x", addr, n))
+		if err != nil {
+			return
+		}
+		if len(rsp) > 2*n || len(rsp)%2 != 0 {
+			return fmt.Errorf("bad reply to m%x,%x", addr, n)
+		}
+		var k int
+		if k, err = hex.Decode(p, rsp); err != nil {
+			return
+		}
+		if k == 0 {
+			return fmt.Errorf("cannot read memory at %#x", addr)
+		}
+		addr += uint64(k)
+		p = p[k:]
+	}
+	return
+}
+
+// binSize returns the number of bytes from p that can be sent in the packet
+// with a header of the given length.
+func (c *Conn) binSize(hdr int, p []byte) int {
+	max := c.pktSize - hdr - 4
+	n, size := 0, 0
+	for ; n < len(p); n++ {
+		m := 1
+		switch p[n] {
+		case '#', '$', '}', '*':
+			m = 2
+		}
+		if size+m > max {
+			break
+		}
+		size += m
+	}
+	return n
+}
+
+// WriteMem writes p to the target memory at addr. It uses the binary X packet
+// and falls back to the M packet if the server doesn't support it.
+func (c *Conn) WriteMem(addr uint64, p []byte) (err error) {
+	defer wrapErr("WriteMem", &err)
+	for len(p) != 0 {
+		if c.features["X"] != "-" {
+			hdr := fmt.Sprintf("X%x,%x:", addr, len(p))
+			n := c.binSize(len(hdr), p)
+			hdr = fmt.Sprintf("X%x,%x:", addr, n)
+			pkt := escape([]byte(hdr), p[:n])
+			err = ok(c.exchange(ReplyTimeout, pkt))
+			if err == nil {
+				addr += uint64(n)
+				p = p[n:]
+				continue
+			}
+			if err != ErrUnsupported {
+				return
+			}
+			c.features["X"] = "-"
+		}
+		n := min(len(p), c.maxHex(24))
+		pkt := fmt.Sprintf("M%x,%x:%x", addr, n, p[:n])
+		if err = ok(c.request(ReplyTimeout, pkt)); err != nil {
+			return
+		}
+		addr += uint64(n)
+		p = p[n:]
+	}
+	return
+}
+
+// FlashErase erases the flash blocks in the range [addr, addr+n). The range
+// must be aligned to the block size reported by the memory map.
+func (c *Conn) FlashErase(addr uint64, n int) (err error) {
+	defer wrapErr("FlashErase", &err)
+	return ok(c.request(EraseTimeout, fmt.Sprintf("vFlashErase:%x,%x", addr, n)))
+}
+
+// FlashWrite writes p to the previously erased flash at addr. The server may
+// buffer the data until FlashDone.
+func (c *Conn) FlashWrite(addr uint64, p []byte) (err error) {
+	defer wrapErr("FlashWrite", &err)
+	for len(p) != 0 {
+		hdr := fmt.Sprintf("vFlashWrite:%x:", addr)
+		n := c.binSize(len(hdr), p)
+		if err = ok(c.exchange(ReplyTimeout, escape([]byte(hdr), p[:n]))); err != nil {
+			return
+		}
+		addr += uint64(n)
+		p = p[n:]
+	}
+	return
+}
+
+// FlashDone finishes the flash programming.
+func (c *Conn) FlashDone() (err error) {
+	defer wrapErr("FlashDone", &err)
+	return ok(c.request(EraseTimeout, "vFlashDone"))
+}
+
+// CRC returns the CRC-32 (see CRC32) of n bytes of the target memory
+// starting from addr calculated by the server.
+func (c *Conn) CRC(addr uint64, n int) (crc uint32, err error) {
+	defer wrapErr("CRC", &err)
+	rsp, err := c.request(EraseTimeout, fmt.Sprintf("qCRC:%x,%x", addr, n))
+	if err != nil {
+		return
+	}
+	if len(rsp) < 2 || rsp[0] != 'C' {
+		return 0, fmt.Errorf("unexpected reply: %.32q", rsp)
+	}
+	v, err := strconv.ParseUint(string(rsp[1:]), 16, 32)
+	return uint32(v), err
+}
+
+// Monitor runs the monitor command (qRcmd) and returns its output.
+func (c *Conn) Monitor(cmd string) (out string, err error) {
+	defer wrapErr("Monitor", &err)
+	rsp, err := c.request(EraseTimeout, "qRcmd,"+hex.EncodeToString([]byte(cmd)))
+	var buf bytes.Buffer
+	for err == nil && len(rsp) > 1 && rsp[0] == 'O' && string(rsp) != "OK" {
+		// Console output.
+		var b []byte
+		if b, err = hex.DecodeString(string(rsp[1:])); err != nil {
+			break
+		}
+		buf.Write(b)
+		c.c.SetDeadline(time.Now().Add(EraseTimeout))
+		if rsp, err = c.receive(); err == nil {
+			err = replyErr(rsp)
+		}
+	}
+	if err == nil && string(rsp) != "OK" {
+		// The output can be also returned as the hex-encoded reply.
+		var b []byte
+		if b, err = hex.DecodeString(string(rsp)); err == nil {
+			buf.Write(b)
+		}
+	}
+	return buf.String(), err
+}
+
+// Detach detaches from the target leaving it running.
+func (c *Conn) Detach() (err error) {
+	defer wrapErr("Detach", &err)
+	return ok(c.request(ReplyTimeout, "D"))
+}
@@ -0,0 +1,390 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gdbrsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	fakeFlash     = 0x0800_0000
+	fakeBlockSize = 0x400
+	fakeRAM       = 0x2000_0000
+)
+
+// fakeServer is the in-process GDB server with 64 KiB of flash and 16 KiB of
+// RAM.
+type fakeServer struct {
+	noAckMode bool // supports QStartNoAckMode
+	noAcks    bool // never sends acks (like the QEMU gdbstub in some modes)
+	noX       bool // doesn't support the X packet
+	pktSize   int
+
+	mu      sync.Mutex
+	flash   []byte
+	ram     []byte
+	pending []segment // vFlashWrite data buffered until vFlashDone
+	packets []string  // m, M, X or the packet data up to the first ':'
+	nacked  bool
+}
+
+type segment struct {
+	addr uint64
+	data []byte
+}
+
+func (s *fakeServer) start(t *testing.T) string {
+	t.Helper()
+	s.flash = bytes.Repeat([]byte{0xff}, 64*1024)
+	s.ram = make([]byte, 16*1024)
+	if s.pktSize == 0 {
+		s.pktSize = 0x1000
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		s.serve(c)
+	}()
+	return ln.Addr().String()
+}
+
+func (s *fakeServer) serve(c net.Conn) {
+	r := bufio.NewReader(c)
+	acks := !s.noAcks
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		if b != '$' {
+			continue // acks
+		}
+		raw, err := r.ReadBytes('#')
+		if err != nil {
+			return
+		}
+		raw = raw[:len(raw)-1]
+		var cs [2]byte
+		if _, err = io.ReadFull(r, cs[:]); err != nil {
+			return
+		}
+		if n, _ := strconv.ParseUint(string(cs[:]), 16, 8); uint8(n) != checksum(raw) {
+			c.Write([]byte{'-'})
+			continue
+		}
+		if acks {
+			if !s.nacked {
+				// Reject the first packet to test the retransmission.
+				s.nacked = true
+				c.Write([]byte{'-'})
+				continue
+			}
+			c.Write([]byte{'+'})
+		}
+		for _, rsp := range s.handle(decode(raw)) {
+			data := escape(nil, []byte(rsp))
+			pkt := fmt.Sprintf("$%s#%02x", data, checksum(data))
+			if _, err = c.Write([]byte(pkt)); err != nil {
+				return
+			}
+		}
+		if string(raw) == "QStartNoAckMode" && s.noAckMode {
+			acks = false
+		}
+	}
+}
+
+// mem returns the memory slice at addr or nil.
+func (s *fakeServer) mem(addr uint64, n int) []byte {
+	switch {
+	case addr >= fakeFlash && addr+uint64(n) <= fakeFlash+uint64(len(s.flash)):
+		return s.flash[addr-fakeFlash:][:n]
+	case addr >= fakeRAM && addr+uint64(n) <= fakeRAM+uint64(len(s.ram)):
+		return s.ram[addr-fakeRAM:][:n]
+	}
+	return nil
+}
+
+// handle returns the replies to the packet.
+func (s *fakeServer) handle(pkt []byte) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cmd, args, _ := strings.Cut(string(pkt), ":")
+	addrLen := func(a string) (uint64, int) {
+		as, ls, _ := strings.Cut(a, ",")
+		addr, _ := strconv.ParseUint(as, 16, 64)
+		n, _ := strconv.ParseUint(ls, 16, 32)
+		return addr, int(n)
+	}
+	name := cmd
+	if strings.IndexByte("mMX", cmd[0]) >= 0 {
+		name = cmd[:1]
+	}
+	s.packets = append(s.packets, name)
+	switch {
+	case cmd == "qSupported":
+		f := fmt.Sprintf("PacketSize=%x;qXfer:memory-map:read+", s.pktSize)
+		if s.noAckMode {
+			f += ";QStartNoAckMode+"
+		}
+		return []string{f}
+	case cmd == "QStartNoAckMode":
+		if s.noAckMode {
+			return []string{"OK"}
+		}
+		return []string{""}
+	case cmd == "?":
+		return []string{"S05"}
+	case cmd == "qXfer" && strings.HasPrefix(args, "memory-map:read::"):
+		doc := fmt.Sprintf(`<?xml version="1.0"?>
+<!DOCTYPE memory-map PUBLIC "+//IDN gnu.org//DTD GDB Memory Map V1.0//EN" "http://sourceware.org/gdb/gdb-memory-map.dtd">
+<memory-map>
+<memory type="flash" start="0x%x" length="0x%x"><property name="blocksize">0x%x</property></memory>
+<memory type="ram" start="0x%x" length="0x%x"/>
+</memory-map>`, fakeFlash, len(s.flash), fakeBlockSize, fakeRAM, len(s.ram))
+		off, n := addrLen(strings.TrimPrefix(args, "memory-map:read::"))
+		if off >= uint64(len(doc)) {
+			return []string{"l"}
+		}
+		if end := off + uint64(n); end < uint64(len(doc)) {
+			return []string{"m" + doc[off:end]}
+		}
+		return []string{"l" + doc[off:]}
+	case cmd[0] == 'm':
+		m := s.mem(addrLen(cmd[1:]))
+		if m == nil {
+			return []string{"E14"}
+		}
+		return []string{hex.EncodeToString(m)}
+	case cmd[0] == 'M':
+		m := s.mem(addrLen(cmd[1:]))
+		data, err := hex.DecodeString(args)
+		if m == nil || err != nil || len(data) != len(m) {
+			return []string{"E01"}
+		}
+		copy(m, data)
+		return []string{"OK"}
+	case cmd[0] == 'X':
+		if s.noX {
+			return []string{""}
+		}
+		m := s.mem(addrLen(cmd[1:]))
+		_, data, _ := bytes.Cut(pkt, []byte{':'})
+		if m == nil || len(data) != len(m) {
+			return []string{"E01"}
+		}
+		copy(m, data)
+		return []string{"OK"}
+	case cmd == "vFlashErase":
+		addr, n := addrLen(args)
+		m := s.mem(addr, n)
+		if m == nil || addr%fakeBlockSize != 0 || n%fakeBlockSize != 0 {
+			return []string{"E01"}
+		}
+		for i := range m {
+			m[i] = 0xff
+		}
+		return []string{"OK"}
+	case cmd == "vFlashWrite":
+		a, _, _ := strings.Cut(args, ":")
+		addr, _ := strconv.ParseUint(a, 16, 64)
+		_, data, _ := bytes.Cut(pkt[len("vFlashWrite:"):], []byte{':'})
+		s.pending = append(s.pending, segment{addr, bytes.Clone(data)})
+		return []string{"OK"}
+	case cmd == "vFlashDone":
+		for _, seg := range s.pending {
+			m := s.mem(seg.addr, len(seg.data))
+			if m == nil {
+				return []string{"E02"}
+			}
+			for i, b := range seg.data {
+				m[i] &= b
+			}
+		}
+		s.pending = nil
+		return []string{"OK"}
+	case cmd == "qCRC":
+		m := s.mem(addrLen(args))
+		if m == nil {
+			return []string{"E03"}
+		}
+		return []string{fmt.Sprintf("C%x", CRC32(m))}
+	case strings.HasPrefix(cmd, "qRcmd,"):
+		c, _ := hex.DecodeString(cmd[len("qRcmd,"):])
+		out := hex.EncodeToString([]byte("target halted\n"))
+		return []string{"O" + out, "O" + hex.EncodeToString(c), "OK"}
+	case cmd == "D":
+		return []string{"OK"}
+	}
+	return []string{""}
+}
+
+// read returns the copy of the memory at addr.
+func (s *fakeServer) read(addr uint64, n int) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return bytes.Clone(s.mem(addr, n))
+}
+
+func (s *fakeServer) count(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, p := range s.packets {
+		if p == name {
+			n++
+		}
+	}
+	return n
+}
+
+// testData contains all the characters that must be escaped.
+func testData(n int) []byte {
+	p := make([]byte, n)
+	for i := range p {
+		p[i] = byte(i*7 + i>>8)
+	}
+	return p
+}
+
+func TestCRC32(t *testing.T) {
+	// CRC-32/MPEG-2 check value.
+	if crc := CRC32([]byte("123456789")); crc != 0x0376_e6e7 {
+		t.Errorf("CRC32(\"123456789\") = %#08x, want 0x0376e6e7", crc)
+	}
+	if crc := CRC32(nil); crc != 0xffff_ffff {
+		t.Errorf("CRC32(nil) = %#08x, want 0xffffffff", crc)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		srv   *fakeServer
+		noAck bool
+	}{
+		{"ack", &fakeServer{}, false},
+		{"no-ack mode", &fakeServer{noAckMode: true}, true},
+		{"no acks", &fakeServer{noAcks: true}, true},
+		{"no X", &fakeServer{noX: true, pktSize: 0x100}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := Dial(tc.srv.start(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			if c.PacketSize() != tc.srv.pktSize {
+				t.Errorf("packet size %d, want %d", c.PacketSize(), tc.srv.pktSize)
+			}
+			if c.noAck != tc.noAck {
+				t.Errorf("no-ack mode %t, want %t", c.noAck, tc.noAck)
+			}
+			data := testData(3000)
+			if err = c.WriteMem(fakeRAM+1, data); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, len(data))
+			if err = c.ReadMem(fakeRAM+1, buf); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf, data) {
+				t.Error("read data differ from written")
+			}
+			x, m := tc.srv.count("X"), tc.srv.count("M")
+			if tc.srv.noX {
+				// One rejected X packet, the rest sent using M.
+				if x != 1 || m < len(data)/c.maxHex(24) {
+					t.Errorf("sent %d X and %d M packets", x, m)
+				}
+			} else if x == 0 || m != 0 {
+				t.Errorf("sent %d X and %d M packets", x, m)
+			}
+			var er *ErrorReply
+			if err = c.ReadMem(0, buf[:4]); !errors.As(err, &er) || er.ErrorCode() != "GDB_E14" {
+				t.Errorf("ReadMem error: %v, want E14", err)
+			}
+			if err = c.Detach(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestFlash(t *testing.T) {
+	srv := &fakeServer{noAckMode: true, pktSize: 0x200}
+	c, err := Dial(srv.start(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	regions, err := c.MemoryMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Region{
+		{"flash", fakeFlash, 64 * 1024, fakeBlockSize},
+		{"ram", fakeRAM, 16 * 1024, 0},
+	}
+	if fmt.Sprint(regions) != fmt.Sprint(want) {
+		t.Fatalf("memory map:\n%v\nwant:\n%v", regions, want)
+	}
+	data := testData(5000)
+	const addr = fakeFlash + fakeBlockSize
+	if err = c.FlashErase(addr, 5*fakeBlockSize); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.FlashWrite(addr, data); err != nil {
+		t.Fatal(err)
+	}
+	if srv.read(addr, 1)[0] != 0xff {
+		t.Error("the data were written before vFlashDone")
+	}
+	if err = c.FlashDone(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(srv.read(addr, len(data)), data) {
+		t.Error("bad flash content")
+	}
+	crc, err := c.CRC(addr, len(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := CRC32(data); crc != want {
+		t.Errorf("CRC %#08x, want %#08x", crc, want)
+	}
+	var er *ErrorReply
+	if err = c.FlashErase(addr+1, fakeBlockSize); !errors.As(err, &er) || er.Code != 1 {
+		t.Errorf("FlashErase error: %v, want E01", err)
+	}
+	out, err := c.Monitor("reset halt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "target halted\nreset halt" {
+		t.Errorf("monitor output %q", out)
+	}
+	if _, err = c.request(ReplyTimeout, "vUnknown"); err != ErrUnsupported {
+		t.Errorf("unknown request error: %v, want ErrUnsupported", err)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gdbrsp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Region describes a memory region of the target memory map.
+type Region struct {
+	Type      string // "ram", "rom" or "flash"
+	Start     uint64
+	Length    uint64
+	BlockSize uint64 // erase block size (flash only)
+}
+
+// Contains reports whether the region contains addr.
+func (r *Region) Contains(addr uint64) bool {
+	return addr >= r.Start && addr-r.Start < r.Length
+}
+
+// MemoryMap reads the memory map of the target. It returns ErrUnsupported if
+// the server doesn't provide the memory map.
+func (c *Conn) MemoryMap() (regions []Region, err error) {
+	defer wrapErr("MemoryMap", &err)
+	if c.features["qXfer:memory-map:read"] != "+" {
+		return nil, ErrUnsupported
+	}
+	var doc []byte
+	for {
+		var rsp []byte
+		rsp, err = c.request(ReplyTimeout, fmt.Sprintf(
+			"qXfer:memory-map:read::%x,%x", len(doc), c.pktSize-8,
+		))
+		if err != nil {
+			return
+		}
+		doc = append(doc, rsp[1:]...)
+		if rsp[0] == 'l' {
+			break
+		}
+		if rsp[0] != 'm' || len(rsp) == 1 {
+			return nil, fmt.Errorf("unexpected reply: %.32q", rsp)
+		}
+	}
+	var mm struct {
+		Memory []struct {
+			Type     string `xml:"type,attr"`
+			Start    string `xml:"start,attr"`
+			Length   string `xml:"length,attr"`
+			Property []struct {
+				Name  string `xml:"name,attr"`
+				Value string `xml:",chardata"`
+			} `xml:"property"`
+		} `xml:"memory"`
+	}
+	dec := xml.NewDecoder(bytes.NewReader(doc))
+	dec.Strict = false // skip the DOCTYPE
+	if err = dec.Decode(&mm); err != nil {
+		return
+	}
+	num := func(s string) uint64 {
+		v, e := strconv.ParseUint(strings.TrimSpace(s), 0, 64)
+		if e != nil && err == nil {
+			err = fmt.Errorf("bad number in memory map: %q", s)
+		}
+		return v
+	}
+	for _, m := range mm.Memory {
+		r := Region{Type: m.Type, Start: num(m.Start), Length: num(m.Length)}
+		for _, p := range m.Property {
+			if p.Name == "blocksize" {
+				r.BlockSize = num(p.Value)
+			}
+		}
+		if r.Type == "flash" && r.BlockSize == 0 {
+			r.BlockSize = r.Length
+		}
+		regions = append(regions, r)
+	}
+	return
+}
@@ -0,0 +1,282 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package halfkay implements the protocol of the HalfKay bootloader used by the
+// Teensy 4.x boards.
+package halfkay
+
+import (
+	"errors"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/usbdev"
+	usb "github.com/google/gousb"
+)
+
+const (
+	Vendor  usb.ID = 0x16c0
+	Product usb.ID = 0x0478
+)
+
+// Model describes the Teensy board.
+type Model struct {
+	Name       string
+	FlashSize  int    // size of the flash memory
+	CodeSize   int    // size of the flash available for the program
+	BlockSize  int    // size of the block written by a single request
+	FlexRAMCfg uint32 // default FlexRAM configuration (GPR17)
+}
+
+// Known models. The values are the same as used by the teensy_loader_cli.
+var (
+	Teensy40 = &Model{"Teensy 4.0", 2048 * 1024, 2031616, 1024, flexRAMCfg}
+	Teensy41 = &Model{"Teensy 4.1", 8192 * 1024, 8126464, 1024, flexRAMCfg}
+	MicroMod = &Model{"Teensy MicroMod", 16384 * 1024, 16515072, 1024, flexRAMCfg}
+)
+
+const flexRAMCfg = 0x5555_5556 // 480 KiB OCRAM, 32 KiB DTCM
+
+// CodeSize returns the size of the flash available for the program on the
+// Teensy 4.x board with the flash of the given size. The end of the flash is
+// reserved for the EEPROM emulation and the restore program: 64 KiB on the
+// 2 MiB flash, 256 KiB on the larger ones.
+func CodeSize(flashSize int) int {
+	if flashSize <= 2048*1024 {
+		return flashSize - 64*1024
+	}
+	return flashSize - 256*1024
+}
+
+// HID usages in the vendor defined page (0xff9c) of the HalfKay report
+// descriptor and the bcdDevice values that identify the model.
+var (
+	usageModels = map[uint32]*Model{
+		0x24: Teensy40,
+		0x25: Teensy41,
+		0x26: MicroMod,
+	}
+	bcdModels = map[usb.BCD]*Model{
+		0x0280: Teensy40,
+		0x0281: Teensy41,
+		0x0282: MicroMod,
+	}
+)
+
+type Conn struct {
+	dev   usbdev.Device
+	intf  usbdev.Interface
+	model *Model
+	buf   []byte
+}
+
+type Error struct {
+	Op  string
+	Err error
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Error() string {
+	return "halfkay: " + e.Op + ": " + e.Err.Error()
+}
+
+func wrapErr(op string, err *error) {
+	if *err != nil {
+		*err = &Error{op, *err}
+	}
+}
+
+// ErrNotFound is returned by Connect if there is no device in the bootloader
+// mode on the USB bus.
+var ErrNotFound = errors.New("no USB devices in the bootloader mode were found")
+
+// Connect connects to the Teensy board in the bootloader mode. You can connect
+// to the concrete device on the USB bus by providing BUS:DEV string where both
+// BUS and DEV are decimal unsigned integers. If busAddr is empty connect will
+// try to find a HalfKay device on the bus (it will return an error if there
+// are more than one such devices).
+func Connect(busAddr string) (conn *Conn, err error) {
+	defer wrapErr("Connect", &err)
+
+	devs, err := usbdev.Open(Vendor, Product, busAddr)
+	if err != nil {
+		return
+	}
+	if len(devs) != 1 {
+		usbdev.Close(devs)
+		if len(devs) == 0 {
+			return nil, ErrNotFound
+		}
+		return nil, errors.New("found more than one USB device in the bootloader mode")
+	}
+	dev := devs[0]
+	defer func() {
+		if err != nil {
+			dev.Close()
+		}
+	}()
+	model := readModel(dev)
+	intf, err := dev.Claim(1, 0, 0)
+	if err != nil {
+		return
+	}
+	conn = &Conn{dev: dev, intf: intf, model: model}
+	return
+}
+
+// Reboot looks for a running Teensy program with the USB serial interface and
+// asks it to reboot into the bootloader by setting the 134 baud rate. The
+// busAddr selects the device as in Connect. The caller should wait for the
+// device to enumerate in the bootloader mode.
+func Reboot(busAddr string) (err error) {
+	defer wrapErr("Reboot", &err)
+
+	devs, err := usbdev.Open(Vendor, 0, busAddr)
+	if err != nil {
+		return
+	}
+	defer usbdev.Close(devs)
+	var dev usbdev.Device
+	var cn, in int
+	for _, d := range devs {
+		if d.Desc().Product == Product {
+			continue
+		}
+		for _, cfg := range d.Desc().Configs {
+			for _, id := range cfg.Interfaces {
+				is := id.AltSettings[0]
+				if is.Class != usb.ClassComm || is.SubClass != 2 {
+					continue // not CDC ACM
+				}
+				if dev != nil && dev != d {
+					return errors.New("found more than one Teensy with USB serial")
+				}
+				dev, cn, in = d, cfg.Number, id.Number
+			}
+		}
+	}
+	if dev == nil {
+		return ErrNotFound
+	}
+	intf, err := dev.Claim(cn, in, 0) // releases it from the kernel driver
+	if err != nil {
+		return
+	}
+	defer intf.Close()
+	const baud = 134
+	lineCoding := [7]byte{baud & 0xff, baud >> 8, 0, 0, 0, 0, 8}
+	_, err = dev.Control(
+		usb.ControlOut|usb.ControlClass|usb.ControlInterface,
+		0x20, 0, uint16(in), lineCoding[:], // SET_LINE_CODING
+	)
+	if errors.Is(err, usb.ErrorNoDevice) || errors.Is(err, usb.ErrorIO) {
+		err = nil // the device may reboot before the status stage
+	}
+	return
+}
+
+// readModel determines the model using the HID report descriptor or, if this
+// fails, using the bcdDevice field of the device descriptor.
+func readModel(dev usbdev.Device) *Model {
+	var buf [256]byte
+	n, err := dev.Control(
+		usb.ControlIn|usb.ControlInterface, 0x06, 0x2200, 0, buf[:],
+	)
+	if err == nil {
+		if m := usageModels[vendorUsage(buf[:n])]; m != nil {
+			return m
+		}
+	}
+	return bcdModels[dev.Desc().Device]
+}
+
+// vendorUsage returns the first usage in the 0xff9c usage page found in the
+// HID report descriptor d.
+func vendorUsage(d []byte) uint32 {
+	var page uint32
+	for len(d) != 0 {
+		prefix := d[0]
+		size := int(prefix & 3)
+		if size == 3 {
+			size = 4
+		}
+		if prefix == 0xfe || 1+size > len(d) {
+			break // long items aren't used in the HalfKay descriptor
+		}
+		var val uint32
+		for i := size; i > 0; i-- {
+			val = val<<8 | uint32(d[i])
+		}
+		switch prefix &^ 3 {
+		case 0x04: // Usage Page (global)
+			page = val
+		case 0x08: // Usage (local)
+			if page == 0xff9c {
+				return val
+			}
+		}
+		d = d[1+size:]
+	}
+	return 0
+}
+
+// Model returns the detected model of the board or nil if the model is
+// unknown.
+func (c *Conn) Model() *Model {
+	return c.model
+}
+
+// List returns all Teensy devices in the bootloader mode.
+func List() (list []usbdev.Info, err error) {
+	list, err = usbdev.List(Vendor, Product, nil)
+	wrapErr("List", &err)
+	return
+}
+
+func (c *Conn) Close() (err error) {
+	c.intf.Close()
+	err = c.dev.Close()
+	wrapErr("Close", &err)
+	return
+}
+
+const hdrSize = 64
+
+func (c *Conn) write(addr int, p []byte) (err error) {
+	n := hdrSize + len(p)
+	if cap(c.buf) < n {
+		c.buf = make([]byte, n)
+	}
+	buf := c.buf[:n]
+	clear(buf[:hdrSize])
+	buf[0] = byte(addr)
+	buf[1] = byte(addr >> 8)
+	buf[2] = byte(addr >> 16)
+	copy(buf[hdrSize:], p)
+	for range 500 {
+		_, err = c.dev.Control(0x21, 9, 0x0200, 0, buf)
+		if err == nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return
+}
+
+// Write writes the block of data at the addr offset from the beginning of
+// the flash. The len(block) must be equal to the block size of the model.
+func (c *Conn) Write(addr int, block []byte) (err error) {
+	err = c.write(addr, block)
+	wrapErr("Write", &err)
+	return
+}
+
+// Boot starts the loaded program.
+func (c *Conn) Boot(blockSize int) (err error) {
+	err = c.write(0xff_ffff, make([]byte, blockSize))
+	wrapErr("Boot", &err)
+	return
+}
@@ -0,0 +1,15 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package halfkay
+
+import "testing"
+
+func TestCodeSize(t *testing.T) {
+	for _, m := range []*Model{Teensy40, Teensy41, MicroMod} {
+		if cs := CodeSize(m.FlashSize); cs != m.CodeSize {
+			t.Errorf("%s: CodeSize(%d) = %d, want %d", m.Name, m.FlashSize, cs, m.CodeSize)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package picoboot
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Permission bits (PartInfo.Perm)
+const (
+	PermSecureRead     uint8 = 1 << 0
+	PermSecureWrite    uint8 = 1 << 1
+	PermNonsecureRead  uint8 = 1 << 2
+	PermNonsecureWrite uint8 = 1 << 3
+	PermBootRead       uint8 = 1 << 4
+	PermBootWrite      uint8 = 1 << 5
+)
+
+// Partition flags (PartInfo.Flags)
+const (
+	PartHasID                  uint32 = 1 << 0
+	PartLinkType               uint32 = 3 << 1 // PartLinkNone, PartLinkA, PartLinkOwner
+	PartLinkValue              uint32 = 15 << 3
+	PartExtraFamilies          uint32 = 3 << 7 // number of extra family IDs
+	PartNotBootableARM         uint32 = 1 << 9
+	PartNotBootableRISCV       uint32 = 1 << 10
+	PartABNonBootableOwnerAff  uint32 = 1 << 11
+	PartHasName                uint32 = 1 << 12
+	PartAcceptsAbsolute        uint32 = 1 << 13
+	PartAcceptsRP2040          uint32 = 1 << 14
+	PartAcceptsRP2350ARMS      uint32 = 1 << 15
+	PartAcceptsRP2350ARMNS     uint32 = 1 << 16
+	PartAcceptsRP2350RISCV     uint32 = 1 << 17
+	PartAcceptsData            uint32 = 1 << 18
+	PartUF2DownloadNoReboot    uint32 = 1 << 19
+	PartUF2DownloadAffinity    uint32 = 0xf << 23 // not reported by GetInfo
+	PartAcceptsDefaultFamilies uint32 = 0x3f << 13
+)
+
+// Values of the PartLinkType field
+const (
+	PartLinkNone  uint32 = 0 << 1
+	PartLinkA     uint32 = 1 << 1
+	PartLinkOwner uint32 = 2 << 1
+)
+
+// PartInfo describes a flash partition (or the unpartitioned space).
+type PartInfo struct {
+	First, Last int   // the first and the last 4 KiB flash sector
+	Perm        uint8 // access permissions (Perm* bits)
+	Flags       uint32
+	ID          uint64   // valid if Flags&PartHasID != 0
+	Families    []uint32 // additional accepted UF2 family IDs
+	Name        string   // valid if Flags&PartHasName != 0
+}
+
+// Link returns the partition number the partition is linked to and the type
+// of link (PartLinkNone, PartLinkA, PartLinkOwner).
+func (p *PartInfo) Link() (typ uint32, n int) {
+	return p.Flags & PartLinkType, int(p.Flags & PartLinkValue >> 3)
+}
+
+// Size returns the partition size in bytes.
+func (p *PartInfo) Size() int {
+	return (p.Last - p.First + 1) * 4096
+}
+
+// PartitionTable is the decoded response to the GetInfo(Partition) command.
+type PartitionTable struct {
+	Present       bool // false means there is no partition table in flash
+	Unpartitioned PartInfo
+	Partitions    []PartInfo
+}
+
+// ABPartner returns the other partition of the A/B pair the i-th partition
+// belongs to or -1 if the partition isn't a part of an A/B pair.
+func (pt *PartitionTable) ABPartner(i int) int {
+	if typ, a := pt.Partitions[i].Link(); typ == PartLinkA {
+		return a // the i-th partition is the B one
+	}
+	for k := range pt.Partitions {
+		if typ, a := pt.Partitions[k].Link(); typ == PartLinkA && a == i {
+			return k
+		}
+	}
+	return -1
+}
+
+func decodeLocation(p *PartInfo, loc, flags uint32) {
+	p.First = int(loc & 0x1fff)
+	p.Last = int(loc >> 13 & 0x1fff)
+	p.Perm = uint8(flags >> 26)
+	p.Flags = flags &^ (0x3f << 26)
+}
+
+// PartitionTable reads the partition table using the GetInfo command (RP2350
+// only).
+func (c *Conn) PartitionTable() (pt *PartitionTable, err error) {
+	if pt, err = c.ptInfo(); err != nil {
+		return nil, err
+	}
+	for i := range pt.Partitions {
+		p := &pt.Partitions[i]
+		if p.Flags&(PartHasID|PartExtraFamilies|PartHasName) != 0 {
+			if err = c.partitionDetails(i, p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return
+}
+
+// ptInfo reads the partition table information and the locations and flags of
+// all partitions.
+func (c *Conn) ptInfo() (pt *PartitionTable, err error) {
+	var buf [64]uint32
+	flags := PTInfo | PartitionLocationAndFlags
+	if err = c.GetInfo(buf[:], Partition, flags); err != nil {
+		return
+	}
+	defer wrapErr("PartitionTable", &err)
+	n := int(buf[0])
+	if n < 4 || n > len(buf)-1 || buf[1]&flags != flags {
+		return nil, errors.New("bad response")
+	}
+	w := buf[2 : 1+n]
+	pt = &PartitionTable{Present: w[0]&0x100 != 0}
+	decodeLocation(&pt.Unpartitioned, w[1], w[2])
+	cnt := int(w[0] & 0xff)
+	w = w[3:]
+	if len(w) < cnt*2 {
+		return nil, errors.New("short response")
+	}
+	pt.Partitions = make([]PartInfo, cnt)
+	for i := range pt.Partitions {
+		decodeLocation(&pt.Partitions[i], w[i*2], w[i*2+1])
+	}
+	return
+}
+
+// partitionDetails reads the ID, the additional families and the name of the
+// i-th partition.
+func (c *Conn) partitionDetails(i int, p *PartInfo) (err error) {
+	var buf [64]uint32
+	flags := PartitionID | PartitionFamilyIDs | PartitionName
+	if err = c.GetInfo(buf[:], Partition, flags|SinglePartition|uint32(i)<<24); err != nil {
+		return
+	}
+	defer wrapErr("PartitionTable", &err)
+	n := int(buf[0])
+	if n < 1 || n > len(buf)-1 {
+		return errors.New("bad response")
+	}
+	w := buf[2 : 1+n]
+	if p.Flags&PartHasID != 0 {
+		if len(w) < 2 {
+			return errors.New("short response")
+		}
+		p.ID = uint64(w[0]) | uint64(w[1])<<32
+		w = w[2:]
+	}
+	if m := int(p.Flags & PartExtraFamilies >> 7); m != 0 {
+		if len(w) < m {
+			return errors.New("short response")
+		}
+		p.Families = append([]uint32(nil), w[:m]...)
+		w = w[m:]
+	}
+	if p.Flags&PartHasName != 0 {
+		b := make([]byte, 0, len(w)*4)
+		for _, v := range w {
+			b = binary.LittleEndian.AppendUint32(b, v)
+		}
+		if len(b) == 0 || int(b[0]&0x7f) > len(b)-1 {
+			return errors.New("bad partition name")
+		}
+		p.Name = string(b[1 : 1+b[0]&0x7f])
+	}
+	return
+}
+
+// UF2Target returns the partition (or the unpartitioned space) where the boot
+// ROM would place the UF2 image of the given family (RP2350 only).
+func (c *Conn) UF2Target(family uint32) (p PartInfo, err error) {
+	var info [4]uint32
+	if err = c.GetInfo(info[:], UF2TargetPartition, family); err != nil {
+		return
+	}
+	defer wrapErr("UF2Target", &err)
+	if info[0] != 3 {
+		return p, errors.New("bad response")
+	}
+	decodeLocation(&p, info[2], info[3])
+	return
+}
@@ -12,7 +12,7 @@ import (
 	"time"
 	"unsafe"
 
-	"github.com/embeddedgo/tools/egtool/internal/util"
+	"github.com/embeddedgo/tools/egtool/internal/usbdev"
 	usb "github.com/google/gousb"
 )
 
@@ -34,16 +34,32 @@ const (
 	cmdOTPWrite        uint8 = 0x0d
 )
 
+const (
+	Vendor        usb.ID = 0x2e8a
+	ProductRP2040 usb.ID = 0x0003 // RP2040 BOOTSEL mode
+	ProductRP2350 usb.ID = 0x000f // RP2350 BOOTSEL mode
+)
+
+func isBootsel(desc *usb.DeviceDesc) bool {
+	return desc.Product == ProductRP2040 || desc.Product == ProductRP2350
+}
+
 type Conn struct {
-	ctx       *usb.Context
-	dev       *usb.Device
+	// Timeout limits the time of waiting for the completion of a long-running
+	// command (FlashErase, Write, Exec, VectorizeFlash, OTPWrite). Connect sets
+	// it to DefaultTimeout. If zero the completion isn't polled and the
+	// command acknowledgment is awaited without a time limit.
+	Timeout time.Duration
+
+	dev       usbdev.Device
+	intf      usbdev.Interface
 	iid       uint16
-	oe        *usb.OutEndpoint
-	ie        *usb.InEndpoint
+	oe        io.Writer
+	ie        io.Reader
 	cmdBuf    [32]byte
 	token     uint32
-	readSpec  [2]uint32
-	writeSpec [2]uint32
+	readSpec  rangeArgs
+	writeSpec rangeArgs
 }
 
 type Error struct {
@@ -65,6 +81,15 @@ func wrapErr(op string, err *error) {
 	}
 }
 
+const (
+	DefaultTimeout = 30 * time.Second
+	pollInterval   = 10 * time.Millisecond
+)
+
+// ErrNotFound is returned by Connect if there is no device in BOOTSEL mode on
+// the USB bus.
+var ErrNotFound = errors.New("no USB devices in BOOTSEL mode were found")
+
 // Connect connects to the USB device in PICOBOOT mode. You can connect to the
 // concrete device on the USB bus by providing BUS:DEV string where both BUS
 // and DEV are decimal unsigned integers. If busAddr is empty connect will try
@@ -73,26 +98,48 @@ func wrapErr(op string, err *error) {
 func Connect(busAddr string) (conn *Conn, err error) {
 	defer wrapErr("Connect", &err)
 
-	ctx, devs, err := util.OpenUSB(0x2e8a, 0x000f, busAddr)
+	devs, err := usbdev.Open(Vendor, 0, busAddr)
 	if err != nil {
 		return
 	}
-	defer ctx.Close()
-	if len(devs) == 0 {
-		return nil, errors.New("no USB devices in BOOTSEL mode were found")
+	n := 0
+	for _, d := range devs {
+		if isBootsel(d.Desc()) {
+			devs[n] = d
+			n++
+		} else {
+			d.Close()
+		}
 	}
+	devs = devs[:n]
 	if len(devs) != 1 {
+		usbdev.Close(devs)
+		if len(devs) == 0 {
+			return nil, ErrNotFound
+		}
 		return nil, errors.New("found more than one USB device in BOOTSEL mode")
 	}
 
 	dev := devs[0]
+	desc := dev.Desc()
+	var intf usbdev.Interface
+	defer func() {
+		if err != nil {
+			if intf != nil {
+				intf.Close()
+			}
+			dev.Close()
+		}
+	}()
 	var cn, in, an int
+	var eps map[usb.EndpointAddress]usb.EndpointDesc
 	ok := false
-	for _, cfg := range dev.Desc.Configs {
+	for _, cfg := range desc.Configs {
 		for _, id := range cfg.Interfaces {
 			for _, is := range id.AltSettings {
 				if is.Class == 0xff && is.SubClass == 0 && is.Protocol == 0 {
 					cn, in, an = cfg.Number, id.Number, is.Alternate
+					eps = is.Endpoints
 					ok = true
 					break
 				}
@@ -102,25 +149,16 @@ func Connect(busAddr string) (conn *Conn, err error) {
 	if !ok {
 		return nil, fmt.Errorf(
 			"the found device %d:%d doesn't provide the expected interface",
-			dev.Desc.Bus, dev.Desc.Address,
+			desc.Bus, desc.Address,
 		)
 	}
-	dev.SetAutoDetach(true)
 
 	// Determine PICOBOOT bulk endpoints (TX/RX).
-	cfg, err := dev.Config(cn)
-	if err != nil {
-		return nil, err
-	}
-	intf, err := cfg.Interface(in, an)
-	if err != nil {
-		return nil, err
-	}
 	var rxn, txn int
-	if n := len(intf.Setting.Endpoints); n != 2 {
+	if n := len(eps); n != 2 {
 		return nil, errors.New("want exactly two USB bulk endpoints")
 	}
-	for _, ed := range intf.Setting.Endpoints {
+	for _, ed := range eps {
 		if ed.Direction == usb.EndpointDirectionIn {
 			rxn = ed.Number
 		} else {
@@ -133,21 +171,36 @@ func Connect(busAddr string) (conn *Conn, err error) {
 	if txn == 0 {
 		return nil, errors.New("no USB OUT endpoint in the USB interface")
 	}
-	ie, err := intf.InEndpoint(rxn)
+	intf, err = dev.Claim(cn, in, an)
 	if err != nil {
 		return nil, err
 	}
-	oe, err := intf.OutEndpoint(txn)
+	ie, err := intf.In(rxn)
 	if err != nil {
 		return nil, err
 	}
-	conn = &Conn{ctx: ctx, dev: dev, iid: uint16(in), oe: oe, ie: ie}
+	oe, err := intf.Out(txn)
+	if err != nil {
+		return nil, err
+	}
+	conn = &Conn{
+		dev: dev, intf: intf, iid: uint16(in),
+		oe: oe, ie: ie, Timeout: DefaultTimeout,
+	}
 	binary.LittleEndian.AppendUint32(conn.cmdBuf[:0], magic)
 	return
 }
 
+// List returns all devices in BOOTSEL mode.
+func List() (list []usbdev.Info, err error) {
+	list, err = usbdev.List(Vendor, 0, isBootsel)
+	wrapErr("List", &err)
+	return
+}
+
 func (c *Conn) Close() (err error) {
-	err = c.ctx.Close()
+	c.intf.Close()
+	err = c.dev.Close()
 	wrapErr("Close", &err)
 	return
 }
@@ -177,13 +230,20 @@ func (c *Conn) writeCmd(cmdId uint8, transferLength int, args any) error {
 	return err
 }
 
-func (c *Conn) ExclusiveAccess(ea bool) (err error) {
+// Exclusivity is the argument of the ExclusiveAccess command.
+type Exclusivity uint8
+
+const (
+	NotExclusive   Exclusivity = 0 // the USB mass storage is fully accessible
+	Exclusive      Exclusivity = 1 // the mass storage is read-only
+	ExclusiveEject Exclusivity = 2 // the mass storage is ejected
+)
+
+// ExclusiveAccess controls the access to the flash through the USB mass
+// storage interface while the PICOBOOT interface is in use.
+func (c *Conn) ExclusiveAccess(mode Exclusivity) (err error) {
 	defer wrapErrStatus(c, "ExclusiveAccess", &err)
-	var arg uint8
-	if ea {
-		arg = 1
-	}
-	err = c.writeCmd(cmdExclusiveAccess, 0, &arg)
+	err = c.writeCmd(cmdExclusiveAccess, 0, &mode)
 	if err != nil {
 		return
 	}
@@ -191,30 +251,36 @@ func (c *Conn) ExclusiveAccess(ea bool) (err error) {
 	return
 }
 
+// rangeArgs are the arguments of the FlashErase, Read and Write commands.
+type rangeArgs struct {
+	Addr uint32
+	Size uint32
+}
+
 func (c *Conn) FlashErase(addr uint32, size int) (err error) {
 	defer wrapErrStatus(c, "FlashErase", &err)
-	err = c.writeCmd(cmdFlashErase, 0, &[2]uint32{addr, uint32(size)})
+	err = c.writeCmd(cmdFlashErase, 0, &rangeArgs{addr, uint32(size)})
 	if err != nil {
 		return
 	}
-	_, err = c.ie.Read(nil)
+	err = c.wait()
 	return
 }
 
 func (c *Conn) SetReadAddr(addr uint32) {
-	c.readSpec[0] = addr
+	c.readSpec.Addr = addr
 }
 
 func (c *Conn) ReadAddr() uint32 {
-	return c.readSpec[0]
+	return c.readSpec.Addr
 }
 
 func (c *Conn) SetWriteAddr(addr uint32) {
-	c.writeSpec[0] = addr
+	c.writeSpec.Addr = addr
 }
 
 func (c *Conn) WriteAddr() uint32 {
-	return c.writeSpec[0]
+	return c.writeSpec.Addr
 }
 
 // Read performs n-byte PICOBOOT read transaction (if err == nil then n is
@@ -222,7 +288,7 @@ func (c *Conn) WriteAddr() uint32 {
 // SetReadAddr).
 func (c *Conn) Read(p []byte) (n int, err error) {
 	defer wrapErrStatus(c, "Read", &err)
-	c.readSpec[1] = uint32(len(p))
+	c.readSpec.Size = uint32(len(p))
 	err = c.writeCmd(cmdRead, len(p), &c.readSpec)
 	if err != nil {
 		return
@@ -231,14 +297,14 @@ func (c *Conn) Read(p []byte) (n int, err error) {
 	if err != nil {
 		return
 	}
-	c.readSpec[0] += uint32(n)
+	c.readSpec.Addr += uint32(n)
 	_, err = c.oe.Write(nil)
 	return
 }
 
 func (c *Conn) Write(p []byte) (n int, err error) {
 	defer wrapErrStatus(c, "Write", &err)
-	c.writeSpec[1] = uint32(len(p))
+	c.writeSpec.Size = uint32(len(p))
 	err = c.writeCmd(cmdWrite, len(p), &c.writeSpec)
 	if err != nil {
 		return
@@ -247,8 +313,8 @@ func (c *Conn) Write(p []byte) (n int, err error) {
 	if err != nil {
 		return
 	}
-	c.writeSpec[0] += uint32(n)
-	_, err = c.ie.Read(nil)
+	c.writeSpec.Addr += uint32(n)
+	err = c.wait()
 	return
 }
 
@@ -262,6 +328,64 @@ func (c *Conn) ExitXIP() (err error) {
 	return
 }
 
+// EnterXIP enables the flash memory-mapped access in the fast read mode
+// (RP2040 only, RP2350 treats it as a no-op).
+func (c *Conn) EnterXIP() (err error) {
+	defer wrapErrStatus(c, "EnterXIP", &err)
+	err = c.writeCmd(cmdEnterXIP, 0, nil)
+	if err != nil {
+		return
+	}
+	_, err = c.ie.Read(nil)
+	return
+}
+
+// Exec executes the function at addr in the boot ROM context and waits for it
+// to return (RP2040 only). The thumb bit of the addr should be set.
+func (c *Conn) Exec(addr uint32) (err error) {
+	defer wrapErrStatus(c, "Exec", &err)
+	err = c.writeCmd(cmdExec, 0, &addr)
+	if err != nil {
+		return
+	}
+	err = c.wait()
+	return
+}
+
+// VectorizeFlash asks the boot ROM to copy the table of the flash access
+// functions used by the USB mass storage and PICOBOOT interfaces to the RAM at
+// addr, so they can be replaced by custom implementations (RP2040 only).
+func (c *Conn) VectorizeFlash(addr uint32) (err error) {
+	defer wrapErrStatus(c, "VectorizeFlash", &err)
+	err = c.writeCmd(cmdVectorizeFlash, 0, &addr)
+	if err != nil {
+		return
+	}
+	err = c.wait()
+	return
+}
+
+// rebootArgs are the arguments of the Reboot command.
+type rebootArgs struct {
+	PC    uint32
+	SP    uint32
+	Delay uint32 // ms
+}
+
+// Reboot reboots the RP2040. If pc is zero the device reboots normally,
+// otherwise it starts the code at pc with the stack pointer set to sp. Use
+// Reboot2 for RP2350.
+func (c *Conn) Reboot(pc, sp uint32, delay time.Duration) (err error) {
+	defer wrapErrStatus(c, "Reboot", &err)
+	a := rebootArgs{pc, sp, uint32(delay / time.Millisecond)}
+	err = c.writeCmd(cmdReboot, 0, &a)
+	if err != nil {
+		return
+	}
+	_, err = c.ie.Read(nil)
+	return
+}
+
 const (
 	// Reboot2 types
 	RebootNormal      uint32 = 0x0
@@ -275,13 +399,20 @@ const (
 	RebootToRISCV uint32 = 1 << 5
 )
 
+// reboot2Args are the arguments of the Reboot2 command.
+type reboot2Args struct {
+	Type  uint32 // reboot type and flags
+	Delay uint32 // ms
+	P0    uint32
+	P1    uint32
+}
+
+// Reboot2 reboots the RP2350. The meaning of p0 and p1 depends on the reboot
+// type (e.g. the start address and the size of the RAM image for
+// RebootRAMImage, the pc and sp for RebootPCSP).
 func (c *Conn) Reboot2(rebootType uint32, delay time.Duration, p0, p1 uint32) (err error) {
 	defer wrapErrStatus(c, "Reboot2", &err)
-	a := [4]uint32{
-		rebootType,
-		uint32(delay / time.Millisecond),
-		p0, p1,
-	}
+	a := reboot2Args{rebootType, uint32(delay / time.Millisecond), p0, p1}
 	err = c.writeCmd(cmdReboot2, 0, &a)
 	if err != nil {
 		return
@@ -318,11 +449,23 @@ const (
 	SinglePartition           uint32 = 1 << 15
 )
 
-func (c *Conn) GetInfo(info []uint32, args ...uint32) (err error) {
+// getInfoArgs are the arguments of the GetInfo command.
+type getInfoArgs struct {
+	Type   uint32 // information type
+	Params [3]uint32
+}
+
+// GetInfo reads the information of the given type (RP2350 only). The first
+// word of the response is the number of the following words. The meaning of
+// the params depends on the information type (e.g. the InfoSys flags).
+func (c *Conn) GetInfo(info []uint32, typ uint32, params ...uint32) (err error) {
 	defer wrapErrStatus(c, "GetInfo", &err)
 	nbytes := len(info) * 4
-	var a [4]uint32
-	copy(a[:], args)
+	a := getInfoArgs{Type: typ}
+	if len(params) > len(a.Params) {
+		return errors.New("too many params")
+	}
+	copy(a.Params[:], params)
 	err = c.writeCmd(cmdGetInfo, nbytes, &a)
 	if err != nil {
 		return
@@ -346,6 +489,70 @@ func (c *Conn) GetInfo(info []uint32, args ...uint32) (err error) {
 	return
 }
 
+type otpArgs struct {
+	Row      uint16
+	RowCount uint16
+	ECC      uint8
+}
+
+func otpRowSize(ecc bool) int {
+	if ecc {
+		return 2 // 16-bit data, ECC protected
+	}
+	return 4 // 24-bit raw row in 32-bit word
+}
+
+// OTPRead reads the OTP rows starting from row (RP2350 only). In the ECC mode
+// every row is read as two bytes of the error-corrected data. In the raw mode
+// every row is read as four bytes (24-bit raw value). The len(p) must be a
+// multiple of the row size.
+func (c *Conn) OTPRead(row uint16, p []byte, ecc bool) (err error) {
+	defer wrapErrStatus(c, "OTPRead", &err)
+	rs := otpRowSize(ecc)
+	if len(p)%rs != 0 {
+		return errors.New("buffer size isn't a multiple of the row size")
+	}
+	a := otpArgs{row, uint16(len(p) / rs), 0}
+	if ecc {
+		a.ECC = 1
+	}
+	err = c.writeCmd(cmdOTPRead, len(p), &a)
+	if err != nil {
+		return
+	}
+	_, err = io.ReadFull(c.ie, p)
+	if err != nil {
+		return
+	}
+	_, err = c.oe.Write(nil)
+	return
+}
+
+// OTPWrite writes the OTP rows starting from row (RP2350 only). The data
+// format is the same as for OTPRead. OTP bits can be only set so the write is
+// irreversible.
+func (c *Conn) OTPWrite(row uint16, p []byte, ecc bool) (err error) {
+	defer wrapErrStatus(c, "OTPWrite", &err)
+	rs := otpRowSize(ecc)
+	if len(p)%rs != 0 {
+		return errors.New("data size isn't a multiple of the row size")
+	}
+	a := otpArgs{row, uint16(len(p) / rs), 0}
+	if ecc {
+		a.ECC = 1
+	}
+	err = c.writeCmd(cmdOTPWrite, len(p), &a)
+	if err != nil {
+		return
+	}
+	_, err = c.oe.Write(p)
+	if err != nil {
+		return
+	}
+	err = c.wait()
+	return
+}
+
 // Token returns a token associated to the last command.
 func (c *Conn) Token() uint32 {
 	return c.token
@@ -389,6 +596,7 @@ var statusStr = [...]string{
 
 type StatusError struct {
 	Cmd    string
+	Code   uint32 // PICOBOOT status code
 	Status string
 }
 
@@ -396,6 +604,23 @@ func (e *StatusError) Error() string {
 	return e.Cmd + " status: " + e.Status
 }
 
+var statusCode = [...]string{
+	"OK", "UNKNOWN_CMD", "INVALID_CMD_LENGTH", "INVALID_TRANSFER_LENGTH",
+	"INVALID_ADDRESS", "BAD_ALIGNMENT", "INTERLEAVED_WRITE", "REBOOTING",
+	"UNKNOWN_ERROR", "INVALID_STATE", "NOT_PERMITTED", "INVALID_ARG",
+	"BUFFER_TOO_SMALL", "PRECONDITION_NOT_MET", "MODIFIED_DATA",
+	"INVALID_DATA", "NOT_FOUND", "UNSUPPORTED_MODIFICATION",
+}
+
+// ErrorCode returns the name of the status code as defined in the RP2040 and
+// RP2350 datasheets with the PICOBOOT_ prefix (e.g. PICOBOOT_INVALID_ADDRESS).
+func (e *StatusError) ErrorCode() string {
+	if e.Code < uint32(len(statusCode)) {
+		return "PICOBOOT_" + statusCode[e.Code]
+	}
+	return "PICOBOOT_UNKNOWN_ERROR"
+}
+
 func wrapErrStatus(c *Conn, op string, err *error) {
 	if *err == nil {
 		return
@@ -409,11 +634,91 @@ func wrapErrStatus(c *Conn, op string, err *error) {
 	*err = &Error{op, *err}
 }
 
+// Requests of the reset interface provided by the applications
+const (
+	resetRequestBootsel uint8 = 0x01
+	resetRequestFlash   uint8 = 0x02
+)
+
+// RebootToBootsel looks for a running program that provides the RP2040/RP2350
+// USB reset interface (vendor specific interface with protocol 1) and asks it
+// to reboot the chip into BOOTSEL mode. The busAddr selects the device as in
+// Connect. The caller should wait for the device to enumerate in BOOTSEL mode.
+func RebootToBootsel(busAddr string) (err error) {
+	defer wrapErr("RebootToBootsel", &err)
+
+	devs, err := usbdev.Open(Vendor, 0, busAddr)
+	if err != nil {
+		return
+	}
+	defer usbdev.Close(devs)
+	var dev usbdev.Device
+	var in int
+	for _, d := range devs {
+		if isBootsel(d.Desc()) {
+			continue
+		}
+		for _, cfg := range d.Desc().Configs {
+			for _, id := range cfg.Interfaces {
+				for _, is := range id.AltSettings {
+					if is.Class != 0xff || is.SubClass != 0 || is.Protocol != 1 {
+						continue
+					}
+					if dev != nil && dev != d {
+						return errors.New("found more than one device with the reset interface")
+					}
+					dev, in = d, id.Number
+				}
+			}
+		}
+	}
+	if dev == nil {
+		return ErrNotFound
+	}
+	_, err = dev.Control(
+		usb.ControlOut|usb.ControlClass|usb.ControlInterface,
+		resetRequestBootsel, 0, uint16(in), nil,
+	)
+	if errors.Is(err, usb.ErrorNoDevice) || errors.Is(err, usb.ErrorIO) {
+		err = nil // the device may reboot before the status stage
+	}
+	return
+}
+
 const (
 	ctrlInterfaceReset    uint8 = 0x41
 	ctrlGetCmommandStatus uint8 = 0x42
 )
 
+// ErrTimeout is returned if a long-running command doesn't complete in the
+// Conn.Timeout time.
+var ErrTimeout = errors.New("timeout waiting for the command completion")
+
+// wait waits for the completion of the last command and reads its
+// acknowledgment. If c.Timeout isn't zero the command status is polled using
+// GetCommandStatus so the host doesn't block on the bulk endpoint forever and
+// the command failure is reported as soon as the device knows about it.
+func (c *Conn) wait() error {
+	if c.Timeout > 0 {
+		deadline := time.Now().Add(c.Timeout)
+		for {
+			token, done, err := c.GetCommandStatus()
+			if err != nil {
+				return err
+			}
+			if token == c.token && done {
+				break
+			}
+			if time.Now().After(deadline) {
+				return ErrTimeout
+			}
+			time.Sleep(pollInterval)
+		}
+	}
+	_, err := c.ie.Read(nil)
+	return err
+}
+
 func (c *Conn) InterfaceReset() (err error) {
 	_, err = c.dev.Control(
 		usb.ControlVendor|usb.ControlInterface,
@@ -423,6 +728,9 @@ func (c *Conn) InterfaceReset() (err error) {
 	return
 }
 
+// GetCommandStatus returns the token and the completion state of the last
+// command received by the device. The command failure is reported as
+// *StatusError.
 func (c *Conn) GetCommandStatus() (token uint32, done bool, err error) {
 	buf := c.cmdBuf[16:32]
 	_, err = c.dev.Control(
@@ -440,14 +748,14 @@ func (c *Conn) GetCommandStatus() (token uint32, done bool, err error) {
 	done = buf[9] == 0
 	if statusId != 0 {
 		cmd := "unknown"
-		if cmdExclusiveAccess <= cmdId && cmdId <= cmdOTPWrite {
-			cmd = cmdStr[cmdId]
+		if id := cmdId &^ 0x80; cmdExclusiveAccess <= id && id <= cmdOTPWrite {
+			cmd = cmdStr[id]
 		}
 		status := "unknown"
-		if 1 <= statusId && statusId <= 17 {
+		if 1 <= statusId && statusId < uint32(len(statusStr)) {
 			status = statusStr[statusId]
 		}
-		err = &StatusError{cmd, status}
+		err = &StatusError{cmd, statusId, status}
 	}
 	return
 }
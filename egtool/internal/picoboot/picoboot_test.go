@@ -0,0 +1,187 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package picoboot_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/picoboot"
+	"github.com/embeddedgo/tools/egtool/internal/usbdev"
+	"github.com/embeddedgo/tools/egtool/internal/usbsim"
+)
+
+const flashBase = 0x1000_0000
+
+func connect(t *testing.T, sim *usbsim.RP2350) *picoboot.Conn {
+	usbdev.Attach(sim)
+	t.Cleanup(func() { usbdev.Detach(sim) })
+	c, err := picoboot.Connect("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestWaitPoll(t *testing.T) {
+	sim := usbsim.NewRP2350(1024)
+	c := connect(t, sim)
+	sim.BusyPolls = 3
+	if err := c.FlashErase(flashBase, 4096); err != nil {
+		t.Fatal(err)
+	}
+	// Three "in progress" responses and the final one.
+	if n := sim.StatusPolls(); n != 4 {
+		t.Errorf("GET_COMMAND_STATUS sent %d times, want 4", n)
+	}
+	data := bytes.Repeat([]byte{0x5a}, 256)
+	c.SetWriteAddr(flashBase)
+	if _, err := c.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if n := sim.StatusPolls(); n != 8 {
+		t.Errorf("GET_COMMAND_STATUS sent %d times, want 8", n)
+	}
+	if !bytes.Equal(sim.Flash.Bytes(flashBase, len(data)), data) {
+		t.Error("bad flash content")
+	}
+}
+
+func TestWaitNoPoll(t *testing.T) {
+	sim := usbsim.NewRP2350(1024)
+	c := connect(t, sim)
+	sim.BusyPolls = 3
+	c.Timeout = 0
+	if err := c.FlashErase(flashBase, 4096); err != nil {
+		t.Fatal(err)
+	}
+	if n := sim.StatusPolls(); n != 0 {
+		t.Errorf("GET_COMMAND_STATUS sent %d times, want 0", n)
+	}
+}
+
+func TestWaitTimeout(t *testing.T) {
+	sim := usbsim.NewRP2350(1024)
+	c := connect(t, sim)
+	sim.BusyPolls = 1 << 30
+	c.Timeout = 50 * time.Millisecond
+	t0 := time.Now()
+	err := c.FlashErase(flashBase, 4096)
+	if !errors.Is(err, picoboot.ErrTimeout) {
+		t.Fatalf("FlashErase error: %v, want %v", err, picoboot.ErrTimeout)
+	}
+	if dt := time.Since(t0); dt > time.Second {
+		t.Errorf("FlashErase returned after %v", dt)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		name string
+		sim  func(flashKiB int) *usbsim.RP2350
+		cmd  func(c *picoboot.Conn) error
+		op   string
+		code uint32
+		ec   string
+	}{
+		{
+			"unaligned", usbsim.NewRP2350,
+			func(c *picoboot.Conn) error { return c.FlashErase(flashBase+256, 4096) },
+			"FlashErase", 5, "PICOBOOT_BAD_ALIGNMENT",
+		},
+		{
+			"address", usbsim.NewRP2350,
+			func(c *picoboot.Conn) error { return c.FlashErase(0x3000_0000, 4096) },
+			"FlashErase", 4, "PICOBOOT_INVALID_ADDRESS",
+		},
+		{
+			"exclusive", usbsim.NewRP2350,
+			func(c *picoboot.Conn) error { return c.ExclusiveAccess(3) },
+			"ExclusiveAccess", 11, "PICOBOOT_INVALID_ARG",
+		},
+		{
+			"getinfo", usbsim.NewRP2350,
+			func(c *picoboot.Conn) error {
+				var info [8]uint32
+				return c.GetInfo(info[:], 0x77)
+			},
+			"GetInfo", 11, "PICOBOOT_INVALID_ARG",
+		},
+		{
+			"rp2350-exec", usbsim.NewRP2350,
+			func(c *picoboot.Conn) error { return c.Exec(0x2000_0001) },
+			"Exec", 1, "PICOBOOT_UNKNOWN_CMD",
+		},
+		{
+			"rp2040-reboot2", usbsim.NewRP2040,
+			func(c *picoboot.Conn) error {
+				return c.Reboot2(picoboot.RebootNormal, 0, 0, 0)
+			},
+			"Reboot2", 1, "PICOBOOT_UNKNOWN_CMD",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := connect(t, tc.sim(1024))
+			err := tc.cmd(c)
+			var se *picoboot.StatusError
+			if !errors.As(err, &se) {
+				t.Fatalf("error: %v, want *StatusError", err)
+			}
+			if se.Cmd != tc.op || se.Code != tc.code || se.ErrorCode() != tc.ec {
+				t.Errorf(
+					"got %s %d %s, want %s %d %s",
+					se.Cmd, se.Code, se.ErrorCode(), tc.op, tc.code, tc.ec,
+				)
+			}
+			// The interface was reset so the next command must work.
+			if _, err := c.Chip(); err != nil {
+				t.Errorf("Chip after the error: %v", err)
+			}
+		})
+	}
+}
+
+func TestRP2040(t *testing.T) {
+	sim := usbsim.NewRP2040(2048)
+	c := connect(t, sim)
+	chip, err := c.Chip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chip != picoboot.ChipRP2040 {
+		t.Errorf("chip %#x, want %#x", chip, picoboot.ChipRP2040)
+	}
+	sim.BusyPolls = 2
+	if err := c.ExclusiveAccess(picoboot.ExclusiveEject); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.VectorizeFlash(0x2000_0100); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Exec(0x2000_0201); err != nil {
+		t.Fatal(err)
+	}
+	if len(sim.Executed) != 1 || sim.Executed[0] != 0x2000_0201 {
+		t.Errorf("executed %#x, want [0x20000201]", sim.Executed)
+	}
+	if n := sim.StatusPolls(); n != 6 {
+		t.Errorf("GET_COMMAND_STATUS sent %d times, want 6", n)
+	}
+	const pc, sp = 0x2000_0001, 0x2004_2000
+	if err := c.Reboot(pc, sp, 500*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	want := usbsim.Reboot{
+		Type: picoboot.RebootPCSP, Delay: 500 * time.Millisecond,
+		P0: pc, P1: sp,
+	}
+	if r := sim.Rebooted; r == nil || *r != want {
+		t.Errorf("rebooted: %+v, want %+v", r, want)
+	}
+}
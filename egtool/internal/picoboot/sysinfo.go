@@ -0,0 +1,157 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package picoboot
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Chip IDs returned by Chip
+const (
+	ChipRP2040 uint32 = 0x01754d
+	ChipRP2350 uint32 = 0x02754d
+)
+
+// Chip reads the chip identifier from the boot ROM.
+func (c *Conn) Chip() (chip uint32, err error) {
+	var buf [4]byte
+	ra := c.ReadAddr()
+	c.SetReadAddr(0x0000_0010)
+	_, err = c.Read(buf[:])
+	c.SetReadAddr(ra)
+	return binary.LittleEndian.Uint32(buf[:]) & 0xffffff, err
+}
+
+// ChipName returns the name of the chip with the given identifier.
+func ChipName(chip uint32) string {
+	switch chip {
+	case ChipRP2040:
+		return "RP2040"
+	case ChipRP2350:
+		return "RP2350"
+	}
+	return "unknown"
+}
+
+// LastBoot describes the most recent boot.
+type LastBoot struct {
+	DiagnosticPartition int8  // partition the Diagnostic refers to
+	Type                uint8 // boot type (Reboot2 type | BootChained)
+	Partition           int8  // booted partition (-1 means none)
+	TBYBAndUpdateInfo   uint8 // try-before-you-buy and flash update flags
+	Diagnostic          uint32
+	RebootParams        [2]uint32
+}
+
+// Flags in the LastBoot.Type field
+const BootChained uint8 = 0x80
+
+// Flags in the LastBoot.TBYBAndUpdateInfo field
+const (
+	TBYBBuyPending  uint8 = 1 << 0 // the booted TBYB image wasn't bought yet
+	TBYBOtherErased uint8 = 1 << 1 // the other A/B partition was erased
+	TBYBFlashUpdate uint8 = 1 << 2 // the boot was a flash update boot
+)
+
+// SysInfo is the decoded response to the GetInfo(InfoSys) command. The Flags
+// field tells which of the following fields are valid.
+type SysInfo struct {
+	Flags uint32
+
+	// ChipInfo
+	PackageSel uint32
+	DeviceID   uint32
+	WaferID    uint32
+
+	Critical     uint32    // Critical: the OTP CRITICAL register
+	CPU          uint32    // CPUInfo: 0 means ARM, 1 means RISC-V
+	FlashDevInfo uint32    // FlashDevInfo: FLASH_DEVINFO in the OTP format
+	BootRandom   [4]uint32 // BootRandom
+	Boot         LastBoot  // BootInfo
+}
+
+// Bits of the SysInfo.Critical field
+const (
+	CritSecureBootEnable   uint32 = 1 << 0
+	CritSecureDebugDisable uint32 = 1 << 1
+	CritDebugDisable       uint32 = 1 << 2
+	CritDefaultArchSel     uint32 = 1 << 3
+	CritGlitchDetectorEna  uint32 = 1 << 4
+	CritGlitchDetectorSens uint32 = 3 << 5
+	CritARMDisable         uint32 = 1 << 16
+	CritRISCVDisable       uint32 = 1 << 17
+)
+
+// ChipID returns the 64-bit unique chip identifier.
+func (si *SysInfo) ChipID() uint64 {
+	return uint64(si.DeviceID)<<32 | uint64(si.WaferID)
+}
+
+// FlashSize returns the size of the flash device connected to the chip
+// select cs (0 or 1) according to the FlashDevInfo field. It returns 0 if
+// there is no device or its size is unknown.
+func (si *SysInfo) FlashSize(cs int) int {
+	n := si.FlashDevInfo >> (8 + 4*uint(cs&1)) & 0xf
+	if n == 0 {
+		return 0
+	}
+	return 4096 << n
+}
+
+// SysInfo reads the system information selected by flags (ChipInfo, Critical,
+// CPUInfo, FlashDevInfo, BootRandom, BootInfo) using the GetInfo command
+// (RP2350 only).
+func (c *Conn) SysInfo(flags uint32) (si *SysInfo, err error) {
+	flags &= ChipInfo | Critical | CPUInfo | FlashDevInfo | BootRandom | BootInfo
+	var buf [2 + 3 + 1 + 1 + 1 + 4 + 4]uint32
+	if err = c.GetInfo(buf[:], InfoSys, flags); err != nil {
+		return
+	}
+	defer wrapErr("SysInfo", &err)
+	n := int(buf[0])
+	if n < 1 || n > len(buf)-1 {
+		return nil, errors.New("bad response")
+	}
+	w := buf[1 : 1+n]
+	si = &SysInfo{Flags: w[0]}
+	w = w[1:]
+	next := func(m int) []uint32 {
+		if len(w) < m {
+			err = errors.New("short response")
+			return make([]uint32, m)
+		}
+		p := w[:m]
+		w = w[m:]
+		return p
+	}
+	if si.Flags&ChipInfo != 0 {
+		p := next(3)
+		si.PackageSel, si.DeviceID, si.WaferID = p[0], p[1], p[2]
+	}
+	if si.Flags&Critical != 0 {
+		si.Critical = next(1)[0]
+	}
+	if si.Flags&CPUInfo != 0 {
+		si.CPU = next(1)[0]
+	}
+	if si.Flags&FlashDevInfo != 0 {
+		si.FlashDevInfo = next(1)[0]
+	}
+	if si.Flags&BootRandom != 0 {
+		copy(si.BootRandom[:], next(4))
+	}
+	if si.Flags&BootInfo != 0 {
+		p := next(4)
+		bi := &si.Boot
+		bi.DiagnosticPartition = int8(p[0])
+		bi.Type = uint8(p[0] >> 8)
+		bi.Partition = int8(p[0] >> 16)
+		bi.TBYBAndUpdateInfo = uint8(p[0] >> 24)
+		bi.Diagnostic = p[1]
+		bi.RebootParams = [2]uint32{p[2], p[3]}
+	}
+	return
+}
@@ -0,0 +1,549 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package stlink implements the host side of the ST-LINK/V2, V2-1 and V3 USB
+// protocol in the SWD mode. It provides the access to the target memory, the
+// Conn implements the cortexm.Mem interface.
+package stlink
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/embeddedgo/tools/egtool/internal/usbdev"
+	usb "github.com/google/gousb"
+)
+
+const Vendor usb.ID = 0x0483
+
+// Product IDs
+const (
+	ProductV2    usb.ID = 0x3748
+	ProductV21   usb.ID = 0x374b
+	ProductV21NM usb.ID = 0x3752 // V2-1 without mass storage
+	ProductV3E   usb.ID = 0x374e
+	ProductV3    usb.ID = 0x374f
+	ProductV3NM  usb.ID = 0x3753 // V3 without mass storage
+	ProductV3PWR usb.ID = 0x3757
+)
+
+var products = []usb.ID{
+	ProductV2, ProductV21, ProductV21NM, ProductV3E, ProductV3, ProductV3NM,
+	ProductV3PWR,
+}
+
+func isSTLink(desc *usb.DeviceDesc) bool {
+	return slices.Contains(products, desc.Product)
+}
+
+// Commands
+const (
+	cmdGetVersion     uint8 = 0xf1
+	cmdDebug          uint8 = 0xf2
+	cmdDFU            uint8 = 0xf3
+	cmdGetCurrentMode uint8 = 0xf5
+	cmdGetVersionV3   uint8 = 0xfb
+
+	dfuExit uint8 = 0x07
+
+	debugReadMem32   uint8 = 0x07
+	debugWriteMem32  uint8 = 0x08
+	debugReadMem8    uint8 = 0x0c
+	debugWriteMem8   uint8 = 0x0d
+	debugExit        uint8 = 0x21
+	debugEnter       uint8 = 0x30
+	debugReadIDCodes uint8 = 0x31
+	debugDriveNRST   uint8 = 0x3c
+	debugLastStatus2 uint8 = 0x3e
+	debugSWDSetFreq  uint8 = 0x43
+	debugReadMem16   uint8 = 0x47
+	debugWriteMem16  uint8 = 0x48
+	debugSetComFreq  uint8 = 0x61
+
+	enterSWD uint8 = 0xa3
+)
+
+// Modes (GetCurrentMode)
+const (
+	ModeDFU        = 0x00
+	ModeMass       = 0x01
+	ModeDebug      = 0x02
+	ModeSWIM       = 0x03
+	ModeBootloader = 0x04
+)
+
+type Error struct {
+	Op  string
+	Err error
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Error() string {
+	return "stlink: " + e.Op + ": " + e.Err.Error()
+}
+
+func wrapErr(op string, err *error) {
+	if *err != nil {
+		*err = &Error{op, *err}
+	}
+}
+
+// ErrNotFound is returned by Connect if there is no ST-LINK probe on the USB
+// bus.
+var ErrNotFound = errors.New("no ST-LINK probes were found")
+
+// Status codes
+const (
+	statusOK                    = 0x80
+	statusFault                 = 0x81
+	statusSWDAPWait             = 0x10
+	statusSWDAPFault            = 0x11
+	statusSWDAPError            = 0x12
+	statusSWDAPParity           = 0x13
+	statusSWDDPWait             = 0x14
+	statusSWDDPFault            = 0x15
+	statusSWDDPError            = 0x16
+	statusSWDDPParity           = 0x17
+	statusSWDAPWDataErr         = 0x18
+	statusSWDAPStickyErr        = 0x19
+	statusSWDAPStickyOverrunErr = 0x1a
+)
+
+var statusNames = map[uint8]string{
+	statusFault:                 "FAULT",
+	statusSWDAPWait:             "SWD_AP_WAIT",
+	statusSWDAPFault:            "SWD_AP_FAULT",
+	statusSWDAPError:            "SWD_AP_ERROR",
+	statusSWDAPParity:           "SWD_AP_PARITY_ERROR",
+	statusSWDDPWait:             "SWD_DP_WAIT",
+	statusSWDDPFault:            "SWD_DP_FAULT",
+	statusSWDDPError:            "SWD_DP_ERROR",
+	statusSWDDPParity:           "SWD_DP_PARITY_ERROR",
+	statusSWDAPWDataErr:         "SWD_AP_WDATA_ERROR",
+	statusSWDAPStickyErr:        "SWD_AP_STICKY_ERROR",
+	statusSWDAPStickyOverrunErr: "SWD_AP_STICKYORUN_ERROR",
+}
+
+// StatusError describes the error status reported by the probe.
+type StatusError struct {
+	Status uint8
+	Addr   uint32 // fault address (if reported)
+}
+
+func (e *StatusError) Error() string {
+	name, ok := statusNames[e.Status]
+	if !ok {
+		name = fmt.Sprintf("error %#02x", e.Status)
+	}
+	if e.Addr != 0 {
+		return fmt.Sprintf("%s at %#08x", name, e.Addr)
+	}
+	return name
+}
+
+// ErrorCode returns the name of the status code with the STLINK_ prefix (e.g.
+// STLINK_SWD_AP_FAULT).
+func (e *StatusError) ErrorCode() string {
+	if name, ok := statusNames[e.Status]; ok {
+		return "STLINK_" + name
+	}
+	return "STLINK_ERROR"
+}
+
+// Version describes the probe firmware.
+type Version struct {
+	STLink int // ST-LINK version (2, 3)
+	JTAG   int // JTAG/SWD firmware version
+	SWIM   int // SWIM (or MSD) version
+	Bridge int // bridge version (V3)
+}
+
+type Conn struct {
+	dev     usbdev.Device
+	intf    usbdev.Interface
+	oe      io.Writer
+	ie      io.Reader
+	cmd     [16]byte
+	ver     Version
+	blkSize int // maximum size of the 32-bit read/write transfer
+}
+
+// Connect connects to the ST-LINK probe. You can connect to the concrete
+// probe on the USB bus by providing BUS:DEV string where both BUS and DEV are
+// decimal unsigned integers. If busAddr is empty connect will try to find an
+// ST-LINK probe on the bus (it will return an error if there are more than one
+// such probes). Connect leaves the DFU/mass storage mode if necessary.
+func Connect(busAddr string) (conn *Conn, err error) {
+	defer wrapErr("Connect", &err)
+
+	devs, err := usbdev.Open(Vendor, 0, busAddr)
+	if err != nil {
+		return
+	}
+	n := 0
+	for _, d := range devs {
+		if isSTLink(d.Desc()) {
+			devs[n] = d
+			n++
+		} else {
+			d.Close()
+		}
+	}
+	devs = devs[:n]
+	if len(devs) != 1 {
+		usbdev.Close(devs)
+		if len(devs) == 0 {
+			return nil, ErrNotFound
+		}
+		return nil, errors.New("found more than one ST-LINK probe")
+	}
+	dev := devs[0]
+	var intf usbdev.Interface
+	defer func() {
+		if err != nil {
+			if intf != nil {
+				intf.Close()
+			}
+			dev.Close()
+		}
+	}()
+	// V2 uses the OUT endpoint 2, V2-1 and V3 use the OUT endpoint 1. The
+	// IN endpoint is 1 in both cases.
+	txn := 1
+	if dev.Desc().Product == ProductV2 {
+		txn = 2
+	}
+	intf, err = dev.Claim(1, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	ie, err := intf.In(1)
+	if err != nil {
+		return nil, err
+	}
+	oe, err := intf.Out(txn)
+	if err != nil {
+		return nil, err
+	}
+	conn = &Conn{dev: dev, intf: intf, oe: oe, ie: ie, blkSize: 1024}
+	if err = conn.readVersion(); err != nil {
+		return nil, err
+	}
+	if conn.ver.STLink >= 3 {
+		conn.blkSize = 4096
+	}
+	mode, err := conn.CurrentMode()
+	if err != nil {
+		return nil, err
+	}
+	switch mode {
+	case ModeDFU:
+		conn.command(cmdDFU, dfuExit)
+		err = conn.send(nil, nil)
+	case ModeDebug:
+		conn.command(cmdDebug, debugExit)
+		err = conn.send(nil, nil)
+	}
+	return
+}
+
+// List returns all ST-LINK probes.
+func List() (list []usbdev.Info, err error) {
+	list, err = usbdev.List(Vendor, 0, isSTLink)
+	wrapErr("List", &err)
+	return
+}
+
+func (c *Conn) Close() (err error) {
+	c.intf.Close()
+	err = c.dev.Close()
+	wrapErr("Close", &err)
+	return
+}
+
+// command prepares the command block.
+func (c *Conn) command(bytes ...uint8) {
+	clear(c.cmd[:])
+	copy(c.cmd[:], bytes)
+}
+
+// send sends the prepared command block followed by the data (if any) and
+// reads the response into rsp (if any).
+func (c *Conn) send(data, rsp []byte) error {
+	if _, err := c.oe.Write(c.cmd[:]); err != nil {
+		return err
+	}
+	if len(data) != 0 {
+		if _, err := c.oe.Write(data); err != nil {
+			return err
+		}
+	}
+	if len(rsp) != 0 {
+		if _, err := io.ReadFull(c.ie, rsp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// check checks the status byte of the response.
+func check(status uint8) error {
+	if status != statusOK {
+		return &StatusError{Status: status}
+	}
+	return nil
+}
+
+func (c *Conn) readVersion() error {
+	var rsp [12]byte
+	c.command(cmdGetVersion)
+	if err := c.send(nil, rsp[:6]); err != nil {
+		return err
+	}
+	v := binary.BigEndian.Uint16(rsp[:])
+	c.ver = Version{STLink: int(v >> 12), JTAG: int(v>>6) & 0x3f, SWIM: int(v) & 0x3f}
+	if c.ver.STLink >= 3 {
+		c.command(cmdGetVersionV3)
+		if err := c.send(nil, rsp[:]); err != nil {
+			return err
+		}
+		c.ver.SWIM = int(rsp[1])
+		c.ver.JTAG = int(rsp[2])
+		c.ver.Bridge = int(rsp[4])
+	}
+	return nil
+}
+
+// Version returns the probe firmware version.
+func (c *Conn) Version() Version {
+	return c.ver
+}
+
+// CurrentMode returns the current mode of the probe (see Mode* constants).
+func (c *Conn) CurrentMode() (mode int, err error) {
+	defer wrapErr("CurrentMode", &err)
+	var rsp [2]byte
+	c.command(cmdGetCurrentMode)
+	err = c.send(nil, rsp[:])
+	return int(rsp[0]), err
+}
+
+// swdFreqs maps the SWD frequencies (kHz) to the V2 divisors.
+var swdFreqs = []struct{ kHz, div int }{
+	{4000, 0}, {1800, 1}, {1200, 2}, {950, 3}, {480, 7}, {240, 15},
+	{125, 31}, {100, 40}, {50, 79}, {25, 158}, {15, 265}, {5, 798},
+}
+
+// EnterSWD sets the SWD clock frequency (the highest supported one not greater
+// than kHz) and switches the probe to the SWD debug mode.
+func (c *Conn) EnterSWD(kHz int) (err error) {
+	defer wrapErr("EnterSWD", &err)
+	var rsp [8]byte
+	// The older V2 firmware (JTAG < 22) cannot change the SWD frequency.
+	if c.ver.STLink >= 3 {
+		c.command(cmdDebug, debugSetComFreq, 0, 0)
+		binary.LittleEndian.PutUint32(c.cmd[4:], uint32(kHz))
+		if err = c.send(nil, rsp[:8]); err != nil {
+			return
+		}
+		if err = check(rsp[0]); err != nil {
+			return
+		}
+	} else if c.ver.JTAG >= 22 {
+		div := swdFreqs[len(swdFreqs)-1].div
+		for _, f := range swdFreqs {
+			if f.kHz <= kHz {
+				div = f.div
+				break
+			}
+		}
+		c.command(cmdDebug, debugSWDSetFreq, uint8(div), uint8(div>>8))
+		if err = c.send(nil, rsp[:2]); err != nil {
+			return
+		}
+		if err = check(rsp[0]); err != nil {
+			return
+		}
+	}
+	c.command(cmdDebug, debugEnter, enterSWD)
+	if err = c.send(nil, rsp[:2]); err != nil {
+		return
+	}
+	return check(rsp[0])
+}
+
+// Exit leaves the debug mode.
+func (c *Conn) Exit() (err error) {
+	defer wrapErr("Exit", &err)
+	c.command(cmdDebug, debugExit)
+	return c.send(nil, nil)
+}
+
+// IDCode returns the content of the DPIDR register of the target.
+func (c *Conn) IDCode() (id uint32, err error) {
+	defer wrapErr("IDCode", &err)
+	var rsp [12]byte
+	c.command(cmdDebug, debugReadIDCodes)
+	if err = c.send(nil, rsp[:]); err != nil {
+		return
+	}
+	if err = check(rsp[0]); err != nil {
+		return
+	}
+	return binary.LittleEndian.Uint32(rsp[4:]), nil
+}
+
+// SetReset sets the state of the target nRESET line.
+func (c *Conn) SetReset(assert bool) (err error) {
+	defer wrapErr("SetReset", &err)
+	var rsp [2]byte
+	var v uint8
+	if !assert {
+		v = 1
+	}
+	c.command(cmdDebug, debugDriveNRST, v)
+	if err = c.send(nil, rsp[:]); err != nil {
+		return
+	}
+	return check(rsp[0])
+}
+
+// lastStatus returns the status of the last memory read/write.
+func (c *Conn) lastStatus() error {
+	var rsp [12]byte
+	c.command(cmdDebug, debugLastStatus2)
+	if err := c.send(nil, rsp[:]); err != nil {
+		return err
+	}
+	if rsp[0] != statusOK {
+		return &StatusError{rsp[0], binary.LittleEndian.Uint32(rsp[4:])}
+	}
+	return nil
+}
+
+// memCmd prepares the memory read/write command.
+func (c *Conn) memCmd(cmd uint8, addr uint32, n int) {
+	c.command(cmdDebug, cmd)
+	binary.LittleEndian.PutUint32(c.cmd[2:], addr)
+	binary.LittleEndian.PutUint16(c.cmd[6:], uint16(n))
+}
+
+// readMem reads len(p) bytes using the given command and maximum transfer
+// size.
+func (c *Conn) readMem(cmd uint8, addr uint32, p []byte, maxN int) error {
+	for len(p) != 0 {
+		n := min(len(p), maxN)
+		c.memCmd(cmd, addr, n)
+		buf := p[:n]
+		if cmd == debugReadMem8 && n == 1 {
+			// The probe returns two bytes in the case of one-byte read.
+			var b [2]byte
+			if err := c.send(nil, b[:]); err != nil {
+				return err
+			}
+			buf[0] = b[0]
+		} else if err := c.send(nil, buf); err != nil {
+			return err
+		}
+		if err := c.lastStatus(); err != nil {
+			return err
+		}
+		addr += uint32(n)
+		p = p[n:]
+	}
+	return nil
+}
+
+// writeMem writes p using the given command and maximum transfer size.
+func (c *Conn) writeMem(cmd uint8, addr uint32, p []byte, maxN int) error {
+	for len(p) != 0 {
+		n := min(len(p), maxN)
+		c.memCmd(cmd, addr, n)
+		if err := c.send(p[:n], nil); err != nil {
+			return err
+		}
+		if err := c.lastStatus(); err != nil {
+			return err
+		}
+		addr += uint32(n)
+		p = p[n:]
+	}
+	return nil
+}
+
+// ReadMem8 reads len(p) bytes from the target memory.
+func (c *Conn) ReadMem8(addr uint32, p []byte) (err error) {
+	defer wrapErr("ReadMem8", &err)
+	return c.readMem(debugReadMem8, addr, p, 64)
+}
+
+// WriteMem8 writes p to the target memory.
+func (c *Conn) WriteMem8(addr uint32, p []byte) (err error) {
+	defer wrapErr("WriteMem8", &err)
+	return c.writeMem(debugWriteMem8, addr, p, 64)
+}
+
+// ReadMem16 reads len(p) half-words from the half-word aligned addr.
+func (c *Conn) ReadMem16(addr uint32, p []uint16) (err error) {
+	defer wrapErr("ReadMem16", &err)
+	if addr&1 != 0 {
+		return errors.New("unaligned address")
+	}
+	buf := make([]byte, len(p)*2)
+	if err = c.readMem(debugReadMem16, addr, buf, c.blkSize); err != nil {
+		return
+	}
+	for i := range p {
+		p[i] = binary.LittleEndian.Uint16(buf[i*2:])
+	}
+	return
+}
+
+// WriteMem16 writes len(p) half-words to the half-word aligned addr.
+func (c *Conn) WriteMem16(addr uint32, p []uint16) (err error) {
+	defer wrapErr("WriteMem16", &err)
+	if addr&1 != 0 {
+		return errors.New("unaligned address")
+	}
+	buf := make([]byte, 0, len(p)*2)
+	for _, v := range p {
+		buf = binary.LittleEndian.AppendUint16(buf, v)
+	}
+	return c.writeMem(debugWriteMem16, addr, buf, c.blkSize)
+}
+
+// ReadMem32 reads len(p) words from the word aligned addr.
+func (c *Conn) ReadMem32(addr uint32, p []uint32) (err error) {
+	defer wrapErr("ReadMem32", &err)
+	if addr&3 != 0 {
+		return errors.New("unaligned address")
+	}
+	buf := make([]byte, len(p)*4)
+	if err = c.readMem(debugReadMem32, addr, buf, c.blkSize); err != nil {
+		return
+	}
+	for i := range p {
+		p[i] = binary.LittleEndian.Uint32(buf[i*4:])
+	}
+	return
+}
+
+// WriteMem32 writes len(p) words to the word aligned addr.
+func (c *Conn) WriteMem32(addr uint32, p []uint32) (err error) {
+	defer wrapErr("WriteMem32", &err)
+	if addr&3 != 0 {
+		return errors.New("unaligned address")
+	}
+	buf := make([]byte, 0, len(p)*4)
+	for _, v := range p {
+		buf = binary.LittleEndian.AppendUint32(buf, v)
+	}
+	return c.writeMem(debugWriteMem32, addr, buf, c.blkSize)
+}
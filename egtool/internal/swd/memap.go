@@ -0,0 +1,112 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package swd
+
+import (
+	"errors"
+	"fmt"
+)
+
+// MEM-AP registers (ADIv5 offsets, ADIv6 adds 0xd00)
+const (
+	CSW = 0x00
+	TAR = 0x04
+	DRW = 0x0c
+	IDR = 0xfc
+)
+
+// CSW bits
+const (
+	CSWSize32     = 2 << 0
+	CSWAddrIncOff = 0 << 4
+	CSWAddrIncOne = 1 << 4
+	CSWDeviceEn   = 1 << 6
+	CSWDbgSwEn    = 1 << 31
+)
+
+// tarWrap is the guaranteed TAR auto-increment range (the TAR wraps at the
+// 1 KiB boundary in the worst case).
+const tarWrap = 1024
+
+// MemAP provides the access to the target memory using a MEM-AP.
+type MemAP struct {
+	dp  *DP
+	ap  uint32
+	off uint32 // offset of the MEM-AP registers (0xd00 for ADIv6)
+	idr uint32
+}
+
+// MemAP returns the MEM-AP with the given address (see DP.ReadAP). It
+// configures the AP for the 32-bit accesses with the single auto-increment.
+func (dp *DP) MemAP(ap uint32) (m *MemAP, err error) {
+	defer wrapErr("MemAP", &err)
+	m = &MemAP{dp: dp, ap: ap}
+	if dp.ADIv6() {
+		m.off = 0xd00
+	}
+	if m.idr, err = dp.ReadAP(ap, m.off+IDR); err != nil {
+		return nil, err
+	}
+	// Class 8 is the MEM-AP class (ADIv5: IDR[16:13], ADIv6 the same).
+	if m.idr == 0 || m.idr>>13&0xf != 8 {
+		return nil, fmt.Errorf("AP %#x isn't a MEM-AP (IDR=%#08x)", ap, m.idr)
+	}
+	csw, err := dp.ReadAP(ap, m.off+CSW)
+	if err != nil {
+		return nil, err
+	}
+	csw = csw&^0x37 | CSWSize32 | CSWAddrIncOne
+	if err = dp.WriteAP(ap, m.off+CSW, csw); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// IDR returns the content of the AP IDR register.
+func (m *MemAP) IDR() uint32 {
+	return m.idr
+}
+
+// DP returns the Debug Port of the MEM-AP.
+func (m *MemAP) DP() *DP {
+	return m.dp
+}
+
+// ReadMem32 reads len(p) words from the target memory starting from the
+// word-aligned address addr.
+func (m *MemAP) ReadMem32(addr uint32, p []uint32) error {
+	return m.transfer("ReadMem32", addr, p, RnW)
+}
+
+// WriteMem32 writes len(p) words to the target memory starting from the
+// word-aligned address addr.
+func (m *MemAP) WriteMem32(addr uint32, p []uint32) error {
+	return m.transfer("WriteMem32", addr, p, 0)
+}
+
+func (m *MemAP) transfer(op string, addr uint32, p []uint32, rnw uint8) (err error) {
+	defer wrapErr(op, &err)
+	if addr&3 != 0 {
+		return errors.New("unaligned address")
+	}
+	dp := m.dp
+	for len(p) != 0 {
+		n := min(len(p), int(tarWrap-addr%tarWrap)/4)
+		if err = dp.WriteAP(m.ap, m.off+TAR, addr); err != nil {
+			break
+		}
+		// TAR and DRW are in the same bank so SELECT is already set.
+		err = dp.port.TransferBlock(APnDP|rnw|DRW, p[:n])
+		if err != nil {
+			break
+		}
+		addr += uint32(n) * 4
+		p = p[n:]
+	}
+	if err != nil {
+		dp.ClearErrors()
+	}
+	return
+}
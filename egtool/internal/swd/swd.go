@@ -0,0 +1,208 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package swd implements the access to the ARM Debug Interface (ADIv5 and
+// ADIv6) Debug Port and Access Port registers over SWD and the MEM-AP based
+// access to the target memory. The SWD transfers are performed by a debug
+// probe (see the cmsisdap package).
+package swd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Port performs the SWD transfers. The request contains the APnDP, RnW bits
+// and the A[3:2] register address bits (the CMSIS-DAP transfer request
+// format).
+type Port interface {
+	// Transfer performs the sequence of transfers. For the write request
+	// data[i] contains the value to be written, for the read request data[i]
+	// receives the read value.
+	Transfer(reqs []uint8, data []uint32) error
+
+	// TransferBlock reads or writes len(data) words from/to the same
+	// register.
+	TransferBlock(req uint8, data []uint32) error
+}
+
+// Request bits
+const (
+	APnDP uint8 = 1 << 0
+	RnW   uint8 = 1 << 1
+)
+
+// DP registers
+const (
+	DPIDR    = 0x0 // read
+	ABORT    = 0x0 // write
+	CTRLSTAT = 0x4 // bank 0
+	SELECT   = 0x8 // write
+	RDBUFF   = 0xc // read
+)
+
+// CTRL/STAT bits
+const (
+	CSYSPWRUPACK = 1 << 31
+	CSYSPWRUPREQ = 1 << 30
+	CDBGPWRUPACK = 1 << 29
+	CDBGPWRUPREQ = 1 << 28
+	STICKYERR    = 1 << 5
+)
+
+// ABORT bits
+const (
+	ORUNERRCLR = 1 << 4
+	WDERRCLR   = 1 << 3
+	STKERRCLR  = 1 << 2
+	STKCMPCLR  = 1 << 1
+	DAPABORT   = 1 << 0
+)
+
+type Error struct {
+	Op  string
+	Err error
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Error() string {
+	return "swd: " + e.Op + ": " + e.Err.Error()
+}
+
+func wrapErr(op string, err *error) {
+	if *err != nil {
+		*err = &Error{op, *err}
+	}
+}
+
+// DP represents the Debug Port of the target.
+type DP struct {
+	port    Port
+	idr     uint32
+	sel     uint32
+	selOK   bool
+	reqs    []uint8
+	data    []uint32
+	version int
+}
+
+// Connect reads the DPIDR register, clears the sticky errors and powers up
+// the debug and system domains. The port must be in the SWD mode after the
+// line reset.
+func Connect(port Port) (dp *DP, err error) {
+	defer wrapErr("Connect", &err)
+	dp = &DP{port: port}
+	if dp.idr, err = dp.ReadDP(DPIDR); err != nil {
+		return nil, err
+	}
+	dp.version = int(dp.idr>>12) & 0xf
+	if dp.version == 0 {
+		return nil, fmt.Errorf("unsupported DP (DPIDR=%#08x)", dp.idr)
+	}
+	err = dp.WriteDP(ABORT, ORUNERRCLR|WDERRCLR|STKERRCLR|STKCMPCLR)
+	if err != nil {
+		return nil, err
+	}
+	if err = dp.WriteDP(SELECT, 0); err != nil {
+		return nil, err
+	}
+	dp.sel, dp.selOK = 0, true
+	err = dp.WriteDP(CTRLSTAT, CSYSPWRUPREQ|CDBGPWRUPREQ)
+	if err != nil {
+		return nil, err
+	}
+	const acks = CSYSPWRUPACK | CDBGPWRUPACK
+	for deadline := time.Now().Add(time.Second); ; {
+		cs, err := dp.ReadDP(CTRLSTAT)
+		if err != nil {
+			return nil, err
+		}
+		if cs&acks == acks {
+			break
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("debug power-up timeout")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return dp, nil
+}
+
+// IDR returns the content of the DPIDR register.
+func (dp *DP) IDR() uint32 {
+	return dp.idr
+}
+
+// Version returns the DP architecture version (DPv1, DPv2, DPv3). DPv3
+// implements ADIv6.
+func (dp *DP) Version() int {
+	return dp.version
+}
+
+// ADIv6 reports whether the DP implements ADIv6 (the APs are addressed by
+// their base addresses).
+func (dp *DP) ADIv6() bool {
+	return dp.version >= 3
+}
+
+func (dp *DP) ReadDP(reg uint8) (uint32, error) {
+	var data [1]uint32
+	err := dp.port.Transfer([]uint8{RnW | reg&0xc}, data[:])
+	return data[0], err
+}
+
+func (dp *DP) WriteDP(reg uint8, val uint32) error {
+	return dp.port.Transfer([]uint8{reg & 0xc}, []uint32{val})
+}
+
+// selectAP appends the SELECT write to the pending requests if the AP register
+// reg of the AP ap isn't selected.
+func (dp *DP) selectAP(ap, reg uint32) {
+	sel := (ap | reg) &^ 0xf
+	if dp.selOK && dp.sel == sel {
+		return
+	}
+	dp.reqs = append(dp.reqs, SELECT)
+	dp.data = append(dp.data, sel)
+	dp.sel, dp.selOK = sel, true
+}
+
+// flush performs the pending requests.
+func (dp *DP) flush() error {
+	err := dp.port.Transfer(dp.reqs, dp.data)
+	if err != nil {
+		dp.selOK = false
+	}
+	return err
+}
+
+// ReadAP reads the AP register. The ap is the AP base address (ADIv6) or the
+// APSEL<<24 value (ADIv5).
+func (dp *DP) ReadAP(ap, reg uint32) (uint32, error) {
+	dp.reqs, dp.data = dp.reqs[:0], dp.data[:0]
+	dp.selectAP(ap, reg)
+	dp.reqs = append(dp.reqs, APnDP|RnW|uint8(reg&0xc))
+	dp.data = append(dp.data, 0)
+	err := dp.flush()
+	return dp.data[len(dp.data)-1], err
+}
+
+// WriteAP writes the AP register (see ReadAP).
+func (dp *DP) WriteAP(ap, reg, val uint32) error {
+	dp.reqs, dp.data = dp.reqs[:0], dp.data[:0]
+	dp.selectAP(ap, reg)
+	dp.reqs = append(dp.reqs, APnDP|uint8(reg&0xc))
+	dp.data = append(dp.data, val)
+	return dp.flush()
+}
+
+// ClearErrors clears the sticky error flags after the failed transfer.
+func (dp *DP) ClearErrors() error {
+	dp.selOK = false
+	return dp.WriteDP(ABORT, ORUNERRCLR|WDERRCLR|STKERRCLR|STKCMPCLR)
+}
@@ -0,0 +1,203 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uf2
+
+import (
+	"bufio"
+	"bytes"
+	"debug/elf"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfoFile is the name of the file that identifies the UF2 bootloader drive.
+const InfoFile = "INFO_UF2.TXT"
+
+// Drive represents a mass-storage volume exposed by a UF2 bootloader.
+type Drive struct {
+	Path string            // mount point
+	Info map[string]string // content of the InfoFile
+}
+
+// BoardID returns the Board-ID field of the InfoFile.
+func (d *Drive) BoardID() string {
+	return d.Info["Board-ID"]
+}
+
+// boardFamilies maps the Board-ID prefixes to the family names.
+var boardFamilies = []struct {
+	prefix, family string
+}{
+	{"RPI-RP2", "rp2040"},
+	{"RP2350", "rp2350_arm_s"},
+	{"nRF52840", "nrf52840"},
+	{"nRF52833", "nrf52833"},
+	{"SAMD21", "samd21"},
+	{"SAMD51", "samd51"},
+	{"STM32F4", "stm32f4"},
+}
+
+// Family returns the UF2 family ID accepted by the bootloader. It uses the
+// Family-ID field if present or infers the family from the Board-ID.
+func (d *Drive) Family() (name string, id uint32, ok bool) {
+	if s := d.Info["Family-ID"]; s != "" {
+		for name, id = range FamilyMap {
+			if id == parseUint32(s) || strings.EqualFold(name, s) {
+				return name, id, true
+			}
+		}
+		if id = parseUint32(s); id != 0 {
+			return s, id, true
+		}
+	}
+	bid := d.BoardID()
+	for _, bf := range boardFamilies {
+		if strings.HasPrefix(bid, bf.prefix) {
+			return bf.family, FamilyMap[bf.family], true
+		}
+	}
+	return "", 0, false
+}
+
+// FamilyELF works like Family but if the bootloader accepts more than one
+// family (the RP2350 boot ROM accepts the Arm and RISC-V images) it selects
+// the one that matches the ELF file f.
+func (d *Drive) FamilyELF(f *elf.File) (name string, id uint32, ok bool) {
+	name, id, ok = d.Family()
+	if ok && strings.HasPrefix(name, "rp2350") {
+		if ef := ELFFamily(f); strings.HasPrefix(ef, "rp2350") {
+			return ef, FamilyMap[ef], true
+		}
+	}
+	return
+}
+
+func parseUint32(s string) uint32 {
+	u, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0
+	}
+	return uint32(u)
+}
+
+// OpenDrive reads the InfoFile from the directory dir.
+func OpenDrive(dir string) (*Drive, error) {
+	data, err := os.ReadFile(filepath.Join(dir, InfoFile))
+	if err != nil {
+		return nil, err
+	}
+	d := &Drive{Path: dir, Info: make(map[string]string)}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		k, v, ok := strings.Cut(sc.Text(), ":")
+		if ok {
+			d.Info[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return d, nil
+}
+
+// Drives returns all mounted UF2 bootloader drives.
+func Drives() ([]*Drive, error) {
+	dirs, err := mountPoints()
+	if err != nil {
+		return nil, err
+	}
+	var drives []*Drive
+	for _, dir := range dirs {
+		if d, err := OpenDrive(dir); err == nil {
+			drives = append(drives, d)
+		}
+	}
+	return drives, nil
+}
+
+// mountsFile lists the mounted file systems on Linux.
+var mountsFile = "/proc/self/mounts"
+
+func mountPoints() ([]string, error) {
+	switch runtime.GOOS {
+	case "linux", "android":
+		data, err := os.ReadFile(mountsFile)
+		if err != nil {
+			return nil, err
+		}
+		var dirs []string
+		for _, line := range strings.Split(string(data), "\n") {
+			fs := strings.Fields(line)
+			if len(fs) < 3 || !strings.Contains(fs[2], "fat") {
+				continue
+			}
+			dirs = append(dirs, unescapeMount(fs[1]))
+		}
+		return dirs, nil
+	case "darwin":
+		return filepath.Glob("/Volumes/*")
+	case "windows":
+		var dirs []string
+		for c := 'D'; c <= 'Z'; c++ {
+			dirs = append(dirs, string(c)+`:\`)
+		}
+		return dirs, nil
+	}
+	return nil, errors.New("uf2: looking for drives isn't supported on " + runtime.GOOS)
+}
+
+// unescapeMount decodes the octal escapes used in /proc/self/mounts.
+func unescapeMount(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			c := (s[i+1]-'0')<<6 | (s[i+2]-'0')<<3 | (s[i+3] - '0')
+			b.WriteByte(c)
+			i += 3
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// Copy writes the UF2 data to the drive as the name file and waits up to
+// timeout for the drive to disappear, which means the bootloader accepted the
+// file and restarted the device. It returns an error if the drive is still
+// present after timeout.
+func (d *Drive) Copy(name string, data []byte, timeout time.Duration) error {
+	f, err := os.Create(filepath.Join(d.Path, name))
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		// The bootloader may reboot the device before the file is closed.
+		if _, err1 := os.Stat(filepath.Join(d.Path, InfoFile)); errors.Is(err1, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	info := filepath.Join(d.Path, InfoFile)
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); {
+		if _, err := os.Stat(info); err != nil {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return errors.New("uf2: the drive " + d.Path + " didn't disappear")
+}
@@ -0,0 +1,161 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uf2
+
+import (
+	"bytes"
+	"debug/elf"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFamilyELF(t *testing.T) {
+	riscv := &elf.File{FileHeader: elf.FileHeader{
+		Class: elf.ELFCLASS32, Machine: elf.EM_RISCV,
+	}}
+	arm := &elf.File{FileHeader: elf.FileHeader{
+		Class: elf.ELFCLASS32, Machine: elf.EM_ARM,
+	}}
+	tests := []struct {
+		info string
+		f    *elf.File
+		want string
+	}{
+		{"Board-ID: RP2350", riscv, "rp2350_riscv"},
+		{"Board-ID: RP2350", arm, "rp2350_arm_s"},
+		{"Board-ID: RPI-RP2", riscv, "rp2040"},
+		{"Board-ID: nRF52840-Feather", arm, "nrf52840"},
+	}
+	for _, tc := range tests {
+		dir := t.TempDir()
+		err := os.WriteFile(
+			filepath.Join(dir, InfoFile), []byte(tc.info+"\n"), 0o644,
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := OpenDrive(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, id, ok := d.FamilyELF(tc.f)
+		if !ok || name != tc.want || id != FamilyMap[tc.want] {
+			t.Errorf(
+				"%s, %v: got %s %#x %t, want %s",
+				tc.info, tc.f.Machine, name, id, ok, tc.want,
+			)
+		}
+	}
+}
+
+// fakeDrive creates the directory that looks like the UF2 bootloader drive.
+func fakeDrive(t *testing.T, dir, info string) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	err := os.WriteFile(filepath.Join(dir, InfoFile), []byte(info), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+const rp2040Info = "UF2 Bootloader v3.0\r\nModel: Raspberry Pi RP2\r\nBoard-ID: RPI-RP2\r\n"
+
+func TestDrives(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the mounts file is used only on Linux")
+	}
+	tmp := t.TempDir()
+	pico := fakeDrive(t, filepath.Join(tmp, "RPI RP2"), rp2040Info)
+	noInfo := filepath.Join(tmp, "USB")
+	if err := os.Mkdir(noInfo, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	ext4 := fakeDrive(t, filepath.Join(tmp, "ext4"), rp2040Info)
+	mounts := filepath.Join(tmp, "mounts")
+	err := os.WriteFile(mounts, []byte(
+		"proc /proc proc rw,nosuid,nodev,noexec,relatime 0 0\n"+
+			"/dev/sda1 "+ext4+" ext4 rw,relatime 0 0\n"+
+			"/dev/sdb1 "+strings.ReplaceAll(pico, " ", `\040`)+" vfat rw,nosuid,nodev,relatime,uid=1000 0 0\n"+
+			"/dev/sdc1 "+noInfo+" msdos rw 0 0\n",
+	), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(name string) { mountsFile = name }(mountsFile)
+	mountsFile = mounts
+
+	drives, err := Drives()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drives) != 1 {
+		t.Fatalf("found %d drives, want 1", len(drives))
+	}
+	d := drives[0]
+	if d.Path != pico || d.BoardID() != "RPI-RP2" || d.Info["Model"] != "Raspberry Pi RP2" {
+		t.Errorf("got %s %q", d.Path, d.Info)
+	}
+	if name, id, ok := d.Family(); !ok || name != "rp2040" || id != FamilyMap["rp2040"] {
+		t.Errorf("family: %s %#x %t", name, id, ok)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	d, err := OpenDrive(fakeDrive(t, t.TempDir(), rp2040Info))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 0x1000_0000, FamilyIDPresent, FamilyMap["rp2040"], 1000)
+	w.Write(bytes.Repeat([]byte{0x5a}, 1000))
+	w.Flush()
+	data := buf.Bytes()
+
+	// The bootloader reads the file and restarts the device, the drive
+	// disappears.
+	got := make(chan []byte, 1)
+	go func() {
+		defer close(got)
+		name := filepath.Join(d.Path, "CURRENT.UF2")
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+			b, err := os.ReadFile(name)
+			if err != nil || len(b) < len(data) {
+				continue
+			}
+			os.Remove(filepath.Join(d.Path, InfoFile))
+			got <- b
+			return
+		}
+	}()
+	if err := d.Copy("CURRENT.UF2", data, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if b := <-got; !bytes.Equal(b, data) {
+		t.Errorf("the bootloader received %d bytes, want %d", len(b), len(data))
+	}
+}
+
+func TestCopyTimeout(t *testing.T) {
+	d, err := OpenDrive(fakeDrive(t, t.TempDir(), rp2040Info))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("UF2\n")
+	err = d.Copy("CURRENT.UF2", data, 200*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "didn't disappear") {
+		t.Fatalf("Copy error: %v, want timeout", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(d.Path, "CURRENT.UF2")); !bytes.Equal(b, data) {
+		t.Errorf("CURRENT.UF2: %q, want %q", b, data)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uf2
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"io"
+	"strings"
+)
+
+// UF2 block flags
+const (
+	NotMainFlash         = 0x00000001
+	FileContainer        = 0x00001000
+	FamilyIDPresent      = 0x00002000
+	MD5ChecksumPresent   = 0x00004000
+	ExtensionTagsPresent = 0x00008000
+)
+
+// FamilyMap maps the known family names to the UF2 family IDs.
+var FamilyMap = map[string]uint32{
+	"rp2040":        0xe48bff56,
+	"absolute":      0xe48bff57,
+	"data":          0xe48bff58,
+	"rp2350_arm_s":  0xe48bff59,
+	"rp2350_riscv":  0xe48bff5a,
+	"rp2350_arm_ns": 0xe48bff5b,
+	"nrf52840":      0xada52840,
+	"nrf52833":      0x621e937a,
+	"samd21":        0x68ed2b88,
+	"samd51":        0x55114460,
+	"stm32f4":       0x57755a57,
+}
+
+type block struct {
+	Magic0 uint32
+	Magic1 uint32
+	Flags  uint32
+	Addr   uint32
+	Len    uint32
+	Seq    uint32
+	Total  uint32
+	Family uint32
+	Data   [256]byte
+	_      [476 - 256]byte
+	Magic2 uint32
+}
+
+// Writer writes the data in the UF2 format.
+type Writer struct {
+	w io.Writer
+	b block
+}
+
+// NewWriter returns a new Writer that writes size bytes of data starting from
+// the addr address to the w.
+func NewWriter(w io.Writer, addr, flags, family uint32, size int) *Writer {
+	u := new(Writer)
+	u.w = w
+	u.b.Magic0 = 0x0a324655
+	u.b.Magic1 = 0x9e5d5157
+	u.b.Flags = flags
+	u.b.Addr = addr
+	u.b.Total = uint32((size + len(u.b.Data) - 1) / len(u.b.Data))
+	u.b.Family = family
+	u.b.Magic2 = 0x0ab16f30
+	return u
+}
+
+func (u *Writer) Write(p []byte) (n int, err error) {
+	b := &u.b
+	for len(p) != 0 {
+		m := copy(b.Data[b.Len:], p)
+		n += m
+		p = p[m:]
+		b.Len += uint32(m)
+		if int(b.Len) == len(b.Data) {
+			err = binary.Write(u.w, binary.LittleEndian, b)
+			if err != nil {
+				return
+			}
+			b.Addr += b.Len
+			b.Seq++
+			b.Len = 0
+		}
+	}
+	return
+}
+
+// Flush writes the last incomplete block.
+func (u *Writer) Flush() (err error) {
+	b := &u.b
+	if b.Len == 0 {
+		return
+	}
+	clear(b.Data[b.Len:])
+	b.Len = uint32(len(b.Data))
+	err = binary.Write(u.w, binary.LittleEndian, b)
+	b.Addr += b.Len
+	b.Seq++
+	b.Len = 0
+	return
+}
+
+// ELFFamily tries to determine the family name of the program in the ELF file.
+// It returns an empty string if the family is unknown.
+func ELFFamily(f *elf.File) string {
+	switch f.Machine {
+	case elf.EM_RISCV:
+		if f.Class == elf.ELFCLASS32 {
+			return "rp2350_riscv" // the only supported RISC-V family
+		}
+		return ""
+	case elf.EM_ARM:
+		syms, err := f.Symbols()
+		if err != nil {
+			return ""
+		}
+		for _, s := range syms {
+			switch {
+			case s.Name == "picometa",
+				strings.HasPrefix(s.Name, "github.com/embeddedgo/pico/"):
+				return "rp2350_arm_s"
+			case strings.HasPrefix(s.Name, "github.com/embeddedgo/rp2040/"):
+				return "rp2040"
+			}
+		}
+	}
+	return ""
+}
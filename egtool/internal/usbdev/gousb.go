@@ -0,0 +1,113 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package usbdev
+
+import (
+	"io"
+	"sync/atomic"
+
+	usb "github.com/google/gousb"
+)
+
+// context is the libusb context shared by the devices opened together. It is
+// closed when the last device is closed.
+type context struct {
+	ctx  *usb.Context
+	refs atomic.Int32
+}
+
+func (c *context) release() {
+	if c.refs.Add(-1) == 0 {
+		c.ctx.Close()
+	}
+}
+
+// device implements Device using gousb.
+type device struct {
+	ctx *context
+	dev *usb.Device
+}
+
+// openUSB opens the matching devices. The devices that cannot be opened (e.g.
+// because of the missing permissions) are skipped. The error is returned only
+// if no device was opened.
+func openUSB(match func(desc *usb.DeviceDesc) bool) ([]Device, error) {
+	ctx := usb.NewContext()
+	udevs, err := ctx.OpenDevices(match)
+	if len(udevs) == 0 {
+		ctx.Close()
+		return nil, err
+	}
+	c := &context{ctx: ctx}
+	c.refs.Store(int32(len(udevs)))
+	devs := make([]Device, len(udevs))
+	for i, d := range udevs {
+		devs[i] = &device{c, d}
+	}
+	return devs, nil
+}
+
+func (d *device) Desc() *usb.DeviceDesc {
+	return d.dev.Desc
+}
+
+func (d *device) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	return d.dev.Control(rType, request, val, idx, data)
+}
+
+func (d *device) Product() (string, error) {
+	return d.dev.Product()
+}
+
+func (d *device) SerialNumber() (string, error) {
+	return d.dev.SerialNumber()
+}
+
+func (d *device) InterfaceDescription(cfg, intf, alt int) (string, error) {
+	return d.dev.InterfaceDescription(cfg, intf, alt)
+}
+
+func (d *device) Claim(cfg, intf, alt int) (Interface, error) {
+	d.dev.SetAutoDetach(true)
+	c, err := d.dev.Config(cfg)
+	if err != nil {
+		return nil, err
+	}
+	i, err := c.Interface(intf, alt)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return &iface{c, i}, nil
+}
+
+func (d *device) Reset() error {
+	return d.dev.Reset()
+}
+
+func (d *device) Close() error {
+	err := d.dev.Close()
+	d.ctx.release()
+	return err
+}
+
+// iface implements Interface using gousb.
+type iface struct {
+	cfg  *usb.Config
+	intf *usb.Interface
+}
+
+func (i *iface) In(ep int) (io.Reader, error) {
+	return i.intf.InEndpoint(ep)
+}
+
+func (i *iface) Out(ep int) (io.Writer, error) {
+	return i.intf.OutEndpoint(ep)
+}
+
+func (i *iface) Close() error {
+	i.intf.Close()
+	return i.cfg.Close()
+}
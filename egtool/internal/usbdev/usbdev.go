@@ -0,0 +1,184 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package usbdev provides the USB device transport used by the bootloader
+// clients (dfu, picoboot, halfkay). The devices are real ones, accessed using
+// libusb, or simulated ones attached to the virtual bus using Attach.
+package usbdev
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	usb "github.com/google/gousb"
+)
+
+// Device is an opened USB device.
+type Device interface {
+	// Desc returns the device descriptor.
+	Desc() *usb.DeviceDesc
+
+	// Control performs a control transfer on the default endpoint.
+	Control(rType, request uint8, val, idx uint16, data []byte) (int, error)
+
+	// Product returns the product string of the device.
+	Product() (string, error)
+
+	// SerialNumber returns the serial number of the device.
+	SerialNumber() (string, error)
+
+	// InterfaceDescription returns the interface string descriptor.
+	InterfaceDescription(cfg, intf, alt int) (string, error)
+
+	// Claim selects the configuration and claims the interface with the
+	// given alternate setting, detaching the kernel driver if necessary.
+	Claim(cfg, intf, alt int) (Interface, error)
+
+	// Reset performs the USB port reset.
+	Reset() error
+
+	Close() error
+}
+
+// Interface is a claimed interface.
+type Interface interface {
+	// In returns the bulk/interrupt IN endpoint with the given number.
+	In(ep int) (io.Reader, error)
+
+	// Out returns the bulk/interrupt OUT endpoint with the given number.
+	Out(ep int) (io.Writer, error)
+
+	Close() error
+}
+
+var (
+	simMu sync.Mutex
+	sims  []Device
+	simOn bool // the virtual bus replaces the real one
+)
+
+// Attach attaches the simulated device to the virtual bus. The device will be
+// returned by Open and List. The Close method of the simulated device is
+// called every time the device returned by Open is closed so it must not
+// detach the device. After the first call of Attach Open and List use only the
+// virtual bus, even if all simulated devices were detached from it.
+func Attach(d Device) {
+	simMu.Lock()
+	sims = append(sims, d)
+	simOn = true
+	simMu.Unlock()
+}
+
+// Detach detaches the simulated device from the virtual bus.
+func Detach(d Device) {
+	simMu.Lock()
+	sims = slices.DeleteFunc(sims, func(s Device) bool { return s == d })
+	simMu.Unlock()
+}
+
+// Attached reports whether the simulated device is attached to the virtual
+// bus.
+func Attached(d Device) bool {
+	simMu.Lock()
+	defer simMu.Unlock()
+	return slices.Contains(sims, d)
+}
+
+func parseBusAddr(busAddr string) (int, int) {
+	s := strings.Split(busAddr, ":")
+	if len(s) != 2 {
+		return -1, -1
+	}
+	bus, err := strconv.ParseUint(s[0], 10, 8)
+	if err != nil {
+		return -1, -1
+	}
+	dev, err := strconv.ParseUint(s[1], 10, 8)
+	if err != nil {
+		return -1, -1
+	}
+	return int(bus), int(dev)
+}
+
+// BusAddr returns the BUS:ADDR location of the device.
+func BusAddr(desc *usb.DeviceDesc) string {
+	return strconv.Itoa(desc.Bus) + ":" + strconv.Itoa(desc.Address)
+}
+
+// Open opens all USB devices with the given vendor and product ID. The zero
+// vendor or product ID matches any ID. If busAddr isn't empty it selects the
+// device by its BUS:ADDR location. If the virtual bus is in use (see Attach)
+// Open returns only the simulated devices.
+func Open(vendor, product usb.ID, busAddr string) (devs []Device, err error) {
+	return OpenMatch(vendor, product, busAddr, nil)
+}
+
+// OpenMatch works like Open but opens only the devices that also satisfy
+// match (nil matches any device). The descriptor is checked before the device
+// is opened so the devices that the user has no access to can be skipped. The
+// devices that cannot be opened are ignored. OpenMatch returns an error only
+// if no device was opened.
+func OpenMatch(vendor, product usb.ID, busAddr string, match func(desc *usb.DeviceDesc) bool) (devs []Device, err error) {
+	bus, addr := parseBusAddr(busAddr)
+	if busAddr != "" && bus < 0 {
+		return nil, errors.New("bad USB device address: " + busAddr)
+	}
+	sel := func(desc *usb.DeviceDesc) bool {
+		if bus >= 0 && (desc.Bus != bus || desc.Address != addr) {
+			return false
+		}
+		if vendor != 0 && desc.Vendor != vendor {
+			return false
+		}
+		if product != 0 && desc.Product != product {
+			return false
+		}
+		return match == nil || match(desc)
+	}
+	simMu.Lock()
+	if simOn {
+		for _, d := range sims {
+			if sel(d.Desc()) {
+				devs = append(devs, d)
+			}
+		}
+		simMu.Unlock()
+		return
+	}
+	simMu.Unlock()
+	return openUSB(sel)
+}
+
+// Close closes all devices.
+func Close(devs []Device) {
+	for _, d := range devs {
+		d.Close()
+	}
+}
+
+// Info describes a USB device found by List.
+type Info struct {
+	BusAddr string // BUS:ADDR location
+	Serial  string // serial number (empty if not available)
+}
+
+// List returns the location and the serial number of all USB devices with
+// the given vendor and product ID that satisfy match. The zero vendor or
+// product ID matches any ID, the nil match matches any device.
+func List(vendor, product usb.ID, match func(desc *usb.DeviceDesc) bool) (list []Info, err error) {
+	devs, err := OpenMatch(vendor, product, "", match)
+	if err != nil {
+		return
+	}
+	for _, d := range devs {
+		sn, _ := d.SerialNumber()
+		list = append(list, Info{BusAddr(d.Desc()), sn})
+		d.Close()
+	}
+	return
+}
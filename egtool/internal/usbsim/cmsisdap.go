@@ -0,0 +1,392 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package usbsim
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/embeddedgo/tools/egtool/internal/usbdev"
+	usb "github.com/google/gousb"
+)
+
+// Target is the memory of the target microcontroller as seen by the debug
+// probe through the MEM-AP. The read and write methods report false on the bus
+// fault.
+type Target interface {
+	Read32(addr uint32) (uint32, bool)
+	Write32(addr, val uint32) bool
+}
+
+// APTarget is implemented by the Target that has other access ports than the
+// AHB-AP (APSEL 0), e.g. the nRF52 CTRL-AP. AccessAP reads or writes the
+// register (including the bank select bits) of the access port.
+type APTarget interface {
+	AccessAP(apsel, reg uint32, read bool, val uint32) (uint32, bool)
+}
+
+// CMSIS-DAP commands
+const (
+	dapInfo              = 0x00
+	dapHostStatus        = 0x01
+	dapConnect           = 0x02
+	dapDisconnect        = 0x03
+	dapTransferConfigure = 0x04
+	dapTransfer          = 0x05
+	dapTransferBlock     = 0x06
+	dapResetTarget       = 0x0a
+	dapSWJPins           = 0x10
+	dapSWJClock          = 0x11
+	dapSWJSequence       = 0x12
+	dapSWDConfigure      = 0x13
+)
+
+const (
+	dapPacketSize = 512
+	dapDPIDR      = 0x2ba0_1477 // ADIv5 DPv1 (Cortex-M4)
+	dapAHBAPIDR   = 0x2477_0011 // AHB-AP
+	swdAckOK      = 1
+	swdAckFault   = 4
+)
+
+// DAP simulates a CMSIS-DAP v2 debug probe (USB ID 0d28:0204) connected over
+// SWD to a target that has the ADIv5 DP and the AHB-AP (APSEL 0). The AP
+// gives access to the Target memory. The accesses to the other APs are
+// forwarded to the Target if it implements APTarget. Otherwise they behave
+// like the accesses to the non-existent AP (the reads return zero).
+type DAP struct {
+	device
+
+	Target Target
+
+	// Connected is set after the DAP_Connect command.
+	Connected bool
+
+	// Clock is the SWD clock frequency set by the host.
+	Clock int
+
+	rsp      []byte
+	ctrlStat uint32
+	sel      uint32
+	csw      uint32
+	tar      uint32
+	rdbuff   uint32
+	sticky   bool
+}
+
+// NewDAP returns a simulated debug probe connected to the target.
+func NewDAP(target Target) *DAP {
+	d := &DAP{Target: target}
+	bulk := func(addr usb.EndpointAddress) usb.EndpointDesc {
+		dir := usb.EndpointDirectionOut
+		if addr&0x80 != 0 {
+			dir = usb.EndpointDirectionIn
+		}
+		return usb.EndpointDesc{
+			Address: addr, Number: int(addr & 0x0f), Direction: dir,
+			MaxPacketSize: dapPacketSize, TransferType: usb.TransferTypeBulk,
+		}
+	}
+	d.init(d, 0x0d28, 0x0204, 0x0100, usb.ConfigDesc{
+		Number: 1,
+		Interfaces: []usb.InterfaceDesc{{
+			Number: 0,
+			AltSettings: []usb.InterfaceSetting{{
+				Number: 0, Class: usb.ClassVendorSpec,
+				Endpoints: map[usb.EndpointAddress]usb.EndpointDesc{
+					0x01: bulk(0x01), 0x81: bulk(0x81),
+				},
+			}},
+		}},
+	})
+	d.names[[3]int{1, 0, 0}] = "CMSIS-DAP v2 Interface"
+	d.product = "Sim CMSIS-DAP"
+	return d
+}
+
+func (d *DAP) Claim(cfg, intf, alt int) (usbdev.Interface, error) {
+	if err := d.claim(cfg, intf, alt); err != nil {
+		return nil, err
+	}
+	return dapIntf{d}, nil
+}
+
+// Reset simulates the USB reset.
+func (d *DAP) Reset() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.gone() {
+		return usb.ErrorNoDevice
+	}
+	d.rsp = nil
+	return nil
+}
+
+func (d *DAP) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	return 0, usb.ErrorPipe
+}
+
+type dapIntf struct {
+	d *DAP
+}
+
+func (i dapIntf) In(ep int) (io.Reader, error) {
+	if ep != 1 {
+		return nil, usb.ErrorNotFound
+	}
+	return dapIn{i.d}, nil
+}
+
+func (i dapIntf) Out(ep int) (io.Writer, error) {
+	if ep != 1 {
+		return nil, usb.ErrorNotFound
+	}
+	return dapOut{i.d}, nil
+}
+
+func (i dapIntf) Close() error {
+	return nil
+}
+
+type dapIn struct {
+	d *DAP
+}
+
+func (e dapIn) Read(p []byte) (int, error) {
+	d := e.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.gone() {
+		return 0, usb.ErrorNoDevice
+	}
+	if d.rsp == nil {
+		return 0, usb.ErrorTimeout
+	}
+	n := copy(p, d.rsp)
+	d.rsp = nil
+	return n, nil
+}
+
+type dapOut struct {
+	d *DAP
+}
+
+func (e dapOut) Write(p []byte) (int, error) {
+	d := e.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.gone() {
+		return 0, usb.ErrorNoDevice
+	}
+	if len(p) == 0 || len(p) > dapPacketSize {
+		return 0, usb.ErrorPipe
+	}
+	d.rsp = d.command(p)
+	return len(p), nil
+}
+
+// command executes the DAP command and returns the response.
+func (d *DAP) command(p []byte) []byte {
+	le := binary.LittleEndian
+	cmd, args := p[0], p[1:]
+	rsp := []byte{cmd}
+	const dapOK, dapError = 0x00, 0xff
+	switch cmd {
+	case dapInfo:
+		if len(args) < 1 {
+			return append(rsp, 0)
+		}
+		switch args[0] {
+		case 0x01:
+			return append(append(rsp, 4), "Sim\x00"...)
+		case 0x02:
+			return append(append(rsp, 8), "SimDAP2\x00"...)
+		case 0x04:
+			return append(append(rsp, 6), "2.1.0\x00"...)
+		case 0xf0:
+			return append(rsp, 1, 0x01) // SWD
+		case 0xfe:
+			return append(rsp, 1, 4)
+		case 0xff:
+			return le.AppendUint16(append(rsp, 2), dapPacketSize)
+		}
+		return append(rsp, 0)
+	case dapHostStatus, dapTransferConfigure, dapSWDConfigure:
+		return append(rsp, dapOK)
+	case dapConnect:
+		if len(args) < 1 || args[0] > 1 {
+			return append(rsp, 0)
+		}
+		d.Connected = true
+		return append(rsp, 1)
+	case dapDisconnect:
+		d.Connected = false
+		return append(rsp, dapOK)
+	case dapSWJClock:
+		if len(args) < 4 {
+			return append(rsp, dapError)
+		}
+		d.Clock = int(le.Uint32(args))
+		return append(rsp, dapOK)
+	case dapSWJSequence:
+		// Any sequence ends with the line reset or idle cycles here.
+		d.sel, d.sticky = 0, false
+		return append(rsp, dapOK)
+	case dapSWJPins:
+		return append(rsp, 0x80)
+	case dapResetTarget:
+		return append(rsp, dapOK, 0)
+	case dapTransfer:
+		return d.transfer(rsp, args)
+	case dapTransferBlock:
+		return d.transferBlock(rsp, args)
+	}
+	return []byte{0xff}
+}
+
+// transfer executes the DAP_Transfer command.
+func (d *DAP) transfer(rsp, args []byte) []byte {
+	le := binary.LittleEndian
+	if !d.Connected || len(args) < 2 {
+		return append(rsp, 0, 0)
+	}
+	n, args := int(args[1]), args[2:]
+	rsp = append(rsp, 0, 0)
+	done, ack := 0, uint8(swdAckOK)
+	for ; done < n && len(args) > 0; done++ {
+		req := args[0]
+		args = args[1:]
+		var val uint32
+		if req&0x02 == 0 {
+			if len(args) < 4 {
+				break
+			}
+			val, args = le.Uint32(args), args[4:]
+		}
+		v, ok := d.access(req, val)
+		if !ok {
+			ack = swdAckFault
+			break
+		}
+		if req&0x02 != 0 {
+			rsp = le.AppendUint32(rsp, v)
+		}
+	}
+	rsp[1], rsp[2] = uint8(done), ack
+	return rsp
+}
+
+// transferBlock executes the DAP_TransferBlock command.
+func (d *DAP) transferBlock(rsp, args []byte) []byte {
+	le := binary.LittleEndian
+	if !d.Connected || len(args) < 4 {
+		return append(rsp, 0, 0, 0)
+	}
+	n, req, args := int(le.Uint16(args[1:])), args[3], args[4:]
+	rsp = append(rsp, 0, 0, 0)
+	done, ack := 0, uint8(swdAckOK)
+	for ; done < n; done++ {
+		var val uint32
+		if req&0x02 == 0 {
+			if len(args) < 4 {
+				break
+			}
+			val, args = le.Uint32(args), args[4:]
+		}
+		v, ok := d.access(req, val)
+		if !ok {
+			ack = swdAckFault
+			break
+		}
+		if req&0x02 != 0 {
+			rsp = le.AppendUint32(rsp, v)
+		}
+	}
+	le.PutUint16(rsp[1:], uint16(done))
+	rsp[3] = ack
+	return rsp
+}
+
+// access performs the single DP/AP register access.
+func (d *DAP) access(req uint8, val uint32) (uint32, bool) {
+	read, reg := req&0x02 != 0, uint32(req&0x0c)
+	if d.sticky && !(req&0x01 == 0 && (reg == 0 || reg == 0x4 && read)) {
+		return 0, false // only ABORT and CTRL/STAT accesses are allowed
+	}
+	if req&0x01 == 0 { // DP
+		switch {
+		case reg == 0x0 && read:
+			return dapDPIDR, true
+		case reg == 0x0:
+			if val&0x04 != 0 {
+				d.sticky = false
+			}
+		case reg == 0x4 && read:
+			cs := d.ctrlStat
+			cs |= cs & (1<<28 | 1<<30) << 1 // power-up ACKs
+			if d.sticky {
+				cs |= 1 << 5
+			}
+			return cs, true
+		case reg == 0x4:
+			d.ctrlStat = val &^ 0xa000_0000
+		case reg == 0x8 && !read:
+			d.sel = val
+		case reg == 0xc && read:
+			return d.rdbuff, true
+		}
+		return 0, true
+	}
+	// AP
+	if d.ctrlStat&(1<<28) == 0 {
+		d.sticky = true
+		return 0, false
+	}
+	var v uint32
+	ok := true
+	if apsel := d.sel >> 24; apsel == 0 {
+		v, ok = d.apAccess(d.sel&0xf0|reg, read, val)
+	} else if t, _ := d.Target.(APTarget); t != nil {
+		v, ok = t.AccessAP(apsel, d.sel&0xf0|reg, read, val)
+	}
+	if !ok {
+		d.sticky = true
+	}
+	d.rdbuff = v
+	return v, ok
+}
+
+func (d *DAP) apAccess(reg uint32, read bool, val uint32) (uint32, bool) {
+	switch reg {
+	case 0x00: // CSW
+		if read {
+			return d.csw | 1<<6, true // DeviceEn
+		}
+		d.csw = val
+	case 0x04: // TAR
+		if read {
+			return d.tar, true
+		}
+		d.tar = val
+	case 0x0c: // DRW
+		var v uint32
+		ok := true
+		if read {
+			v, ok = d.Target.Read32(d.tar &^ 3)
+		} else {
+			ok = d.Target.Write32(d.tar&^3, val)
+		}
+		if d.csw&0x30 == 0x10 {
+			// Single auto-increment wraps at the 1 KiB boundary.
+			d.tar = d.tar&^0x3ff | (d.tar+4)&0x3ff
+		}
+		return v, ok
+	case 0xfc: // IDR
+		if read {
+			return dapAHBAPIDR, true
+		}
+	}
+	return 0, true
+}
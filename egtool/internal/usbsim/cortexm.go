@@ -0,0 +1,105 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package usbsim
+
+// Cortex-M debug registers
+const (
+	scsCPUID = 0xe000_ed00
+	scsAIRCR = 0xe000_ed0c
+	scsDFSR  = 0xe000_ed30
+	scsDHCSR = 0xe000_edf0
+	scsDCRSR = 0xe000_edf4
+	scsDCRDR = 0xe000_edf8
+	scsDEMCR = 0xe000_edfc
+)
+
+// scs emulates the Cortex-M core debug registers good enough to halt, reset
+// and access the core registers. It doesn't execute any code.
+type scs struct {
+	cpuid uint32
+
+	// Halted reports whether the core is halted.
+	Halted bool
+
+	// Resets counts the system resets requested using AIRCR.
+	Resets int
+
+	Regs [19]uint32 // core registers (R0-R15, xPSR, MSP, PSP)
+
+	debugEn bool
+	demcr   uint32
+	dcrdr   uint32
+}
+
+// read reads the debug register. It reports false if addr isn't a debug
+// register.
+func (s *scs) read(addr uint32) (uint32, bool) {
+	switch addr {
+	case scsCPUID:
+		return s.cpuid, true
+	case scsDHCSR:
+		v := uint32(1 << 16) // S_REGRDY
+		if s.debugEn {
+			v |= 1 << 0
+		}
+		if s.Halted {
+			v |= 1<<17 | 1<<1
+		}
+		return v, true
+	case scsDCRDR:
+		return s.dcrdr, true
+	case scsDEMCR:
+		return s.demcr, true
+	case scsAIRCR:
+		return 0xfa05_0000, true
+	case scsDFSR:
+		return 0, true
+	}
+	return 0, false
+}
+
+// write writes the debug register. It reports false if addr isn't a debug
+// register.
+func (s *scs) write(addr, val uint32) bool {
+	switch addr {
+	case scsDHCSR:
+		if val>>16 != 0xa05f {
+			return true
+		}
+		s.debugEn = val&1 != 0
+		s.Halted = s.debugEn && val&2 != 0
+	case scsDCRSR:
+		n := val & 0x7f
+		if int(n) >= len(s.Regs) {
+			return true
+		}
+		if val&(1<<16) != 0 {
+			s.Regs[n] = s.dcrdr
+		} else {
+			s.dcrdr = s.Regs[n]
+		}
+	case scsDCRDR:
+		s.dcrdr = val
+	case scsDEMCR:
+		s.demcr = val
+	case scsAIRCR:
+		if val == 0x05fa_0004 {
+			s.Resets++
+			s.Halted = s.debugEn && s.demcr&1 != 0
+		}
+	case scsDFSR:
+	default:
+		return false
+	}
+	return true
+}
+
+func le32(p []byte) uint32 {
+	return uint32(p[0]) | uint32(p[1])<<8 | uint32(p[2])<<16 | uint32(p[3])<<24
+}
+
+func putLE32(p []byte, v uint32) {
+	p[0], p[1], p[2], p[3] = byte(v), byte(v>>8), byte(v>>16), byte(v>>24)
+}
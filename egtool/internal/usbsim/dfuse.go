@@ -0,0 +1,369 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package usbsim
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/usbdev"
+	usb "github.com/google/gousb"
+)
+
+// DFU states and status codes
+const (
+	dfuIdle         = 2
+	dfuDnloadSync   = 3
+	dfuDnbusy       = 4
+	dfuDnloadIdle   = 5
+	dfuManifestSync = 6
+	dfuManifest     = 7
+	dfuUploadIdle   = 9
+	dfuError        = 10
+
+	errTarget     = 0x01
+	errVerify     = 0x07
+	errAddress    = 0x08
+	errNotDone    = 0x09
+	errStalledPkt = 0x0f
+)
+
+const dfuseTransSize = 2048 // wTransferSize
+
+// DfuSe commands (block 0 downloads)
+const (
+	dfuseGetCommands   = 0x00
+	dfuseSetAddress    = 0x21
+	dfuseErase         = 0x41
+	dfuseReadUnprotect = 0x92
+)
+
+// STM32 simulates an STM32 microcontroller in the system memory DfuSe
+// bootloader mode (USB ID 0483:df11). The internal flash is available as the
+// alternate setting 0, the second alternate setting describes the option bytes
+// but doesn't support any operation. The data block n is written at the address
+// pointer + (n-2)*size where size is the length of the first data block.
+type STM32 struct {
+	device
+
+	Flash *Flash
+
+	// PollTimeout is the bwPollTimeout reported by the device in the
+	// dfuDNBUSY state.
+	PollTimeout time.Duration
+
+	// Left is set when the device leaves the DFU mode and jumps to the
+	// loaded program.
+	Left bool
+
+	// DnloadErrors is the number of the next DFU_DNLOAD requests that fail
+	// with usb.ErrorIO before they reach the device.
+	DnloadErrors int
+
+	// BusyPolls is the number of the DFU_GETSTATUS requests answered in the
+	// dfuDNBUSY state before the device finishes processing the block.
+	BusyPolls int
+
+	// StatusErrors is the number of the next DFU_GETSTATUS requests that
+	// are processed by the device but their response is lost (they fail with
+	// usb.ErrorIO).
+	StatusErrors int
+
+	reqs [7]int // number of the DFU requests received
+
+	alt      int
+	state    uint8
+	status   uint8
+	addr     uint32 // DfuSe address pointer
+	blkSize  int    // size of the data blocks, set by the first one
+	blkNum   uint16
+	blk      []byte
+	pollTime time.Duration
+}
+
+// NewSTM32 returns a simulated STM32 device with the given flash size in KiB
+// organized in 2 KiB pages. The flash is mapped at 0x0800_0000.
+func NewSTM32(flashKiB int) *STM32 {
+	d := &STM32{
+		Flash:       NewFlash(0x0800_0000, flashKiB*1024, 2048),
+		PollTimeout: time.Millisecond,
+		state:       dfuIdle,
+		addr:        0x0800_0000,
+	}
+	dfuAlt := func(alt int) usb.InterfaceSetting {
+		return usb.InterfaceSetting{
+			Number: 0, Alternate: alt,
+			Class: usb.ClassApplication, SubClass: 1, Protocol: 2,
+		}
+	}
+	d.init(d, 0x0483, 0xdf11, 0x2200, usb.ConfigDesc{
+		Number: 1,
+		Interfaces: []usb.InterfaceDesc{{
+			Number:      0,
+			AltSettings: []usb.InterfaceSetting{dfuAlt(0), dfuAlt(1)},
+		}},
+	})
+	d.names[[3]int{1, 0, 0}] = fmt.Sprintf(
+		"@Internal Flash  /0x08000000/%03d*002Kg", flashKiB/2,
+	)
+	d.names[[3]int{1, 0, 1}] = "@Option Bytes  /0x1FFFF800/01*016 e"
+	return d
+}
+
+// State returns the current DFU state of the device.
+func (d *STM32) State() uint8 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.state
+}
+
+// SetState sets the DFU state and status of the device, e.g. to simulate the
+// device left in the middle of an operation by a previous session.
+func (d *STM32) SetState(state, status uint8) {
+	d.mu.Lock()
+	d.state, d.status = state, status
+	d.mu.Unlock()
+}
+
+// Requests returns the number of the DFU class requests with the given
+// bRequest code received by the device.
+func (d *STM32) Requests(request uint8) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if int(request) >= len(d.reqs) {
+		return 0
+	}
+	return d.reqs[request]
+}
+
+// configDesc returns the raw configuration descriptor including the DFU
+// functional descriptor.
+func (d *STM32) configDesc() []byte {
+	b := []byte{9, 0x02, 0, 0, 1, 1, 0, 0x80, 50}
+	for _, alt := range []int{0, 1} {
+		b = append(b, 9, 0x04, 0, byte(alt), 0, 0xfe, 1, 2, byte(4+alt))
+	}
+	// bmAttributes: bitCanDnload | bitCanUpload | bitWillDetach
+	b = append(b, 9, 0x21, 0x0b, 0xff, 0)
+	b = binary.LittleEndian.AppendUint16(b, dfuseTransSize)
+	b = binary.LittleEndian.AppendUint16(b, 0x011a)
+	binary.LittleEndian.PutUint16(b[2:], uint16(len(b)))
+	return b
+}
+
+func (d *STM32) Claim(cfg, intf, alt int) (usbdev.Interface, error) {
+	if err := d.claim(cfg, intf, alt); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	d.alt = alt
+	d.mu.Unlock()
+	return noEndpoints{}, nil
+}
+
+// Reset simulates the USB reset which makes the device leave the DFU mode.
+func (d *STM32) Reset() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.gone() {
+		return usb.ErrorNoDevice
+	}
+	d.Left = true
+	d.leave()
+	return nil
+}
+
+// stall stalls the request and moves the device to the dfuERROR state.
+func (d *STM32) stall() error {
+	d.state, d.status = dfuError, errStalledPkt
+	return usb.ErrorPipe
+}
+
+func (d *STM32) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.gone() {
+		return 0, usb.ErrorNoDevice
+	}
+	switch rType {
+	case usb.ControlIn | usb.ControlDevice:
+		if request == 0x06 && val == 0x0200 {
+			return copy(data, d.configDesc()), nil // GET_DESCRIPTOR
+		}
+		return 0, usb.ErrorPipe
+	case usb.ControlIn | usb.ControlClass | usb.ControlInterface:
+		if int(request) < len(d.reqs) {
+			d.reqs[request]++
+		}
+		n, err := d.classIn(request, val, data)
+		if request == 0x03 && err == nil && d.StatusErrors > 0 {
+			d.StatusErrors--
+			return 0, usb.ErrorIO
+		}
+		return n, err
+	case usb.ControlOut | usb.ControlClass | usb.ControlInterface:
+		if int(request) < len(d.reqs) {
+			d.reqs[request]++
+		}
+		if request == 0x01 && d.DnloadErrors > 0 {
+			d.DnloadErrors--
+			return 0, usb.ErrorIO
+		}
+		return d.classOut(request, val, data)
+	}
+	return 0, usb.ErrorPipe
+}
+
+func (d *STM32) classIn(request uint8, val uint16, data []byte) (int, error) {
+	switch request {
+	case 0x02: // DFU_UPLOAD
+		if d.state != dfuIdle && d.state != dfuUploadIdle || d.alt != 0 {
+			return 0, d.stall()
+		}
+		d.state = dfuUploadIdle
+		if val == 0 {
+			cmds := []byte{
+				dfuseGetCommands, dfuseSetAddress, dfuseErase,
+				dfuseReadUnprotect,
+			}
+			return copy(data, cmds), nil
+		}
+		if val < 2 {
+			return 0, d.stall()
+		}
+		addr := d.addr + uint32(val-2)*uint32(len(data))
+		if _, err := d.Flash.ReadAt(data, int64(addr)); err != nil {
+			d.state, d.status = dfuError, errAddress
+			return 0, usb.ErrorPipe
+		}
+		return len(data), nil
+	case 0x03: // DFU_GETSTATUS
+		if len(data) < 6 {
+			return 0, d.stall()
+		}
+		d.getStatus()
+		pt := uint32(d.pollTime / time.Millisecond)
+		data[0] = d.status
+		data[1], data[2], data[3] = byte(pt), byte(pt>>8), byte(pt>>16)
+		data[4] = d.state
+		data[5] = 0
+		if d.state == dfuManifest {
+			// The bootloader jumps to the loaded program.
+			d.Left = true
+			d.leave()
+		}
+		return 6, nil
+	case 0x05: // DFU_GETSTATE
+		if len(data) < 1 {
+			return 0, d.stall()
+		}
+		data[0] = d.state
+		return 1, nil
+	}
+	return 0, d.stall()
+}
+
+func (d *STM32) classOut(request uint8, val uint16, data []byte) (int, error) {
+	switch request {
+	case 0x01: // DFU_DNLOAD
+		if d.state != dfuIdle && d.state != dfuDnloadIdle || d.alt != 0 {
+			return 0, d.stall()
+		}
+		if len(data) == 0 {
+			if d.state != dfuDnloadIdle {
+				d.state, d.status = dfuError, errNotDone
+				return 0, usb.ErrorPipe
+			}
+			d.state = dfuManifestSync
+			return 0, nil
+		}
+		if len(data) > dfuseTransSize {
+			return 0, d.stall()
+		}
+		d.blkNum = val
+		d.blk = append(d.blk[:0], data...)
+		d.state = dfuDnloadSync
+		return len(data), nil
+	case 0x04: // DFU_CLRSTATUS
+		if d.state != dfuError {
+			return 0, d.stall()
+		}
+		d.state, d.status = dfuIdle, 0
+		return 0, nil
+	case 0x06: // DFU_ABORT
+		switch d.state {
+		case dfuIdle, dfuDnloadSync, dfuDnloadIdle, dfuManifestSync, dfuUploadIdle:
+			d.state = dfuIdle
+			return 0, nil
+		}
+	}
+	return 0, d.stall()
+}
+
+// getStatus advances the state machine as the DFU_GETSTATUS request does.
+func (d *STM32) getStatus() {
+	d.pollTime = 0
+	switch d.state {
+	case dfuDnloadSync:
+		d.state = dfuDnbusy
+		d.pollTime = d.PollTimeout
+		if st := d.execute(); st != 0 {
+			d.state, d.status = dfuError, st
+		}
+	case dfuDnbusy:
+		if d.BusyPolls > 0 {
+			d.BusyPolls--
+			d.pollTime = d.PollTimeout
+			break
+		}
+		d.state = dfuDnloadIdle
+	case dfuManifestSync:
+		d.state = dfuManifest
+	}
+}
+
+// execute executes the downloaded block. It returns the DFU status code.
+func (d *STM32) execute() uint8 {
+	le := binary.LittleEndian
+	b := d.blk
+	switch {
+	case d.blkNum == 0: // DfuSe command
+		switch {
+		case b[0] == dfuseSetAddress && len(b) == 5:
+			d.addr = le.Uint32(b[1:])
+			d.blkSize = 0
+		case b[0] == dfuseErase && len(b) == 1:
+			d.Flash.EraseAll()
+		case b[0] == dfuseErase && len(b) == 5:
+			if d.Flash.Erase(le.Uint32(b[1:]), 1) != nil {
+				return errTarget
+			}
+		case b[0] == dfuseReadUnprotect && len(b) == 1:
+			d.Flash.EraseAll()
+		default:
+			return errStalledPkt
+		}
+	case d.blkNum == 1:
+		return errStalledPkt
+	default:
+		// The last block of the image may be shorter than the others.
+		if d.blkSize == 0 || d.blkNum == 2 {
+			d.blkSize = len(b)
+		}
+		if len(b) > d.blkSize {
+			return errStalledPkt
+		}
+		addr := d.addr + uint32(d.blkNum-2)*uint32(d.blkSize)
+		ok, err := d.Flash.Program(addr, b)
+		if err != nil {
+			return errAddress
+		}
+		if !ok {
+			return errVerify
+		}
+	}
+	return 0
+}
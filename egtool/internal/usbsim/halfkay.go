@@ -0,0 +1,144 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package usbsim
+
+import (
+	"github.com/embeddedgo/tools/egtool/internal/halfkay"
+	"github.com/embeddedgo/tools/egtool/internal/usbdev"
+	usb "github.com/google/gousb"
+)
+
+const (
+	teensyFlashBase = 0x6000_0000
+	halfKayHdrSize  = 64
+	halfKayBoot     = 0xff_ffff
+)
+
+// Teensy simulates a Teensy 4.x board in the HalfKay bootloader mode (USB ID
+// 16c0:0478). The first write to the address 0 erases the whole flash, as the
+// real bootloader does before programming a new image.
+type Teensy struct {
+	device
+
+	Model *halfkay.Model
+	Flash *Flash
+
+	// Booted is set when the device leaves the bootloader and starts the
+	// loaded program.
+	Booted bool
+
+	usage uint8
+}
+
+// NewTeensy returns a simulated Teensy board. The model must be one of
+// halfkay.Teensy40, halfkay.Teensy41, halfkay.MicroMod.
+func NewTeensy(model *halfkay.Model) *Teensy {
+	var bcd usb.BCD
+	var usage uint8
+	switch model {
+	case halfkay.Teensy40:
+		bcd, usage = 0x0280, 0x24
+	case halfkay.Teensy41:
+		bcd, usage = 0x0281, 0x25
+	case halfkay.MicroMod:
+		bcd, usage = 0x0282, 0x26
+	default:
+		panic("usbsim: unsupported Teensy model")
+	}
+	d := &Teensy{
+		Model: model,
+		Flash: NewFlash(teensyFlashBase, model.FlashSize, 4096),
+		usage: usage,
+	}
+	d.init(d, halfkay.Vendor, halfkay.Product, bcd, usb.ConfigDesc{
+		Number: 1,
+		Interfaces: []usb.InterfaceDesc{{
+			Number: 0,
+			AltSettings: []usb.InterfaceSetting{{
+				Number: 0, Class: usb.ClassHID,
+				Endpoints: map[usb.EndpointAddress]usb.EndpointDesc{
+					0x81: {
+						Address: 0x81, Number: 1,
+						Direction:     usb.EndpointDirectionIn,
+						MaxPacketSize: 64,
+						TransferType:  usb.TransferTypeInterrupt,
+					},
+				},
+			}},
+		}},
+	})
+	return d
+}
+
+func (d *Teensy) Claim(cfg, intf, alt int) (usbdev.Interface, error) {
+	if err := d.claim(cfg, intf, alt); err != nil {
+		return nil, err
+	}
+	return noEndpoints{}, nil
+}
+
+// Reset simulates the USB reset. The device stays in the bootloader mode.
+func (d *Teensy) Reset() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.gone() {
+		return usb.ErrorNoDevice
+	}
+	return nil
+}
+
+// reportDesc returns the HID report descriptor that identifies the model.
+func (d *Teensy) reportDesc() []byte {
+	size := halfKayHdrSize + d.Model.BlockSize
+	return []byte{
+		0x06, 0x9c, 0xff, // Usage Page (0xff9c)
+		0x09, d.usage, // Usage
+		0xa1, 0x01, // Collection (Application)
+		0x0a, 0x19, 0x00, // Usage (0x19)
+		0x75, 0x08, // Report Size (8)
+		0x15, 0x00, // Logical Minimum (0)
+		0x26, 0xff, 0x00, // Logical Maximum (255)
+		0x96, byte(size), byte(size >> 8), // Report Count
+		0x91, 0x02, // Output (Data, Var, Abs)
+		0xc0, // End Collection
+	}
+}
+
+func (d *Teensy) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.gone() {
+		return 0, usb.ErrorNoDevice
+	}
+	switch {
+	case rType == usb.ControlIn|usb.ControlInterface && request == 0x06 && val == 0x2200:
+		return copy(data, d.reportDesc()), nil // GET_DESCRIPTOR (report)
+	case rType == usb.ControlOut|usb.ControlClass|usb.ControlInterface && request == 0x09:
+		return d.setReport(data)
+	}
+	return 0, usb.ErrorPipe
+}
+
+// setReport handles the HID SET_REPORT request that carries the address and
+// the block of data to be written.
+func (d *Teensy) setReport(data []byte) (int, error) {
+	if len(data) != halfKayHdrSize+d.Model.BlockSize {
+		return 0, usb.ErrorPipe
+	}
+	addr := int(data[0]) | int(data[1])<<8 | int(data[2])<<16
+	switch {
+	case addr == halfKayBoot:
+		d.Booted = true
+		d.leave()
+	case addr%d.Model.BlockSize != 0 || addr+d.Model.BlockSize > d.Model.CodeSize:
+		return 0, usb.ErrorPipe
+	default:
+		if addr == 0 {
+			d.Flash.EraseAll()
+		}
+		d.Flash.Program(teensyFlashBase+uint32(addr), data[halfKayHdrSize:])
+	}
+	return len(data), nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package usbsim
+
+import "sync"
+
+// nRF52 memory map and registers
+const (
+	nrfRAM       = 0x2000_0000
+	nrfFICR      = 0x1000_0000
+	nrfNVMC      = 0x4001_e000
+	nrfNVMCReady = nrfNVMC + 0x400
+	nrfNVMCCfg   = nrfNVMC + 0x504
+	nrfNVMCErase = nrfNVMC + 0x508
+
+	nrfCtrlAPIDR = 0x0288_0000
+)
+
+// NRF52 simulates the memory of an nRF52 microcontroller (Cortex-M4 core,
+// FICR, NVMC, RAM, flash) as seen by a debug probe. It emulates the core
+// debug registers good enough to halt, reset and access the core registers
+// but doesn't execute any code. The CTRL-AP (APSEL 1) allows to check and
+// remove the access port protection.
+type NRF52 struct {
+	mu sync.Mutex
+
+	Part  uint32 // FICR INFO.PART
+	Flash *Flash
+	RAM   []byte
+
+	// APProtect enables the access port protection: the AHB-AP accesses
+	// fail. The CTRL-AP ERASEALL command clears the flash and RAM and
+	// disables the protection.
+	APProtect bool
+
+	scs
+
+	nvmcCfg uint32
+}
+
+// NewNRF52 returns a simulated nRF52840 (or other nRF52 part) with the given
+// flash size in KiB organized in 4 KiB pages and 256 KiB of RAM.
+func NewNRF52(part uint32, flashKiB int) *NRF52 {
+	return &NRF52{
+		Part:  part,
+		Flash: NewFlash(0, flashKiB*1024, 4096),
+		RAM:   make([]byte, 256*1024),
+		scs:   scs{cpuid: 0x410f_c241}, // Cortex-M4 r0p1
+	}
+}
+
+func (t *NRF52) Read32(addr uint32) (uint32, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case t.APProtect:
+		return 0, false
+	case t.Flash.Contains(addr, 4):
+		var b [4]byte
+		t.Flash.ReadAt(b[:], int64(addr))
+		return le32(b[:]), true
+	case addr >= nrfRAM && addr-nrfRAM < uint32(len(t.RAM))-3:
+		return le32(t.RAM[addr-nrfRAM:]), true
+	case addr == nrfFICR+0x10: // CODEPAGESIZE
+		return uint32(t.Flash.SectorSize), true
+	case addr == nrfFICR+0x14: // CODESIZE
+		return uint32(t.Flash.Size() / t.Flash.SectorSize), true
+	case addr == nrfFICR+0x100: // INFO.PART
+		return t.Part, true
+	case addr == nrfNVMCReady:
+		return 1, true
+	case addr == nrfNVMCCfg:
+		return t.nvmcCfg, true
+	}
+	return t.scs.read(addr)
+}
+
+func (t *NRF52) Write32(addr, val uint32) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case t.APProtect:
+		return false
+	case t.Flash.Contains(addr, 4):
+		if t.nvmcCfg != 1 {
+			return true // ignored
+		}
+		var b [4]byte
+		putLE32(b[:], val)
+		t.Flash.Program(addr, b[:])
+	case addr >= nrfRAM && addr-nrfRAM < uint32(len(t.RAM))-3:
+		putLE32(t.RAM[addr-nrfRAM:], val)
+	case addr == nrfNVMCCfg:
+		t.nvmcCfg = val & 3
+	case addr == nrfNVMCErase:
+		if t.nvmcCfg == 2 {
+			t.Flash.Erase(val, 1)
+		}
+	default:
+		return t.scs.write(addr, val)
+	}
+	return true
+}
+
+// AccessAP implements the CTRL-AP registers: RESET, ERASEALL, ERASEALLSTATUS
+// (the erase completes immediately), APPROTECTSTATUS and IDR.
+func (t *NRF52) AccessAP(apsel, reg uint32, read bool, val uint32) (uint32, bool) {
+	if apsel != 1 {
+		return 0, true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch reg {
+	case 0x004: // ERASEALL
+		if !read && val&1 != 0 {
+			t.Flash.EraseAll()
+			clear(t.RAM)
+			t.APProtect = false // UICR erased
+		}
+	case 0x00c: // APPROTECTSTATUS
+		if read && !t.APProtect {
+			return 1, true
+		}
+	case 0x0fc: // IDR
+		if read {
+			return nrfCtrlAPIDR, true
+		}
+	}
+	return 0, true
+}
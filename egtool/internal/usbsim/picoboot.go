@@ -0,0 +1,791 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package usbsim
+
+import (
+	"encoding/binary"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/embeddedgo/tools/egtool/internal/picoboot"
+	"github.com/embeddedgo/tools/egtool/internal/uf2"
+	"github.com/embeddedgo/tools/egtool/internal/usbdev"
+	usb "github.com/google/gousb"
+)
+
+// PICOBOOT commands
+const (
+	pbExclusiveAccess = 0x01
+	pbReboot          = 0x02
+	pbFlashErase      = 0x03
+	pbRead            = 0x84
+	pbWrite           = 0x05
+	pbExitXIP         = 0x06
+	pbEnterXIP        = 0x07
+	pbExec            = 0x08
+	pbVectorizeFlash  = 0x09
+	pbReboot2         = 0x0a
+	pbGetInfo         = 0x8b
+	pbOTPRead         = 0x8c
+	pbOTPWrite        = 0x0d
+)
+
+// PICOBOOT status codes
+const (
+	pbOK                      = 0
+	pbUnknownCmd              = 1
+	pbInvalidCmdLength        = 2
+	pbInvalidTransferLength   = 3
+	pbInvalidAddress          = 4
+	pbBadAlignment            = 5
+	pbInvalidArg              = 11
+	pbNotFound                = 16
+	pbUnsupportedModification = 17
+)
+
+var pbArgsSize = map[uint8]int{
+	pbExclusiveAccess: 1,
+	pbReboot:          12,
+	pbFlashErase:      8,
+	pbRead:            8,
+	pbWrite:           8,
+	pbExitXIP:         0,
+	pbEnterXIP:        0,
+	pbExec:            4,
+	pbVectorizeFlash:  4,
+	pbReboot2:         16,
+	pbGetInfo:         16,
+	pbOTPRead:         5,
+	pbOTPWrite:        5,
+}
+
+// States of the PICOBOOT bulk interface
+const (
+	pbStateCmd     = iota // waiting for a command
+	pbStateDataIn         // the host reads the data
+	pbStateDataOut        // the host writes the data
+	pbStateAckIn          // the host reads the zero-length acknowledgment
+	pbStateAckOut         // the host writes the zero-length acknowledgment
+	pbStateStalled        // the command failed, the endpoints are halted
+)
+
+const (
+	rp2350RAMBase   = 0x2000_0000
+	rp2350RAMSize   = 520 * 1024
+	rp2350FlashBase = 0x1000_0000
+	rp2350OTPRows   = 4096
+	rp2040RAMSize   = 264 * 1024
+)
+
+// Reboot contains the parameters of the REBOOT2 command. The RP2040 REBOOT
+// command is described using the RebootNormal or RebootPCSP type with the pc
+// in P0 and the sp in P1.
+type Reboot struct {
+	Type   uint32
+	Delay  time.Duration
+	P0, P1 uint32
+}
+
+// RP2350 simulates the RP2350 microcontroller in the BOOTSEL mode (USB ID
+// 2e8a:000f). It implements the PICOBOOT interface, the USB mass storage
+// interface is only described in the descriptors. The RP2040 specific commands
+// are rejected. NewRP2040 returns the device that simulates RP2040 instead.
+type RP2350 struct {
+	device
+
+	Flash *Flash
+	RAM   []byte
+	OTP   [rp2350OTPRows]uint32 // raw 24-bit rows
+
+	// PartitionTable is the partition table reported by the boot ROM.
+	PartitionTable picoboot.PartitionTable
+
+	// LastBoot describes the boot that preceded the BOOTSEL mode.
+	LastBoot picoboot.LastBoot
+
+	// Rebooted is set when the device leaves the BOOTSEL mode after the
+	// REBOOT or REBOOT2 command.
+	Rebooted *Reboot
+
+	// Executed contains the addresses of the functions called using the
+	// EXEC command (RP2040).
+	Executed []uint32
+
+	// BusyPolls is the number of GET_COMMAND_STATUS requests that report the
+	// long-running command (FLASH_ERASE, WRITE, EXEC, VECTORIZE_FLASH,
+	// OTP_WRITE) as still in progress. Zero means all commands complete
+	// immediately.
+	BusyPolls int
+
+	rp2040    bool
+	exclusive uint8
+	busy      int
+	polls     int
+	state     int
+	token     uint32
+	cmd       uint8
+	status    uint32
+	args      []byte
+	in        []byte // data to be sent to the host
+	out       []byte // data received from the host
+	outLen    int
+	reboot    *Reboot
+}
+
+var (
+	rp2350ROM = bootROM(32*1024, 2)
+	rp2040ROM = bootROM(16*1024, 1)
+)
+
+func bootROM(size int, version byte) []byte {
+	rom := make([]byte, size)
+	copy(rom[0x10:], []byte{'M', 'u', version}) // magic and version
+	return rom
+}
+
+// NewRP2350 returns a simulated RP2350 device with the given flash size in
+// KiB (a power of two) and no partition table.
+func NewRP2350(flashKiB int) *RP2350 {
+	d := &RP2350{
+		Flash: NewFlash(rp2350FlashBase, flashKiB*1024, 4096),
+		RAM:   make([]byte, rp2350RAMSize),
+	}
+	d.PartitionTable.Unpartitioned = picoboot.PartInfo{
+		First: 0, Last: 0x1fff, Perm: 0x3f,
+		Flags: picoboot.PartAcceptsDefaultFamilies,
+	}
+	d.LastBoot.DiagnosticPartition = -1
+	d.LastBoot.Type = uint8(picoboot.RebootBootsel)
+	d.LastBoot.Partition = -1
+	d.init(d, picoboot.Vendor, picoboot.ProductRP2350, 0x0100, pbConfig())
+	d.names[[3]int{1, 0, 0}] = "Board MSC"
+	d.names[[3]int{1, 1, 0}] = "Board PICOBOOT"
+	return d
+}
+
+// NewRP2040 returns a simulated RP2040 device (USB ID 2e8a:0003) with the
+// given flash size in KiB. It accepts the RP2040 specific commands (REBOOT,
+// EXEC, VECTORIZE_FLASH) and rejects the RP2350 ones (REBOOT2, GET_INFO and
+// the OTP commands).
+func NewRP2040(flashKiB int) *RP2350 {
+	d := &RP2350{
+		Flash:  NewFlash(rp2350FlashBase, flashKiB*1024, 4096),
+		RAM:    make([]byte, rp2040RAMSize),
+		rp2040: true,
+	}
+	d.init(d, picoboot.Vendor, picoboot.ProductRP2040, 0x0100, pbConfig())
+	d.names[[3]int{1, 0, 0}] = "Board MSC"
+	d.names[[3]int{1, 1, 0}] = "Board PICOBOOT"
+	return d
+}
+
+// pbConfig returns the configuration descriptor with the USB mass storage
+// and PICOBOOT interfaces.
+func pbConfig() usb.ConfigDesc {
+	bulk := func(addr usb.EndpointAddress) usb.EndpointDesc {
+		dir := usb.EndpointDirectionOut
+		if addr&0x80 != 0 {
+			dir = usb.EndpointDirectionIn
+		}
+		return usb.EndpointDesc{
+			Address: addr, Number: int(addr & 0x0f), Direction: dir,
+			MaxPacketSize: 64, TransferType: usb.TransferTypeBulk,
+		}
+	}
+	return usb.ConfigDesc{
+		Number: 1,
+		Interfaces: []usb.InterfaceDesc{
+			{Number: 0, AltSettings: []usb.InterfaceSetting{{
+				Number: 0, Class: usb.ClassMassStorage, SubClass: 6, Protocol: 0x50,
+				Endpoints: map[usb.EndpointAddress]usb.EndpointDesc{
+					0x81: bulk(0x81), 0x02: bulk(0x02),
+				},
+			}}},
+			{Number: 1, AltSettings: []usb.InterfaceSetting{{
+				Number: 1, Class: usb.ClassVendorSpec,
+				Endpoints: map[usb.EndpointAddress]usb.EndpointDesc{
+					0x03: bulk(0x03), 0x84: bulk(0x84),
+				},
+			}}},
+		},
+	}
+}
+
+func (d *RP2350) Claim(cfg, intf, alt int) (usbdev.Interface, error) {
+	if err := d.claim(cfg, intf, alt); err != nil {
+		return nil, err
+	}
+	if intf != 1 {
+		return noEndpoints{}, nil
+	}
+	return pbIntf{d}, nil
+}
+
+// Reset simulates the USB reset. The device stays in the BOOTSEL mode.
+func (d *RP2350) Reset() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.gone() {
+		return usb.ErrorNoDevice
+	}
+	d.state, d.status = pbStateCmd, pbOK
+	return nil
+}
+
+func (d *RP2350) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.gone() {
+		return 0, usb.ErrorNoDevice
+	}
+	if idx != 1 {
+		return 0, usb.ErrorPipe
+	}
+	switch {
+	case rType == usb.ControlVendor|usb.ControlInterface && request == 0x41:
+		// INTERFACE_RESET
+		d.state, d.status = pbStateCmd, pbOK
+		return 0, nil
+	case rType == usb.ControlVendor|usb.ControlInterface|usb.ControlIn && request == 0x42:
+		// GET_COMMAND_STATUS
+		var buf [16]byte
+		le := binary.LittleEndian
+		le.PutUint32(buf[0:], d.token)
+		le.PutUint32(buf[4:], d.status)
+		buf[8] = d.cmd
+		d.polls++
+		if d.busy > 0 {
+			d.busy--
+			buf[9] = 1 // in progress
+		}
+		return copy(data, buf[:]), nil
+	}
+	return 0, usb.ErrorPipe
+}
+
+// pbIntf is the claimed PICOBOOT interface.
+type pbIntf struct {
+	d *RP2350
+}
+
+// StatusPolls returns the number of the GET_COMMAND_STATUS requests received
+// by the device.
+func (d *RP2350) StatusPolls() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.polls
+}
+
+func (i pbIntf) In(ep int) (io.Reader, error) {
+	if ep != 4 {
+		return nil, usb.ErrorNotFound
+	}
+	return pbIn{i.d}, nil
+}
+
+func (i pbIntf) Out(ep int) (io.Writer, error) {
+	if ep != 3 {
+		return nil, usb.ErrorNotFound
+	}
+	return pbOut{i.d}, nil
+}
+
+func (i pbIntf) Close() error {
+	return nil
+}
+
+type pbIn struct {
+	d *RP2350
+}
+
+func (e pbIn) Read(p []byte) (int, error) {
+	d := e.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.gone() {
+		return 0, usb.ErrorNoDevice
+	}
+	switch d.state {
+	case pbStateDataIn:
+		n := copy(p, d.in)
+		d.in = d.in[n:]
+		if len(d.in) == 0 {
+			d.state = pbStateAckOut
+		}
+		return n, nil
+	case pbStateAckIn:
+		d.state, d.busy = pbStateCmd, 0
+		if d.reboot != nil {
+			d.Rebooted, d.reboot = d.reboot, nil
+			d.leave()
+		}
+		return 0, nil
+	case pbStateStalled:
+		return 0, usb.ErrorPipe
+	}
+	return 0, usb.ErrorTimeout
+}
+
+type pbOut struct {
+	d *RP2350
+}
+
+func (e pbOut) Write(p []byte) (int, error) {
+	d := e.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.gone() {
+		return 0, usb.ErrorNoDevice
+	}
+	switch d.state {
+	case pbStateCmd:
+		d.command(p)
+		return len(p), nil
+	case pbStateDataOut:
+		n := min(len(p), d.outLen-len(d.out))
+		d.out = append(d.out, p[:n]...)
+		if len(d.out) == d.outLen {
+			d.finish(d.execOut())
+		}
+		return n, nil
+	case pbStateAckOut:
+		d.state = pbStateCmd
+		return 0, nil
+	case pbStateStalled:
+		return 0, usb.ErrorPipe
+	}
+	return 0, usb.ErrorTimeout
+}
+
+// finish ends the command with the given status.
+func (d *RP2350) finish(status uint32) {
+	d.status = status
+	if status != pbOK {
+		d.state = pbStateStalled
+		d.reboot = nil
+		return
+	}
+	d.state = pbStateAckIn
+	switch d.cmd {
+	case pbFlashErase, pbWrite, pbExec, pbVectorizeFlash, pbOTPWrite:
+		d.busy = d.BusyPolls
+	}
+}
+
+// supported reports whether the command is implemented by the simulated chip.
+func (d *RP2350) supported(cmd uint8) bool {
+	switch cmd {
+	case pbReboot, pbExec, pbVectorizeFlash:
+		return d.rp2040
+	case pbReboot2, pbGetInfo, pbOTPRead, pbOTPWrite:
+		return !d.rp2040
+	}
+	return true
+}
+
+// command decodes and starts the command.
+func (d *RP2350) command(p []byte) {
+	le := binary.LittleEndian
+	if len(p) != 32 || le.Uint32(p) != 0x431fd10b {
+		d.cmd = 0
+		d.finish(pbUnknownCmd)
+		return
+	}
+	d.token = le.Uint32(p[4:])
+	d.cmd = p[8]
+	d.busy = 0
+	size, ok := pbArgsSize[d.cmd]
+	if !ok || !d.supported(d.cmd) {
+		d.finish(pbUnknownCmd)
+		return
+	}
+	if int(p[9]) != size {
+		d.finish(pbInvalidCmdLength)
+		return
+	}
+	d.args = append(d.args[:0], p[16:16+size]...)
+	transferLen := int(le.Uint32(p[12:]))
+	if d.cmd&0x80 != 0 {
+		in, status := d.execIn(transferLen)
+		if status == pbOK && len(in) != transferLen {
+			status = pbInvalidTransferLength
+		}
+		if status != pbOK {
+			d.finish(status)
+			return
+		}
+		d.in, d.status, d.state = in, pbOK, pbStateDataIn
+		return
+	}
+	if transferLen != 0 {
+		if status := d.checkOut(transferLen); status != pbOK {
+			d.finish(status)
+			return
+		}
+		d.out, d.outLen = d.out[:0], transferLen
+		d.status, d.state = pbOK, pbStateDataOut
+		return
+	}
+	d.finish(d.exec())
+}
+
+func (d *RP2350) arg32(i int) uint32 {
+	return binary.LittleEndian.Uint32(d.args[i*4:])
+}
+
+// mem returns the memory region that contains the range [addr, addr+n) and
+// the offset of addr in it.
+func (d *RP2350) mem(addr uint32, n int) (m []byte, off int, ok bool) {
+	rom := rp2350ROM
+	if d.rp2040 {
+		rom = rp2040ROM
+	}
+	switch {
+	case addr < uint32(len(rom)):
+		m = rom
+	case addr >= rp2350RAMBase && addr-rp2350RAMBase < uint32(len(d.RAM)):
+		m, addr = d.RAM, addr-rp2350RAMBase
+	default:
+		return nil, 0, false
+	}
+	if uint64(addr)+uint64(n) > uint64(len(m)) {
+		return nil, 0, false
+	}
+	return m, int(addr), true
+}
+
+// exec executes the command that doesn't transfer any data.
+func (d *RP2350) exec() uint32 {
+	switch d.cmd {
+	case pbExclusiveAccess:
+		if d.args[0] > 2 {
+			return pbInvalidArg
+		}
+		d.exclusive = d.args[0]
+	case pbFlashErase:
+		addr, size := d.arg32(0), int(d.arg32(1))
+		if addr&4095 != 0 || size&4095 != 0 {
+			return pbBadAlignment
+		}
+		if !d.Flash.Contains(addr, size) {
+			return pbInvalidAddress
+		}
+		d.Flash.Erase(addr, size)
+	case pbExitXIP, pbEnterXIP:
+		// nop on RP2350
+	case pbReboot2:
+		d.reboot = &Reboot{
+			Type:  d.arg32(0),
+			Delay: time.Duration(d.arg32(1)) * time.Millisecond,
+			P0:    d.arg32(2),
+			P1:    d.arg32(3),
+		}
+	case pbReboot:
+		r := &Reboot{
+			Type:  picoboot.RebootNormal,
+			Delay: time.Duration(d.arg32(2)) * time.Millisecond,
+			P0:    d.arg32(0),
+			P1:    d.arg32(1),
+		}
+		if r.P0 != 0 {
+			r.Type = picoboot.RebootPCSP
+		}
+		d.reboot = r
+	case pbExec, pbVectorizeFlash:
+		addr := d.arg32(0)
+		if d.cmd == pbExec {
+			addr &^= 1 // thumb bit
+		}
+		if _, _, ok := d.mem(addr, 4); !ok || addr < rp2350RAMBase {
+			return pbInvalidAddress
+		}
+		if d.cmd == pbExec {
+			d.Executed = append(d.Executed, d.arg32(0))
+		}
+	default:
+		return pbUnknownCmd
+	}
+	return pbOK
+}
+
+// checkOut checks the arguments of the command that receives data.
+func (d *RP2350) checkOut(n int) uint32 {
+	switch d.cmd {
+	case pbWrite:
+		addr, size := d.arg32(0), int(d.arg32(1))
+		if size != n {
+			return pbInvalidTransferLength
+		}
+		if d.Flash.Contains(addr, size) {
+			if addr&255 != 0 || size&255 != 0 {
+				return pbBadAlignment
+			}
+			return pbOK
+		}
+		if _, _, ok := d.mem(addr, size); !ok || addr < rp2350RAMBase {
+			return pbInvalidAddress
+		}
+	case pbOTPWrite:
+		row, cnt, ecc := d.otpArgs()
+		if cnt*otpRowSize(ecc) != n {
+			return pbInvalidTransferLength
+		}
+		if row+cnt > rp2350OTPRows {
+			return pbInvalidAddress
+		}
+	default:
+		return pbInvalidTransferLength
+	}
+	return pbOK
+}
+
+// execOut executes the command after all its data was received.
+func (d *RP2350) execOut() uint32 {
+	switch d.cmd {
+	case pbWrite:
+		addr := d.arg32(0)
+		if d.Flash.Contains(addr, len(d.out)) {
+			d.Flash.Program(addr, d.out)
+			return pbOK
+		}
+		m, off, _ := d.mem(addr, len(d.out))
+		copy(m[off:], d.out)
+	case pbOTPWrite:
+		row, cnt, ecc := d.otpArgs()
+		le := binary.LittleEndian
+		for i := range cnt {
+			r := &d.OTP[row+i]
+			if ecc {
+				// The ECC bits aren't emulated, only the data.
+				v := uint32(le.Uint16(d.out[i*2:]))
+				if *r != 0 && *r != v {
+					return pbUnsupportedModification
+				}
+				*r = v
+			} else {
+				v := le.Uint32(d.out[i*4:]) & 0xffffff
+				if *r|v != v {
+					return pbUnsupportedModification
+				}
+				*r = v
+			}
+		}
+	}
+	return pbOK
+}
+
+func (d *RP2350) otpArgs() (row, cnt int, ecc bool) {
+	le := binary.LittleEndian
+	return int(le.Uint16(d.args)), int(le.Uint16(d.args[2:])), d.args[4] != 0
+}
+
+func otpRowSize(ecc bool) int {
+	if ecc {
+		return 2
+	}
+	return 4
+}
+
+// execIn executes the command that sends data to the host.
+func (d *RP2350) execIn(n int) ([]byte, uint32) {
+	switch d.cmd {
+	case pbRead:
+		addr, size := d.arg32(0), int(d.arg32(1))
+		if size != n {
+			return nil, pbInvalidTransferLength
+		}
+		if d.Flash.Contains(addr, size) {
+			return d.Flash.Bytes(addr, size), pbOK
+		}
+		m, off, ok := d.mem(addr, size)
+		if !ok {
+			return nil, pbInvalidAddress
+		}
+		return slices.Clone(m[off : off+size]), pbOK
+	case pbOTPRead:
+		row, cnt, ecc := d.otpArgs()
+		if row+cnt > rp2350OTPRows {
+			return nil, pbInvalidAddress
+		}
+		var buf []byte
+		le := binary.LittleEndian
+		for _, r := range d.OTP[row : row+cnt] {
+			if ecc {
+				buf = le.AppendUint16(buf, uint16(r))
+			} else {
+				buf = le.AppendUint32(buf, r)
+			}
+		}
+		return buf, pbOK
+	case pbGetInfo:
+		info, status := d.getInfo()
+		if status != pbOK {
+			return nil, status
+		}
+		if len(info)*4 > n {
+			return nil, pbInvalidTransferLength
+		}
+		buf := make([]byte, n)
+		for i, w := range info {
+			binary.LittleEndian.PutUint32(buf[i*4:], w)
+		}
+		return buf, pbOK
+	}
+	return nil, pbUnknownCmd
+}
+
+// getInfo returns the response to the GET_INFO command. The first word is the
+// number of the remaining words.
+func (d *RP2350) getInfo() ([]uint32, uint32) {
+	var w []uint32
+	switch d.arg32(0) {
+	case picoboot.InfoSys:
+		flags := d.arg32(1) & (picoboot.ChipInfo | picoboot.Critical |
+			picoboot.CPUInfo | picoboot.FlashDevInfo | picoboot.BootRandom |
+			picoboot.BootInfo)
+		w = append(w, flags)
+		if flags&picoboot.ChipInfo != 0 {
+			w = append(w, 0, 0x5a5a0000|uint32(d.desc.Address), 0x12345678)
+		}
+		if flags&picoboot.Critical != 0 {
+			w = append(w, 0)
+		}
+		if flags&picoboot.CPUInfo != 0 {
+			w = append(w, 0) // ARM
+		}
+		if flags&picoboot.FlashDevInfo != 0 {
+			n := uint32(0)
+			for 4096<<n < d.Flash.Size() {
+				n++
+			}
+			w = append(w, n<<8)
+		}
+		if flags&picoboot.BootRandom != 0 {
+			w = append(w, 0x01234567, 0x89abcdef, 0xfedcba98, 0x76543210)
+		}
+		if flags&picoboot.BootInfo != 0 {
+			b := &d.LastBoot
+			w = append(
+				w,
+				uint32(uint8(b.DiagnosticPartition))|uint32(b.Type)<<8|
+					uint32(uint8(b.Partition))<<16|uint32(b.TBYBAndUpdateInfo)<<24,
+				b.Diagnostic, b.RebootParams[0], b.RebootParams[1],
+			)
+		}
+	case picoboot.Partition:
+		var ok bool
+		if w, ok = d.partitionInfo(d.arg32(1)); !ok {
+			return nil, pbInvalidArg
+		}
+	case picoboot.UF2TargetPartition:
+		n, p := d.uf2Target(d.arg32(1))
+		if p == nil {
+			return nil, pbNotFound
+		}
+		loc, flags := encodeLocation(p)
+		w = append(w, uint32(n), loc, flags)
+	default:
+		return nil, pbInvalidArg
+	}
+	return append([]uint32{uint32(len(w))}, w...), pbOK
+}
+
+func encodeLocation(p *picoboot.PartInfo) (loc, flags uint32) {
+	loc = uint32(p.First) | uint32(p.Last)<<13
+	flags = p.Flags | uint32(p.Perm)<<26
+	return
+}
+
+func (d *RP2350) partitionInfo(flags uint32) (w []uint32, ok bool) {
+	pt := &d.PartitionTable
+	if flags&picoboot.SinglePartition != 0 {
+		i := int(flags >> 24)
+		if i >= len(pt.Partitions) {
+			return nil, false
+		}
+		p := &pt.Partitions[i]
+		flags &= picoboot.PartitionLocationAndFlags | picoboot.PartitionID |
+			picoboot.PartitionFamilyIDs | picoboot.PartitionName
+		w = append(w, flags)
+		if flags&picoboot.PartitionLocationAndFlags != 0 {
+			loc, fl := encodeLocation(p)
+			w = append(w, loc, fl)
+		}
+		if flags&picoboot.PartitionID != 0 && p.Flags&picoboot.PartHasID != 0 {
+			w = append(w, uint32(p.ID), uint32(p.ID>>32))
+		}
+		if flags&picoboot.PartitionFamilyIDs != 0 {
+			w = append(w, p.Families...)
+		}
+		if flags&picoboot.PartitionName != 0 && p.Flags&picoboot.PartHasName != 0 {
+			b := append([]byte{byte(len(p.Name))}, p.Name...)
+			for len(b)%4 != 0 {
+				b = append(b, 0)
+			}
+			for k := 0; k < len(b); k += 4 {
+				w = append(w, binary.LittleEndian.Uint32(b[k:]))
+			}
+		}
+		return w, true
+	}
+	flags &= picoboot.PTInfo | picoboot.PartitionLocationAndFlags
+	w = append(w, flags)
+	if flags&picoboot.PTInfo != 0 {
+		info := uint32(len(pt.Partitions))
+		if pt.Present {
+			info |= 0x100
+		}
+		loc, fl := encodeLocation(&pt.Unpartitioned)
+		w = append(w, info, loc, fl)
+	}
+	if flags&picoboot.PartitionLocationAndFlags != 0 {
+		for i := range pt.Partitions {
+			loc, fl := encodeLocation(&pt.Partitions[i])
+			w = append(w, loc, fl)
+		}
+	}
+	return w, true
+}
+
+// accepts reports whether the partition accepts the UF2 family.
+func accepts(p *picoboot.PartInfo, family uint32) bool {
+	for name, flag := range map[string]uint32{
+		"absolute":      picoboot.PartAcceptsAbsolute,
+		"rp2040":        picoboot.PartAcceptsRP2040,
+		"rp2350_arm_s":  picoboot.PartAcceptsRP2350ARMS,
+		"rp2350_arm_ns": picoboot.PartAcceptsRP2350ARMNS,
+		"rp2350_riscv":  picoboot.PartAcceptsRP2350RISCV,
+		"data":          picoboot.PartAcceptsData,
+	} {
+		if uf2.FamilyMap[name] == family && p.Flags&flag != 0 {
+			return true
+		}
+	}
+	return slices.Contains(p.Families, family)
+}
+
+// uf2Target returns the partition that accepts the UF2 family. If the first
+// such partition belongs to an A/B pair the one that wasn't booted is chosen.
+// The returned number is -1 for the unpartitioned space.
+func (d *RP2350) uf2Target(family uint32) (int, *picoboot.PartInfo) {
+	pt := &d.PartitionTable
+	if !pt.Present {
+		if accepts(&pt.Unpartitioned, family) {
+			return -1, &pt.Unpartitioned
+		}
+		return -1, nil
+	}
+	for i := range pt.Partitions {
+		if !accepts(&pt.Partitions[i], family) {
+			continue
+		}
+		if b := pt.ABPartner(i); b >= 0 && int(d.LastBoot.Partition) == i {
+			i = b
+		}
+		return i, &pt.Partitions[i]
+	}
+	return -1, nil
+}
@@ -0,0 +1,327 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package usbsim
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/embeddedgo/tools/egtool/internal/usbdev"
+	usb "github.com/google/gousb"
+)
+
+// ST-LINK commands
+const (
+	stlGetVersion     = 0xf1
+	stlDebug          = 0xf2
+	stlDFU            = 0xf3
+	stlGetCurrentMode = 0xf5
+
+	stlDFUExit = 0x07
+
+	stlReadMem32   = 0x07
+	stlWriteMem32  = 0x08
+	stlReadMem8    = 0x0c
+	stlWriteMem8   = 0x0d
+	stlExit        = 0x21
+	stlEnter       = 0x30
+	stlReadIDCodes = 0x31
+	stlDriveNRST   = 0x3c
+	stlLastStatus2 = 0x3e
+	stlSWDSetFreq  = 0x43
+	stlReadMem16   = 0x47
+	stlWriteMem16  = 0x48
+
+	stlEnterSWD = 0xa3
+)
+
+const (
+	stlModeDFU   = 0x00
+	stlModeMass  = 0x01
+	stlModeDebug = 0x02
+
+	stlOK         = 0x80
+	stlFault      = 0x81
+	stlSWDAPFault = 0x11
+
+	stlPacketSize = 64
+	stlVersion    = 2<<12 | 37<<6 | 26 // V2J37S26
+)
+
+// STLink simulates an ST-LINK/V2-1 debug probe (USB ID 0483:374b) connected
+// over SWD to the Target. It starts in the DFU mode like the real probe just
+// after it was plugged in.
+type STLink struct {
+	device
+
+	Target Target
+
+	// Version is the firmware version reported by the GET_VERSION command
+	// (STLINK<<12 | JTAG<<6 | SWIM), V2J37S26 by default.
+	Version uint16
+
+	// Mode is the current probe mode (0: DFU, 1: mass storage, 2: debug).
+	Mode int
+
+	// Div is the SWD clock divisor set by the host.
+	Div int
+
+	// NRST is the state of the target reset line (true means asserted).
+	NRST bool
+
+	rsp     []byte
+	wcmd    uint8  // pending write command
+	waddr   uint32 // pending write address
+	wdata   []byte // pending write data
+	wlen    int    // pending write length
+	status  uint8  // status of the last memory access
+	faultAt uint32
+}
+
+// NewSTLink returns a simulated ST-LINK probe connected to the target.
+func NewSTLink(target Target) *STLink {
+	s := &STLink{
+		Target: target, Version: stlVersion, Mode: stlModeDFU, status: stlOK,
+	}
+	bulk := func(addr usb.EndpointAddress) usb.EndpointDesc {
+		dir := usb.EndpointDirectionOut
+		if addr&0x80 != 0 {
+			dir = usb.EndpointDirectionIn
+		}
+		return usb.EndpointDesc{
+			Address: addr, Number: int(addr & 0x0f), Direction: dir,
+			MaxPacketSize: stlPacketSize, TransferType: usb.TransferTypeBulk,
+		}
+	}
+	s.init(s, 0x0483, 0x374b, 0x0100, usb.ConfigDesc{
+		Number: 1,
+		Interfaces: []usb.InterfaceDesc{{
+			Number: 0,
+			AltSettings: []usb.InterfaceSetting{{
+				Number: 0, Class: usb.ClassVendorSpec,
+				Endpoints: map[usb.EndpointAddress]usb.EndpointDesc{
+					0x01: bulk(0x01), 0x81: bulk(0x81),
+				},
+			}},
+		}},
+	})
+	s.names[[3]int{1, 0, 0}] = "ST-Link Debug"
+	return s
+}
+
+func (s *STLink) Claim(cfg, intf, alt int) (usbdev.Interface, error) {
+	if err := s.claim(cfg, intf, alt); err != nil {
+		return nil, err
+	}
+	return stlIntf{s}, nil
+}
+
+// Reset simulates the USB reset.
+func (s *STLink) Reset() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gone() {
+		return usb.ErrorNoDevice
+	}
+	s.rsp, s.wdata, s.wlen = nil, nil, 0
+	return nil
+}
+
+func (s *STLink) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+	return 0, usb.ErrorPipe
+}
+
+type stlIntf struct {
+	s *STLink
+}
+
+func (i stlIntf) In(ep int) (io.Reader, error) {
+	if ep != 1 {
+		return nil, usb.ErrorNotFound
+	}
+	return stlIn{i.s}, nil
+}
+
+func (i stlIntf) Out(ep int) (io.Writer, error) {
+	if ep != 1 {
+		return nil, usb.ErrorNotFound
+	}
+	return stlOut{i.s}, nil
+}
+
+func (i stlIntf) Close() error {
+	return nil
+}
+
+type stlIn struct {
+	s *STLink
+}
+
+func (e stlIn) Read(p []byte) (int, error) {
+	s := e.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gone() {
+		return 0, usb.ErrorNoDevice
+	}
+	if len(s.rsp) == 0 {
+		return 0, usb.ErrorTimeout
+	}
+	n := copy(p, s.rsp)
+	s.rsp = s.rsp[n:]
+	return n, nil
+}
+
+type stlOut struct {
+	s *STLink
+}
+
+func (e stlOut) Write(p []byte) (int, error) {
+	s := e.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gone() {
+		return 0, usb.ErrorNoDevice
+	}
+	if s.wlen != 0 {
+		// The data phase of the write command.
+		s.wdata = append(s.wdata, p...)
+		if len(s.wdata) >= s.wlen {
+			s.writeMem(s.wcmd, s.waddr, s.wdata[:s.wlen])
+			s.wdata, s.wlen = nil, 0
+		}
+		return len(p), nil
+	}
+	if len(p) != 16 {
+		return 0, usb.ErrorPipe
+	}
+	s.rsp = s.command(p)
+	return len(p), nil
+}
+
+// command executes the command block and returns the response.
+func (s *STLink) command(p []byte) []byte {
+	le := binary.LittleEndian
+	switch p[0] {
+	case stlGetVersion:
+		rsp := binary.BigEndian.AppendUint16(nil, s.Version)
+		rsp = le.AppendUint16(rsp, 0x0483)
+		return le.AppendUint16(rsp, 0x374b)
+	case stlGetCurrentMode:
+		return []byte{uint8(s.Mode), 0}
+	case stlDFU:
+		if p[1] == stlDFUExit {
+			s.Mode = stlModeMass
+		}
+		return nil
+	case stlDebug:
+	default:
+		return nil
+	}
+	// Debug commands.
+	sub, addr, n := p[1], le.Uint32(p[2:]), int(le.Uint16(p[6:]))
+	switch sub {
+	case stlEnter:
+		if p[2] != stlEnterSWD {
+			return []byte{stlFault, 0}
+		}
+		s.Mode = stlModeDebug
+		return []byte{stlOK, 0}
+	case stlExit:
+		s.Mode = stlModeMass
+		return nil
+	case stlSWDSetFreq:
+		s.Div = int(le.Uint16(p[2:]))
+		return []byte{stlOK, 0}
+	case stlDriveNRST:
+		s.NRST = p[2] == 0
+		return []byte{stlOK, 0}
+	}
+	if s.Mode != stlModeDebug {
+		return []byte{stlFault, 0}
+	}
+	switch sub {
+	case stlReadIDCodes:
+		rsp := le.AppendUint32([]byte{stlOK, 0, 0, 0}, dapDPIDR)
+		return append(rsp, 0, 0, 0, 0)
+	case stlLastStatus2:
+		rsp := []byte{s.status, 0, 0, 0}
+		rsp = le.AppendUint32(rsp, s.faultAt)
+		return append(rsp, 0, 0, 0, 0)
+	case stlReadMem8, stlReadMem16, stlReadMem32:
+		rsp := s.readMem(sub, addr, n)
+		if sub == stlReadMem8 && n == 1 {
+			rsp = append(rsp, 0)
+		}
+		return rsp
+	case stlWriteMem8, stlWriteMem16, stlWriteMem32:
+		s.wcmd, s.waddr, s.wlen, s.wdata = sub, addr, n, nil
+		return nil
+	}
+	return []byte{stlFault, 0}
+}
+
+// stlWidth returns the access width for the memory command.
+func stlWidth(cmd uint8) int {
+	switch cmd {
+	case stlReadMem8, stlWriteMem8:
+		return 1
+	case stlReadMem16, stlWriteMem16:
+		return 2
+	}
+	return 4
+}
+
+// readMem reads n bytes. The whole response is returned even if the access
+// failed (the failed part reads as zeros).
+func (s *STLink) readMem(cmd uint8, addr uint32, n int) []byte {
+	rsp := make([]byte, n)
+	s.status, s.faultAt = stlOK, 0
+	if addr&uint32(stlWidth(cmd)-1) != 0 {
+		s.status, s.faultAt = stlSWDAPFault, addr
+		return rsp
+	}
+	for i := 0; i < n; i++ {
+		a := addr + uint32(i)
+		w, ok := s.Target.Read32(a &^ 3)
+		if !ok {
+			s.status, s.faultAt = stlSWDAPFault, a
+			break
+		}
+		rsp[i] = byte(w >> (a & 3 * 8))
+	}
+	return rsp
+}
+
+// writeMem writes p. The 8-bit and 16-bit writes are performed as the
+// read-modify-write of the whole word.
+func (s *STLink) writeMem(cmd uint8, addr uint32, p []byte) {
+	s.status, s.faultAt = stlOK, 0
+	width := stlWidth(cmd)
+	if addr&uint32(width-1) != 0 || len(p)%width != 0 {
+		s.status, s.faultAt = stlSWDAPFault, addr
+		return
+	}
+	for i := 0; i < len(p); i += width {
+		a := addr + uint32(i)
+		var w uint32
+		ok := true
+		if width == 4 {
+			w = le32(p[i:])
+		} else if w, ok = s.Target.Read32(a &^ 3); ok {
+			for k := 0; k < width; k++ {
+				sh := (a + uint32(k)) & 3 * 8
+				w = w&^(0xff<<sh) | uint32(p[i+k])<<sh
+			}
+		}
+		if ok {
+			ok = s.Target.Write32(a&^3, w)
+		}
+		if !ok {
+			s.status, s.faultAt = stlSWDAPFault, a
+			return
+		}
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package usbsim
+
+import "sync"
+
+// STM32F4 memory map and registers
+const (
+	f4Flash     = 0x0800_0000
+	f4RAM       = 0x2000_0000
+	f4FlashSize = 0x1fff_7a20 // F_SIZE in the upper half-word
+	f4DBGMCU    = 0xe004_2000
+	f4KEYR      = 0x4002_3c04
+	f4SR        = 0x4002_3c0c
+	f4CR        = 0x4002_3c10
+	f4OPTKEYR   = 0x4002_3c08
+	f4OPTCR     = 0x4002_3c14
+
+	f4SRPGSERR = 1 << 7
+	f4CRPG     = 1 << 0
+	f4CRSER    = 1 << 1
+	f4CRSTRT   = 1 << 16
+	f4CRLOCK   = 1 << 31
+
+	f4OPTCRLOCK = 1 << 0
+	f4OPTCRSTRT = 1 << 1
+	f4OPTCRDef  = 0x0fff_aaec // reset value without OPTLOCK
+)
+
+// STM32F4 simulates the memory of an STM32F4 microcontroller (Cortex-M4 core,
+// DBGMCU, the flash interface, RAM, flash) as seen by a debug probe. The flash
+// consists of the 4 x 16 KiB, 1 x 64 KiB and 7 x 128 KiB sectors in every
+// 1 MiB bank. See NRF52 for the limitations of the core emulation.
+type STM32F4 struct {
+	mu sync.Mutex
+
+	DevID uint32 // DBGMCU_IDCODE DEV_ID
+	Flash *Flash
+	RAM   []byte
+
+	// RDP is the read protection option byte. The flash can't be accessed
+	// if it isn't 0xaa (level 0). Programming 0xaa if it isn't erases the
+	// whole flash.
+	RDP uint8
+
+	scs
+
+	cr        uint32
+	sr        uint32
+	keyIdx    int
+	optcr     uint32
+	optKeyIdx int
+}
+
+// NewSTM32F4 returns a simulated STM32F4 with the given device ID (e.g. 0x413
+// for STM32F405/407), the flash size in KiB and 128 KiB of RAM.
+func NewSTM32F4(devID uint32, flashKiB int) *STM32F4 {
+	return &STM32F4{
+		DevID: devID,
+		Flash: NewFlash(f4Flash, flashKiB*1024, 16*1024),
+		RAM:   make([]byte, 128*1024),
+		RDP:   0xaa,
+		scs:   scs{cpuid: 0x410f_c241}, // Cortex-M4 r0p1
+		cr:    f4CRLOCK,
+		optcr: f4OPTCRDef | f4OPTCRLOCK,
+	}
+}
+
+// sector returns the address and the size of the n-th sector (SNB). It
+// returns zero size for the non-existent sector.
+func (t *STM32F4) sector(snb uint32) (addr uint32, size int) {
+	addr = f4Flash
+	if snb&0x10 != 0 {
+		addr += 1 << 20
+		snb &^= 0x10
+	}
+	switch {
+	case snb < 4:
+		addr += snb * 16 * 1024
+		size = 16 * 1024
+	case snb == 4:
+		addr += 64 * 1024
+		size = 64 * 1024
+	case snb < 12:
+		addr += (snb - 4) * 128 * 1024
+		size = 128 * 1024
+	}
+	if size == 0 || !t.Flash.Contains(addr, size) {
+		return 0, 0
+	}
+	return
+}
+
+func (t *STM32F4) Read32(addr uint32) (uint32, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case t.Flash.Contains(addr, 4):
+		if t.RDP != 0xaa {
+			return 0, false
+		}
+		var b [4]byte
+		t.Flash.ReadAt(b[:], int64(addr))
+		return le32(b[:]), true
+	case addr >= f4RAM && addr-f4RAM < uint32(len(t.RAM))-3:
+		return le32(t.RAM[addr-f4RAM:]), true
+	case addr == f4FlashSize:
+		return uint32(t.Flash.Size()/1024) << 16, true
+	case addr == f4DBGMCU:
+		return 0x1000_0000 | t.DevID, true // REV_ID = 0x1000
+	case addr == f4KEYR:
+		return 0, true
+	case addr == f4SR:
+		return t.sr, true
+	case addr == f4CR:
+		return t.cr, true
+	case addr == f4OPTKEYR:
+		return 0, true
+	case addr == f4OPTCR:
+		return t.optcr&^0xff00 | uint32(t.RDP)<<8, true
+	}
+	return t.scs.read(addr)
+}
+
+func (t *STM32F4) Write32(addr, val uint32) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case t.Flash.Contains(addr, 4):
+		if t.RDP != 0xaa {
+			return false
+		}
+		if t.cr&(f4CRLOCK|f4CRPG) != f4CRPG {
+			t.sr |= f4SRPGSERR
+			return true
+		}
+		var b [4]byte
+		putLE32(b[:], val)
+		t.Flash.Program(addr, b[:])
+	case addr >= f4RAM && addr-f4RAM < uint32(len(t.RAM))-3:
+		putLE32(t.RAM[addr-f4RAM:], val)
+	case addr == f4KEYR:
+		keys := [2]uint32{0x4567_0123, 0xcdef_89ab}
+		if t.cr&f4CRLOCK == 0 || val != keys[t.keyIdx] {
+			t.keyIdx = 0
+			return false // a wrong sequence locks the interface until reset
+		}
+		if t.keyIdx++; t.keyIdx == len(keys) {
+			t.keyIdx = 0
+			t.cr &^= f4CRLOCK
+		}
+	case addr == f4SR:
+		t.sr &^= val // rc_w1 bits
+	case addr == f4CR:
+		if t.cr&f4CRLOCK != 0 {
+			return true
+		}
+		t.cr = val
+		if val&(f4CRSER|f4CRSTRT) == f4CRSER|f4CRSTRT {
+			if a, n := t.sector(val >> 3 & 0x1f); n != 0 {
+				t.Flash.Erase(a, n)
+			} else {
+				t.sr |= f4SRPGSERR
+			}
+			t.cr &^= f4CRSTRT
+		}
+	case addr == f4OPTKEYR:
+		keys := [2]uint32{0x0819_2a3b, 0x4c5d_6e7f}
+		if t.optcr&f4OPTCRLOCK == 0 || val != keys[t.optKeyIdx] {
+			t.optKeyIdx = 0
+			return false
+		}
+		if t.optKeyIdx++; t.optKeyIdx == len(keys) {
+			t.optKeyIdx = 0
+			t.optcr &^= f4OPTCRLOCK
+		}
+	case addr == f4OPTCR:
+		if t.optcr&f4OPTCRLOCK != 0 {
+			return true
+		}
+		t.optcr = val &^ f4OPTCRSTRT
+		if val&f4OPTCRSTRT != 0 {
+			rdp := uint8(val >> 8)
+			if t.RDP != 0xaa && rdp == 0xaa {
+				t.Flash.EraseAll() // level 1 -> 0 regression
+			}
+			if t.RDP != 0xcc {
+				t.RDP = rdp
+			}
+		}
+	default:
+		return t.scs.write(addr, val)
+	}
+	return true
+}
@@ -0,0 +1,259 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package usbsim provides in-process simulators of the USB devices in the
+// bootloader mode: the STM32 DfuSe bootloader, the RP2040 and RP2350 PICOBOOT
+// interface and the Teensy 4.x HalfKay bootloader. Every simulator emulates the flash
+// memory of the device so the whole loading process can be tested without any
+// hardware. There is also the CMSIS-DAP debug probe simulator connected to a
+// simulated target memory (e.g. NRF52). Use usbdev.Attach to make the
+// simulated device visible to the USB clients.
+package usbsim
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/embeddedgo/tools/egtool/internal/usbdev"
+	usb "github.com/google/gousb"
+)
+
+// Bus is the number of the virtual bus the simulated devices are attached to.
+const Bus = 0
+
+var lastAddr atomic.Int32
+
+// device contains the state common for all simulated devices. It implements
+// the usbdev.Device methods that don't depend on the device class.
+type device struct {
+	mu      sync.Mutex
+	desc    usb.DeviceDesc
+	serial  string
+	product string
+	names   map[[3]int]string // interface descriptions
+	self    usbdev.Device     // the simulator that embeds the device
+}
+
+func (d *device) init(self usbdev.Device, vendor, product usb.ID, bcd usb.BCD, cfgs ...usb.ConfigDesc) {
+	addr := int(lastAddr.Add(1))
+	d.desc = usb.DeviceDesc{
+		Bus:                  Bus,
+		Address:              addr,
+		Speed:                usb.SpeedFull,
+		Spec:                 0x0200,
+		Device:               bcd,
+		Vendor:               vendor,
+		Product:              product,
+		MaxControlPacketSize: 64,
+		Configs:              make(map[int]usb.ConfigDesc),
+	}
+	for _, cfg := range cfgs {
+		d.desc.Configs[cfg.Number] = cfg
+	}
+	d.self = self
+	d.serial = fmt.Sprintf("SIM%08X", addr)
+	d.product = "Simulated Device"
+	d.names = make(map[[3]int]string)
+}
+
+// gone reports whether the device isn't attached to the virtual bus (e.g. it
+// was rebooted or unplugged). Attach it again to simulate the re-enumeration.
+func (d *device) gone() bool {
+	return !usbdev.Attached(d.self)
+}
+
+// leave detaches the device from the virtual bus.
+func (d *device) leave() {
+	usbdev.Detach(d.self)
+}
+
+func (d *device) Desc() *usb.DeviceDesc {
+	return &d.desc
+}
+
+func (d *device) Product() (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.product, nil
+}
+
+// SetProduct sets the product string reported by the device.
+func (d *device) SetProduct(name string) {
+	d.mu.Lock()
+	d.product = name
+	d.mu.Unlock()
+}
+
+func (d *device) SerialNumber() (string, error) {
+	return d.serial, nil
+}
+
+// SetSerialNumber sets the serial number reported by the device.
+func (d *device) SetSerialNumber(sn string) {
+	d.mu.Lock()
+	d.serial = sn
+	d.mu.Unlock()
+}
+
+func (d *device) InterfaceDescription(cfg, intf, alt int) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.gone() {
+		return "", usb.ErrorNoDevice
+	}
+	if !d.hasIntf(cfg, intf, alt) {
+		return "", usb.ErrorNotFound
+	}
+	return d.names[[3]int{cfg, intf, alt}], nil
+}
+
+func (d *device) hasIntf(cfg, intf, alt int) bool {
+	c, ok := d.desc.Configs[cfg]
+	if !ok {
+		return false
+	}
+	for _, id := range c.Interfaces {
+		for _, is := range id.AltSettings {
+			if is.Number == intf && is.Alternate == alt {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// claim checks that the device provides the interface.
+func (d *device) claim(cfg, intf, alt int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.gone() {
+		return usb.ErrorNoDevice
+	}
+	if !d.hasIntf(cfg, intf, alt) {
+		return usb.ErrorNotFound
+	}
+	return nil
+}
+
+// Close does nothing. The simulated device stays on the virtual bus until it
+// leaves it itself or is detached using usbdev.Detach.
+func (d *device) Close() error {
+	return nil
+}
+
+// noEndpoints is the claimed interface without bulk/interrupt endpoints
+// available to the host.
+type noEndpoints struct{}
+
+func (noEndpoints) In(ep int) (io.Reader, error) {
+	return nil, usb.ErrorNotFound
+}
+
+func (noEndpoints) Out(ep int) (io.Writer, error) {
+	return nil, usb.ErrorNotFound
+}
+
+func (noEndpoints) Close() error {
+	return nil
+}
+
+// Flash emulates a NOR flash memory. The erased flash reads as 0xff and the
+// program operation can only clear bits.
+type Flash struct {
+	Base       uint32 // address of the first byte
+	SectorSize int    // size of the erase unit
+
+	mu   sync.Mutex
+	data []byte
+
+	// Statistics
+	Erased  int // number of erased sectors
+	Written int // number of programmed bytes
+}
+
+// NewFlash returns the fully erased flash memory of the given size.
+func NewFlash(base uint32, size, sectorSize int) *Flash {
+	f := &Flash{Base: base, SectorSize: sectorSize, data: make([]byte, size)}
+	for i := range f.data {
+		f.data[i] = 0xff
+	}
+	return f
+}
+
+// Size returns the flash size in bytes.
+func (f *Flash) Size() int {
+	return len(f.data)
+}
+
+// Contains reports whether the range [addr, addr+n) lies in the flash.
+func (f *Flash) Contains(addr uint32, n int) bool {
+	return addr >= f.Base && uint64(addr-f.Base)+uint64(n) <= uint64(len(f.data))
+}
+
+var errRange = errors.New("address out of range")
+
+// ReadAt implements io.ReaderAt. The off is the absolute address.
+func (f *Flash) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off > 1<<32-1 || !f.Contains(uint32(off), len(p)) {
+		return 0, errRange
+	}
+	f.mu.Lock()
+	copy(p, f.data[uint32(off)-f.Base:])
+	f.mu.Unlock()
+	return len(p), nil
+}
+
+// Bytes returns a copy of n bytes of the flash content starting from addr.
+func (f *Flash) Bytes(addr uint32, n int) []byte {
+	p := make([]byte, n)
+	if _, err := f.ReadAt(p, int64(addr)); err != nil {
+		return nil
+	}
+	return p
+}
+
+// Erase erases all sectors that overlap the range [addr, addr+n).
+func (f *Flash) Erase(addr uint32, n int) error {
+	if !f.Contains(addr, n) {
+		return errRange
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ss := f.SectorSize
+	start := int(addr-f.Base) / ss * ss
+	end := (int(addr-f.Base) + n + ss - 1) / ss * ss
+	for i := start; i < end; i++ {
+		f.data[i] = 0xff
+	}
+	f.Erased += (end - start) / ss
+	return nil
+}
+
+// EraseAll erases the whole flash.
+func (f *Flash) EraseAll() {
+	f.Erase(f.Base, len(f.data))
+}
+
+// Program programs p at addr. It reports whether the programmed content is
+// equal to p (false means the range wasn't erased).
+func (f *Flash) Program(addr uint32, p []byte) (ok bool, err error) {
+	if !f.Contains(addr, len(p)) {
+		return false, errRange
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ok = true
+	d := f.data[addr-f.Base:]
+	for i, b := range p {
+		d[i] &= b
+		if d[i] != b {
+			ok = false
+		}
+	}
+	f.Written += len(p)
+	return
+}
@@ -0,0 +1,112 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// JSON enables the machine-readable output. If set the commands write
+// newline-delimited JSON events to the standard output instead of the
+// human-readable diagnostic information and Fatal, FatalErr report the
+// failure as the "error" event.
+var JSON bool
+
+// Event kinds
+const (
+	EvFound  = "found"  // device found (Device, Serial, Name)
+	EvErase  = "erase"  // erasing memory (Addr, Total)
+	EvWrite  = "write"  // write progress (Done, Total)
+	EvVerify = "verify" // verifying written memory (Done, Total)
+	EvReboot = "reboot" // rebooting the device (Msg)
+	EvData   = "data"   // data read from the device (Addr, Data)
+	EvDone   = "done"   // successful end of the operation (Name, Total, Time)
+	EvError  = "error"  // failure (Msg, Code)
+)
+
+// Event is a single line of the JSON output. The zero fields are omitted
+// except the numeric fields of the progress and data events (see MarshalJSON).
+type Event struct {
+	Event  string   `json:"event"`
+	Device string   `json:"device,omitempty"` // BUS:ADDR or the drive path
+	Serial string   `json:"serial,omitempty"`
+	Name   string   `json:"name,omitempty"` // device model, output file
+	Addr   uint32   `json:"addr,omitempty"`
+	Done   int      `json:"done,omitempty"`
+	Total  int      `json:"total,omitempty"`
+	Data   []uint32 `json:"data,omitempty"`
+	Time   float64  `json:"time,omitempty"` // seconds
+	Msg    string   `json:"msg,omitempty"`
+	Code   string   `json:"code,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler. The address and the progress of the
+// erase, write, verify and data events are always present because zero is a
+// valid value there.
+func (e *Event) MarshalJSON() ([]byte, error) {
+	type event Event // no MarshalJSON method
+	v := struct {
+		*event
+		Addr  *uint32 `json:"addr,omitempty"`
+		Done  *int    `json:"done,omitempty"`
+		Total *int    `json:"total,omitempty"`
+	}{event: (*event)(e)}
+	switch e.Event {
+	case EvErase:
+		v.Addr, v.Total = &e.Addr, &e.Total
+	case EvWrite, EvVerify:
+		v.Done, v.Total = &e.Done, &e.Total
+	case EvData:
+		v.Addr = &e.Addr
+	default:
+		if e.Addr != 0 {
+			v.Addr = &e.Addr
+		}
+		if e.Done != 0 {
+			v.Done = &e.Done
+		}
+		if e.Total != 0 {
+			v.Total = &e.Total
+		}
+	}
+	return json.Marshal(&v)
+}
+
+var evMu sync.Mutex
+
+// Emit writes e as a single JSON line to the standard output. It can be used
+// concurrently.
+func Emit(e *Event) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		panic(err)
+	}
+	b = append(b, '\n')
+	evMu.Lock()
+	os.Stdout.Write(b)
+	evMu.Unlock()
+}
+
+// ErrorEvent returns the "error" event that describes err. The Code field is
+// set if err wraps an error that provides the ErrorCode method (e.g.
+// dfu.StatusError, picoboot.StatusError).
+func ErrorEvent(device string, err error) *Event {
+	e := &Event{Event: EvError, Device: device, Msg: err.Error()}
+	var ec interface{ ErrorCode() string }
+	if errors.As(err, &ec) {
+		e.Code = ec.ErrorCode()
+	}
+	return e
+}
+
+// DoneEvent returns the "done" event with the time elapsed since t0.
+func DoneEvent(device string, t0 time.Time) *Event {
+	dt := time.Since(t0).Milliseconds()
+	return &Event{Event: EvDone, Device: device, Time: float64(dt) / 1000}
+}
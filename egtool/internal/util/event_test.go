@@ -0,0 +1,51 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEventJSON(t *testing.T) {
+	tests := []struct {
+		e    Event
+		want string
+	}{
+		{
+			Event{Event: EvWrite, Done: 0, Total: 4096},
+			`{"event":"write","done":0,"total":4096}`,
+		},
+		{
+			Event{Event: EvVerify, Device: "1:2"},
+			`{"event":"verify","device":"1:2","done":0,"total":0}`,
+		},
+		{
+			Event{Event: EvErase, Addr: 0, Total: 8192},
+			`{"event":"erase","addr":0,"total":8192}`,
+		},
+		{
+			Event{Event: EvData, Addr: 0, Data: []uint32{0, 1}},
+			`{"event":"data","data":[0,1],"addr":0}`,
+		},
+		{
+			Event{Event: EvFound, Name: "RP2350"},
+			`{"event":"found","name":"RP2350"}`,
+		},
+		{
+			Event{Event: EvDone, Total: 2},
+			`{"event":"done","total":2}`,
+		},
+	}
+	for _, tc := range tests {
+		b, err := json.Marshal(&tc.e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tc.want {
+			t.Errorf("got %s, want %s", b, tc.want)
+		}
+	}
+}
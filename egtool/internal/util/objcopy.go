@@ -145,7 +145,7 @@ func (ss Sections) Flatten(w io.Writer, pad byte) (n int, err error) {
 			pa += uint64(m)
 		}
 		m, err = w.Write(s.Data)
-		n += n
+		n += m
 		if err != nil {
 			return
 		}
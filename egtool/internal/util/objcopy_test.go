@@ -0,0 +1,66 @@
+// Copyright 2025 The Embedded Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFlatten(t *testing.T) {
+	tests := []struct {
+		name string
+		ss   Sections
+		want []byte
+	}{
+		{"empty", nil, nil},
+		{
+			"one",
+			Sections{{Paddr: 0x100, Data: []byte{1, 2, 3}}},
+			[]byte{1, 2, 3},
+		},
+		{
+			"adjacent",
+			Sections{
+				{Paddr: 0x103, Data: []byte{4, 5}},
+				{Paddr: 0x100, Data: []byte{1, 2, 3}},
+			},
+			[]byte{1, 2, 3, 4, 5},
+		},
+		{
+			"gaps",
+			Sections{
+				{Paddr: 0x100, Data: []byte{1, 2}},
+				{Paddr: 0x108, Data: []byte{6}},
+				{Paddr: 0x104, Data: []byte{3, 4, 5}},
+			},
+			[]byte{1, 2, 0xff, 0xff, 3, 4, 5, 0xff, 6},
+		},
+	}
+	for _, tc := range tests {
+		var buf bytes.Buffer
+		n, err := tc.ss.Flatten(&buf, 0xff)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), tc.want) {
+			t.Errorf("%s: got % x, want % x", tc.name, buf.Bytes(), tc.want)
+		}
+		if n != len(tc.want) {
+			t.Errorf("%s: n=%d, want %d", tc.name, n, len(tc.want))
+		}
+	}
+}
+
+func TestFlattenOverlap(t *testing.T) {
+	ss := Sections{
+		{Paddr: 0x100, Data: []byte{1, 2, 3}},
+		{Paddr: 0x102, Data: []byte{4}},
+	}
+	if _, err := ss.Flatten(new(bytes.Buffer), 0); err == nil {
+		t.Error("Flatten succeeded with overlapping sections")
+	}
+}
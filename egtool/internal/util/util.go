@@ -21,6 +21,10 @@ func Warn(f string, args ...any) {
 }
 
 func Fatal(f string, args ...any) {
+	if JSON {
+		Emit(&Event{Event: EvError, Msg: fmt.Sprintf(f, args...)})
+		os.Exit(1)
+	}
 	fmt.Fprintf(os.Stderr, f+"\n", args...)
 	os.Exit(1)
 }
@@ -31,6 +35,14 @@ func FatalErr(what string, err error) {
 	if err == nil {
 		return
 	}
+	if JSON {
+		e := ErrorEvent("", err)
+		if what != "" {
+			e.Msg = what + ": " + e.Msg
+		}
+		Emit(e)
+		os.Exit(1)
+	}
 	s := err.Error() + "\n"
 	if what != "" {
 		s = what + ": " + s
@@ -12,10 +12,15 @@ import (
 
 	"github.com/embeddedgo/tools/egtool/internal/cmd/bin"
 	"github.com/embeddedgo/tools/egtool/internal/cmd/build"
+	"github.com/embeddedgo/tools/egtool/internal/cmd/debug"
+	"github.com/embeddedgo/tools/egtool/internal/cmd/fault"
 	"github.com/embeddedgo/tools/egtool/internal/cmd/hex"
 	"github.com/embeddedgo/tools/egtool/internal/cmd/imxmbr"
 	"github.com/embeddedgo/tools/egtool/internal/cmd/isrnames"
 	"github.com/embeddedgo/tools/egtool/internal/cmd/load"
+	"github.com/embeddedgo/tools/egtool/internal/cmd/otp"
+	"github.com/embeddedgo/tools/egtool/internal/cmd/pico"
+	"github.com/embeddedgo/tools/egtool/internal/cmd/term"
 )
 
 type tool struct {
@@ -26,10 +31,15 @@ type tool struct {
 var tools = map[string]tool{
 	"bin":      {bin.DescrBin, bin.Main},
 	"build":    {build.Descr, build.Main},
+	"debug":    {debug.Descr, debug.Main},
+	"fault":    {fault.Descr, fault.Main},
 	"hex":      {hex.Descr, hex.Main},
 	"imxmbr":   {imxmbr.Descr, imxmbr.Main},
 	"isrnames": {isrnames.Descr, isrnames.Main},
 	"load":     {load.Descr, load.Main},
+	"otp":      {otp.Descr, otp.Main},
+	"pico":     {pico.Descr, pico.Main},
+	"term":     {term.Descr, term.Main},
 	"uf2":      {bin.DescrUF2, bin.Main},
 }
 